@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"github.com/munnik/gosk/config"
+	"github.com/munnik/gosk/logger"
+	"github.com/munnik/gosk/mapper"
+	"github.com/munnik/gosk/nanomsg"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var aisCmd = &cobra.Command{
+	Use:   "ais",
+	Short: "Track AIS targets",
+	Long:  `Track AIS targets, calculate the closest point of approach of each target and raise SignalK notifications when a target comes too close`,
+	Run:   doAIS,
+}
+
+func init() {
+	rootCmd.AddCommand(aisCmd)
+	aisCmd.Flags().StringVarP(&subscribeURL, "subscribeURL", "s", "", "Nanomsg URL, the URL is used to listen for subscribed data.")
+	aisCmd.MarkFlagRequired("subscribeURL")
+	aisCmd.Flags().StringVarP(&publishURL, "publishURL", "p", "", "Nanomsg URL, the URL is used to publish the data on. It listens for connections.")
+	aisCmd.MarkFlagRequired("publishURL")
+}
+
+func doAIS(cmd *cobra.Command, args []string) {
+	subscriber, err := nanomsg.NewSub(subscribeURL, []byte{})
+	if err != nil {
+		logger.GetLogger().Fatal(
+			"Could not subscribe",
+			zap.String("URL", subscribeURL),
+			zap.String("Error", err.Error()),
+		)
+	}
+	publisher := nanomsg.NewPub(publishURL)
+	m, err := mapper.NewAISMapper(config.NewAISConfig(cfgFile))
+	if err != nil {
+		logger.GetLogger().Fatal(
+			"Could not create the AIS mapper",
+			zap.String("Config file", cfgFile),
+			zap.String("Error", err.Error()),
+		)
+	}
+	m.Map(subscriber, publisher)
+}
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"github.com/munnik/gosk/config"
+	"github.com/munnik/gosk/logger"
+	"github.com/munnik/gosk/mapper"
+	"github.com/munnik/gosk/nanomsg"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var alarmCmd = &cobra.Command{
+	Use:   "alarm",
+	Short: "Raise notifications based on incoming data",
+	Long:  `Raise SignalK notifications when the incoming data crosses thresholds, changes too fast, becomes stale or matches an expression`,
+	Run:   doAlarm,
+}
+
+func init() {
+	rootCmd.AddCommand(alarmCmd)
+	alarmCmd.Flags().StringVarP(&subscribeURL, "subscribeURL", "s", "", "Nanomsg URL, the URL is used to listen for subscribed data.")
+	alarmCmd.MarkFlagRequired("subscribeURL")
+	alarmCmd.Flags().StringVarP(&publishURL, "publishURL", "p", "", "Nanomsg URL, the URL is used to publish the data on. It listens for connections.")
+	alarmCmd.MarkFlagRequired("publishURL")
+}
+
+func doAlarm(cmd *cobra.Command, args []string) {
+	subscriber, err := nanomsg.NewSub(subscribeURL, []byte{})
+	if err != nil {
+		logger.GetLogger().Fatal(
+			"Could not subscribe",
+			zap.String("URL", subscribeURL),
+			zap.String("Error", err.Error()),
+		)
+	}
+	publisher := nanomsg.NewPub(publishURL)
+	m, err := mapper.NewAlarmMapper(config.NewAlarmConfig(cfgFile))
+	if err != nil {
+		logger.GetLogger().Fatal(
+			"Could not create the alarm mapper",
+			zap.String("Config file", cfgFile),
+			zap.String("Error", err.Error()),
+		)
+	}
+	m.Map(subscriber, publisher)
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/munnik/gosk/connector"
 	"github.com/munnik/gosk/logger"
 	"github.com/munnik/gosk/nanomsg"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 )
@@ -49,28 +50,7 @@ func init() {
 }
 
 func doConnect(cmd *cobra.Command, args []string) {
-	var err error
-	c := config.NewConnectorConfig(cfgFile)
-	var conn connector.Connector
-
-	switch c.Protocol {
-	case config.CSVType, config.NMEA0183Type, config.JSONType:
-		conn, err = connector.NewLineConnector(c)
-	case config.ModbusType:
-		rgc := config.NewRegisterGroupsConfig(cfgFile)
-		conn, err = connector.NewModbusConnector(c, rgc)
-	case config.CanBusType:
-		conn, err = connector.NewCanBusConnector(c)
-	case config.HttpType:
-		ugc := config.NewUrlGroupsConfig(cfgFile)
-		conn, err = connector.NewHttpConnector(c, ugc)
-	default:
-		logger.GetLogger().Fatal(
-			"Not a supported protocol",
-			zap.String("Protocol", c.Protocol),
-		)
-		return
-	}
+	conn, err := createConnector(cfgFile, prometheus.DefaultRegisterer)
 	if err != nil {
 		logger.GetLogger().Fatal(
 			"Error while creating the connector",
@@ -101,3 +81,25 @@ func doConnect(cmd *cobra.Command, args []string) {
 
 	conn.Publish(nanomsg.NewPub(publishURL))
 }
+
+// createConnector creates the connector of the config file, the metrics are registered with reg
+func createConnector(configFile string, reg prometheus.Registerer) (connector.Connector, error) {
+	c := config.NewConnectorConfig(configFile)
+
+	switch c.Protocol {
+	case config.CSVType, config.NMEA0183Type, config.JSONType:
+		return connector.NewLineConnector(c)
+	case config.ModbusType:
+		rgc := config.NewRegisterGroupsConfig(configFile)
+		return connector.NewModbusConnector(c, rgc, reg)
+	case config.CanBusType, config.NMEA2000Type:
+		return connector.NewCanBusConnector(c)
+	case config.HttpType:
+		ugc := config.NewUrlGroupsConfig(configFile)
+		return connector.NewHttpConnector(c, ugc)
+	case config.LWEType:
+		lgc := config.NewLWEGroupsConfig(configFile)
+		return connector.NewLWEConnector(c, lgc)
+	}
+	return nil, fmt.Errorf("not a supported protocol %s", c.Protocol)
+}
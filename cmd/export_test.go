@@ -0,0 +1,6 @@
+package cmd
+
+var (
+	CreatePipelineNode = createPipelineNode
+	NodeRegisterer     = nodeRegisterer
+)
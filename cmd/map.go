@@ -17,10 +17,13 @@ limitations under the License.
 package cmd
 
 import (
+	"fmt"
+
 	"github.com/munnik/gosk/config"
 	"github.com/munnik/gosk/logger"
 	"github.com/munnik/gosk/mapper"
 	"github.com/munnik/gosk/nanomsg"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 )
@@ -51,36 +54,7 @@ func doMap(cmd *cobra.Command, args []string) {
 	}
 	publisher := nanomsg.NewPub(publishURL)
 
-	c := config.NewMapperConfig(cfgFile)
-	var m mapper.Mapper
-	switch c.Protocol {
-	case config.CSVType:
-		c2 := config.NewCSVMapperConfig(cfgFile)
-		cmc := config.NewCSVMappingConfig(cfgFile)
-		m, err = mapper.NewCSVMapper(c2, cmc)
-	case config.JSONType:
-		jmc := config.NewJSONMappingConfig(cfgFile)
-		m, err = mapper.NewJSONMapper(c, jmc)
-	case config.ModbusType:
-		rmc := config.NewModbusMappingsConfig(cfgFile)
-		m, err = mapper.NewModbusMapper(c, rmc)
-	case config.NMEA0183Type:
-		m, err = mapper.NewNmea0183Mapper(c)
-	case config.CanBusType:
-		c2 := config.NewCanBusMapperConfig(cfgFile)
-		cmc := config.NewCanBusMappingConfig(cfgFile)
-		m, err = mapper.NewCanBusMapper(c2, cmc)
-	case config.SignalKType:
-		amc := config.NewExpressionMappingConfig(cfgFile)
-		m, err = mapper.NewAggregateMapper(c, amc)
-
-	default:
-		logger.GetLogger().Fatal(
-			"Not a supported protocol",
-			zap.String("Protocol", c.Protocol),
-		)
-		return
-	}
+	m, err := createMapper(cfgFile, prometheus.DefaultRegisterer)
 	if err != nil {
 		logger.GetLogger().Fatal(
 			"Error while creating the mapper",
@@ -90,3 +64,32 @@ func doMap(cmd *cobra.Command, args []string) {
 	}
 	m.Map(subscriber, publisher)
 }
+
+func createMapper(configFile string, reg prometheus.Registerer) (mapper.Mapper, error) {
+	c := config.NewMapperConfig(configFile)
+
+	switch c.Protocol {
+	case config.CSVType:
+		c2 := config.NewCSVMapperConfig(configFile)
+		cmc := config.NewCSVMappingConfig(configFile)
+		return mapper.NewCSVMapper(c2, cmc, reg)
+	case config.JSONType:
+		jmc := config.NewJSONMappingConfig(configFile)
+		return mapper.NewJSONMapper(c, jmc, reg)
+	case config.ModbusType:
+		rmc := config.NewModbusMappingsConfig(configFile)
+		return mapper.NewModbusMapper(c, rmc, reg)
+	case config.NMEA0183Type:
+		return mapper.NewNmea0183Mapper(c, reg)
+	case config.CanBusType:
+		c2 := config.NewCanBusMapperConfig(configFile)
+		cmc := config.NewCanBusMappingConfig(configFile)
+		return mapper.NewCanBusMapper(c2, cmc, reg)
+	case config.NMEA2000Type:
+		return mapper.NewNmea2000Mapper(c, reg)
+	case config.SignalKType:
+		amc := config.NewExpressionMappingConfig(configFile)
+		return mapper.NewAggregateMapper(c, amc, reg)
+	}
+	return nil, fmt.Errorf("not a supported protocol %s", c.Protocol)
+}
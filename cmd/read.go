@@ -20,6 +20,7 @@ import (
 	"github.com/munnik/gosk/config"
 	"github.com/munnik/gosk/nanomsg"
 	"github.com/munnik/gosk/reader"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/cobra"
 )
 
@@ -35,6 +36,12 @@ var (
 		Long:  `Read messages from a broker`,
 		Run:   doMQTTRead,
 	}
+	signalKReadCmd = &cobra.Command{
+		Use:   "signalk",
+		Short: "Read deltas from a SignalK server",
+		Long:  `Read deltas from the stream of another SignalK server, like signalk-server or another gosk instance`,
+		Run:   doSignalKRead,
+	}
 )
 
 func init() {
@@ -43,10 +50,20 @@ func init() {
 	readCmd.AddCommand(mqttReadCmd)
 	mqttReadCmd.Flags().StringVarP(&publishURL, "publishURL", "p", "", "Nanomsg URL, the URL is used to publish the data on. It listens for connections.")
 	mqttReadCmd.MarkFlagRequired("publishURL")
+
+	readCmd.AddCommand(signalKReadCmd)
+	signalKReadCmd.Flags().StringVarP(&publishURL, "publishURL", "p", "", "Nanomsg URL, the URL is used to publish the data on. It listens for connections.")
+	signalKReadCmd.MarkFlagRequired("publishURL")
 }
 
 func doMQTTRead(cmd *cobra.Command, args []string) {
 	c := config.NewMQTTConfig(cfgFile)
-	r := reader.NewMqttReader(c)
+	r := reader.NewMqttReader(c, prometheus.DefaultRegisterer)
+	r.ReadMapped(nanomsg.NewPub(publishURL))
+}
+
+func doSignalKRead(cmd *cobra.Command, args []string) {
+	c := config.NewSignalKReaderConfig(cfgFile)
+	r := reader.NewSignalKReader(c, prometheus.DefaultRegisterer)
 	r.ReadMapped(nanomsg.NewPub(publishURL))
 }
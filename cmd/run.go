@@ -0,0 +1,274 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/munnik/gosk/config"
+	"github.com/munnik/gosk/logger"
+	"github.com/munnik/gosk/mapper"
+	"github.com/munnik/gosk/nanomsg"
+	"github.com/munnik/gosk/reader"
+	"github.com/munnik/gosk/version"
+	"github.com/munnik/gosk/writer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/cobra"
+	"go.nanomsg.org/mangos/v3"
+	"go.uber.org/zap"
+)
+
+var runCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Run a pipeline of connectors, mappers and writers in a single process",
+	Long: `Run a pipeline of connectors, mappers, filters and writers in a single process. The nodes of the
+pipeline are declared in the config file and exchange messages in memory, nanomsg URLs can still be used to
+exchange messages with external processes.`,
+	Run: doRun,
+}
+
+// pipelineNode runs a node of the pipeline, the subscriber is nil when the node has no inputs and the publisher is
+// nil when the node doesn't produce output
+type pipelineNode func(subscriber mangos.Socket, publisher mangos.Socket)
+
+func init() {
+	rootCmd.AddCommand(runCmd)
+}
+
+func doRun(cmd *cobra.Command, args []string) {
+	c := config.NewPipelineConfig(cfgFile)
+	if len(c.Nodes) == 0 {
+		logger.GetLogger().Fatal(
+			"The pipeline has no nodes",
+			zap.String("Config file", cfgFile),
+		)
+	}
+
+	names := make(map[string]struct{}, len(c.Nodes))
+	for _, nc := range c.Nodes {
+		if _, ok := names[nc.Name]; ok || nc.Name == "" {
+			logger.GetLogger().Fatal(
+				"Each node in the pipeline should have a unique name",
+				zap.String("Name", nc.Name),
+			)
+		}
+		names[nc.Name] = struct{}{}
+	}
+
+	// the nodes are created one by one because the config files are read using a global viper instance
+	nodes := make([]pipelineNode, 0, len(c.Nodes))
+	for _, nc := range c.Nodes {
+		node, err := createPipelineNode(nc, nodeRegisterer(prometheus.DefaultRegisterer, nc.Name))
+		if err != nil {
+			logger.GetLogger().Fatal(
+				"Error while creating the node",
+				zap.String("Name", nc.Name),
+				zap.String("Config file", nc.ConfigFile),
+				zap.String("Error", err.Error()),
+			)
+		}
+		nodes = append(nodes, node)
+	}
+
+	// create all publishers before the subscribers dial them
+	publishers := make([]mangos.Socket, len(c.Nodes))
+	for i, nc := range c.Nodes {
+		if strings.HasPrefix(nc.Type, "write") && nc.Type != "write nmea0183" {
+			continue // the NMEA 0183 writer can publish the sentences as raw messages
+		}
+		publishers[i] = nanomsg.NewPub(pipelineURL(nc.Name))
+		if nc.PublishURL != "" {
+			if err := publishers[i].Listen(nc.PublishURL); err != nil {
+				logger.GetLogger().Fatal(
+					"Could not listen on the URL",
+					zap.String("URL", nc.PublishURL),
+					zap.String("Error", err.Error()),
+				)
+			}
+		}
+	}
+
+	// create all subscribers before the nodes start publishing, nodes are dialed directly, external processes in
+	// the background because they might not be available yet
+	subscribers := make([]mangos.Socket, len(c.Nodes))
+	for i, nc := range c.Nodes {
+		if len(nc.Inputs) == 0 {
+			continue
+		}
+		external := make([]string, 0, len(nc.Inputs))
+		for _, input := range nc.Inputs {
+			if _, ok := names[input]; !ok {
+				external = append(external, input)
+			}
+		}
+		var err error
+		if subscribers[i], err = nanomsg.NewSubAsync(external, []byte{}); err != nil {
+			logger.GetLogger().Fatal(
+				"Could not subscribe",
+				zap.String("Name", nc.Name),
+				zap.Strings("URLs", external),
+				zap.String("Error", err.Error()),
+			)
+		}
+		for _, input := range nc.Inputs {
+			if _, ok := names[input]; !ok {
+				continue
+			}
+			if err := subscribers[i].Dial(pipelineURL(input)); err != nil {
+				logger.GetLogger().Fatal(
+					"Could not subscribe",
+					zap.String("Name", nc.Name),
+					zap.String("Input", input),
+					zap.String("Error", err.Error()),
+				)
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(len(nodes))
+	for i := range nodes {
+		go func(node pipelineNode, subscriber mangos.Socket, publisher mangos.Socket) {
+			defer wg.Done()
+			node(subscriber, publisher)
+		}(nodes[i], subscribers[i], publishers[i])
+	}
+	wg.Wait()
+}
+
+func pipelineURL(name string) string {
+	return "inproc://" + name
+}
+
+// nodeRegisterer adds the name of the node to the metrics of the node, nodes of the same type register the same metrics
+func nodeRegisterer(reg prometheus.Registerer, name string) prometheus.Registerer {
+	return prometheus.WrapRegistererWith(prometheus.Labels{"node": name}, reg)
+}
+
+// createPipelineNode creates the node of the config, the metrics of the node are registered with reg
+func createPipelineNode(nc config.PipelineNodeConfig, reg prometheus.Registerer) (pipelineNode, error) {
+	requireInputs := func(node pipelineNode) (pipelineNode, error) {
+		if len(nc.Inputs) == 0 {
+			return nil, fmt.Errorf("a node of type %s requires at least one input", nc.Type)
+		}
+		return node, nil
+	}
+
+	switch nc.Type {
+	case "connect":
+		conn, err := createConnector(nc.ConfigFile, reg)
+		if err != nil {
+			return nil, err
+		}
+		return func(subscriber mangos.Socket, publisher mangos.Socket) {
+			if subscriber != nil {
+				conn.Subscribe(subscriber)
+			}
+			conn.Publish(publisher)
+		}, nil
+	case "map":
+		m, err := createMapper(nc.ConfigFile, reg)
+		if err != nil {
+			return nil, err
+		}
+		return requireInputs(m.Map)
+	case "filter":
+		f, err := mapper.NewExpressionFilter(config.NewExpressionMappingConfig(nc.ConfigFile))
+		if err != nil {
+			return nil, err
+		}
+		return requireInputs(f.Map)
+	case "ratelimit":
+		f, err := mapper.NewRateLimitFilter(config.NewRateLimitConfig(nc.ConfigFile))
+		if err != nil {
+			return nil, err
+		}
+		return requireInputs(f.Map)
+	case "alarm":
+		m, err := mapper.NewAlarmMapper(config.NewAlarmConfig(nc.ConfigFile))
+		if err != nil {
+			return nil, err
+		}
+		return requireInputs(m.Map)
+	case "ais":
+		m, err := mapper.NewAISMapper(config.NewAISConfig(nc.ConfigFile))
+		if err != nil {
+			return nil, err
+		}
+		return requireInputs(m.Map)
+	case "read mqtt":
+		r := reader.NewMqttReader(config.NewMQTTConfig(nc.ConfigFile), reg)
+		return func(subscriber mangos.Socket, publisher mangos.Socket) {
+			r.ReadMapped(publisher)
+		}, nil
+	case "read signalk":
+		r := reader.NewSignalKReader(config.NewSignalKReaderConfig(nc.ConfigFile), reg)
+		return func(subscriber mangos.Socket, publisher mangos.Socket) {
+			r.ReadMapped(publisher)
+		}, nil
+	case "write database raw":
+		w := writer.NewPostgresqlWriter(config.NewPostgresqlConfig(nc.ConfigFile), reg)
+		return requireInputs(func(subscriber mangos.Socket, publisher mangos.Socket) {
+			go w.StartRawWorkers()
+			w.WriteRaw(subscriber)
+		})
+	case "write database mapped":
+		w := writer.NewPostgresqlWriter(config.NewPostgresqlConfig(nc.ConfigFile), reg)
+		return requireInputs(func(subscriber mangos.Socket, publisher mangos.Socket) {
+			go w.StartMappedWorkers()
+			w.WriteMapped(subscriber)
+		})
+	case "write mqtt":
+		w := writer.NewMqttWriter(config.NewMQTTConfig(nc.ConfigFile))
+		return requireInputs(func(subscriber mangos.Socket, publisher mangos.Socket) {
+			w.WriteMapped(subscriber)
+		})
+	case "write signalk":
+		w := writer.NewSignalKWriter(config.NewSignalKConfig(nc.ConfigFile).WithVersion(version.Version), reg)
+		return requireInputs(func(subscriber mangos.Socket, publisher mangos.Socket) {
+			w.WriteMapped(subscriber)
+		})
+	case "write stdout raw":
+		w := writer.NewStdOutWriter()
+		return requireInputs(func(subscriber mangos.Socket, publisher mangos.Socket) {
+			w.WriteRaw(subscriber)
+		})
+	case "write stdout mapped":
+		w := writer.NewStdOutWriter()
+		return requireInputs(func(subscriber mangos.Socket, publisher mangos.Socket) {
+			w.WriteMapped(subscriber)
+		})
+	case "write lwe":
+		w := writer.NewLWEWriter(config.NewLWEConfig(nc.ConfigFile))
+		return requireInputs(func(subscriber mangos.Socket, publisher mangos.Socket) {
+			w.WriteRaw(subscriber)
+		})
+	case "write modbus":
+		w := writer.NewModbusWriter(config.NewModbusServerConfig(nc.ConfigFile))
+		return requireInputs(func(subscriber mangos.Socket, publisher mangos.Socket) {
+			w.WriteMapped(subscriber)
+		})
+	case "write nmea0183":
+		w := writer.NewNMEA0183Writer(config.NewNMEA0183WriterConfig(nc.ConfigFile))
+		return requireInputs(func(subscriber mangos.Socket, publisher mangos.Socket) {
+			w.WithPublisher(publisher).WriteMapped(subscriber)
+		})
+	}
+	return nil, fmt.Errorf("not a supported node type %s", nc.Type)
+}
@@ -0,0 +1,43 @@
+package cmd_test
+
+import (
+	. "github.com/munnik/gosk/cmd"
+	"github.com/munnik/gosk/config"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ = Describe("Run", func() {
+	It("registers the metrics of nodes of the same type with the name of the node", func() {
+		reg := prometheus.NewRegistry()
+		for _, name := range []string{"first", "second"} {
+			nc := config.PipelineNodeConfig{Name: name, Type: "read signalk", ConfigFile: "../config/reader/sample-signalk.yaml"}
+			node, err := CreatePipelineNode(nc, NodeRegisterer(reg, nc.Name))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(node).NotTo(BeNil())
+		}
+
+		families, err := reg.Gather()
+		Expect(err).NotTo(HaveOccurred())
+		nodes := make(map[string][]string)
+		for _, family := range families {
+			for _, metric := range family.GetMetric() {
+				for _, label := range metric.GetLabel() {
+					if label.GetName() == "node" {
+						nodes[family.GetName()] = append(nodes[family.GetName()], label.GetValue())
+					}
+				}
+			}
+		}
+		Expect(nodes).To(HaveKeyWithValue("gosk_signalk_reconnects_total", ConsistOf("first", "second")))
+	})
+	It("requires inputs for nodes that consume messages", func() {
+		_, err := CreatePipelineNode(config.PipelineNodeConfig{Name: "stdout", Type: "write stdout raw"}, prometheus.NewRegistry())
+		Expect(err).To(HaveOccurred())
+	})
+	It("fails for an unsupported type", func() {
+		_, err := CreatePipelineNode(config.PipelineNodeConfig{Name: "unknown", Type: "write unknown", Inputs: []string{"first"}}, prometheus.NewRegistry())
+		Expect(err).To(HaveOccurred())
+	})
+})
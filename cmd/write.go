@@ -24,6 +24,7 @@ import (
 	"github.com/munnik/gosk/nanomsg"
 	"github.com/munnik/gosk/version"
 	"github.com/munnik/gosk/writer"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/cobra"
 )
 
@@ -85,6 +86,18 @@ var (
 		Long:  `Write messages to an UDP multicast group according to the LWE (IEC 61162-450) protocol`,
 		Run:   doWriteLWE,
 	}
+	writeModbusCmd = &cobra.Command{
+		Use:   "modbus",
+		Short: "Expose mapped messages as Modbus registers",
+		Long:  `Starts a Modbus TCP or RTU server that exposes the values of mapped messages as coils and registers`,
+		Run:   doWriteModbus,
+	}
+	writeNMEA0183Cmd = &cobra.Command{
+		Use:   "nmea0183",
+		Short: "Write mapped messages as NMEA 0183 sentences",
+		Long:  `Write mapped messages as NMEA 0183 sentences to a TCP, UDP or serial connection, or publish them as raw messages`,
+		Run:   doWriteNMEA0183,
+	}
 )
 
 func init() {
@@ -117,6 +130,15 @@ func init() {
 	writeCmd.AddCommand(writeLWECmd)
 	writeLWECmd.Flags().StringVarP(&subscribeURL, "subscribeURL", "s", "", "Nanomsg URL, the URL is used to listen for subscribed data.")
 	writeLWECmd.MarkFlagRequired("subscribeURL")
+
+	writeCmd.AddCommand(writeModbusCmd)
+	writeModbusCmd.Flags().StringVarP(&subscribeURL, "subscribeURL", "s", "", "Nanomsg URL, the URL is used to listen for subscribed data.")
+	writeModbusCmd.MarkFlagRequired("subscribeURL")
+
+	writeCmd.AddCommand(writeNMEA0183Cmd)
+	writeNMEA0183Cmd.Flags().StringVarP(&subscribeURL, "subscribeURL", "s", "", "Nanomsg URL, the URL is used to listen for subscribed data.")
+	writeNMEA0183Cmd.MarkFlagRequired("subscribeURL")
+	writeNMEA0183Cmd.Flags().StringVarP(&publishURL, "publishURL", "p", "", "Nanomsg URL, the URL is used to publish the sentences as raw messages when no URL is configured.")
 }
 
 func doWriteDatabaseRaw(cmd *cobra.Command, args []string) {
@@ -129,7 +151,7 @@ func doWriteDatabaseRaw(cmd *cobra.Command, args []string) {
 		)
 	}
 	c := config.NewPostgresqlConfig(cfgFile)
-	w := writer.NewPostgresqlWriter(c)
+	w := writer.NewPostgresqlWriter(c, prometheus.DefaultRegisterer)
 	go w.StartRawWorkers()
 	w.WriteRaw(subscriber)
 }
@@ -144,7 +166,7 @@ func doWriteDatabaseMapped(cmd *cobra.Command, args []string) {
 		)
 	}
 	c := config.NewPostgresqlConfig(cfgFile)
-	w := writer.NewPostgresqlWriter(c)
+	w := writer.NewPostgresqlWriter(c, prometheus.DefaultRegisterer)
 	go w.StartMappedWorkers()
 	w.WriteMapped(subscriber)
 }
@@ -173,7 +195,7 @@ func doWriteSignalK(cmd *cobra.Command, args []string) {
 		)
 	}
 	c := config.NewSignalKConfig(cfgFile).WithVersion(version.Version)
-	s := writer.NewSignalKWriter(c)
+	s := writer.NewSignalKWriter(c, prometheus.DefaultRegisterer)
 	s.WriteMapped(subscriber)
 }
 
@@ -191,6 +213,37 @@ func doWriteLWE(cmd *cobra.Command, args []string) {
 	w.WriteRaw(subscriber)
 }
 
+func doWriteModbus(cmd *cobra.Command, args []string) {
+	subscriber, err := nanomsg.NewSub(subscribeURL, []byte{})
+	if err != nil {
+		logger.GetLogger().Fatal(
+			"Could not subscribe to the URL",
+			zap.String("URL", subscribeURL),
+			zap.String("Error", err.Error()),
+		)
+	}
+	c := config.NewModbusServerConfig(cfgFile)
+	w := writer.NewModbusWriter(c)
+	w.WriteMapped(subscriber)
+}
+
+func doWriteNMEA0183(cmd *cobra.Command, args []string) {
+	subscriber, err := nanomsg.NewSub(subscribeURL, []byte{})
+	if err != nil {
+		logger.GetLogger().Fatal(
+			"Could not subscribe to the URL",
+			zap.String("URL", subscribeURL),
+			zap.String("Error", err.Error()),
+		)
+	}
+	c := config.NewNMEA0183WriterConfig(cfgFile)
+	w := writer.NewNMEA0183Writer(c)
+	if publishURL != "" {
+		w.WithPublisher(nanomsg.NewPub(publishURL))
+	}
+	w.WriteMapped(subscriber)
+}
+
 func doWriteStdOutMapped(cmd *cobra.Command, args []string) {
 	subscriber, err := nanomsg.NewSub(subscribeURL, []byte{})
 	if err != nil {
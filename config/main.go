@@ -3,11 +3,13 @@ package config
 import (
 	"fmt"
 	"net/url"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/antonmedv/expr/vm"
 	"github.com/munnik/gosk/logger"
+	"github.com/munnik/gosk/message"
 	"github.com/munnik/gosk/protocol"
 	"go.uber.org/zap"
 )
@@ -17,17 +19,28 @@ const (
 	NMEA0183Type = "nmea0183"
 	// ModbusType is used to identify the data as Modbus data
 	ModbusType = "modbus"
+	// ModbusHealthType is used to identify the data as the health of the register groups of a Modbus connector
+	ModbusHealthType = "modbus_health"
+	// ModbusAcknowledgementType is used to identify the data as the result of a command that is written by a Modbus
+	// connector
+	ModbusAcknowledgementType = "modbus_acknowledgement"
 	// CSVType is used to identify the data as comma separated values data
 	CSVType = "csv"
 	// JSONType is used to identify the data as json messages
 	JSONType = "json"
 
 	CanBusType = "canbus"
+	// NMEA2000Type is used to identify the data as NMEA 2000 PGNs received via a CanBus connector
+	NMEA2000Type = "nmea2000"
 
 	SignalKType = "signalk"
 
 	HttpType = "http"
 
+	// LWEType is used to receive NMEA 0183 sentences from IEC 61162-450 multicast groups, the received data has the
+	// NMEA0183Type
+	LWEType = "lwe"
+
 	ParityMap string = "NOE" // None, Odd, Even
 )
 
@@ -42,6 +55,11 @@ type ConnectorConfig struct {
 	Parity       int      `mapstructure:"_"`
 	ParityString string   `mapstructure:"parity"`
 	Protocol     string   `mapstructure:"protocol"`
+	// modbus only, the device information functions and the mask write and read/write multiple registers commands
+	// are not supported by the Modbus library. They are sent on a second TCP connection to the slave, on a serial line
+	// the port is borrowed from the library. Many devices accept only one or two connections, so this is disabled by
+	// default.
+	ExtraConnection bool `mapstructure:"extraConnection"`
 }
 
 func NewConnectorConfig(configFilePath string) *ConnectorConfig {
@@ -65,10 +83,79 @@ type RegisterGroupConfig struct {
 	PollingInterval       time.Duration `mapstructure:"pollingInterval"`
 }
 
+// NewRegisterGroupsConfig reads the register groups, when a mappings file is configured the register groups of the
+// mappings are derived and added
 func NewRegisterGroupsConfig(configFilePath string) []RegisterGroupConfig {
 	var result []RegisterGroupConfig
 	readConfigFile(&result, configFilePath, "registerGroups")
+	optimization := NewRegisterGroupsOptimizationConfig(configFilePath)
+	if optimization.MappingsFile != "" {
+		result = append(result, optimization.RegisterGroups(NewModbusMappingsConfig(optimization.MappingsFile))...)
+	}
+	for i := range result {
+		if result[i].NumberOfCoilsOrRegisters == 0 {
+			result[i].NumberOfCoilsOrRegisters = 1
+		}
+		if result[i].PollingInterval <= 0 && protocol.IsModbusDeviceFunction(result[i].FunctionCode) {
+			// the device information rarely changes, it is also read after every reconnect
+			result[i].PollingInterval = time.Hour
+		}
+		if result[i].PollingInterval <= 0 {
+			result[i].PollingInterval = time.Second
+		}
+	}
+
+	return result
+}
+
+// RegisterGroupsOptimizationConfig derives the register groups from the mappings of a Modbus mapper, the mapped
+// addresses of the same slave, function code and polling interval are read in as few requests as possible
+type RegisterGroupsOptimizationConfig struct {
+	MappingsFile    string        `mapstructure:"mappingsFile"`    // configuration file of the Modbus mapper
+	MaximumGap      uint16        `mapstructure:"maximumGap"`      // number of unmapped coils or registers that may be read to combine two requests
+	MaximumLength   uint16        `mapstructure:"maximumLength"`   // maximum number of coils or registers of a request, 0 is the Modbus maximum
+	PollingInterval time.Duration `mapstructure:"pollingInterval"` // used for the mappings without a polling interval
+}
+
+func NewRegisterGroupsOptimizationConfig(configFilePath string) *RegisterGroupsOptimizationConfig {
+	result := &RegisterGroupsOptimizationConfig{
+		MaximumGap:      10,
+		PollingInterval: time.Second,
+	}
+	readConfigFile(result, configFilePath, "registerGroupsOptimization")
+
+	return result
+}
+
+// RegisterGroups returns the minimal register groups that read all mapped addresses, mappings with a function code that
+// can't be read are ignored
+func (o *RegisterGroupsOptimizationConfig) RegisterGroups(mappings []ModbusMappingsConfig) []RegisterGroupConfig {
+	headers := make(map[time.Duration][]protocol.ModbusHeader)
+	for _, m := range mappings {
+		switch m.FunctionCode {
+		case protocol.READ_COILS, protocol.READ_DISCRETE_INPUTS, protocol.READ_HOLDING_REGISTERS, protocol.READ_INPUT_REGISTERS:
+		default:
+			continue
+		}
+		pollingInterval := m.PollingInterval
+		if pollingInterval <= 0 {
+			pollingInterval = o.PollingInterval
+		}
+		headers[pollingInterval] = append(headers[pollingInterval], m.ModbusHeader)
+	}
+
+	pollingIntervals := make([]time.Duration, 0, len(headers))
+	for pollingInterval := range headers {
+		pollingIntervals = append(pollingIntervals, pollingInterval)
+	}
+	sort.Slice(pollingIntervals, func(i, j int) bool { return pollingIntervals[i] < pollingIntervals[j] })
 
+	var result []RegisterGroupConfig
+	for _, pollingInterval := range pollingIntervals {
+		for _, h := range protocol.CoalesceModbusHeaders(headers[pollingInterval], o.MaximumGap, o.MaximumLength) {
+			result = append(result, RegisterGroupConfig{ModbusHeader: h, PollingInterval: pollingInterval})
+		}
+	}
 	return result
 }
 
@@ -81,6 +168,19 @@ func (rgc *RegisterGroupConfig) ExtractModbusHeader() *protocol.ModbusHeader {
 	}
 }
 
+// LWEGroupConfig is an IEC 61162-450 multicast group, e.g. 239.192.0.4:60004 for the NAVD group
+type LWEGroupConfig struct {
+	Address   string `mapstructure:"address"`
+	Interface string `mapstructure:"interface"` // name of the network interface to join the group on, the default interface is used when empty
+}
+
+func NewLWEGroupsConfig(configFilePath string) []LWEGroupConfig {
+	var result []LWEGroupConfig
+	readConfigFile(&result, configFilePath, "lweGroups")
+
+	return result
+}
+
 type UrlGroupConfig struct {
 	Url             string        `mapstructure:"url"`
 	PollingInterval time.Duration `mapstructure:"pollingInterval"`
@@ -94,13 +194,39 @@ func NewUrlGroupsConfig(configFilePath string) []UrlGroupConfig {
 }
 
 const (
-	ProtocolOptionNmeaParse = "nmea_parse"
+	ProtocolOptionNmeaParse             = "nmea_parse"
+	ProtocolOptionNmeaReassemblyTimeout = "nmea_reassembly_timeout" // incomplete sequences of sentences are dropped after this duration
 )
 
 type MapperConfig struct {
 	Context         string            `mapstructure:"context"`
 	Protocol        string            `mapstructure:"protocol"`
 	ProtocolOptions map[string]string `mapstructure:"protocol_options"`
+	Validation      *ValidationConfig `mapstructure:"validation"` // optional, the mapped values are validated against the SignalK metadata
+}
+
+const (
+	ValidationPolicyReject  = "reject"  // remove invalid values from the delta
+	ValidationPolicyFlag    = "flag"    // log invalid values but publish them anyway
+	ValidationPolicyConvert = "convert" // convert values to the SignalK unit if possible, otherwise remove them
+)
+
+type ValidationConfig struct {
+	Policy       string        `mapstructure:"policy"`   // reject, flag or convert
+	MetadataFile string        `mapstructure:"metadata"` // keyswithmetadata.json of the SignalK specification, a built-in subset is used when empty
+	Units        []UnitConfig  `mapstructure:"units"`    // units of mapped values that differ from the SignalK unit, these values are always converted
+	Ranges       []RangeConfig `mapstructure:"ranges"`   // valid ranges of paths, replaces the default range of the SignalK unit
+}
+
+type UnitConfig struct {
+	Path string `mapstructure:"path"`
+	Unit string `mapstructure:"unit"` // e.g. kn, deg, C, %, rpm, bar, l or l/h
+}
+
+type RangeConfig struct {
+	Path string  `mapstructure:"path"`
+	Min  float64 `mapstructure:"min"` // in the SignalK unit of the path
+	Max  float64 `mapstructure:"max"` // in the SignalK unit of the path
 }
 
 func NewMapperConfig(configFilePath string) MapperConfig {
@@ -139,7 +265,8 @@ type MappingConfig struct {
 	Expression            string                 `mapstructure:"expression"`
 	ExpressionEnvironment map[string]interface{} `mapstructure:"expressionEnvironment"`
 	CompiledExpression    *vm.Program
-	Path                  string `mapstructure:"path"`
+	Path                  string        `mapstructure:"path"`
+	Meta                  *message.Meta `mapstructure:"meta"` // metadata of the path, sent along with the mapped values
 }
 
 func (m *MappingConfig) verify() {
@@ -157,16 +284,47 @@ func (m *MappingConfig) verify() {
 	}
 }
 
+// ModbusMappingsConfig maps registers or coils to a path, when the data type is set the registers are decoded and the
+// result is available in the expression as value
 type ModbusMappingsConfig struct {
 	MappingConfig         `mapstructure:",squash"`
 	protocol.ModbusHeader `mapstructure:",squash"`
+	DataType              string        `mapstructure:"dataType"`        // int16, uint16, int32, uint32, int64, float32, float64, string, bcd or bitfield
+	ByteOrder             string        `mapstructure:"byteOrder"`       // bigEndian or littleEndian, the order of the bytes in a register
+	WordOrder             string        `mapstructure:"wordOrder"`       // bigEndian or littleEndian, the order of the registers
+	Scale                 float64       `mapstructure:"scale"`           // the decoded number is multiplied with the scale, 0 is handled as 1
+	Offset                float64       `mapstructure:"offset"`          // added to the decoded number after scaling
+	PollingInterval       time.Duration `mapstructure:"pollingInterval"` // used when the connector derives the register groups from the mappings
+}
+
+// verify rejects a mapping with a data type of which the number of registers isn't known, the number of registers of
+// strings, BCD numbers and bitfields has to be configured
+func (m *ModbusMappingsConfig) verify() {
+	m.MappingConfig.verify()
+	if m.DataType != "" && m.NumberOfCoilsOrRegisters == 0 {
+		logger.GetLogger().Fatal(
+			"Number of coils or registers was not set and can't be derived from the data type",
+			zap.String("Data type", m.DataType),
+			zap.String("Register mapping", fmt.Sprintf("%+v", m)),
+		)
+	}
 }
 
 func NewModbusMappingsConfig(configFilePath string) []ModbusMappingsConfig {
 	var result []ModbusMappingsConfig
 	readConfigFile(&result, configFilePath, "mappings")
-	for _, rmc := range result {
-		rmc.verify()
+	for i := range result {
+		if result[i].DataType != "" {
+			if result[i].Expression == "" {
+				result[i].Expression = "value"
+			}
+			if result[i].NumberOfCoilsOrRegisters == 0 {
+				// strings, BCD numbers and bitfields need the number of registers
+				n, _ := protocol.RegistersForEncoding(result[i].DataType)
+				result[i].NumberOfCoilsOrRegisters = uint16(n)
+			}
+		}
+		result[i].verify()
 	}
 	return result
 }
@@ -217,6 +375,110 @@ func NewExpressionMappingConfig(configFilePath string) []ExpressionMappingConfig
 	return result
 }
 
+const (
+	NotificationStateNormal    = "normal"
+	NotificationStateAlert     = "alert"
+	NotificationStateWarn      = "warn"
+	NotificationStateAlarm     = "alarm"
+	NotificationStateEmergency = "emergency"
+)
+
+type AlarmConfig struct {
+	AcknowledgeURL string            `mapstructure:"acknowledge_url"` // nanomsg URL of the SignalK writer, acknowledgements are received on this URL
+	Rules          []AlarmRuleConfig `mapstructure:"rules"`
+}
+
+// AlarmRuleConfig raises a notification on the path when a condition on the source paths is met. The expression
+// uses the same environment as the aggregate mapper and returns a bool or the state of the notification.
+type AlarmRuleConfig struct {
+	ExpressionMappingConfig `mapstructure:",squash"`
+	Message                 string            `mapstructure:"message"`
+	Method                  []string          `mapstructure:"method"`
+	State                   string            `mapstructure:"state"`        // state when the expression, the rate of change or the stale timeout triggers
+	Zones                   []AlarmZoneConfig `mapstructure:"zones"`        // thresholds on the value of the first source path
+	Hysteresis              float64           `mapstructure:"hysteresis"`   // the value has to leave a zone by this amount before the zone is left
+	RateOfChange            float64           `mapstructure:"rateOfChange"` // maximum change of the value of the first source path per second, 0 to disable
+	StaleTimeout            time.Duration     `mapstructure:"staleTimeout"` // maximum time without updates of the first source path, 0 to disable
+}
+
+type AlarmZoneConfig struct {
+	Lower   *float64 `mapstructure:"lower"`
+	Upper   *float64 `mapstructure:"upper"`
+	State   string   `mapstructure:"state"`
+	Message string   `mapstructure:"message"`
+}
+
+func NewAlarmConfig(configFilePath string) *AlarmConfig {
+	result := &AlarmConfig{}
+	readConfigFile(result, configFilePath)
+
+	for i := range result.Rules {
+		r := &result.Rules[i]
+		if len(r.SourcePaths) == 0 {
+			logger.GetLogger().Warn(
+				"Source paths were not set",
+				zap.String("Alarm rule", fmt.Sprintf("%+v", r)),
+			)
+			continue
+		}
+		if r.Path == "" {
+			r.Path = "notifications." + r.SourcePaths[0]
+		}
+		if r.Method == nil {
+			r.Method = []string{"visual", "sound"}
+		}
+		if r.State == "" {
+			r.State = NotificationStateAlarm
+		}
+		if r.Expression == "" && len(r.Zones) == 0 && r.RateOfChange == 0 && r.StaleTimeout == 0 {
+			logger.GetLogger().Warn(
+				"The alarm rule has no conditions",
+				zap.String("Alarm rule", fmt.Sprintf("%+v", r)),
+			)
+		}
+	}
+	return result
+}
+
+// AISConfig is the configuration of the AIS target tracker, the closest point of approach of each target is
+// calculated against the self context
+type AISConfig struct {
+	SelfContext  string          `mapstructure:"self_context"`
+	LostTimeout  time.Duration   `mapstructure:"lost_timeout"` // targets without position reports for this long are removed
+	URLString    string          `mapstructure:"url"`          // the targets are served as GeoJSON when set
+	URL          *url.URL        `mapstructure:"_"`
+	Method       []string        `mapstructure:"method"`
+	Zones        []AISZoneConfig `mapstructure:"zones"`
+	MaxTimeToCPA time.Duration   `mapstructure:"max_time_to_cpa"` // closest approaches further in the future are ignored
+}
+
+// AISZoneConfig raises a notification when the closest point of approach of a target is within the distance
+type AISZoneConfig struct {
+	Distance float64 `mapstructure:"distance"` // in meters
+	State    string  `mapstructure:"state"`
+	Message  string  `mapstructure:"message"`
+}
+
+func NewAISConfig(configFilePath string) *AISConfig {
+	result := &AISConfig{
+		SelfContext:  "vessels.self",
+		LostTimeout:  6 * time.Minute,
+		MaxTimeToCPA: 30 * time.Minute,
+	}
+	readConfigFile(result, configFilePath)
+
+	result.URL, _ = url.Parse(result.URLString)
+	if result.Method == nil {
+		result.Method = []string{"visual", "sound"}
+	}
+	for i := range result.Zones {
+		if result.Zones[i].State == "" {
+			result.Zones[i].State = NotificationStateAlarm
+		}
+	}
+	return result
+}
+
 type CanBusMappingConfig struct {
 	MappingConfig `mapstructure:",squash"`
 	Name          string `mapstructure:"name"`
@@ -238,6 +500,21 @@ type MQTTConfig struct {
 	Password   string        `mapstructure:"password"`
 	Interval   time.Duration `mapstructure:"interval"`    // interval to flush the cache in seconds, ignored for reader
 	BufferSize int           `mapstructure:"buffer_size"` // maximum size of the cache in MBs, cache will be flushed when size is reached, ignored for reader
+	Spool      *SpoolConfig  `mapstructure:"spool"`       // optional disk backed buffer for batches that are not confirmed by the broker, ignored for reader
+}
+
+const (
+	FsyncAlways   = "always"   // sync after every write
+	FsyncInterval = "interval" // sync periodically
+	FsyncNever    = "never"    // leave it to the operating system
+)
+
+type SpoolConfig struct {
+	Directory     string        `mapstructure:"directory"`
+	MaxSize       int64         `mapstructure:"max_size"`     // maximum size of all segments in MBs, the oldest segments are removed when this size is reached
+	SegmentSize   int64         `mapstructure:"segment_size"` // maximum size of a segment in MBs
+	Fsync         string        `mapstructure:"fsync"`        // always, interval or never
+	FsyncInterval time.Duration `mapstructure:"fsync_interval"`
 }
 
 func NewMQTTConfig(configFilePath string) *MQTTConfig {
@@ -246,6 +523,56 @@ func NewMQTTConfig(configFilePath string) *MQTTConfig {
 		Interval:   30 * time.Second,
 	}
 	readConfigFile(&result, configFilePath)
+	if result.Spool != nil {
+		if result.Spool.MaxSize == 0 {
+			result.Spool.MaxSize = 1024
+		}
+		if result.Spool.SegmentSize == 0 {
+			result.Spool.SegmentSize = 16
+		}
+		if result.Spool.Fsync == "" {
+			result.Spool.Fsync = FsyncAlways
+		}
+		if result.Spool.FsyncInterval == 0 {
+			result.Spool.FsyncInterval = time.Second
+		}
+	}
+
+	return &result
+}
+
+// SignalKReaderConfig is the configuration of a reader that receives deltas from another SignalK server
+type SignalKReaderConfig struct {
+	URLString    string                   `mapstructure:"url"` // http or https URL of the server, the stream and api paths are added
+	URL          *url.URL                 `mapstructure:"_"`
+	Context      string                   `mapstructure:"context"`       // replaces vessels.self, the self context of the server is used when empty
+	Subscribe    []SignalKSubscribeConfig `mapstructure:"subscribe"`     // the server decides what is sent when empty
+	PollInterval time.Duration            `mapstructure:"poll_interval"` // interval to request the full data model of self, disabled when zero
+	MinBackoff   time.Duration            `mapstructure:"min_backoff"`   // time to wait before the first reconnect
+	MaxBackoff   time.Duration            `mapstructure:"max_backoff"`   // the time between reconnects doubles until this maximum
+}
+
+type SignalKSubscribeConfig struct {
+	Context   string        `mapstructure:"context"` // vessels.self when empty
+	Path      string        `mapstructure:"path"`    // may contain * as a wildcard
+	Period    time.Duration `mapstructure:"period"`
+	MinPeriod time.Duration `mapstructure:"min_period"`
+	Policy    string        `mapstructure:"policy"` // instant, ideal or fixed
+}
+
+func NewSignalKReaderConfig(configFilePath string) *SignalKReaderConfig {
+	result := SignalKReaderConfig{
+		MinBackoff: time.Second,
+		MaxBackoff: time.Minute,
+	}
+	readConfigFile(&result, configFilePath)
+
+	result.URL, _ = url.Parse(result.URLString)
+	for i := range result.Subscribe {
+		if result.Subscribe[i].Context == "" {
+			result.Subscribe[i].Context = "vessels.self"
+		}
+	}
 
 	return &result
 }
@@ -288,12 +615,50 @@ func NewBigCacheConfig(configFilePath string) *BigCacheConfig {
 }
 
 type SignalKConfig struct {
-	URLString        string            `mapstructure:"url"`
-	URL              *url.URL          `mapstructure:"_"`
-	Version          string            `mapstructure:"_"`
-	SelfContext      string            `mapstructure:"self_context"`
-	PostgresqlConfig *PostgresqlConfig `mapstructure:"database"`
-	BigCacheConfig   *BigCacheConfig   `mapstructure:"cache"`
+	URLString        string                 `mapstructure:"url"`
+	URL              *url.URL               `mapstructure:"_"`
+	Version          string                 `mapstructure:"_"`
+	SelfContext      string                 `mapstructure:"self_context"`
+	PostgresqlConfig *PostgresqlConfig      `mapstructure:"database"`
+	BigCacheConfig   *BigCacheConfig        `mapstructure:"cache"`
+	PutMappings      []PutMappingConfig     `mapstructure:"put"`
+	AcknowledgeURL   string                 `mapstructure:"acknowledge_url"` // nanomsg URL to publish PUT requests on notifications to, the alarm stage subscribes to this URL
+	Meta             []MetaConfig           `mapstructure:"meta"`            // metadata of the paths of the self context, overrides the metadata received from the mappers
+	SourcePriorities []SourcePriorityConfig `mapstructure:"source_priorities"`
+}
+
+// defaultSourceTimeout is used when no timeout is configured for a source of a source priority
+const defaultSourceTimeout = 10 * time.Second
+
+// SourcePriorityConfig selects the value of a path when multiple sources send values for the path. The value of the
+// first source that sent a value within its timeout is used, when all values are older the newest value is used.
+// Sources that are not listed have the lowest priority.
+type SourcePriorityConfig struct {
+	Path    string                `mapstructure:"path"` // may contain * as a wildcard
+	Sources []SourceTimeoutConfig `mapstructure:"sources"`
+}
+
+type SourceTimeoutConfig struct {
+	Source  string        `mapstructure:"source"` // label of the source
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// MetaConfig is the SignalK metadata of a path
+type MetaConfig struct {
+	Path         string `mapstructure:"path"`
+	message.Meta `mapstructure:",squash"`
+}
+
+// PutMappingConfig is a reverse mapping, it maps a value of a SignalK PUT request to a raw command for a connector.
+// The expression gets the requested value as `value` and the same functions as the expressions of the mappers, for
+// Modbus the result is a number, a bool or a list of those, for NMEA 0183 the result is inserted in the template.
+type PutMappingConfig struct {
+	MappingConfig         `mapstructure:",squash"`
+	protocol.ModbusHeader `mapstructure:",squash"`
+	URLString             string `mapstructure:"url"`       // nanomsg URL to publish the raw command on, the connector subscribes to this URL
+	Connector             string `mapstructure:"connector"` // name of the connector
+	Protocol              string `mapstructure:"protocol"`
+	Template              string `mapstructure:"template"` // NMEA 0183 sentence template, e.g. "$IIXDR,A,{{.}},D,RUDDER"
 }
 
 func NewSignalKConfig(configFilePath string) *SignalKConfig {
@@ -305,6 +670,16 @@ func NewSignalKConfig(configFilePath string) *SignalKConfig {
 	readConfigFile(&result, configFilePath)
 
 	result.URL, _ = url.Parse(result.URLString)
+	for _, pmc := range result.PutMappings {
+		pmc.verify()
+	}
+	for _, spc := range result.SourcePriorities {
+		for i := range spc.Sources {
+			if spc.Sources[i].Timeout <= 0 {
+				spc.Sources[i].Timeout = defaultSourceTimeout
+			}
+		}
+	}
 
 	return &result
 }
@@ -352,6 +727,103 @@ func NewLWEConfig(configFilePath string) *LWEConfig {
 	return result
 }
 
+// NMEA0183WriterConfig is the configuration of the writer that encodes mapped values as NMEA 0183 sentences
+type NMEA0183WriterConfig struct {
+	Context   string                   `mapstructure:"context"` // only values of this context are encoded
+	URLString string                   `mapstructure:"url"`     // tcp (listen), udp (broadcast) or file (serial), raw messages are published when empty
+	URL       *url.URL                 `mapstructure:"_"`
+	BaudRate  int                      `mapstructure:"baudRate"`
+	Connector string                   `mapstructure:"connector"` // name of the connector of the published raw messages
+	Sentences []NMEA0183SentenceConfig `mapstructure:"sentences"`
+}
+
+type NMEA0183SentenceConfig struct {
+	Type        string                `mapstructure:"type"`      // RMC, GGA, HDT, VTG, DBT, MWV, XDR, RSA or ROT
+	TalkerID    string                `mapstructure:"talker_id"` // the common talker ID of the sentence is used when empty
+	Interval    time.Duration         `mapstructure:"interval"`
+	Timeout     time.Duration         `mapstructure:"timeout"`     // values that are older are not used
+	Transducers []XDRTransducerConfig `mapstructure:"transducers"` // only used for XDR
+}
+
+// XDRTransducerConfig is a measurement in a XDR sentence, the value is converted to the unit of the transducer type
+type XDRTransducerConfig struct {
+	Path string `mapstructure:"path"`
+	Type string `mapstructure:"type"` // C temperature, P pressure, A angle, U voltage, I current, H humidity, T tachometer or G generic
+	Name string `mapstructure:"name"`
+}
+
+func NewNMEA0183WriterConfig(configFilePath string) *NMEA0183WriterConfig {
+	result := &NMEA0183WriterConfig{
+		BaudRate:  4800,
+		Connector: "nmea0183",
+	}
+	readConfigFile(result, configFilePath)
+
+	result.URL, _ = url.Parse(result.URLString)
+	for i := range result.Sentences {
+		if result.Sentences[i].Interval <= 0 {
+			result.Sentences[i].Interval = time.Second
+		}
+		if result.Sentences[i].Timeout <= 0 {
+			result.Sentences[i].Timeout = 5 * time.Second
+		}
+	}
+
+	return result
+}
+
+// ModbusServerConfig is the configuration of the writer that exposes mapped values as registers of a Modbus server
+type ModbusServerConfig struct {
+	Context      string                 `mapstructure:"context"` // only values of this context are exposed
+	URLString    string                 `mapstructure:"url"`     // tcp://0.0.0.0:502 for Modbus TCP or file:///dev/ttyUSB0 for Modbus RTU
+	URL          *url.URL               `mapstructure:"_"`
+	Slave        uint8                  `mapstructure:"slave"` // unit id of the server, only used for Modbus RTU
+	BaudRate     int                    `mapstructure:"baudRate"`
+	DataBits     int                    `mapstructure:"dataBits"`
+	StopBits     int                    `mapstructure:"stopBits"`
+	Parity       int                    `mapstructure:"_"`
+	ParityString string                 `mapstructure:"parity"`
+	Registers    []ModbusRegisterConfig `mapstructure:"registers"`
+}
+
+// ModbusRegisterConfig binds a SignalK path to an address, the function code that is used to read the address
+// determines the table, 1 for coils, 2 for discrete inputs, 3 for holding registers and 4 for input registers
+type ModbusRegisterConfig struct {
+	Path         string  `mapstructure:"path"`
+	FunctionCode uint16  `mapstructure:"functionCode"`
+	Address      uint16  `mapstructure:"address"`
+	Encoding     string  `mapstructure:"encoding"`  // int16, uint16, int32, uint32, int64, float32 or float64, not used for coils and discrete inputs
+	WordOrder    string  `mapstructure:"wordOrder"` // bigEndian or littleEndian, only used for 32 bit encodings
+	Scale        float64 `mapstructure:"scale"`     // the value is multiplied with the scale before it is encoded
+}
+
+func NewModbusServerConfig(configFilePath string) *ModbusServerConfig {
+	result := &ModbusServerConfig{
+		Slave:        1,
+		BaudRate:     9600,
+		DataBits:     8,
+		StopBits:     1,
+		ParityString: "E",
+	}
+	readConfigFile(result, configFilePath)
+
+	result.URL, _ = url.Parse(result.URLString)
+	result.Parity = strings.Index(ParityMap, result.ParityString)
+	for i := range result.Registers {
+		if result.Registers[i].Encoding == "" {
+			result.Registers[i].Encoding = protocol.MODBUS_ENCODING_UINT16
+		}
+		if result.Registers[i].WordOrder == "" {
+			result.Registers[i].WordOrder = protocol.MODBUS_WORD_ORDER_BIG_ENDIAN
+		}
+		if result.Registers[i].Scale == 0 {
+			result.Registers[i].Scale = 1
+		}
+	}
+
+	return result
+}
+
 type EventConfig struct {
 	Expression string `mapstructure:"expression"`
 }
@@ -392,3 +864,22 @@ func NewTestDataConfig(configFilePath string) *TestDataConfig {
 
 	return result
 }
+
+type PipelineNodeConfig struct {
+	Name       string   `mapstructure:"name"`
+	Type       string   `mapstructure:"type"`       // same as the command, e.g. "connect", "map" or "write database raw"
+	ConfigFile string   `mapstructure:"config"`     // config file used by the node
+	Inputs     []string `mapstructure:"inputs"`     // names of other nodes or nanomsg URLs of external processes
+	PublishURL string   `mapstructure:"publishURL"` // optional nanomsg URL to make the output available to external processes
+}
+
+type PipelineConfig struct {
+	Nodes []PipelineNodeConfig `mapstructure:"nodes"`
+}
+
+func NewPipelineConfig(configFilePath string) *PipelineConfig {
+	result := &PipelineConfig{}
+	readConfigFile(result, configFilePath)
+
+	return result
+}
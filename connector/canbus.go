@@ -1,16 +1,18 @@
 package connector
 
 import (
-	"encoding/binary"
+	"io"
+	"time"
 
 	"github.com/munnik/gosk/config"
 	"github.com/munnik/gosk/logger"
+	"github.com/munnik/gosk/protocol"
 	"go.nanomsg.org/mangos/v3"
 	"go.uber.org/zap"
-
-	"github.com/brutella/can"
 )
 
+// CanBusConnector reads the classic CAN, CAN FD and error frames of the interface, the frames are sent in the raw
+// format of protocol.CanFrameToBytes
 type CanBusConnector struct {
 	config *config.ConnectorConfig
 }
@@ -26,10 +28,11 @@ func (r *CanBusConnector) Publish(publisher mangos.Socket) {
 		for {
 			if err := r.receive(stream); err != nil {
 				logger.GetLogger().Warn(
-					"Error while receiving data for the stream",
+					"Error while receiving data for the stream, retrying in 5 seconds",
 					zap.String("URL", r.config.URL.String()),
 					zap.String("Error", err.Error()),
 				)
+				time.Sleep(5 * time.Second)
 			}
 		}
 	}()
@@ -41,37 +44,31 @@ func (*CanBusConnector) Subscribe(subscriber mangos.Socket) {
 }
 
 func (r *CanBusConnector) receive(stream chan<- []byte) error {
-	bus, err := can.NewBusForInterfaceWithName(r.config.URL.Host)
+	socket, err := openCanSocket(r.config.URL.Host)
 	if err != nil {
 		return err
 	}
-	defer bus.Disconnect()
-	bus.SubscribeFunc(handleCanFrameStream(stream))
-	bus.ConnectAndPublish()
-	return nil
-}
-
-func handleCanFrameStream(stream chan<- []byte) can.HandlerFunc {
-	return func(frm can.Frame) {
-		// fmt.Println(frm)
-		bytes := FrameToBytes(frm)
-		stream <- bytes
-
-	}
+	defer socket.Close()
+	return readCanFrames(socket, r.config.URL.Host, stream)
 }
 
-func FrameToBytes(frm can.Frame) []byte {
-	bytes := make([]byte, 0, 8+frm.Length)
-	out := make([]byte, 4)
-	binary.BigEndian.PutUint32(out, frm.ID)
-
-	bytes = append(bytes, out...)
-	bytes = append(bytes, frm.Length)
-	bytes = append(bytes, frm.Flags)
-	bytes = append(bytes, frm.Res0)
-	bytes = append(bytes, frm.Res1)
-	for _, v := range frm.Data {
-		bytes = append(bytes, v)
+// readCanFrames reads the frames until the socket fails, every read returns one classic or CAN FD frame
+func readCanFrames(socket io.Reader, name string, stream chan<- []byte) error {
+	buffer := make([]byte, protocol.CANFD_MTU)
+	for {
+		n, err := socket.Read(buffer)
+		if err != nil {
+			return err
+		}
+		frame, err := protocol.SocketCanToFrame(buffer[:n], name)
+		if err != nil {
+			logger.GetLogger().Warn(
+				"Could not decode the CAN frame",
+				zap.String("Interface", name),
+				zap.String("Error", err.Error()),
+			)
+			continue
+		}
+		stream <- protocol.CanFrameToBytes(frame)
 	}
-	return bytes
 }
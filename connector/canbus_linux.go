@@ -0,0 +1,41 @@
+package connector
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"github.com/munnik/gosk/logger"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+// openCanSocket opens a raw SocketCAN socket that receives the CAN FD and error frames as well
+func openCanSocket(name string) (io.ReadCloser, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find the interface %v, the error that occurred was %v", name, err)
+	}
+	fd, err := unix.Socket(unix.AF_CAN, unix.SOCK_RAW, unix.CAN_RAW)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create a CAN socket, the error that occurred was %v", err)
+	}
+	if err := unix.SetsockoptInt(fd, unix.SOL_CAN_RAW, unix.CAN_RAW_FD_FRAMES, 1); err != nil {
+		// kernels without CAN FD support only deliver classic frames
+		logger.GetLogger().Warn(
+			"Unable to enable CAN FD frames",
+			zap.String("Interface", name),
+			zap.String("Error", err.Error()),
+		)
+	}
+	if err := unix.SetsockoptInt(fd, unix.SOL_CAN_RAW, unix.CAN_RAW_ERR_FILTER, unix.CAN_ERR_MASK); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("unable to enable the error frames on %v, the error that occurred was %v", name, err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrCAN{Ifindex: iface.Index}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("unable to bind to the interface %v, the error that occurred was %v", name, err)
+	}
+	return os.NewFile(uintptr(fd), name), nil
+}
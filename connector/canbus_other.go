@@ -0,0 +1,12 @@
+//go:build !linux
+
+package connector
+
+import (
+	"fmt"
+	"io"
+)
+
+func openCanSocket(name string) (io.ReadCloser, error) {
+	return nil, fmt.Errorf("unable to open the interface %v, CAN is only supported on Linux", name)
+}
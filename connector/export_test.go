@@ -0,0 +1,60 @@
+package connector
+
+import (
+	"time"
+
+	"github.com/munnik/gosk/config"
+	"github.com/munnik/gosk/message"
+	"github.com/munnik/gosk/protocol"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/simonvetter/modbus"
+)
+
+const ModbusMaximumBackoff = modbusMaximumBackoff
+
+type (
+	ModbusConnection    = modbusConnection
+	RegisterGroupReader = registerGroupReader
+)
+
+var Backoff = backoff
+
+// NewTestModbusConnector creates an open connector that reads the register groups with the readers
+func NewTestModbusConnector(c *config.ConnectorConfig, connection ModbusConnection, rgcs []config.RegisterGroupConfig, readers []RegisterGroupReader) *ModbusConnector {
+	m := newModbusConnector(c, connection, protocol.NewModbusTransport(&modbus.ClientConfiguration{}, nil), prometheus.NewRegistry())
+	for i, rgc := range rgcs {
+		m.registerGroups = append(m.registerGroups, newRegisterGroup(rgc, readers[i]))
+	}
+	m.open = true
+	return m
+}
+
+func (m *ModbusConnector) Poll(i int, stream chan<- []byte) {
+	m.poll(m.registerGroups[i], stream)
+}
+
+func (m *ModbusConnector) Schedule(stream chan<- []byte, done <-chan struct{}) {
+	m.schedule(stream, done)
+}
+
+func (m *ModbusConnector) Delay(i int) time.Duration {
+	return m.registerGroups[i].delay
+}
+
+func (m *ModbusConnector) Health(i int) protocol.ModbusHealth {
+	m.healthMu.Lock()
+	defer m.healthMu.Unlock()
+
+	return *m.registerGroups[i].health
+}
+
+func (m *ModbusConnector) IsOpen() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.open
+}
+
+func (l *LWEConnector) ParseDatagram(datagram []byte, now time.Time) []*message.Raw {
+	return l.parseDatagram(datagram, now)
+}
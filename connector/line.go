@@ -115,7 +115,7 @@ func (l LineConnector) createConnection() (io.ReadWriter, error) {
 func (l LineConnector) createNetworkConnection() (io.ReadWriter, error) {
 	if l.config.Listen {
 		if l.config.URL.Scheme == "tcp" {
-			listener, err := net.Listen(l.config.URL.Scheme, fmt.Sprintf("%s:%s", l.config.URL.Hostname(), l.config.URL.Port()))
+			listener, err := net.Listen(l.config.URL.Scheme, net.JoinHostPort(l.config.URL.Hostname(), l.config.URL.Port()))
 			if err != nil {
 				return nil, fmt.Errorf("unable to listen on %v, the error that occurred was %v", l.config.URL.String(), err)
 			}
@@ -125,7 +125,7 @@ func (l LineConnector) createNetworkConnection() (io.ReadWriter, error) {
 			}
 			return conn, nil
 		} else if l.config.URL.Scheme == "udp" {
-			conn, err := net.ListenPacket(l.config.URL.Scheme, fmt.Sprintf("%s:%s", l.config.URL.Hostname(), l.config.URL.Port()))
+			conn, err := net.ListenPacket(l.config.URL.Scheme, net.JoinHostPort(l.config.URL.Hostname(), l.config.URL.Port()))
 			if err != nil {
 				return nil, fmt.Errorf("unable to listen on %v, the error that occurred was %v", l.config.URL.String(), err)
 			}
@@ -133,7 +133,7 @@ func (l LineConnector) createNetworkConnection() (io.ReadWriter, error) {
 			return UdpListenerConnection{conn: conn}, nil
 		}
 	} else {
-		conn, err := net.Dial(l.config.URL.Scheme, fmt.Sprintf("%s:%s", l.config.URL.Hostname(), l.config.URL.Port()))
+		conn, err := net.Dial(l.config.URL.Scheme, net.JoinHostPort(l.config.URL.Hostname(), l.config.URL.Port()))
 		if err != nil {
 			return nil, fmt.Errorf("unable to dial to %v, the error that occurred was %v", l.config.URL.String(), err)
 		}
@@ -0,0 +1,140 @@
+package connector
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/munnik/gosk/config"
+	"github.com/munnik/gosk/logger"
+	"github.com/munnik/gosk/message"
+	"github.com/munnik/gosk/protocol"
+	"go.nanomsg.org/mangos/v3"
+	"go.uber.org/zap"
+)
+
+const (
+	lweMaxDatagramSize = 65535
+	lweGroupTimeout    = 5 * time.Second
+	lweLineCountWindow = 32 // number of recent line counts per source that are used to detect duplicates
+)
+
+// LWEConnector receives NMEA 0183 sentences from IEC 61162-450 multicast groups, the tag blocks are removed and used
+// for the timestamp and the connector of the raw messages
+type LWEConnector struct {
+	config     *config.ConnectorConfig
+	groups     []config.LWEGroupConfig
+	tagGroups  *protocol.TagBlockGroups
+	lineCounts *protocol.LineCounts
+}
+
+func NewLWEConnector(c *config.ConnectorConfig, lgc []config.LWEGroupConfig) (*LWEConnector, error) {
+	if len(lgc) == 0 {
+		return nil, fmt.Errorf("no multicast groups are configured")
+	}
+	return &LWEConnector{
+		config:     c,
+		groups:     lgc,
+		tagGroups:  protocol.NewTagBlockGroups(lweGroupTimeout),
+		lineCounts: protocol.NewLineCounts(lweLineCountWindow),
+	}, nil
+}
+
+func (l *LWEConnector) Publish(publisher mangos.Socket) {
+	stream := make(chan *message.Raw, 1)
+	defer close(stream)
+	for _, group := range l.groups {
+		go func(group config.LWEGroupConfig) {
+			for {
+				if err := l.receive(group, stream); err != nil {
+					logger.GetLogger().Warn(
+						"Error while receiving data from the multicast group, retrying in 5 seconds",
+						zap.String("Address", group.Address),
+						zap.String("Error", err.Error()),
+					)
+					time.Sleep(5 * time.Second)
+				}
+			}
+		}(group)
+	}
+	for m := range stream {
+		send(m, publisher)
+	}
+}
+
+func (*LWEConnector) Subscribe(subscriber mangos.Socket) {
+	// do nothing, use the lwe writer to send sentences
+}
+
+func (l *LWEConnector) receive(group config.LWEGroupConfig, stream chan<- *message.Raw) error {
+	address, err := net.ResolveUDPAddr("udp4", group.Address)
+	if err != nil {
+		return err
+	}
+	var iface *net.Interface
+	if group.Interface != "" {
+		if iface, err = net.InterfaceByName(group.Interface); err != nil {
+			return err
+		}
+	}
+	conn, err := net.ListenMulticastUDP("udp4", iface, address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetReadBuffer(lweMaxDatagramSize)
+
+	buffer := make([]byte, lweMaxDatagramSize)
+	for {
+		n, _, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			return err
+		}
+		for _, m := range l.parseDatagram(buffer[:n], time.Now()) {
+			stream <- m
+		}
+	}
+}
+
+// parseDatagram returns a raw message for each sentence in the datagram, datagrams without the UdPbC header (like
+// binary file transfers) are ignored
+func (l *LWEConnector) parseDatagram(datagram []byte, now time.Time) []*message.Raw {
+	if !bytes.HasPrefix(datagram, []byte(protocol.LWE_HEADER)) {
+		return nil
+	}
+
+	result := make([]*message.Raw, 0, 1)
+	for _, line := range bytes.Split(datagram[len(protocol.LWE_HEADER):], []byte("\r\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		tagBlock, sentence, err := protocol.SplitTagBlock(line)
+		if err != nil {
+			logger.GetLogger().Warn(
+				"Invalid tag block",
+				zap.ByteString("Line", line),
+				zap.String("Error", err.Error()),
+			)
+			continue
+		}
+		// lines of a group without a source get the source of the first line before the line count is checked
+		l.tagGroups.Complete(tagBlock, now)
+		if tagBlock != nil && tagBlock.LineCount != -1 && l.lineCounts.IsDuplicate(tagBlock.Source, tagBlock.LineCount) {
+			continue
+		}
+
+		// the buffer of the datagram is reused so the sentence is copied
+		m := message.NewRaw().WithConnector(l.config.Name).WithType(config.NMEA0183Type).WithValue(append([]byte{}, sentence...))
+		if tagBlock != nil {
+			if tagBlock.Source != "" {
+				m.WithConnector(l.config.Name + "." + tagBlock.Source)
+			}
+			if !tagBlock.Timestamp.IsZero() {
+				m.Timestamp = tagBlock.Timestamp
+			}
+		}
+		result = append(result, m)
+	}
+	return result
+}
@@ -0,0 +1,51 @@
+package connector_test
+
+import (
+	"strings"
+	"time"
+
+	"github.com/munnik/gosk/config"
+	. "github.com/munnik/gosk/connector"
+	"github.com/munnik/gosk/message"
+	"github.com/munnik/gosk/protocol"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LWE datagrams", func() {
+	now := time.Now()
+	var connector *LWEConnector
+	BeforeEach(func() {
+		var err error
+		connector, err = NewLWEConnector(&config.ConnectorConfig{Name: "lwe"}, []config.LWEGroupConfig{{}})
+		Expect(err).NotTo(HaveOccurred())
+	})
+	datagram := func(lines ...string) []byte {
+		return []byte(protocol.LWE_HEADER + strings.Join(lines, "\r\n") + "\r\n")
+	}
+	sentences := func(raws []*message.Raw) []string {
+		result := make([]string, 0, len(raws))
+		for _, r := range raws {
+			result = append(result, r.Connector+" "+string(r.Value))
+		}
+		return result
+	}
+
+	It("ignores datagrams without header", func() {
+		Expect(connector.ParseDatagram([]byte("RaUdP\x00$GPHDT,123.4,T*31\r\n"), now)).To(BeEmpty())
+	})
+	It("drops lines with a line count that was received recently", func() {
+		result := connector.ParseDatagram(datagram(`\s:SI0001,n:9*13\$GPHDT,123.4,T*31`), now)
+		Expect(sentences(result)).To(Equal([]string{"lwe.SI0001 $GPHDT,123.4,T*31"}))
+		result = connector.ParseDatagram(datagram(`\s:SI0001,n:9*13\$GPHDT,123.4,T*31`), now)
+		Expect(result).To(BeEmpty())
+		result = connector.ParseDatagram(datagram(`\s:SI0002,n:9*10\$GPHDT,123.4,T*31`), now)
+		Expect(sentences(result)).To(Equal([]string{"lwe.SI0002 $GPHDT,123.4,T*31"}))
+	})
+	It("checks the line count of grouped lines against the source of the group", func() {
+		result := connector.ParseDatagram(datagram(`\g:1-2-7,s:SI0001,n:5*5A\$GPHDT,123.4,T*31`, `\g:2-2-7,n:6*24\$GPROT,12.0,A*02`), now)
+		Expect(sentences(result)).To(Equal([]string{"lwe.SI0001 $GPHDT,123.4,T*31", "lwe.SI0001 $GPROT,12.0,A*02"}))
+		result = connector.ParseDatagram(datagram(`\g:1-2-8,s:SI0002,n:5*56\$GPHDT,123.4,T*31`, `\g:2-2-8,n:6*2B\$GPROT,12.0,A*02`), now)
+		Expect(sentences(result)).To(Equal([]string{"lwe.SI0002 $GPHDT,123.4,T*31", "lwe.SI0002 $GPROT,12.0,A*02"}))
+	})
+})
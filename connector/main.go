@@ -24,26 +24,30 @@ func process(stream <-chan []byte, connector string, protocol string, publisher
 		)
 
 		m = message.NewRaw().WithConnector(connector).WithValue(value).WithType(protocol)
-		bytes, err := json.Marshal(m)
-		if err != nil {
-			logger.GetLogger().Warn(
-				"Unable to marshall the message to JSON",
-				zap.ByteString("Message", value),
-				zap.String("Error", err.Error()),
-			)
-			continue
-		}
-		if err := publisher.Send(bytes); err != nil {
-			logger.GetLogger().Warn(
-				"Unable to send the message using NanoMSG",
-				zap.ByteString("Message", bytes),
-				zap.String("Error", err.Error()),
-			)
-			continue
-		}
-		logger.GetLogger().Debug(
-			"Send the message on the NanoMSG socket",
-			zap.ByteString("Message", value),
+		send(m, publisher)
+	}
+}
+
+func send(m *message.Raw, publisher mangos.Socket) {
+	bytes, err := json.Marshal(m)
+	if err != nil {
+		logger.GetLogger().Warn(
+			"Unable to marshall the message to JSON",
+			zap.ByteString("Message", m.Value),
+			zap.String("Error", err.Error()),
+		)
+		return
+	}
+	if err := publisher.Send(bytes); err != nil {
+		logger.GetLogger().Warn(
+			"Unable to send the message using NanoMSG",
+			zap.ByteString("Message", bytes),
+			zap.String("Error", err.Error()),
 		)
+		return
 	}
+	logger.GetLogger().Debug(
+		"Send the message on the NanoMSG socket",
+		zap.ByteString("Message", m.Value),
+	)
 }
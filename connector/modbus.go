@@ -1,8 +1,11 @@
 package connector
 
 import (
+	"container/heap"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
 	"sync"
 	"time"
 
@@ -10,20 +13,70 @@ import (
 	"github.com/munnik/gosk/logger"
 	"github.com/munnik/gosk/message"
 	"github.com/munnik/gosk/protocol"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/simonvetter/modbus"
 	"go.nanomsg.org/mangos/v3"
 	"go.uber.org/zap"
 )
 
+const (
+	// modbusMaximumBackoff is the maximum interval between reads of a failing register group
+	modbusMaximumBackoff = time.Minute
+	// modbusReconnectInterval is the minimum interval between reconnects, all register groups fail when the
+	// connection is broken
+	modbusReconnectInterval = 5 * time.Second
+	modbusHealthInterval    = 10 * time.Second
+	// modbusAcknowledgementBuffer is the number of acknowledgements that are kept until the connector publishes
+	modbusAcknowledgementBuffer = 100
+)
+
+// modbusConnection is the connection that is shared by the register groups, it is implemented by modbus.ModbusClient
+type modbusConnection interface {
+	Open() error
+	Close() error
+}
+
+// registerGroupReader reads a register group as the value of a raw message, it is implemented by
+// protocol.ModbusClient
+type registerGroupReader interface {
+	ReadRegisterGroup() ([]byte, error)
+}
+
+// ModbusConnector polls the register groups one at a time on a shared schedule, a failing register group is read less
+// often and doesn't affect the other register groups
 type ModbusConnector struct {
-	config               *config.ConnectorConfig
-	registerGroupsConfig []config.RegisterGroupConfig
-	realClient           *modbus.ModbusClient
+	config         *config.ConnectorConfig
+	registerGroups []*registerGroup
+	realClient     *modbus.ModbusClient
+	connection     modbusConnection
+	transport      *protocol.ModbusTransport
+
+	acknowledgements chan *message.Raw // results of the written commands, they are sent on the publish socket
+
+	mu            sync.Mutex // the unit id is set on the shared client so only one request is done at the same time
+	open          bool
+	lastReconnect time.Time
+	connects      uint64 // number of successful reconnects, the device information is read again after a reconnect
+
+	healthMu sync.Mutex
+
+	pollDuration *prometheus.HistogramVec
+	pollErrors   *prometheus.CounterVec
+	lastSuccess  *prometheus.GaugeVec
+	reconnects   prometheus.Counter
 }
 
-func NewModbusConnector(c *config.ConnectorConfig, rgcs []config.RegisterGroupConfig) (*ModbusConnector, error) {
+// NewModbusConnector creates the connector, the metrics are registered with reg
+func NewModbusConnector(c *config.ConnectorConfig, rgcs []config.RegisterGroupConfig, reg prometheus.Registerer) (*ModbusConnector, error) {
 	for _, rgc := range rgcs {
 		// TODO add write function codes
+		if protocol.IsModbusDeviceFunction(rgc.FunctionCode) {
+			if !c.ExtraConnection {
+				return nil, fmt.Errorf("function code %v of register group %v needs an extra connection, set extraConnection to read it", rgc.FunctionCode, rgc)
+			}
+			continue
+		}
 		if rgc.FunctionCode == protocol.READ_COILS || rgc.FunctionCode == protocol.READ_DISCRETE_INPUTS {
 			if rgc.NumberOfCoilsOrRegisters > protocol.MODBUS_MAXIMUM_NUMBER_OF_COILS {
 				return nil, fmt.Errorf("maximum number %v of coils exceeded for register group %v", protocol.MODBUS_MAXIMUM_NUMBER_OF_COILS, rgc)
@@ -34,48 +87,80 @@ func NewModbusConnector(c *config.ConnectorConfig, rgcs []config.RegisterGroupCo
 			}
 		}
 	}
-	realClient, err := modbus.NewClient(&modbus.ClientConfiguration{
+	clientConfiguration := &modbus.ClientConfiguration{
 		URL:      c.URL.String(),
 		Speed:    uint(c.BaudRate),
 		DataBits: uint(c.DataBits),
 		Parity:   uint(c.Parity),
 		StopBits: uint(c.StopBits),
 		Timeout:  1 * time.Second,
-	})
+	}
+	realClient, err := modbus.NewClient(clientConfiguration)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create modbus client %v, the error that occurred was %v", c.URL.String(), err)
 	}
+
+	// without the extra connection the client has no transport, the functions that need it return an error
+	var transport *protocol.ModbusTransport
+	if c.ExtraConnection {
+		transport = protocol.NewModbusTransport(clientConfiguration, realClient)
+	}
+	m := newModbusConnector(c, realClient, transport, reg)
+	m.realClient = realClient
+	for _, rgc := range rgcs {
+		client := protocol.NewModbusClient(realClient, rgc.ExtractModbusHeader()).WithTransport(m.transport)
+		m.registerGroups = append(m.registerGroups, newRegisterGroup(rgc, client))
+	}
 	if err := realClient.Open(); err != nil {
-		return nil, fmt.Errorf("unable to open modbus client %v, the error that occurred was %v", c.URL.String(), err)
+		// the register groups reconnect when they are polled
+		logger.GetLogger().Warn(
+			"Unable to open the modbus client",
+			zap.String("URL", c.URL.String()),
+			zap.String("Error", err.Error()),
+		)
+	} else {
+		m.open = true
 	}
 
-	return &ModbusConnector{config: c, registerGroupsConfig: rgcs, realClient: realClient}, nil
+	return m, nil
+}
+
+func newModbusConnector(c *config.ConnectorConfig, connection modbusConnection, transport *protocol.ModbusTransport, reg prometheus.Registerer) *ModbusConnector {
+	factory := promauto.With(reg)
+	labels := []string{"slave", "function_code", "address"}
+	return &ModbusConnector{
+		config:           c,
+		connection:       connection,
+		transport:        transport,
+		acknowledgements: make(chan *message.Raw, modbusAcknowledgementBuffer),
+		pollDuration:     factory.NewHistogramVec(prometheus.HistogramOpts{Name: "gosk_modbus_poll_duration_seconds", Help: "duration of successful reads of a register group"}, labels),
+		pollErrors:       factory.NewCounterVec(prometheus.CounterOpts{Name: "gosk_modbus_poll_errors_total", Help: "total number of failed reads of a register group"}, append(labels, "exception")),
+		lastSuccess:      factory.NewGaugeVec(prometheus.GaugeOpts{Name: "gosk_modbus_last_success_time", Help: "last successful read of a register group"}, labels),
+		reconnects:       factory.NewCounter(prometheus.CounterOpts{Name: "gosk_modbus_reconnects_total", Help: "total number of reconnects of the modbus client"}),
+	}
 }
 
 func (m *ModbusConnector) Publish(publisher mangos.Socket) {
 	stream := make(chan []byte, 1)
 	defer close(stream)
+	go m.schedule(stream, nil)
+	go m.publishHealth(publisher)
 	go func() {
-		for {
-			if err := m.receive(stream); err != nil {
-				logger.GetLogger().Warn(
-					"Error while receiving data for the stream",
-					zap.String("URL", m.config.URL.String()),
-					zap.String("Error", err.Error()),
-				)
-			}
+		for acknowledgement := range m.acknowledgements {
+			send(acknowledgement, publisher)
 		}
 	}()
 	process(stream, m.config.Name, m.config.Protocol, publisher)
 }
 
+// Subscribe writes the commands of the received raw messages, the result of every command is published as a raw
+// message with the ModbusAcknowledgementType
 func (m *ModbusConnector) Subscribe(subscriber mangos.Socket) {
 	go func(connector *ModbusConnector, subscriber mangos.Socket) {
 		client := protocol.NewModbusClient(
 			connector.realClient,
-			nil, // no need to set this because it will not be used in the Write([]byte) function
-		)
-		raw := &message.Raw{}
+			nil, // no need to set this because it will not be used in the Execute([]byte) function
+		).WithTransport(connector.transport)
 		for {
 			received, err := subscriber.Recv()
 			if err != nil {
@@ -85,6 +170,7 @@ func (m *ModbusConnector) Subscribe(subscriber mangos.Socket) {
 				)
 				continue
 			}
+			raw := &message.Raw{}
 			if err := json.Unmarshal(received, raw); err != nil {
 				logger.GetLogger().Warn(
 					"Could not unmarshal the received data",
@@ -93,39 +179,300 @@ func (m *ModbusConnector) Subscribe(subscriber mangos.Socket) {
 				)
 				continue
 			}
-			client.Write(raw.Value)
+			connector.acknowledge(raw, connector.execute(client, raw.Value))
 		}
 	}(m, subscriber)
 }
 
-func (m *ModbusConnector) receive(stream chan<- []byte) error {
-	errors := make(chan error)
-	done := make(chan bool)
-	var wg sync.WaitGroup
-	wg.Add(len(m.registerGroupsConfig))
+func (m *ModbusConnector) execute(client *protocol.ModbusClient, command []byte) *protocol.ModbusAcknowledgement {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	// start a go routine for each register group, if an error occurs send it on the error channel
-	for _, rgc := range m.registerGroupsConfig {
-		go func(rgc config.RegisterGroupConfig) {
-			client := protocol.NewModbusClient(m.realClient, rgc.ExtractModbusHeader())
-			if err := client.Poll(stream, rgc.PollingInterval); err != nil {
-				errors <- err
-			}
-			wg.Done()
-		}(rgc)
+	var registers []uint16
+	err := fmt.Errorf("the modbus client %v is not open", m.config.URL.String())
+	if m.open {
+		registers, err = client.Execute(command)
+		m.closeOnError(err)
+	}
+	result := &protocol.ModbusAcknowledgement{Success: err == nil, Registers: registers}
+	if header, _, headerErr := protocol.ExtractModbusHeader(command); headerErr == nil {
+		result.Slave = header.Slave
+		result.FunctionCode = header.FunctionCode
+		result.Address = header.Address
+	}
+	if err != nil {
+		result.Error = err.Error()
+		result.Exception = protocol.ModbusException(err)
+	}
+	return result
+}
+
+// acknowledge queues the result of the command for publishing, the result is dropped when the queue is full
+func (m *ModbusConnector) acknowledge(command *message.Raw, acknowledgement *protocol.ModbusAcknowledgement) {
+	acknowledgement.Request = command.Uuid.String()
+	if !acknowledgement.Success {
+		logger.GetLogger().Warn(
+			"Could not write the command",
+			zap.ByteString("Command", command.Value),
+			zap.String("Error", acknowledgement.Error),
+		)
+	}
+	bytes, err := json.Marshal(acknowledgement)
+	if err != nil {
+		logger.GetLogger().Warn(
+			"Unable to marshall the acknowledgement of the command",
+			zap.String("Error", err.Error()),
+		)
+		return
 	}
-	go func() {
-		// if the reading of all register groups is finished close the done channel
-		wg.Wait()
-		close(done)
-	}()
 	select {
-	case <-done:
-		// all reading is done, break the select statement
-		break
-	case err := <-errors:
-		close(errors)
-		return err
-	}
-	return nil
+	case m.acknowledgements <- message.NewRaw().WithConnector(m.config.Name).WithType(config.ModbusAcknowledgementType).WithValue(bytes):
+	default:
+		logger.GetLogger().Warn(
+			"Dropped the acknowledgement of the command, it is not published in time",
+			zap.ByteString("Acknowledgement", bytes),
+		)
+	}
+}
+
+// registerGroup is the state of a register group that is polled by the scheduler
+type registerGroup struct {
+	config config.RegisterGroupConfig
+	client registerGroupReader
+	health *protocol.ModbusHealth
+	labels prometheus.Labels
+	delay  time.Duration // the polling interval or the backoff after a failure
+	due    time.Time
+}
+
+func newRegisterGroup(rgc config.RegisterGroupConfig, client registerGroupReader) *registerGroup {
+	return &registerGroup{
+		config: rgc,
+		client: client,
+		health: &protocol.ModbusHealth{Slave: rgc.Slave, FunctionCode: rgc.FunctionCode, Address: rgc.Address},
+		labels: prometheus.Labels{
+			"slave":         strconv.Itoa(int(rgc.Slave)),
+			"function_code": strconv.Itoa(int(rgc.FunctionCode)),
+			"address":       strconv.Itoa(int(rgc.Address)),
+		},
+		delay: rgc.PollingInterval,
+	}
+}
+
+// registerGroupQueue implements heap.Interface, the register group that is due first is at the top
+type registerGroupQueue []*registerGroup
+
+func (q registerGroupQueue) Len() int            { return len(q) }
+func (q registerGroupQueue) Less(i, j int) bool  { return q[i].due.Before(q[j].due) }
+func (q registerGroupQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *registerGroupQueue) Push(x interface{}) { *q = append(*q, x.(*registerGroup)) }
+func (q *registerGroupQueue) Pop() interface{} {
+	old := *q
+	result := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return result
+}
+
+// schedule polls the register groups one at a time in the order they are due, the first polls of the register groups
+// with the same polling interval are spread over the interval so the requests don't reach the bus in bursts. The device
+// information is read directly after connecting. Polling stops when done is closed.
+func (m *ModbusConnector) schedule(stream chan<- []byte, done <-chan struct{}) {
+	if len(m.registerGroups) == 0 {
+		return
+	}
+
+	counts := make(map[time.Duration]int)
+	for _, g := range m.registerGroups {
+		counts[g.config.PollingInterval]++
+	}
+	offsets := make(map[time.Duration]int)
+	start := time.Now()
+	queue := make(registerGroupQueue, 0, len(m.registerGroups))
+	for _, g := range m.registerGroups {
+		interval := g.config.PollingInterval
+		g.due = start.Add(interval * time.Duration(offsets[interval]) / time.Duration(counts[interval]))
+		offsets[interval]++
+		if protocol.IsModbusDeviceFunction(g.config.FunctionCode) {
+			g.due = start
+		}
+		queue = append(queue, g)
+	}
+	heap.Init(&queue)
+
+	connects := m.connectCount()
+	for {
+		g := queue[0]
+		timer := time.NewTimer(time.Until(g.due))
+		select {
+		case <-timer.C:
+		case <-done:
+			timer.Stop()
+			return
+		}
+		m.poll(g, stream)
+		g.due = g.due.Add(g.delay)
+		now := time.Now()
+		if g.due.Before(now) {
+			// the bus can't keep up, the missed polls are skipped instead of done in a burst
+			g.due = now
+		}
+		heap.Fix(&queue, 0)
+
+		if c := m.connectCount(); c != connects {
+			// the device can be replaced while the connection was broken
+			connects = c
+			for _, g := range queue {
+				if protocol.IsModbusDeviceFunction(g.config.FunctionCode) {
+					g.due = now
+				}
+			}
+			heap.Init(&queue)
+		}
+	}
+}
+
+func (m *ModbusConnector) connectCount() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.connects
+}
+
+// poll reads the register group once and sets the delay until the next read, after a failure the delay is doubled up
+// to the maximum backoff
+func (m *ModbusConnector) poll(g *registerGroup, stream chan<- []byte) {
+	start := time.Now()
+	bytes, err := m.read(g.client)
+	if err == nil {
+		latency := time.Since(start)
+		m.pollDuration.With(g.labels).Observe(latency.Seconds())
+		m.lastSuccess.With(g.labels).Set(float64(start.Unix()))
+		m.healthMu.Lock()
+		g.health.LastSuccess = start
+		g.health.Latency = latency
+		g.health.ConsecutiveErrors = 0
+		m.healthMu.Unlock()
+
+		stream <- bytes
+		g.delay = g.config.PollingInterval
+		return
+	}
+
+	exception := protocol.ModbusException(err)
+	m.pollErrors.With(prometheus.Labels{
+		"slave":         g.labels["slave"],
+		"function_code": g.labels["function_code"],
+		"address":       g.labels["address"],
+		"exception":     strconv.Itoa(int(exception)),
+	}).Inc()
+	m.healthMu.Lock()
+	g.health.Errors++
+	g.health.ConsecutiveErrors++
+	g.health.LastError = err.Error()
+	g.health.LastException = exception
+	m.healthMu.Unlock()
+
+	switch exception {
+	case protocol.MODBUS_EXCEPTION_ILLEGAL_FUNCTION, protocol.MODBUS_EXCEPTION_ILLEGAL_DATA_ADDRESS, protocol.MODBUS_EXCEPTION_ILLEGAL_DATA_VALUE:
+		// the slave rejects the request, retrying soon will not help
+		g.delay = modbusMaximumBackoff
+	case 0:
+		// a timeout on a serial line is caused by the slave, other errors can be caused by a broken connection
+		if !(errors.Is(err, modbus.ErrRequestTimedOut) && m.config.URL.Scheme == "rtu") {
+			m.reconnect()
+		}
+		g.delay = backoff(g.delay)
+	default:
+		g.delay = backoff(g.delay)
+	}
+	logger.GetLogger().Warn(
+		"Could not read the register group",
+		zap.Uint8("Slave", g.config.Slave),
+		zap.Uint16("Function code", g.config.FunctionCode),
+		zap.Uint16("Address", g.config.Address),
+		zap.Uint8("Exception", exception),
+		zap.Duration("Retry in", g.delay),
+		zap.String("Error", err.Error()),
+	)
+}
+
+// backoff doubles the delay up to the maximum backoff, a delay that is already longer is not changed
+func backoff(delay time.Duration) time.Duration {
+	if delay >= modbusMaximumBackoff {
+		return delay
+	}
+	if delay*2 > modbusMaximumBackoff {
+		return modbusMaximumBackoff
+	}
+	return delay * 2
+}
+
+func (m *ModbusConnector) read(client registerGroupReader) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.open {
+		return nil, fmt.Errorf("the modbus client %v is not open", m.config.URL.String())
+	}
+	bytes, err := client.ReadRegisterGroup()
+	m.closeOnError(err)
+	return bytes, err
+}
+
+// closeOnError marks the client as closed when it could not be reopened after a request of the transport, the lock
+// must be held
+func (m *ModbusConnector) closeOnError(err error) {
+	if errors.Is(err, protocol.ErrModbusClientClosed) {
+		m.open = false
+	}
+}
+
+// reconnect reopens the client, the client is reopened at most once per reconnect interval
+func (m *ModbusConnector) reconnect() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if time.Since(m.lastReconnect) < modbusReconnectInterval {
+		return
+	}
+	m.lastReconnect = time.Now()
+	m.reconnects.Inc()
+
+	if m.transport != nil {
+		m.transport.Close()
+	}
+	if m.open {
+		m.connection.Close()
+	}
+	if err := m.connection.Open(); err != nil {
+		m.open = false
+		logger.GetLogger().Warn(
+			"Unable to reopen the modbus client",
+			zap.String("URL", m.config.URL.String()),
+			zap.String("Error", err.Error()),
+		)
+		return
+	}
+	m.open = true
+	m.connects++
+}
+
+// publishHealth sends the health of the register groups as raw messages with the ModbusHealthType
+func (m *ModbusConnector) publishHealth(publisher mangos.Socket) {
+	ticker := time.NewTicker(modbusHealthInterval)
+	for range ticker.C {
+		m.healthMu.Lock()
+		for _, g := range m.registerGroups {
+			bytes, err := json.Marshal(g.health)
+			if err != nil {
+				logger.GetLogger().Warn(
+					"Unable to marshall the health of the register group",
+					zap.String("Error", err.Error()),
+				)
+				continue
+			}
+			send(message.NewRaw().WithConnector(m.config.Name).WithType(config.ModbusHealthType).WithValue(bytes), publisher)
+		}
+		m.healthMu.Unlock()
+	}
 }
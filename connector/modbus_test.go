@@ -0,0 +1,220 @@
+package connector_test
+
+import (
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/munnik/gosk/config"
+	. "github.com/munnik/gosk/connector"
+	"github.com/munnik/gosk/protocol"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/simonvetter/modbus"
+)
+
+// fakeConnection counts the number of times the connection is opened
+type fakeConnection struct {
+	mu    sync.Mutex
+	opens int
+	err   error
+}
+
+func (c *fakeConnection) Open() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.opens++
+	return c.err
+}
+
+func (c *fakeConnection) Close() error {
+	return nil
+}
+
+func (c *fakeConnection) Opens() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.opens
+}
+
+// fakeReader returns the error or the value and records the time of every read
+type fakeReader struct {
+	mu    sync.Mutex
+	value []byte
+	err   error
+	reads []time.Time
+}
+
+func (r *fakeReader) ReadRegisterGroup() ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.reads = append(r.reads, time.Now())
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.value, nil
+}
+
+func (r *fakeReader) Reads() []time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]time.Time{}, r.reads...)
+}
+
+var _ = Describe("Modbus connector", func() {
+	newConfig := func(rawURL string) *config.ConnectorConfig {
+		u, err := url.Parse(rawURL)
+		Expect(err).NotTo(HaveOccurred())
+		return &config.ConnectorConfig{Name: "modbus", URL: u, Protocol: config.ModbusType}
+	}
+	registerGroup := func(address uint16, interval time.Duration) config.RegisterGroupConfig {
+		return config.RegisterGroupConfig{
+			ModbusHeader:    protocol.ModbusHeader{Slave: 1, FunctionCode: protocol.READ_HOLDING_REGISTERS, Address: address, NumberOfCoilsOrRegisters: 1},
+			PollingInterval: interval,
+		}
+	}
+
+	DescribeTable(
+		"backoff",
+		func(delay time.Duration, expected time.Duration) {
+			Expect(Backoff(delay)).To(Equal(expected))
+		},
+		Entry("doubles the delay", time.Second, 2*time.Second),
+		Entry("is limited to the maximum backoff", 40*time.Second, ModbusMaximumBackoff),
+		Entry("doesn't change a longer delay", 2*ModbusMaximumBackoff, 2*ModbusMaximumBackoff),
+	)
+
+	Describe("create", func() {
+		device := config.RegisterGroupConfig{
+			ModbusHeader: protocol.ModbusHeader{Slave: 1, FunctionCode: protocol.REPORT_SERVER_ID, NumberOfCoilsOrRegisters: 1},
+		}
+		It("refuses the device information without an extra connection", func() {
+			_, err := NewModbusConnector(newConfig("tcp://127.0.0.1:1"), []config.RegisterGroupConfig{registerGroup(10, time.Second), device}, prometheus.NewRegistry())
+			Expect(err).To(HaveOccurred())
+		})
+		It("reads the device information with an extra connection", func() {
+			c := newConfig("tcp://127.0.0.1:1")
+			c.ExtraConnection = true
+			_, err := NewModbusConnector(c, []config.RegisterGroupConfig{registerGroup(10, time.Second), device}, prometheus.NewRegistry())
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Describe("poll", func() {
+		var connection *fakeConnection
+		var stream chan []byte
+		BeforeEach(func() {
+			connection = &fakeConnection{}
+			stream = make(chan []byte, 10)
+		})
+
+		It("sends the register group and resets the delay after a success", func() {
+			reader := &fakeReader{err: modbus.ErrServerDeviceBusy}
+			m := NewTestModbusConnector(newConfig("tcp://127.0.0.1:502"), connection, []config.RegisterGroupConfig{registerGroup(10, time.Second)}, []RegisterGroupReader{reader})
+			m.Poll(0, stream)
+			Expect(m.Delay(0)).To(Equal(2 * time.Second))
+
+			reader.err, reader.value = nil, []byte{0x01, 0x02}
+			m.Poll(0, stream)
+			Expect(stream).To(Receive(Equal([]byte{0x01, 0x02})))
+			Expect(m.Delay(0)).To(Equal(time.Second))
+			health := m.Health(0)
+			Expect(health.Errors).To(Equal(uint64(1)))
+			Expect(health.ConsecutiveErrors).To(BeZero())
+			Expect(health.LastSuccess).NotTo(BeZero())
+		})
+		It("waits the maximum backoff when the slave rejects the request", func() {
+			reader := &fakeReader{err: modbus.ErrIllegalDataAddress}
+			m := NewTestModbusConnector(newConfig("tcp://127.0.0.1:502"), connection, []config.RegisterGroupConfig{registerGroup(10, time.Second)}, []RegisterGroupReader{reader})
+			m.Poll(0, stream)
+			Expect(m.Delay(0)).To(Equal(ModbusMaximumBackoff))
+			Expect(m.Health(0).LastException).To(Equal(uint8(protocol.MODBUS_EXCEPTION_ILLEGAL_DATA_ADDRESS)))
+			Expect(connection.Opens()).To(BeZero())
+			Expect(stream).NotTo(Receive())
+		})
+		It("reconnects at most once per interval after a connection error", func() {
+			reader := &fakeReader{err: errors.New("connection reset by peer")}
+			m := NewTestModbusConnector(newConfig("tcp://127.0.0.1:502"), connection, []config.RegisterGroupConfig{registerGroup(10, time.Second)}, []RegisterGroupReader{reader})
+			m.Poll(0, stream)
+			m.Poll(0, stream)
+			Expect(connection.Opens()).To(Equal(1))
+			Expect(m.Delay(0)).To(Equal(4 * time.Second))
+			Expect(m.Health(0).ConsecutiveErrors).To(Equal(uint64(2)))
+		})
+		It("doesn't reconnect after a timeout on a serial line", func() {
+			reader := &fakeReader{err: modbus.ErrRequestTimedOut}
+			m := NewTestModbusConnector(newConfig("rtu:///dev/ttyUSB0"), connection, []config.RegisterGroupConfig{registerGroup(10, time.Second)}, []RegisterGroupReader{reader})
+			m.Poll(0, stream)
+			Expect(connection.Opens()).To(BeZero())
+			Expect(m.IsOpen()).To(BeTrue())
+		})
+		It("closes the client when it could not be reopened", func() {
+			reader := &fakeReader{err: protocol.ErrModbusClientClosed}
+			connection.err = errors.New("no such device")
+			m := NewTestModbusConnector(newConfig("rtu:///dev/ttyUSB0"), connection, []config.RegisterGroupConfig{registerGroup(10, time.Second)}, []RegisterGroupReader{reader})
+			m.Poll(0, stream)
+			Expect(m.IsOpen()).To(BeFalse())
+			Expect(connection.Opens()).To(Equal(1))
+
+			// the register group is not read while the client is closed
+			m.Poll(0, stream)
+			Expect(reader.Reads()).To(HaveLen(1))
+		})
+	})
+
+	Describe("schedule", func() {
+		It("spreads the polls and backs off a failing register group without delaying the others", func() {
+			interval := 100 * time.Millisecond
+			healthy := &fakeReader{value: []byte{0x01}}
+			failing := &fakeReader{err: modbus.ErrServerDeviceBusy}
+			m := NewTestModbusConnector(
+				newConfig("tcp://127.0.0.1:502"),
+				&fakeConnection{},
+				[]config.RegisterGroupConfig{registerGroup(10, interval), registerGroup(20, interval)},
+				[]RegisterGroupReader{healthy, failing},
+			)
+			stream := make(chan []byte, 100)
+			done := make(chan struct{})
+			start := time.Now()
+			go m.Schedule(stream, done)
+			time.Sleep(4*interval + interval/2)
+			close(done)
+
+			// the healthy register group is read at 0, 1, 2, 3 and 4 intervals
+			Expect(len(healthy.Reads())).To(BeNumerically(">=", 4))
+			// the failing register group is read at 0.5 interval and after a backoff of 2 intervals
+			reads := failing.Reads()
+			Expect(reads).To(HaveLen(2))
+			Expect(reads[0].Sub(start)).To(BeNumerically("~", interval/2, interval/4))
+			Expect(reads[1].Sub(reads[0])).To(BeNumerically("~", 2*interval, interval/4))
+		})
+		It("reads the device information first", func() {
+			device := config.RegisterGroupConfig{
+				ModbusHeader:    protocol.ModbusHeader{Slave: 1, FunctionCode: protocol.REPORT_SERVER_ID, NumberOfCoilsOrRegisters: 1},
+				PollingInterval: time.Hour,
+			}
+			registers := &fakeReader{value: []byte{0x01}}
+			information := &fakeReader{value: []byte{0x02}}
+			m := NewTestModbusConnector(
+				newConfig("tcp://127.0.0.1:502"),
+				&fakeConnection{},
+				[]config.RegisterGroupConfig{registerGroup(10, time.Hour), registerGroup(20, time.Hour), device},
+				[]RegisterGroupReader{registers, &fakeReader{value: []byte{0x01}}, information},
+			)
+			stream := make(chan []byte, 10)
+			done := make(chan struct{})
+			defer close(done)
+			go m.Schedule(stream, done)
+			Eventually(stream).Should(Receive())
+			Eventually(stream).Should(Receive())
+			Expect(information.Reads()).To(HaveLen(1))
+			Expect(registers.Reads()).To(HaveLen(1))
+		})
+	})
+})
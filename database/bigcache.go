@@ -48,7 +48,7 @@ func (c *BigCache) WriteMapped(mappedList ...message.Mapped) []message.Mapped {
 	changes := make([]message.SingleValueMapped, 0)
 	for _, mapped := range mappedList {
 		for _, m := range mapped.ToSingleValueMapped() {
-			if originalBytes, err := c.mappedCache.Get(m.Context + "." + m.Path); err == nil {
+			if originalBytes, err := c.mappedCache.Get(mappedKey(m)); err == nil {
 				var original message.SingleValueMapped
 				if err := json.Unmarshal(originalBytes, &original); err == nil {
 					if original.Equals(m) || m.Timestamp.Before(original.Timestamp) {
@@ -66,7 +66,7 @@ func (c *BigCache) WriteMapped(mappedList ...message.Mapped) []message.Mapped {
 				)
 				continue
 			}
-			c.mappedCache.Set(m.Context+"."+m.Path, bytes)
+			c.mappedCache.Set(mappedKey(m), bytes)
 		}
 	}
 
@@ -77,6 +77,12 @@ func (c *BigCache) WriteMapped(mappedList ...message.Mapped) []message.Mapped {
 	return result
 }
 
+// mappedKey returns the key of the value in the cache, the values of multiple sources of the same path are kept
+// separately
+func mappedKey(m message.SingleValueMapped) string {
+	return m.Context + "." + m.Path + "/" + m.Source.Ref()
+}
+
 func (c *BigCache) ReadRaw(where string, arguments ...interface{}) ([]message.Raw, error) {
 	if uuid, err := uuid.Parse(where); err == nil {
 		bytes, err := c.rawCache.Get(uuid.String())
@@ -0,0 +1,46 @@
+package database_test
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/munnik/gosk/config"
+	. "github.com/munnik/gosk/database"
+	"github.com/munnik/gosk/message"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("BigCache", func() {
+	now := time.Now().UTC()
+	mapped := func(label string, timestamp time.Time, value float64) message.Mapped {
+		s := message.NewSource().WithLabel(label).WithType(config.NMEA0183Type).WithUuid(uuid.Nil)
+		u := message.NewUpdate().WithSource(*s).WithTimestamp(timestamp).AddValue(message.NewValue().WithPath("navigation.speedOverGround").WithValue(value))
+		return *message.NewMapped().WithContext("testingContext").WithOrigin("testingContext").AddUpdate(u)
+	}
+
+	var cache *BigCache
+	BeforeEach(func() {
+		cache = NewBigCache(&config.BigCacheConfig{LifeWindow: 60, HardMaxCacheSize: 1})
+	})
+
+	It("keeps a value per source", func() {
+		cache.WriteMapped(mapped("GPS1", now, 3.2), mapped("GPS2", now, 3.4))
+		result, err := cache.ReadMapped("")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(ConsistOf(mapped("GPS1", now, 3.2), mapped("GPS2", now, 3.4)))
+	})
+	It("replaces the value of the same source", func() {
+		cache.WriteMapped(mapped("GPS1", now, 3.2))
+		changes := cache.WriteMapped(mapped("GPS1", now.Add(time.Second), 3.3))
+		Expect(changes).To(HaveLen(1))
+		result, err := cache.ReadMapped("")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(ConsistOf(mapped("GPS1", now.Add(time.Second), 3.3)))
+	})
+	It("ignores older values of the same source", func() {
+		cache.WriteMapped(mapped("GPS1", now, 3.2))
+		changes := cache.WriteMapped(mapped("GPS1", now.Add(-time.Second), 3.3))
+		Expect(changes).To(BeEmpty())
+	})
+})
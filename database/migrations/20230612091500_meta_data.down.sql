@@ -0,0 +1 @@
+DROP TABLE "meta_data";
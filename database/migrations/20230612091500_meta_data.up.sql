@@ -0,0 +1,7 @@
+CREATE TABLE "meta_data" (
+    "context" TEXT NOT NULL,
+    "path" TEXT NOT NULL,
+    "meta" JSONB NOT NULL,
+    "time" TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY ("context", "path")
+);
@@ -36,8 +36,34 @@ const (
 	logTransferInsertQuery         = `INSERT INTO "transfer_log" ("time", "origin", "message") VALUES (NOW(), $1, $2)`
 	selectMappedCountPerUuid       = `SELECT "uuid", COUNT("uuid") FROM "mapped_data" WHERE "origin" = $1 AND "time" BETWEEN $2 AND $2 + '5m'::interval GROUP BY 1`
 	selectFirstMappedDataPerOrigin = `SELECT "origin", MIN("start") FROM "transfer_local_data" GROUP BY 1`
+	metaUpsertQuery                = `INSERT INTO "meta_data" ("context", "path", "meta", "time") VALUES ($1, $2, $3, $4) ON CONFLICT ("context", "path") DO UPDATE SET "meta" = "meta_data"."meta" || EXCLUDED."meta", "time" = EXCLUDED."time"`
+	selectMetaQuery                = `SELECT "time", "context", "path", "meta" FROM "meta_data"`
+	selectHistoryQuery             = `SELECT time_bucket(make_interval(secs => $1), "time") AS "bucket", %s FROM "mapped_data" WHERE "context" = $2 AND "path" = $3 AND "time" >= $4 AND "time" < $5 %s GROUP BY 1 ORDER BY 1`
 )
 
+const (
+	HistoryMethodAverage = "average"
+	HistoryMethodMin     = "min"
+	HistoryMethodMax     = "max"
+	HistoryMethodFirst   = "first"
+	HistoryMethodLast    = "last"
+)
+
+// historyAggregates maps a history method on the aggregate and an extra condition, numeric aggregates only use numeric values
+var historyAggregates = map[string][2]string{
+	HistoryMethodAverage: {`to_jsonb(AVG("value"::double precision))`, `AND jsonb_typeof("value") = 'number'`},
+	HistoryMethodMin:     {`to_jsonb(MIN("value"::double precision))`, `AND jsonb_typeof("value") = 'number'`},
+	HistoryMethodMax:     {`to_jsonb(MAX("value"::double precision))`, `AND jsonb_typeof("value") = 'number'`},
+	HistoryMethodFirst:   {`first("value", "time")`, ``},
+	HistoryMethodLast:    {`last("value", "time")`, ``},
+}
+
+// HistoryValue is the aggregated value of a path in a time bucket
+type HistoryValue struct {
+	Timestamp time.Time
+	Value     interface{}
+}
+
 //go:embed migrations/*.sql
 var fs embed.FS
 
@@ -60,7 +86,9 @@ type PostgresqlDatabase struct {
 	batchSizeGauge  prometheus.Gauge
 }
 
-func NewPostgresqlDatabase(c *config.PostgresqlConfig) *PostgresqlDatabase {
+// NewPostgresqlDatabase creates the database, the metrics are registered with reg
+func NewPostgresqlDatabase(c *config.PostgresqlConfig, reg prometheus.Registerer) *PostgresqlDatabase {
+	factory := promauto.With(reg)
 	result := &PostgresqlDatabase{
 		url:             c.URLString,
 		batchSize:       c.BatchFlushLength,
@@ -68,11 +96,11 @@ func NewPostgresqlDatabase(c *config.PostgresqlConfig) *PostgresqlDatabase {
 		lastFlush:       time.Now(),
 		upgradeDone:     false,
 		databaseTimeout: c.Timeout,
-		flushes:         promauto.NewCounter(prometheus.CounterOpts{Name: "gosk_psql_flushes_total", Help: "total number batches flushed"}),
-		lastFlushGauge:  promauto.NewGauge(prometheus.GaugeOpts{Name: "gosk_psql_last_flush_time", Help: "last db flush"}),
-		writes:          promauto.NewCounter(prometheus.CounterOpts{Name: "gosk_psql_writes_total", Help: "total number of deltas added to queue"}),
-		timeouts:        promauto.NewCounter(prometheus.CounterOpts{Name: "gosk_psql_timeouts_total", Help: "total number timeouts"}),
-		batchSizeGauge:  promauto.NewGauge(prometheus.GaugeOpts{Name: "gosk_psql_batch_length", Help: "number of deltas in current batch"}),
+		flushes:         factory.NewCounter(prometheus.CounterOpts{Name: "gosk_psql_flushes_total", Help: "total number batches flushed"}),
+		lastFlushGauge:  factory.NewGauge(prometheus.GaugeOpts{Name: "gosk_psql_last_flush_time", Help: "last db flush"}),
+		writes:          factory.NewCounter(prometheus.CounterOpts{Name: "gosk_psql_writes_total", Help: "total number of deltas added to queue"}),
+		timeouts:        factory.NewCounter(prometheus.CounterOpts{Name: "gosk_psql_timeouts_total", Help: "total number timeouts"}),
+		batchSizeGauge:  factory.NewGauge(prometheus.GaugeOpts{Name: "gosk_psql_batch_length", Help: "number of deltas in current batch"}),
 	}
 	go func() {
 		ticker := time.NewTicker(c.BatchFlushInterval)
@@ -151,6 +179,21 @@ func (db *PostgresqlDatabase) WriteMapped(mapped message.Mapped) {
 	for _, svm := range mapped.ToSingleValueMapped() {
 		db.WriteSingleValueMapped(svm)
 	}
+	for _, u := range mapped.Updates {
+		for _, m := range u.Meta {
+			db.WriteMeta(mapped.Context, u.Timestamp, m)
+		}
+	}
+}
+
+// WriteMeta stores the metadata of a path, the properties are merged with the metadata that is already stored
+func (db *PostgresqlDatabase) WriteMeta(context string, timestamp time.Time, meta message.MetaValue) {
+	db.writes.Inc()
+	db.batchSizeGauge.Inc()
+	db.batch.Queue(metaUpsertQuery, context, meta.Path, meta.Value, timestamp)
+	if db.batch.Len() > db.batchSize {
+		go db.flushBatch()
+	}
 }
 
 func (db *PostgresqlDatabase) WriteSingleValueMapped(svm message.SingleValueMapped) {
@@ -232,6 +275,87 @@ func (db *PostgresqlDatabase) ReadMapped(appendToQuery string, arguments ...inte
 	return result, nil
 }
 
+// ReadMeta returns the stored metadata, each delta contains the metadata of a single path
+func (db *PostgresqlDatabase) ReadMeta() ([]message.Mapped, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.databaseTimeout)
+	defer cancel()
+	rows, err := db.GetConnection().Query(ctx, selectMetaQuery)
+	if err != nil {
+		return nil, err
+	} else if ctx.Err() != nil {
+		logger.GetLogger().Error("Timeout during database lookup")
+		db.timeouts.Inc()
+		return nil, ctx.Err()
+	}
+	defer rows.Close()
+
+	result := make([]message.Mapped, 0)
+	for rows.Next() {
+		var mappedContext string
+		m := message.NewMetaValue()
+		u := message.NewUpdate()
+		if err := rows.Scan(&u.Timestamp, &mappedContext, &m.Path, &m.Value); err != nil {
+			return nil, err
+		}
+		result = append(result, *message.NewMapped().WithContext(mappedContext).AddUpdate(u.AddMeta(m)))
+	}
+	// check for errors after last call to .Next()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// IsHistoryMethod returns true when the values can be aggregated with the method
+func IsHistoryMethod(method string) bool {
+	_, ok := historyAggregates[method]
+	return ok
+}
+
+// SelectHistory returns the values of a path aggregated in buckets of the given resolution, the resolution is passed
+// as a number of seconds
+func (db *PostgresqlDatabase) SelectHistory(mappedContext string, path string, method string, from time.Time, to time.Time, resolution time.Duration) ([]HistoryValue, error) {
+	aggregate, ok := historyAggregates[method]
+	if !ok {
+		return nil, fmt.Errorf("unsupported method %s", method)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), db.databaseTimeout)
+	defer cancel()
+	rows, err := db.GetConnection().Query(ctx, fmt.Sprintf(selectHistoryQuery, aggregate[0], aggregate[1]), resolution.Seconds(), mappedContext, path, from, to)
+	if err != nil {
+		return nil, err
+	} else if ctx.Err() != nil {
+		logger.GetLogger().Error("Timeout during database lookup")
+		db.timeouts.Inc()
+		return nil, ctx.Err()
+	}
+	defer rows.Close()
+
+	result := make([]HistoryValue, 0)
+	for rows.Next() {
+		var v HistoryValue
+		if err := rows.Scan(&v.Timestamp, &v.Value); err != nil {
+			return nil, err
+		}
+		if v.Value, err = message.Decode(v.Value); err != nil {
+			logger.GetLogger().Warn(
+				"Could not decode value",
+				zap.String("Error", err.Error()),
+				zap.Any("Value", v.Value),
+			)
+		}
+		result = append(result, v)
+	}
+	// check for errors after last call to .Next()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
 // Returns the start timestamp of each period that has local count but no remote count
 func (db *PostgresqlDatabase) SelectFirstMappedDataPerOrigin() (map[string]time.Time, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), db.databaseTimeout)
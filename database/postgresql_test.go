@@ -6,16 +6,18 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
 	"github.com/munnik/gosk/config"
 	. "github.com/munnik/gosk/database"
 	"github.com/munnik/gosk/message"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 var _ = Describe("Test database", Ordered, func() {
 	c := config.NewPostgresqlConfig("postgresql_test.yaml")
-	db := NewPostgresqlDatabase(c)
+	db := NewPostgresqlDatabase(c, prometheus.NewRegistry())
 
 	now := time.Now()
 
@@ -49,7 +51,7 @@ var _ = Describe("Test database", Ordered, func() {
 
 	Describe("Reconnect",
 		func() {
-			Context("ping", func() {
+			It("ping", func() {
 				db.GetConnection().Close()
 				err := db.GetConnection().Ping(context.Background())
 
@@ -104,3 +106,52 @@ var _ = Describe("Test database", Ordered, func() {
 		),
 	)
 })
+
+var _ = Describe("History", Ordered, func() {
+	c := config.NewPostgresqlConfig("postgresql_test.yaml")
+	db := NewPostgresqlDatabase(c, prometheus.NewRegistry())
+
+	now := time.Now()
+
+	// the history specs need a running database, they are skipped when it isn't available
+	BeforeAll(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		conn, err := pgx.Connect(ctx, c.URLString)
+		if err != nil {
+			Skip("PostgreSQL is not available: " + err.Error())
+		}
+		conn.Close(context.Background())
+	})
+
+	BeforeEach(func() {
+		err := db.UpgradeDatabase()
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		err := db.DowngradeDatabase()
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	It("aggregates the values in buckets of the resolution", func() {
+		insertQuery := `INSERT INTO "mapped_data" ("time", "connector", "type", "context", "path", "value", "uuid", "origin") VALUES ($1, 'testingLabel', 'testingType', 'testingContext', 'testingPath', $2, $3, 'testingOrigin')`
+		start := now.UTC().Truncate(time.Hour).Add(-time.Hour)
+		for offset, value := range map[time.Duration]float64{0: 1, 40 * time.Second: 3, 100 * time.Second: 10} {
+			_, err := db.GetConnection().Exec(context.Background(), insertQuery, start.Add(offset), value, uuid.New())
+			Expect(err).ShouldNot(HaveOccurred())
+		}
+
+		result, err := db.SelectHistory("testingContext", "testingPath", HistoryMethodAverage, start, start.Add(time.Hour), 90*time.Second)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(result).To(HaveLen(2))
+		Expect(result[0].Timestamp.Equal(start)).To(BeTrue())
+		Expect(result[0].Value).To(Equal(2.0))
+		Expect(result[1].Timestamp.Equal(start.Add(90 * time.Second))).To(BeTrue())
+		Expect(result[1].Value).To(Equal(10.0))
+	})
+	It("fails on an unsupported method", func() {
+		_, err := db.SelectHistory("testingContext", "testingPath", "median", now.Add(-time.Hour), now, time.Minute)
+		Expect(err).Should(HaveOccurred())
+	})
+})
@@ -28,6 +28,7 @@ require (
 	go.einride.tech/can v0.5.5
 	go.nanomsg.org/mangos/v3 v3.4.2
 	go.uber.org/zap v1.24.0
+	golang.org/x/sys v0.7.0
 	nhooyr.io/websocket v1.8.7
 )
 
@@ -75,7 +76,6 @@ require (
 	golang.org/x/mod v0.10.0 // indirect
 	golang.org/x/net v0.9.0 // indirect
 	golang.org/x/sync v0.1.0 // indirect
-	golang.org/x/sys v0.7.0 // indirect
 	golang.org/x/text v0.9.0 // indirect
 	golang.org/x/tools v0.8.0 // indirect
 	google.golang.org/protobuf v1.30.0 // indirect
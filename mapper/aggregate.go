@@ -8,6 +8,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/munnik/gosk/config"
 	"github.com/munnik/gosk/message"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.nanomsg.org/mangos/v3"
 )
 
@@ -16,9 +17,16 @@ type AggregateMapper struct {
 	protocol          string
 	aggregateMappings map[string][]config.ExpressionMappingConfig
 	env               ExpressionEnvironment
+	validator         *Validator
+	windows           *WindowFunctions
+	meta              *metaTracker
 }
 
-func NewAggregateMapper(c config.MapperConfig, emc []config.ExpressionMappingConfig) (*AggregateMapper, error) {
+func NewAggregateMapper(c config.MapperConfig, emc []config.ExpressionMappingConfig, reg prometheus.Registerer) (*AggregateMapper, error) {
+	validator, err := NewValidator(c.Validation, reg)
+	if err != nil {
+		return nil, err
+	}
 	env := NewExpressionEnvironment()
 
 	mappings := make(map[string][]config.ExpressionMappingConfig)
@@ -28,11 +36,11 @@ func NewAggregateMapper(c config.MapperConfig, emc []config.ExpressionMappingCon
 		}
 	}
 
-	return &AggregateMapper{config: c, protocol: config.SignalKType, aggregateMappings: mappings, env: env}, nil
+	return &AggregateMapper{config: c, protocol: config.SignalKType, aggregateMappings: mappings, env: env, validator: validator, windows: NewWindowFunctions(), meta: newMetaTracker()}, nil
 }
 
 func (m *AggregateMapper) Map(subscriber mangos.Socket, publisher mangos.Socket) {
-	processMapped(subscriber, publisher, m)
+	processMapped(subscriber, publisher, m, m.validator)
 }
 
 func (m *AggregateMapper) DoMap(input *message.Mapped) (*message.Mapped, error) {
@@ -48,12 +56,13 @@ func (m *AggregateMapper) DoMap(input *message.Mapped) (*message.Mapped, error)
 			m.env[path] = svm
 			vm := vm.VM{}
 			for _, mapping := range mappings {
-				output, err := runExpr(vm, m.env, mapping.MappingConfig)
+				output, err := runExpr(vm, m.env, mapping.MappingConfig, m.windows, input.Context, svm.Timestamp)
 				if err == nil { // don't insert a path twice
 					if v := u.GetValueByPath(mapping.Path); v != nil {
 						v.WithValue(output)
 					} else {
 						u.AddValue(message.NewValue().WithPath(mapping.Path).WithValue(output))
+						m.meta.addMeta(u, input.Context, mapping.MappingConfig)
 					}
 				}
 			}
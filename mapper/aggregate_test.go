@@ -9,12 +9,14 @@ import (
 	"github.com/munnik/gosk/message"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 var _ = Describe("DoMap aggregate same update", func() {
 	mapper, _ := NewAggregateMapper(
 		config.MapperConfig{Context: "testingContext"},
 		config.NewExpressionMappingConfig("aggregate_test.yaml"),
+		prometheus.NewRegistry(),
 	)
 	now := time.Now()
 	DescribeTable("Messages",
@@ -238,3 +240,45 @@ var _ = Describe("DoMap aggregate same update", func() {
 		),
 	)
 })
+
+var _ = Describe("DoMap aggregate with meta", func() {
+	var mapper *AggregateMapper
+	BeforeEach(func() {
+		mapper, _ = NewAggregateMapper(
+			config.MapperConfig{Context: "testingContext"},
+			config.NewExpressionMappingConfig("aggregate_meta_test.yaml"),
+			prometheus.NewRegistry(),
+		)
+	})
+	input := func(context string) *message.Mapped {
+		return message.NewMapped().WithContext(context).WithOrigin(context).AddUpdate(
+			message.NewUpdate().WithSource(
+				*message.NewSource().WithLabel("testingConnector").WithType(config.JSONType).WithUuid(uuid.Nil),
+			).WithTimestamp(
+				time.Now(),
+			).AddValue(
+				message.NewValue().WithPath("propulsion.port.drive.power").WithValue(10.0),
+			).AddValue(
+				message.NewValue().WithPath("propulsion.starboard.drive.power").WithValue(20.0),
+			),
+		)
+	}
+	meta := func(result *message.Mapped) []message.MetaValue {
+		return result.Updates[len(result.Updates)-1].Meta
+	}
+
+	It("adds the meta once per context", func() {
+		result, err := mapper.DoMap(input("firstContext"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(meta(result)).To(HaveLen(1))
+		Expect(meta(result)[0].Path).To(Equal("propulsion.combined.drive.power"))
+
+		result, err = mapper.DoMap(input("firstContext"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(meta(result)).To(BeEmpty())
+
+		result, err = mapper.DoMap(input("secondContext"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(meta(result)).To(HaveLen(1))
+	})
+})
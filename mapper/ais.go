@@ -0,0 +1,323 @@
+package mapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/munnik/gosk/config"
+	"github.com/munnik/gosk/logger"
+	"github.com/munnik/gosk/message"
+	"go.nanomsg.org/mangos/v3"
+	"go.uber.org/zap"
+)
+
+const (
+	aisContextPrefix    = "vessels.urn:mrn:imo:mmsi:"
+	aisCheckInterval    = 10 * time.Second
+	closestApproachPath = "navigation.closestApproach"
+	aisNotificationPath = "notifications.navigation.closestApproach"
+	earthRadius         = 6371000.0 // in meters
+)
+
+// aisTarget is the state of a single vessel, the static and voyage data is merged with the position reports
+type aisTarget struct {
+	mmsi              string
+	values            map[string]interface{}
+	positionTimestamp time.Time // timestamp of the last position, used to extrapolate the position
+	lastReceived      time.Time // local time the last position was received, used to age out the target
+	closestApproach   *message.ClosestApproach
+	state             string
+}
+
+func newAISTarget(mmsi string, now time.Time) *aisTarget {
+	return &aisTarget{
+		mmsi:         mmsi,
+		values:       make(map[string]interface{}),
+		lastReceived: now,
+		state:        config.NotificationStateNormal,
+	}
+}
+
+// update merges the value with the stored value of the path
+func (t *aisTarget) update(svm message.SingleValueMapped, now time.Time) {
+	if svm.Path == "navigation.position" {
+		t.positionTimestamp = svm.Timestamp
+		t.lastReceived = now
+	}
+	if current, ok := t.values[svm.Path].(message.Merger); ok {
+		if right, ok := svm.Value.(message.Merger); ok {
+			if merged, err := current.Merge(right); err == nil {
+				t.values[svm.Path] = merged
+				return
+			}
+		}
+	}
+	t.values[svm.Path] = svm.Value
+}
+
+func (t *aisTarget) name() string {
+	if v, ok := t.values[""].(message.VesselInfo); ok && v.Name != nil && *v.Name != "" {
+		return *v.Name
+	}
+	return t.mmsi
+}
+
+// motion returns the position extrapolated to now in meters relative to the origin and the velocity in meters per
+// second, false is returned when the position is unknown
+func (t *aisTarget) motion(origin message.Position, now time.Time) (x float64, y float64, vx float64, vy float64, ok bool) {
+	p, ok := t.values["navigation.position"].(message.Position)
+	if !ok || p.Latitude == nil || p.Longitude == nil {
+		return 0, 0, 0, 0, false
+	}
+	x = (*p.Longitude - *origin.Longitude) * math.Pi / 180 * earthRadius * math.Cos(*origin.Latitude*math.Pi/180)
+	y = (*p.Latitude - *origin.Latitude) * math.Pi / 180 * earthRadius
+
+	// a target without speed or course, like a moored vessel, is stationary
+	if f, err := ListToFloats([]interface{}{t.values["navigation.speedOverGround"], t.values["navigation.courseOverGroundTrue"]}); err == nil {
+		vx = f[0] * math.Sin(f[1])
+		vy = f[0] * math.Cos(f[1])
+	}
+	elapsed := now.Sub(t.positionTimestamp).Seconds()
+	return x + vx*elapsed, y + vy*elapsed, vx, vy, true
+}
+
+// AISMapper tracks the AIS targets and calculates the closest point of approach of each target, the received deltas
+// are passed through
+type AISMapper struct {
+	config  *config.AISConfig
+	mu      sync.Mutex
+	self    *aisTarget
+	targets map[string]*aisTarget // map of context to target
+}
+
+func NewAISMapper(c *config.AISConfig) (*AISMapper, error) {
+	for _, z := range c.Zones {
+		if _, ok := notificationSeverity[z.State]; !ok {
+			return nil, fmt.Errorf("the zone state %s of the AIS configuration is not valid", z.State)
+		}
+	}
+	return &AISMapper{
+		config:  c,
+		self:    newAISTarget("", time.Now()),
+		targets: make(map[string]*aisTarget),
+	}, nil
+}
+
+func (m *AISMapper) Map(subscriber mangos.Socket, publisher mangos.Socket) {
+	if m.config.URL != nil && m.config.URL.Host != "" {
+		go m.serveTargets()
+	}
+	go m.checkPeriodically(publisher)
+	processMapped(subscriber, publisher, m, nil)
+}
+
+func (m *AISMapper) DoMap(input *message.Mapped) (*message.Mapped, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if input.Context == m.config.SelfContext {
+		for _, svm := range input.ToSingleValueMapped() {
+			m.self.update(svm, now)
+		}
+		return input, nil
+	}
+	if !strings.HasPrefix(input.Context, aisContextPrefix) {
+		return input, nil
+	}
+
+	t, ok := m.targets[input.Context]
+	if !ok {
+		t = newAISTarget(strings.TrimPrefix(input.Context, aisContextPrefix), now)
+		m.targets[input.Context] = t
+	}
+	for _, svm := range input.ToSingleValueMapped() {
+		if strings.HasPrefix(svm.Path, "notifications.") || svm.Path == closestApproachPath {
+			continue // the output of the tracker itself
+		}
+		t.update(svm, now)
+	}
+
+	if u := m.closestApproach(t, now); u != nil {
+		input.AddUpdate(u)
+	}
+	return input, nil
+}
+
+// Check removes the targets that are lost and updates the closest point of approach of the other targets, because
+// it also changes when self moves
+func (m *AISMapper) Check(now time.Time) []message.Mapped {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make([]message.Mapped, 0)
+	for context, t := range m.targets {
+		if now.Sub(t.lastReceived) > m.config.LostTimeout {
+			delete(m.targets, context)
+			logger.GetLogger().Info(
+				"AIS target lost",
+				zap.String("MMSI", t.mmsi),
+			)
+			if t.state == config.NotificationStateNormal {
+				continue
+			}
+			u := m.newUpdate(now)
+			u.AddValue(message.NewValue().WithPath(aisNotificationPath).WithValue(m.notification(config.NotificationStateNormal, fmt.Sprintf("%s lost", t.name()))))
+			result = append(result, *message.NewMapped().WithContext(context).WithOrigin(m.config.SelfContext).AddUpdate(u))
+			continue
+		}
+		if u := m.closestApproach(t, now); u != nil {
+			result = append(result, *message.NewMapped().WithContext(context).WithOrigin(m.config.SelfContext).AddUpdate(u))
+		}
+	}
+	return result
+}
+
+// closestApproach calculates the closest point of approach of the target, the returned update also contains the
+// notification when the state changed, nil is returned when the position of self or the target is unknown
+func (m *AISMapper) closestApproach(t *aisTarget, now time.Time) *message.Update {
+	origin, ok := m.self.values["navigation.position"].(message.Position)
+	if !ok || origin.Latitude == nil || origin.Longitude == nil {
+		return nil
+	}
+	sx, sy, svx, svy, _ := m.self.motion(origin, now)
+	tx, ty, tvx, tvy, ok := t.motion(origin, now)
+	if !ok {
+		return nil
+	}
+
+	// relative position and velocity of the target
+	rx, ry, vx, vy := tx-sx, ty-sy, tvx-svx, tvy-svy
+	timeTo := 0.0
+	if v2 := vx*vx + vy*vy; v2 > 1e-9 {
+		timeTo = -(rx*vx + ry*vy) / v2
+	}
+	distance := math.Hypot(rx+vx*timeTo, ry+vy*timeTo)
+	t.closestApproach = &message.ClosestApproach{Distance: &distance, TimeTo: &timeTo}
+
+	u := m.newUpdate(now)
+	u.AddValue(message.NewValue().WithPath(closestApproachPath).WithValue(*t.closestApproach))
+
+	state, msg := config.NotificationStateNormal, ""
+	if timeTo >= 0 && timeTo <= m.config.MaxTimeToCPA.Seconds() {
+		for _, z := range m.config.Zones {
+			if distance <= z.Distance && notificationSeverity[z.State] > notificationSeverity[state] {
+				state, msg = z.State, z.Message
+			}
+		}
+	}
+	if state != t.state {
+		t.state = state
+		if msg == "" {
+			msg = fmt.Sprintf("Closest approach of %s is %.0f m in %.0f s", t.name(), distance, timeTo)
+		}
+		u.AddValue(message.NewValue().WithPath(aisNotificationPath).WithValue(m.notification(state, msg)))
+	}
+	return u
+}
+
+func (m *AISMapper) newUpdate(now time.Time) *message.Update {
+	s := message.NewSource().WithLabel("ais").WithType(config.SignalKType).WithUuid(uuid.Nil)
+	return message.NewUpdate().WithSource(*s).WithTimestamp(now)
+}
+
+func (m *AISMapper) notification(state string, msg string) message.Notification {
+	method := m.config.Method
+	if state == config.NotificationStateNormal {
+		method = []string{}
+	}
+	return message.Notification{State: &state, Method: method, Message: &msg}
+}
+
+func (m *AISMapper) checkPeriodically(publisher mangos.Socket) {
+	ticker := time.NewTicker(aisCheckInterval)
+	for now := range ticker.C {
+		for _, mapped := range m.Check(now) {
+			sendMapped(publisher, &mapped)
+		}
+	}
+}
+
+// GeoJSONFeatureCollection contains a point feature per AIS target
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJSONFeature `json:"features"`
+}
+
+type GeoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   GeoJSONGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type GeoJSONGeometry struct {
+	Type        string    `json:"type"`
+	Coordinates []float64 `json:"coordinates"`
+}
+
+// Targets returns the targets with a known position as GeoJSON points, the properties are the values of the target
+func (m *AISMapper) Targets() GeoJSONFeatureCollection {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := GeoJSONFeatureCollection{Type: "FeatureCollection", Features: make([]GeoJSONFeature, 0, len(m.targets))}
+	for _, t := range m.targets {
+		p, ok := t.values["navigation.position"].(message.Position)
+		if !ok || p.Latitude == nil || p.Longitude == nil {
+			continue
+		}
+		properties := map[string]interface{}{
+			"mmsi":  t.mmsi,
+			"name":  t.name(),
+			"state": t.state,
+		}
+		for path, value := range t.values {
+			if path != "" && path != "navigation.position" {
+				properties[path] = value
+			}
+		}
+		if t.closestApproach != nil {
+			properties[closestApproachPath] = *t.closestApproach
+		}
+		result.Features = append(result.Features, GeoJSONFeature{
+			Type:       "Feature",
+			Geometry:   GeoJSONGeometry{Type: "Point", Coordinates: []float64{*p.Longitude, *p.Latitude}},
+			Properties: properties,
+		})
+	}
+	sort.Slice(result.Features, func(i, j int) bool {
+		return result.Features[i].Properties["mmsi"].(string) < result.Features[j].Properties["mmsi"].(string)
+	})
+	return result
+}
+
+func (m *AISMapper) serveTargets() {
+	path := m.config.URL.Path
+	if path == "" {
+		path = "/"
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/geo+json")
+		if err := json.NewEncoder(w).Encode(m.Targets()); err != nil {
+			logger.GetLogger().Warn(
+				"Could not encode the AIS targets",
+				zap.String("Error", err.Error()),
+			)
+		}
+	})
+	if err := http.ListenAndServe(m.config.URL.Host, mux); err != nil {
+		logger.GetLogger().Fatal(
+			"Could not serve the AIS targets",
+			zap.String("URL", m.config.URLString),
+			zap.String("Error", err.Error()),
+		)
+	}
+}
@@ -0,0 +1,102 @@
+package mapper_test
+
+import (
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/munnik/gosk/config"
+	. "github.com/munnik/gosk/mapper"
+	"github.com/munnik/gosk/message"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AISMapper", func() {
+	const self = "vessels.urn:mrn:imo:mmsi:244770688"
+	const target = "vessels.urn:mrn:imo:mmsi:244123456"
+	var m *AISMapper
+	var now time.Time
+	BeforeEach(func() {
+		var err error
+		m, err = NewAISMapper(config.NewAISConfig("ais_test.yaml"))
+		Expect(err).NotTo(HaveOccurred())
+		now = time.Now()
+	})
+	delta := func(context string, values ...*message.Value) *message.Mapped {
+		u := message.NewUpdate().WithSource(
+			*message.NewSource().WithLabel("testingConnector").WithType(config.NMEA0183Type).WithUuid(uuid.Nil),
+		).WithTimestamp(now)
+		for _, v := range values {
+			u.AddValue(v)
+		}
+		return message.NewMapped().WithContext(context).WithOrigin(context).AddUpdate(u)
+	}
+	position := func(latitude float64, longitude float64) *message.Value {
+		return message.NewValue().WithPath("navigation.position").WithValue(message.Position{Latitude: &latitude, Longitude: &longitude})
+	}
+	speed := func(speed float64) *message.Value {
+		return message.NewValue().WithPath("navigation.speedOverGround").WithValue(speed)
+	}
+	course := func(course float64) *message.Value {
+		return message.NewValue().WithPath("navigation.courseOverGroundTrue").WithValue(course)
+	}
+	It("Passes the deltas through and doesn't calculate the closest approach without the position of self", func() {
+		result, err := m.DoMap(delta(target, position(52.01, 5)))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Updates).To(HaveLen(1))
+	})
+	It("Calculates the closest approach of a target on a collision course", func() {
+		m.DoMap(delta(self, position(52, 5), speed(5), course(0)))
+		// about 1112 meters north heading south
+		result, _ := m.DoMap(delta(target, position(52.01, 5), speed(5), course(math.Pi)))
+		Expect(result.Updates).To(HaveLen(2))
+		v := valuesByPath(result)
+		ca := v["navigation.closestApproach"].(message.ClosestApproach)
+		Expect(*ca.Distance).To(BeNumerically("<", 1))
+		Expect(*ca.TimeTo).To(BeNumerically("~", 111.2, 0.5))
+		n := v["notifications.navigation.closestApproach"].(message.Notification)
+		Expect(*n.State).To(Equal(config.NotificationStateAlarm))
+		Expect(*n.Message).To(Equal("Collision danger"))
+		Expect(n.Method).To(Equal([]string{"visual", "sound"}))
+	})
+	It("Doesn't raise a notification when the closest approach has passed", func() {
+		m.DoMap(delta(self, position(52, 5), speed(5), course(0)))
+		result, _ := m.DoMap(delta(target, position(51.99, 5), speed(5), course(math.Pi)))
+		v := valuesByPath(result)
+		Expect(*v["navigation.closestApproach"].(message.ClosestApproach).TimeTo).To(BeNumerically("<", 0))
+		Expect(v).NotTo(HaveKey("notifications.navigation.closestApproach"))
+	})
+	It("Only adds a notification when the state changes", func() {
+		m.DoMap(delta(self, position(52, 5), speed(5), course(0)))
+		m.DoMap(delta(target, position(52.01, 5), speed(5), course(math.Pi)))
+		result, _ := m.DoMap(delta(target, position(52.01, 5)))
+		Expect(valuesByPath(result)).NotTo(HaveKey("notifications.navigation.closestApproach"))
+	})
+	It("Merges the static data with the position reports", func() {
+		name := "Test vessel"
+		mmsi := "244123456"
+		m.DoMap(delta(target, message.NewValue().WithPath("").WithValue(message.VesselInfo{MMSI: &mmsi})))
+		m.DoMap(delta(target, message.NewValue().WithPath("").WithValue(message.VesselInfo{Name: &name}), message.NewValue().WithPath("communication.callsignVhf").WithValue("PD1234")))
+		m.DoMap(delta(target, position(52.01, 5), speed(5)))
+		targets := m.Targets()
+		Expect(targets.Features).To(HaveLen(1))
+		f := targets.Features[0]
+		Expect(f.Geometry.Coordinates).To(Equal([]float64{5, 52.01}))
+		Expect(f.Properties).To(HaveKeyWithValue("mmsi", mmsi))
+		Expect(f.Properties).To(HaveKeyWithValue("name", name))
+		Expect(f.Properties).To(HaveKeyWithValue("communication.callsignVhf", "PD1234"))
+		Expect(f.Properties).To(HaveKeyWithValue("navigation.speedOverGround", 5.0))
+	})
+	It("Ages out lost targets and clears the notification", func() {
+		m.DoMap(delta(self, position(52, 5), speed(5), course(0)))
+		m.DoMap(delta(target, position(52.01, 5), speed(5), course(math.Pi)))
+		Expect(m.Check(now.Add(time.Minute))).To(HaveLen(1))
+		result := m.Check(now.Add(7 * time.Minute))
+		Expect(result).To(HaveLen(1))
+		n := valuesByPath(&result[0])["notifications.navigation.closestApproach"].(message.Notification)
+		Expect(*n.State).To(Equal(config.NotificationStateNormal))
+		Expect(m.Targets().Features).To(BeEmpty())
+		Expect(m.Check(now.Add(8 * time.Minute))).To(BeEmpty())
+	})
+})
@@ -0,0 +1,393 @@
+package mapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antonmedv/expr/vm"
+	"github.com/google/uuid"
+	"github.com/munnik/gosk/config"
+	"github.com/munnik/gosk/logger"
+	"github.com/munnik/gosk/message"
+	"github.com/munnik/gosk/nanomsg"
+	"go.nanomsg.org/mangos/v3"
+	"go.uber.org/zap"
+)
+
+const staleCheckInterval = time.Second
+
+var notificationSeverity = map[string]int{
+	config.NotificationStateNormal:    0,
+	config.NotificationStateAlert:     1,
+	config.NotificationStateWarn:      2,
+	config.NotificationStateAlarm:     3,
+	config.NotificationStateEmergency: 4,
+}
+
+// alarm is the state of a single rule for a single context
+type alarm struct {
+	rule            *config.AlarmRuleConfig
+	state           string
+	message         string
+	acknowledged    bool
+	zone            int    // index of the active zone, -1 when no zone is active
+	expressionState string // state returned by the expression
+	rateExceeded    bool
+	stale           bool
+	lastValue       float64
+	lastTimestamp   time.Time // timestamp of the last value, used for the rate of change
+	lastReceived    time.Time // local time the last value was received, used for the stale timeout
+}
+
+func newAlarm(rule *config.AlarmRuleConfig, now time.Time) *alarm {
+	return &alarm{
+		rule:            rule,
+		zone:            -1,
+		expressionState: config.NotificationStateNormal,
+		lastReceived:    now,
+	}
+}
+
+// receive updates the conditions that depend on the value of the first source path
+func (a *alarm) receive(value float64, timestamp time.Time, now time.Time) {
+	if a.rule.RateOfChange > 0 && !a.lastTimestamp.IsZero() {
+		if elapsed := timestamp.Sub(a.lastTimestamp).Seconds(); elapsed > 0 {
+			a.rateExceeded = math.Abs(value-a.lastValue)/elapsed > a.rule.RateOfChange
+		}
+	}
+	a.lastValue = value
+	a.lastTimestamp = timestamp
+	a.lastReceived = now
+	a.stale = false
+
+	zones := a.rule.Zones
+	if a.zone != -1 && !inZone(zones[a.zone], value, a.rule.Hysteresis) {
+		a.zone = -1
+	}
+	for i, z := range zones {
+		if inZone(z, value, 0) && (a.zone == -1 || notificationSeverity[z.State] > notificationSeverity[zones[a.zone].State]) {
+			a.zone = i
+		}
+	}
+}
+
+func inZone(z config.AlarmZoneConfig, value float64, margin float64) bool {
+	return (z.Lower == nil || value >= *z.Lower-margin) && (z.Upper == nil || value <= *z.Upper+margin)
+}
+
+// update determines the state from the conditions and returns true when the state or the message changed
+func (a *alarm) update() bool {
+	state := config.NotificationStateNormal
+	msg := a.rule.Message
+	raise := func(s string, m string) {
+		if notificationSeverity[s] > notificationSeverity[state] {
+			state = s
+			if m != "" {
+				msg = m
+			}
+		}
+	}
+	if a.zone != -1 {
+		raise(a.rule.Zones[a.zone].State, a.rule.Zones[a.zone].Message)
+	}
+	if a.stale {
+		raise(a.rule.State, fmt.Sprintf("No updates of %s for more than %s", a.rule.SourcePaths[0], a.rule.StaleTimeout))
+	}
+	if a.rateExceeded {
+		raise(a.rule.State, fmt.Sprintf("%s changes faster than %g per second", a.rule.SourcePaths[0], a.rule.RateOfChange))
+	}
+	raise(a.expressionState, "")
+
+	if state == a.state && msg == a.message {
+		return false
+	}
+	if state == config.NotificationStateNormal || notificationSeverity[state] > notificationSeverity[a.state] {
+		a.acknowledged = false
+	}
+	a.state = state
+	a.message = msg
+	return true
+}
+
+func (a *alarm) notification() message.Notification {
+	state := a.state
+	msg := a.message
+	method := a.rule.Method
+	if a.acknowledged || a.state == config.NotificationStateNormal {
+		method = []string{}
+	}
+	return message.Notification{State: &state, Method: method, Message: &msg}
+}
+
+// AlarmMapper raises SignalK notifications based on the rules, the received deltas are passed through
+type AlarmMapper struct {
+	config  *config.AlarmConfig
+	mu      sync.Mutex
+	rules   map[string][]*config.AlarmRuleConfig // map of source path to the rules that use it
+	alarms  map[string]map[string]*alarm         // map of context and notification path to the alarm
+	envs    map[string]ExpressionEnvironment     // map of context to the expression environment
+	windows *WindowFunctions
+}
+
+func NewAlarmMapper(c *config.AlarmConfig) (*AlarmMapper, error) {
+	rules := make(map[string][]*config.AlarmRuleConfig)
+	for i := range c.Rules {
+		r := &c.Rules[i]
+		if len(r.SourcePaths) == 0 {
+			return nil, fmt.Errorf("the alarm rule for %s has no source paths", r.Path)
+		}
+		for _, z := range r.Zones {
+			if _, ok := notificationSeverity[z.State]; !ok {
+				return nil, fmt.Errorf("the zone state %s of the alarm rule for %s is not valid", z.State, r.Path)
+			}
+		}
+		if _, ok := notificationSeverity[r.State]; !ok {
+			return nil, fmt.Errorf("the state %s of the alarm rule for %s is not valid", r.State, r.Path)
+		}
+		for _, s := range r.SourcePaths {
+			rules[s] = append(rules[s], r)
+		}
+	}
+
+	return &AlarmMapper{
+		config:  c,
+		rules:   rules,
+		alarms:  make(map[string]map[string]*alarm),
+		envs:    make(map[string]ExpressionEnvironment),
+		windows: NewWindowFunctions(),
+	}, nil
+}
+
+func (m *AlarmMapper) Map(subscriber mangos.Socket, publisher mangos.Socket) {
+	if m.config.AcknowledgeURL != "" {
+		acknowledgements, err := nanomsg.NewSubAsync([]string{m.config.AcknowledgeURL}, []byte{})
+		if err != nil {
+			logger.GetLogger().Fatal(
+				"Could not subscribe to the acknowledgements",
+				zap.String("URL", m.config.AcknowledgeURL),
+				zap.String("Error", err.Error()),
+			)
+		}
+		go m.receiveAcknowledgements(acknowledgements, publisher)
+	}
+	go m.checkStalePeriodically(publisher)
+	processMapped(subscriber, publisher, m, nil)
+}
+
+func (m *AlarmMapper) DoMap(input *message.Mapped) (*message.Mapped, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	env, ok := m.envs[input.Context]
+	if !ok {
+		env = NewExpressionEnvironment()
+		m.envs[input.Context] = env
+	}
+
+	s := message.NewSource().WithLabel("alarm").WithType(config.SignalKType).WithUuid(uuid.Nil)
+	u := message.NewUpdate().WithSource(*s).WithTimestamp(time.Time{}) // initialize with empty timestamp instead of hidden now
+	affected := make([]*config.AlarmRuleConfig, 0)
+	for _, svm := range input.ToSingleValueMapped() {
+		rules, ok := m.rules[svm.Path]
+		if !ok {
+			continue
+		}
+		if svm.Timestamp.After(u.Timestamp) { // take most recent timestamp from relevant data
+			u.WithTimestamp(svm.Timestamp)
+		}
+		u.Source.Uuid = svm.Source.Uuid // take the uuid from the message that updated this value
+		env[strings.ReplaceAll(svm.Path, ".", "_")] = svm
+		for _, r := range rules {
+			if r.SourcePaths[0] == svm.Path {
+				if value, err := ListToFloats([]interface{}{svm.Value}); err == nil {
+					m.getAlarm(input.Context, r, now).receive(value[0], svm.Timestamp, now)
+				}
+			}
+			affected = appendRule(affected, r)
+		}
+	}
+
+	for _, r := range affected {
+		a := m.getAlarm(input.Context, r, now)
+		if r.Expression != "" && hasSourcePaths(env, r.SourcePaths) {
+			state, err := m.evaluate(input.Context, env, r, u.Timestamp)
+			if err != nil {
+				logger.GetLogger().Warn(
+					"Could not evaluate the alarm rule",
+					zap.String("Path", r.Path),
+					zap.String("Error", err.Error()),
+				)
+			} else {
+				a.expressionState = state
+			}
+		}
+		if a.update() {
+			u.AddValue(message.NewValue().WithPath(r.Path).WithValue(a.notification()))
+		}
+	}
+
+	if len(u.Values) > 0 {
+		return input.AddUpdate(u), nil
+	}
+	return input, nil
+}
+
+// CheckStale raises the notifications of the rules with a stale timeout that didn't receive updates in time
+func (m *AlarmMapper) CheckStale(now time.Time) []message.Mapped {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make([]message.Mapped, 0)
+	for context, alarms := range m.alarms {
+		s := message.NewSource().WithLabel("alarm").WithType(config.SignalKType).WithUuid(uuid.Nil)
+		u := message.NewUpdate().WithSource(*s).WithTimestamp(now)
+		for path, a := range alarms {
+			if a.rule.StaleTimeout == 0 || a.stale || now.Sub(a.lastReceived) <= a.rule.StaleTimeout {
+				continue
+			}
+			a.stale = true
+			if a.update() {
+				u.AddValue(message.NewValue().WithPath(path).WithValue(a.notification()))
+			}
+		}
+		if len(u.Values) > 0 {
+			result = append(result, *message.NewMapped().WithContext(context).WithOrigin(context).AddUpdate(u))
+		}
+	}
+	return result
+}
+
+// Acknowledge silences the active notification on the path, the notification is raised again when the state gets
+// more severe, nil is returned when there is no active notification
+func (m *AlarmMapper) Acknowledge(context string, path string) *message.Mapped {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a, ok := m.alarms[context][path]
+	if !ok || a.acknowledged || a.state == config.NotificationStateNormal {
+		return nil
+	}
+	a.acknowledged = true
+	logger.GetLogger().Info(
+		"Notification acknowledged",
+		zap.String("Context", context),
+		zap.String("Path", path),
+		zap.String("State", a.state),
+	)
+
+	s := message.NewSource().WithLabel("alarm").WithType(config.SignalKType).WithUuid(uuid.Nil)
+	u := message.NewUpdate().WithSource(*s).WithTimestamp(time.Now())
+	u.AddValue(message.NewValue().WithPath(path).WithValue(a.notification()))
+	return message.NewMapped().WithContext(context).WithOrigin(context).AddUpdate(u)
+}
+
+func (m *AlarmMapper) getAlarm(context string, rule *config.AlarmRuleConfig, now time.Time) *alarm {
+	alarms, ok := m.alarms[context]
+	if !ok {
+		alarms = make(map[string]*alarm)
+		m.alarms[context] = alarms
+	}
+	a, ok := alarms[rule.Path]
+	if !ok {
+		a = newAlarm(rule, now)
+		alarms[rule.Path] = a
+	}
+	return a
+}
+
+// evaluate runs the expression of the rule for the context, the expression returns a bool or a state
+func (m *AlarmMapper) evaluate(context string, env ExpressionEnvironment, rule *config.AlarmRuleConfig, timestamp time.Time) (string, error) {
+	output, err := runExpr(vm.VM{}, env, rule.MappingConfig, m.windows, context, timestamp)
+	if err != nil {
+		return "", err
+	}
+	switch o := output.(type) {
+	case bool:
+		if o {
+			return rule.State, nil
+		}
+		return config.NotificationStateNormal, nil
+	case string:
+		if _, ok := notificationSeverity[o]; ok {
+			return o, nil
+		}
+	}
+	return "", fmt.Errorf("expected a bool or a notification state but got %v", output)
+}
+
+func (m *AlarmMapper) checkStalePeriodically(publisher mangos.Socket) {
+	ticker := time.NewTicker(staleCheckInterval)
+	for now := range ticker.C {
+		for _, mapped := range m.CheckStale(now) {
+			sendMapped(publisher, &mapped)
+		}
+	}
+}
+
+func (m *AlarmMapper) receiveAcknowledgements(subscriber mangos.Socket, publisher mangos.Socket) {
+	for {
+		received, err := subscriber.Recv()
+		if err != nil {
+			logger.GetLogger().Warn(
+				"Could not receive a message from the publisher",
+				zap.String("Error", err.Error()),
+			)
+			continue
+		}
+		var mapped message.Mapped
+		if err := json.Unmarshal(received, &mapped); err != nil {
+			logger.GetLogger().Warn(
+				"Could not unmarshal the received data",
+				zap.ByteString("Received", received),
+				zap.String("Error", err.Error()),
+			)
+			continue
+		}
+		for _, svm := range mapped.ToSingleValueMapped() {
+			if out := m.Acknowledge(svm.Context, svm.Path); out != nil {
+				sendMapped(publisher, out)
+			}
+		}
+	}
+}
+
+func sendMapped(publisher mangos.Socket, mapped *message.Mapped) {
+	bytes, err := json.Marshal(mapped)
+	if err != nil {
+		logger.GetLogger().Warn(
+			"Could not marshal the mapped data",
+			zap.String("Error", err.Error()),
+		)
+		return
+	}
+	if err := publisher.Send(bytes); err != nil {
+		logger.GetLogger().Warn(
+			"Unable to send the message using NanoMSG",
+			zap.ByteString("Message", bytes),
+			zap.String("Error", err.Error()),
+		)
+	}
+}
+
+func hasSourcePaths(env ExpressionEnvironment, paths []string) bool {
+	for _, p := range paths {
+		if _, ok := env[strings.ReplaceAll(p, ".", "_")]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func appendRule(rules []*config.AlarmRuleConfig, rule *config.AlarmRuleConfig) []*config.AlarmRuleConfig {
+	for _, r := range rules {
+		if r == rule {
+			return rules
+		}
+	}
+	return append(rules, rule)
+}
@@ -0,0 +1,131 @@
+package mapper_test
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/munnik/gosk/config"
+	. "github.com/munnik/gosk/mapper"
+	"github.com/munnik/gosk/message"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AlarmMapper", func() {
+	const context = "vessels.urn:mrn:imo:mmsi:123456789"
+	now := time.Now()
+	var m *AlarmMapper
+	BeforeEach(func() {
+		var err error
+		m, err = NewAlarmMapper(config.NewAlarmConfig("alarm_test.yaml"))
+		Expect(err).NotTo(HaveOccurred())
+	})
+	delta := func(timestamp time.Time, values ...*message.Value) *message.Mapped {
+		u := message.NewUpdate().WithSource(
+			*message.NewSource().WithLabel("testingConnector").WithType(config.ModbusType).WithUuid(uuid.Nil),
+		).WithTimestamp(timestamp)
+		for _, v := range values {
+			u.AddValue(v)
+		}
+		return message.NewMapped().WithContext(context).WithOrigin(context).AddUpdate(u)
+	}
+	notifications := func(mapped *message.Mapped) map[string]message.Notification {
+		result := make(map[string]message.Notification)
+		for _, svm := range mapped.ToSingleValueMapped() {
+			if n, ok := svm.Value.(message.Notification); ok {
+				result[svm.Path] = n
+			}
+		}
+		return result
+	}
+	temperature := func(value float64) *message.Value {
+		return message.NewValue().WithPath("propulsion.mainEngine.temperature").WithValue(value)
+	}
+
+	It("Passes the delta through and adds the notification", func() {
+		result, err := m.DoMap(delta(now, temperature(350)))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Updates).To(HaveLen(2))
+		Expect(result.Updates[0].Values[0].Path).To(Equal("propulsion.mainEngine.temperature"))
+		n := notifications(result)["notifications.propulsion.mainEngine.temperature"]
+		Expect(*n.State).To(Equal(config.NotificationStateNormal))
+		Expect(n.Method).To(BeEmpty())
+	})
+	It("Only adds a notification when the state changes", func() {
+		m.DoMap(delta(now, temperature(350)))
+		result, _ := m.DoMap(delta(now, temperature(351)))
+		Expect(result.Updates).To(HaveLen(1))
+	})
+	It("Uses the most severe zone and applies the hysteresis when leaving a zone", func() {
+		m.DoMap(delta(now, temperature(350)))
+		result, _ := m.DoMap(delta(now, temperature(365)))
+		n := notifications(result)["notifications.propulsion.mainEngine.temperature"]
+		Expect(*n.State).To(Equal(config.NotificationStateWarn))
+		Expect(*n.Message).To(Equal("Main engine temperature is high"))
+		Expect(n.Method).To(Equal([]string{"visual", "sound"}))
+
+		result, _ = m.DoMap(delta(now, temperature(380)))
+		n = notifications(result)["notifications.propulsion.mainEngine.temperature"]
+		Expect(*n.State).To(Equal(config.NotificationStateAlarm))
+		Expect(*n.Message).To(Equal("Main engine temperature is too high"))
+
+		result, _ = m.DoMap(delta(now, temperature(372)))
+		Expect(notifications(result)).To(BeEmpty())
+
+		result, _ = m.DoMap(delta(now, temperature(370)))
+		n = notifications(result)["notifications.propulsion.mainEngine.temperature"]
+		Expect(*n.State).To(Equal(config.NotificationStateWarn))
+
+		result, _ = m.DoMap(delta(now, temperature(362)))
+		Expect(notifications(result)).To(BeEmpty())
+
+		result, _ = m.DoMap(delta(now, temperature(360)))
+		n = notifications(result)["notifications.propulsion.mainEngine.temperature"]
+		Expect(*n.State).To(Equal(config.NotificationStateNormal))
+	})
+	It("Raises a notification when the value changes too fast", func() {
+		level := func(value float64) *message.Value {
+			return message.NewValue().WithPath("tanks.fuel.portAft.currentLevel").WithValue(value)
+		}
+		m.DoMap(delta(now, level(0.5)))
+		result, _ := m.DoMap(delta(now.Add(10*time.Second), level(0.55)))
+		Expect(notifications(result)).To(BeEmpty())
+		result, _ = m.DoMap(delta(now.Add(20*time.Second), level(0.75)))
+		n := notifications(result)["notifications.tanks.fuel.portAft"]
+		Expect(*n.State).To(Equal(config.NotificationStateWarn))
+	})
+	It("Raises a notification when the value is stale", func() {
+		m.DoMap(delta(now, message.NewValue().WithPath("tanks.fuel.portAft.currentLevel").WithValue(0.5)))
+		Expect(m.CheckStale(time.Now().Add(30 * time.Second))).To(BeEmpty())
+		result := m.CheckStale(time.Now().Add(2 * time.Minute))
+		Expect(result).To(HaveLen(1))
+		Expect(result[0].Context).To(Equal(context))
+		n := notifications(&result[0])["notifications.tanks.fuel.portAft"]
+		Expect(*n.State).To(Equal(config.NotificationStateWarn))
+		Expect(m.CheckStale(time.Now().Add(3 * time.Minute))).To(BeEmpty())
+	})
+	It("Evaluates the expression when all source paths are received", func() {
+		result, _ := m.DoMap(delta(now, message.NewValue().WithPath("propulsion.mainEngine.revolutions").WithValue(10.0)))
+		n := notifications(result)["notifications.propulsion.mainEngine.oilPressure"]
+		Expect(*n.State).To(Equal(config.NotificationStateNormal))
+		result, _ = m.DoMap(delta(now, message.NewValue().WithPath("propulsion.mainEngine.oilPressure").WithValue(100000.0)))
+		n = notifications(result)["notifications.propulsion.mainEngine.oilPressure"]
+		Expect(*n.State).To(Equal(config.NotificationStateAlarm))
+		Expect(*n.Message).To(Equal("Low oil pressure"))
+	})
+	It("Silences an acknowledged notification until the state gets more severe", func() {
+		Expect(m.Acknowledge(context, "notifications.propulsion.mainEngine.temperature")).To(BeNil())
+		m.DoMap(delta(now, temperature(365)))
+		result := m.Acknowledge(context, "notifications.propulsion.mainEngine.temperature")
+		Expect(result).NotTo(BeNil())
+		n := notifications(result)["notifications.propulsion.mainEngine.temperature"]
+		Expect(*n.State).To(Equal(config.NotificationStateWarn))
+		Expect(n.Method).To(BeEmpty())
+		Expect(m.Acknowledge(context, "notifications.propulsion.mainEngine.temperature")).To(BeNil())
+
+		mapped, _ := m.DoMap(delta(now, temperature(380)))
+		n = notifications(mapped)["notifications.propulsion.mainEngine.temperature"]
+		Expect(*n.State).To(Equal(config.NotificationStateAlarm))
+		Expect(n.Method).To(Equal([]string{"visual", "sound"}))
+	})
+})
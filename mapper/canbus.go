@@ -1,18 +1,21 @@
 package mapper
 
 import (
-	"encoding/binary"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
+	"sync"
 
 	"github.com/antonmedv/expr/vm"
 	"github.com/munnik/gosk/config"
 	"github.com/munnik/gosk/logger"
 	"github.com/munnik/gosk/message"
+	"github.com/munnik/gosk/protocol"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.nanomsg.org/mangos/v3"
 	"go.uber.org/zap"
 
-	"github.com/brutella/can"
 	"go.einride.tech/can/pkg/dbc"
 )
 
@@ -21,9 +24,17 @@ type CanBusMapper struct {
 	protocol       string
 	dbc            DBC
 	canbusMappings map[string]map[string]config.CanBusMappingConfig
+	validator      *Validator
+	windows        *WindowFunctions
+	meta           *metaTracker
+	canErrors      *canErrorNotifications
 }
 
-func NewCanBusMapper(c config.CanBusMapperConfig, cmc []config.CanBusMappingConfig) (*CanBusMapper, error) {
+func NewCanBusMapper(c config.CanBusMapperConfig, cmc []config.CanBusMappingConfig, reg prometheus.Registerer) (*CanBusMapper, error) {
+	validator, err := NewValidator(c.Validation, reg)
+	if err != nil {
+		return nil, err
+	}
 	// parse DBC file and store mappings
 	dbc := readDBC(c.DbcFile)
 	mappings := make(map[string]map[string]config.CanBusMappingConfig)
@@ -34,11 +45,11 @@ func NewCanBusMapper(c config.CanBusMapperConfig, cmc []config.CanBusMappingConf
 		}
 		mappings[m.Origin][m.Name] = m
 	}
-	return &CanBusMapper{config: c, protocol: config.CanBusType, dbc: dbc, canbusMappings: mappings}, nil
+	return &CanBusMapper{config: c, protocol: config.CanBusType, dbc: dbc, canbusMappings: mappings, validator: validator, windows: NewWindowFunctions(), meta: newMetaTracker(), canErrors: newCanErrorNotifications()}, nil
 }
 
 func (m *CanBusMapper) Map(subscriber mangos.Socket, publisher mangos.Socket) {
-	process(subscriber, publisher, m)
+	process(subscriber, publisher, m, m.validator)
 }
 
 func (m *CanBusMapper) DoMap(r *message.Raw) (*message.Mapped, error) {
@@ -46,22 +57,34 @@ func (m *CanBusMapper) DoMap(r *message.Raw) (*message.Mapped, error) {
 	s := message.NewSource().WithLabel(r.Connector).WithType(m.protocol).WithUuid(r.Uuid)
 	u := message.NewUpdate().WithSource(*s).WithTimestamp(r.Timestamp)
 
-	frm := createFrame(r)
-	// lookup mappings for frame
-	mappings, present := m.dbc[frm.ID]
+	frm, err := createFrame(r)
+	if err != nil {
+		return nil, err
+	}
+	if frm.IsError() {
+		m.canErrors.mapErrorFrame(frm, r.Connector, u)
+		return result.AddUpdate(u), nil
+	}
+	if frm.IsRemote() {
+		return nil, fmt.Errorf("remote transmission request %x has no data to map", frm.ID)
+	}
+	m.canErrors.clear(frm, r.Connector, u)
+	// lookup mappings for frame, the message ids of the DBC file have the extended frame flag like SocketCAN
+	mappings, present := m.dbc[frm.ID&(protocol.CAN_EFF_FLAG|protocol.CAN_EFF_MASK)]
 	if present {
 		// apply all mappings
 		vm := vm.VM{}
 		for _, mapping := range mappings.Signals {
-			val := extractSignal(mapping, string(mappings.Name), frm)
+			val := extractSignal(mapping, string(mappings.Name), frm.Data)
 			mapping, present := m.canbusMappings[val.origin][val.name]
 
 			if present {
 				env := NewExpressionEnvironment()
 				env["value"] = val.value
-				output, err := runExpr(vm, env, mapping.MappingConfig)
+				output, err := runExpr(vm, env, mapping.MappingConfig, m.windows, m.config.Context, r.Timestamp)
 				if err == nil {
 					u.AddValue(message.NewValue().WithPath(mapping.Path).WithValue(output))
+					m.meta.addMeta(u, m.config.Context, mapping.MappingConfig)
 				} else {
 					logger.GetLogger().Error(
 						"Could not map value",
@@ -77,27 +100,17 @@ func (m *CanBusMapper) DoMap(r *message.Raw) (*message.Mapped, error) {
 	return result.AddUpdate(u), nil
 }
 
-func createFrame(r *message.Raw) can.Frame {
-	data := [8]uint8{}
-	copy(data[:], r.Value[8:16])
-	frm := can.Frame{
-		ID:     binary.BigEndian.Uint32(r.Value[0:4]),
-		Length: r.Value[4],
-		Flags:  r.Value[5],
-		Res0:   r.Value[6],
-		Res1:   r.Value[7],
-		Data:   data,
-	}
-	return frm
+func createFrame(r *message.Raw) (protocol.CanFrame, error) {
+	return protocol.BytesToCanFrame(r.Value)
 }
 
-func extractSignal(mapping dbc.SignalDef, origin string, frm can.Frame) Signal {
+// extractSignal extracts the bits of the signal, the bits are numbered from the most significant bit of the first byte
+// and bits beyond the payload are 0
+func extractSignal(mapping dbc.SignalDef, origin string, data []byte) Signal {
 	// get name
 	name := mapping.Name
-	start := mapping.StartBit
-	length := mapping.Size
-	data := make([]uint8, 8)
-	copy(data, frm.Data[:])
+	start := int(mapping.StartBit)
+	length := int(mapping.Size)
 	if mapping.IsBigEndian {
 		start = start - 7
 		// reverse the bits in each byte
@@ -105,10 +118,17 @@ func extractSignal(mapping dbc.SignalDef, origin string, frm can.Frame) Signal {
 		// 	data[i] = bits.Reverse8(b)
 		// }
 	}
+	if length > 64 {
+		length = 64
+	}
 	// extract the correct bits
-	temp := binary.BigEndian.Uint64(data[:])
-	temp = temp << start
-	temp = temp >> (64 - (length))
+	var temp uint64
+	for i := 0; i < length; i++ {
+		temp <<= 1
+		if bit := start + i; bit >= 0 && bit/8 < len(data) {
+			temp |= uint64(data[bit/8]>>(7-bit%8)) & 1
+		}
+	}
 
 	// get value
 	var val float64
@@ -124,6 +144,125 @@ func extractSignal(mapping dbc.SignalDef, origin string, frm can.Frame) Signal {
 	return Signal{origin: origin, name: string(name), value: res}
 }
 
+// canErrorClasses are the error classes of an error frame with the name of the notification, the state of the
+// notification and the message
+var canErrorClasses = []struct {
+	class   uint32
+	name    string
+	state   string
+	message string
+}{
+	{protocol.CAN_ERR_TX_TIMEOUT, "transmitTimeout", config.NotificationStateWarn, "The transmission timed out"},
+	{protocol.CAN_ERR_LOSTARB, "lostArbitration", config.NotificationStateWarn, "The arbitration was lost"},
+	{protocol.CAN_ERR_CRTL, "controller", config.NotificationStateWarn, "The controller has problems"},
+	{protocol.CAN_ERR_PROT, "protocolViolation", config.NotificationStateWarn, "A protocol violation was detected"},
+	{protocol.CAN_ERR_TRX, "transceiver", config.NotificationStateAlarm, "The transceiver has problems"},
+	{protocol.CAN_ERR_ACK, "noAcknowledge", config.NotificationStateWarn, "No acknowledge was received on transmission"},
+	{protocol.CAN_ERR_BUSOFF, canBusOff, config.NotificationStateAlarm, "The controller is bus off"},
+	{protocol.CAN_ERR_BUSERROR, "busError", config.NotificationStateWarn, "A bus error was detected"},
+}
+
+// canBusOff is the name of the notification that is only cleared by a restart of the controller
+const canBusOff = "busOff"
+
+// canControllerProblems are the controller problems in data[1] of an error frame
+var canControllerProblems = []struct {
+	problem uint8
+	message string
+}{
+	{protocol.CAN_ERR_CRTL_RX_OVERFLOW, "receive buffer overflow"},
+	{protocol.CAN_ERR_CRTL_TX_OVERFLOW, "transmit buffer overflow"},
+	{protocol.CAN_ERR_CRTL_RX_WARNING, "receive error warning"},
+	{protocol.CAN_ERR_CRTL_TX_WARNING, "transmit error warning"},
+	{protocol.CAN_ERR_CRTL_RX_PASSIVE, "receive error passive"},
+	{protocol.CAN_ERR_CRTL_TX_PASSIVE, "transmit error passive"},
+	{protocol.CAN_ERR_CRTL_ACTIVE, "error active again"},
+}
+
+// canErrorNotifications keeps track of the raised notifications of the error classes per interface, every mapper has
+// its own state
+type canErrorNotifications struct {
+	mu     sync.Mutex
+	raised map[string]map[string]struct{} // map of the notification prefix to the names of the raised notifications
+}
+
+func newCanErrorNotifications() *canErrorNotifications {
+	return &canErrorNotifications{raised: make(map[string]map[string]struct{})}
+}
+
+// canErrorPrefix returns the path prefix of the notifications of the interface of the frame, the connector is used
+// when the frame has no interface name
+func canErrorPrefix(frm protocol.CanFrame, connector string) string {
+	name := frm.Interface
+	if name == "" {
+		name = connector
+	}
+	return "notifications.canbus." + name + "."
+}
+
+// canNotification returns the notification with the state, the notification is silenced when the state is normal
+func canNotification(state string, description string) message.Notification {
+	method := []string{"visual", "sound"}
+	if state == config.NotificationStateNormal {
+		method = []string{}
+	}
+	return message.Notification{State: &state, Method: method, Message: &description}
+}
+
+// mapErrorFrame maps the error classes of the error frame to notifications.canbus.<interface>.<class>. A restart of
+// the controller clears the bus off notification.
+func (n *canErrorNotifications) mapErrorFrame(frm protocol.CanFrame, connector string, u *message.Update) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	prefix := canErrorPrefix(frm, connector)
+	raised, ok := n.raised[prefix]
+	if !ok {
+		raised = make(map[string]struct{})
+		n.raised[prefix] = raised
+	}
+	for _, c := range canErrorClasses {
+		if frm.ID&c.class == 0 {
+			continue
+		}
+		description := c.message
+		if c.class == protocol.CAN_ERR_CRTL && len(frm.Data) > 1 {
+			var problems []string
+			for _, p := range canControllerProblems {
+				if frm.Data[1]&p.problem != 0 {
+					problems = append(problems, p.message)
+				}
+			}
+			if len(problems) > 0 {
+				description = fmt.Sprintf("%s: %s", description, strings.Join(problems, ", "))
+			}
+		}
+		raised[c.name] = struct{}{}
+		u.AddValue(message.NewValue().WithPath(prefix + c.name).WithValue(canNotification(c.state, description)))
+	}
+	if frm.ID&protocol.CAN_ERR_RESTARTED != 0 && u.GetValueByPath(prefix+canBusOff) == nil {
+		delete(raised, canBusOff)
+		u.AddValue(message.NewValue().WithPath(prefix + canBusOff).WithValue(canNotification(config.NotificationStateNormal, "The controller restarted")))
+	}
+}
+
+// clear sets the raised notifications of the interface of the data frame to normal, a received data frame shows
+// that the bus works again. The bus off notification is only cleared by a restart of the controller.
+func (n *canErrorNotifications) clear(frm protocol.CanFrame, connector string, u *message.Update) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	prefix := canErrorPrefix(frm, connector)
+	raised := n.raised[prefix]
+	for _, c := range canErrorClasses {
+		if _, ok := raised[c.name]; !ok || c.name == canBusOff {
+			continue
+		}
+		delete(raised, c.name)
+		u.AddValue(message.NewValue().WithPath(prefix + c.name).WithValue(canNotification(config.NotificationStateNormal, "Frames are received again")))
+	}
+}
+
 type Signal struct {
 	origin string
 	name   string
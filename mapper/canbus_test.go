@@ -0,0 +1,83 @@
+package mapper_test
+
+import (
+	"time"
+
+	"github.com/munnik/gosk/config"
+	. "github.com/munnik/gosk/mapper"
+	"github.com/munnik/gosk/message"
+	"github.com/munnik/gosk/protocol"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ = Describe("DoMap canbus", func() {
+	mapper, _ := NewCanBusMapper(
+		config.CanBusMapperConfig{MapperConfig: config.MapperConfig{Context: "testingContext"}, DbcFile: "canbus_test.dbc"},
+		[]config.CanBusMappingConfig{
+			{MappingConfig: config.MappingConfig{Expression: "value", Path: "tanks.fuel.0.currentLevel"}, Name: "Level", Origin: "FdMessage"},
+		},
+		prometheus.NewRegistry(),
+	)
+	now := time.Now()
+	raw := func(frame protocol.CanFrame) *message.Raw {
+		m := message.NewRaw().WithConnector("testingConnector").WithType(config.CanBusType).WithValue(protocol.CanFrameToBytes(frame))
+		m.Timestamp = now
+		return m
+	}
+	It("Maps a signal beyond the first 8 bytes of a CAN FD frame", func() {
+		data := make([]byte, 12)
+		data[8] = 0x01
+		data[9] = 0x2c
+		result, err := mapper.DoMap(raw(protocol.CanFrame{ID: protocol.CAN_EFF_FLAG | 0x400, Flags: protocol.CANFD_FDF, Interface: "can0", Data: data}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(valuesByPath(result)).To(Equal(map[string]interface{}{"tanks.fuel.0.currentLevel": 30.0}))
+	})
+	notification := func(state string, description string) message.Notification {
+		method := []string{"visual", "sound"}
+		if state == config.NotificationStateNormal {
+			method = []string{}
+		}
+		return message.Notification{State: &state, Method: method, Message: &description}
+	}
+
+	It("Maps the classes of an error frame to notifications", func() {
+		data := make([]byte, 8)
+		data[1] = protocol.CAN_ERR_CRTL_TX_PASSIVE
+		result, err := mapper.DoMap(raw(protocol.CanFrame{ID: protocol.CAN_ERR_FLAG | protocol.CAN_ERR_CRTL | protocol.CAN_ERR_BUSOFF, Interface: "can0", Data: data}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(valuesByPath(result)).To(Equal(map[string]interface{}{
+			"notifications.canbus.can0.controller": notification(config.NotificationStateWarn, "The controller has problems: transmit error passive"),
+			"notifications.canbus.can0.busOff":     notification(config.NotificationStateAlarm, "The controller is bus off"),
+		}))
+	})
+	It("Clears the bus off notification when the controller restarted", func() {
+		result, err := mapper.DoMap(raw(protocol.CanFrame{ID: protocol.CAN_ERR_FLAG | protocol.CAN_ERR_RESTARTED, Data: make([]byte, 8)}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(valuesByPath(result)).To(Equal(map[string]interface{}{
+			"notifications.canbus.testingConnector.busOff": notification(config.NotificationStateNormal, "The controller restarted"),
+		}))
+	})
+	It("Clears the other notifications when a data frame is received", func() {
+		mapper, _ := NewCanBusMapper(
+			config.CanBusMapperConfig{MapperConfig: config.MapperConfig{Context: "testingContext"}, DbcFile: "canbus_test.dbc"},
+			[]config.CanBusMappingConfig{},
+			prometheus.NewRegistry(),
+		)
+		_, err := mapper.DoMap(raw(protocol.CanFrame{ID: protocol.CAN_ERR_FLAG | protocol.CAN_ERR_ACK | protocol.CAN_ERR_BUSOFF, Interface: "can0", Data: make([]byte, 8)}))
+		Expect(err).NotTo(HaveOccurred())
+
+		result, err := mapper.DoMap(raw(protocol.CanFrame{ID: protocol.CAN_EFF_FLAG | 0x400, Interface: "can1", Data: make([]byte, 8)}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(valuesByPath(result)).To(BeEmpty())
+		result, err = mapper.DoMap(raw(protocol.CanFrame{ID: protocol.CAN_EFF_FLAG | 0x400, Interface: "can0", Data: make([]byte, 8)}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(valuesByPath(result)).To(Equal(map[string]interface{}{
+			"notifications.canbus.can0.noAcknowledge": notification(config.NotificationStateNormal, "Frames are received again"),
+		}))
+		result, err = mapper.DoMap(raw(protocol.CanFrame{ID: protocol.CAN_EFF_FLAG | 0x400, Interface: "can0", Data: make([]byte, 8)}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(valuesByPath(result)).To(BeEmpty())
+	})
+})
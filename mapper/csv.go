@@ -11,6 +11,7 @@ import (
 	"github.com/munnik/gosk/config"
 	"github.com/munnik/gosk/logger"
 	"github.com/munnik/gosk/message"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.nanomsg.org/mangos/v3"
 	"go.uber.org/zap"
 )
@@ -19,14 +20,21 @@ type CSVMapper struct {
 	config           config.CSVMapperConfig
 	protocol         string
 	csvMappingConfig []config.CSVMappingConfig
+	validator        *Validator
+	windows          *WindowFunctions
+	meta             *metaTracker
 }
 
-func NewCSVMapper(c config.CSVMapperConfig, cmc []config.CSVMappingConfig) (*CSVMapper, error) {
-	return &CSVMapper{config: c, protocol: config.CSVType, csvMappingConfig: cmc}, nil
+func NewCSVMapper(c config.CSVMapperConfig, cmc []config.CSVMappingConfig, reg prometheus.Registerer) (*CSVMapper, error) {
+	validator, err := NewValidator(c.Validation, reg)
+	if err != nil {
+		return nil, err
+	}
+	return &CSVMapper{config: c, protocol: config.CSVType, csvMappingConfig: cmc, validator: validator, windows: NewWindowFunctions(), meta: newMetaTracker()}, nil
 }
 
 func (m *CSVMapper) Map(subscriber mangos.Socket, publisher mangos.Socket) {
-	process(subscriber, publisher, m)
+	process(subscriber, publisher, m, m.validator)
 }
 
 func (m *CSVMapper) DoMap(r *message.Raw) (*message.Mapped, error) {
@@ -90,12 +98,13 @@ func (m *CSVMapper) DoMap(r *message.Raw) (*message.Mapped, error) {
 			env["floatValues"] = floatValues
 			env["intValues"] = intValues
 
-			output, err := runExpr(vm, env, cmc.MappingConfig)
+			output, err := runExpr(vm, env, cmc.MappingConfig, m.windows, m.config.Context, u.Timestamp)
 			if err == nil { // don't insert a path twice
 				if v := u.GetValueByPath(cmc.Path); v != nil {
 					v.WithValue(output)
 				} else {
 					u.AddValue(message.NewValue().WithPath(cmc.Path).WithValue(output))
+					m.meta.addMeta(u, m.config.Context, cmc.MappingConfig)
 				}
 			}
 		}
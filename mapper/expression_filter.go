@@ -13,6 +13,7 @@ import (
 type ExpressionFilter struct {
 	filterMappings map[string][]config.ExpressionMappingConfig
 	env            ExpressionEnvironment
+	windows        *WindowFunctions
 }
 
 func NewExpressionFilter(emc []config.ExpressionMappingConfig) (*ExpressionFilter, error) {
@@ -25,11 +26,11 @@ func NewExpressionFilter(emc []config.ExpressionMappingConfig) (*ExpressionFilte
 		}
 	}
 
-	return &ExpressionFilter{env: env, filterMappings: mappings}, nil
+	return &ExpressionFilter{env: env, filterMappings: mappings, windows: NewWindowFunctions()}, nil
 }
 
 func (f *ExpressionFilter) Map(subscriber mangos.Socket, publisher mangos.Socket) {
-	processMapped(subscriber, publisher, f)
+	processMapped(subscriber, publisher, f, nil)
 }
 
 func (f *ExpressionFilter) DoMap(delta *message.Mapped) (*message.Mapped, error) {
@@ -42,7 +43,7 @@ func (f *ExpressionFilter) DoMap(delta *message.Mapped) (*message.Mapped, error)
 			f.env[path] = svm
 			vm := vm.VM{}
 			for _, mapping := range mappings {
-				output, err := runExpr(vm, f.env, mapping.MappingConfig)
+				output, err := runExpr(vm, f.env, mapping.MappingConfig, f.windows, delta.Context, svm.Timestamp)
 				if err != nil {
 					return nil, err
 				}
@@ -62,6 +63,7 @@ func (f *ExpressionFilter) DoMap(delta *message.Mapped) (*message.Mapped, error)
 			result.AddUpdate(&u)
 		}
 	}
+	copyMeta(result, delta)
 
 	return result, nil
 }
@@ -2,6 +2,7 @@ package mapper
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/antonmedv/expr"
 	"github.com/antonmedv/expr/vm"
@@ -119,8 +120,13 @@ func ListToFloats(input []interface{}) ([]float64, error) {
 	return result, nil
 }
 
-func runExpr(vm vm.VM, env ExpressionEnvironment, mappingConfig config.MappingConfig) (interface{}, error) {
+// runExpr runs the expression of the mapping, the state of the time-window functions is kept in windows per context.
+// The timestamp is the time of the value and is used by the time-window functions.
+func runExpr(vm vm.VM, env ExpressionEnvironment, mappingConfig config.MappingConfig, windows *WindowFunctions, context string, timestamp time.Time) (interface{}, error) {
 	env, err := mergeEnvironments(env, mappingConfig.ExpressionEnvironment)
+	if err == nil {
+		env, err = mergeEnvironments(env, windows.Environment(mappingKey(context, mappingConfig), timestamp))
+	}
 	if err != nil {
 		logger.GetLogger().Warn(
 			"Could not merge the environments",
@@ -132,7 +138,7 @@ func runExpr(vm vm.VM, env ExpressionEnvironment, mappingConfig config.MappingCo
 	if mappingConfig.CompiledExpression == nil {
 		// TODO: each iteration the CompiledExpression is nil
 		var err error
-		if mappingConfig.CompiledExpression, err = expr.Compile(mappingConfig.Expression, expr.Env(env)); err != nil {
+		if mappingConfig.CompiledExpression, err = expr.Compile(mappingConfig.Expression, expr.Env(env), expr.Patch(&windowCallSites{})); err != nil {
 			logger.GetLogger().Warn(
 				"Could not compile the mapping expression",
 				zap.String("Expression", mappingConfig.Expression),
@@ -163,6 +169,31 @@ func runExpr(vm vm.VM, env ExpressionEnvironment, mappingConfig config.MappingCo
 	return output, nil
 }
 
+// ExpressionRunner runs the expression of a mapping outside of a mapper, e.g. for the PUT requests of the SignalK
+// writer. The expression is compiled once without the types of the environment because they may differ for each run.
+type ExpressionRunner struct {
+	mappingConfig config.MappingConfig
+	windows       *WindowFunctions
+	context       string
+}
+
+func NewExpressionRunner(context string, mappingConfig config.MappingConfig) (*ExpressionRunner, error) {
+	var err error
+	if mappingConfig.CompiledExpression, err = expr.Compile(mappingConfig.Expression, expr.Patch(&windowCallSites{})); err != nil {
+		return nil, err
+	}
+	return &ExpressionRunner{mappingConfig: mappingConfig, windows: NewWindowFunctions(), context: context}, nil
+}
+
+// Run runs the expression with the helpers of NewExpressionEnvironment, the environment of the mapping and env
+func (r *ExpressionRunner) Run(env ExpressionEnvironment, timestamp time.Time) (interface{}, error) {
+	e := NewExpressionEnvironment()
+	for k, v := range env {
+		e[k] = v
+	}
+	return runExpr(vm.VM{}, e, r.mappingConfig, r.windows, r.context, timestamp)
+}
+
 func mergeEnvironments(left ExpressionEnvironment, right ExpressionEnvironment) (ExpressionEnvironment, error) {
 	result := make(ExpressionEnvironment)
 	for k, v := range left {
@@ -8,6 +8,7 @@ import (
 	"github.com/munnik/gosk/config"
 	"github.com/munnik/gosk/logger"
 	"github.com/munnik/gosk/message"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.nanomsg.org/mangos/v3"
 	"go.uber.org/zap"
 )
@@ -16,14 +17,21 @@ type JSONMapper struct {
 	config            config.MapperConfig
 	protocol          string
 	jsonMappingConfig []config.JSONMappingConfig
+	validator         *Validator
+	windows           *WindowFunctions
+	meta              *metaTracker
 }
 
-func NewJSONMapper(c config.MapperConfig, jmc []config.JSONMappingConfig) (*JSONMapper, error) {
-	return &JSONMapper{config: c, protocol: config.JSONType, jsonMappingConfig: jmc}, nil
+func NewJSONMapper(c config.MapperConfig, jmc []config.JSONMappingConfig, reg prometheus.Registerer) (*JSONMapper, error) {
+	validator, err := NewValidator(c.Validation, reg)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONMapper{config: c, protocol: config.JSONType, jsonMappingConfig: jmc, validator: validator, windows: NewWindowFunctions(), meta: newMetaTracker()}, nil
 }
 
 func (m *JSONMapper) Map(subscriber mangos.Socket, publisher mangos.Socket) {
-	process(subscriber, publisher, m)
+	process(subscriber, publisher, m, m.validator)
 }
 
 func (m *JSONMapper) DoMap(r *message.Raw) (*message.Mapped, error) {
@@ -48,12 +56,13 @@ func (m *JSONMapper) DoMap(r *message.Raw) (*message.Mapped, error) {
 		env := NewExpressionEnvironment()
 		env["json"] = j
 
-		output, err := runExpr(vm, env, jmc.MappingConfig)
+		output, err := runExpr(vm, env, jmc.MappingConfig, m.windows, m.config.Context, r.Timestamp)
 		if err == nil { // don't insert a path twice
 			if v := u.GetValueByPath(jmc.Path); v != nil {
 				v.WithValue(output)
 			} else {
 				u.AddValue(message.NewValue().WithPath(jmc.Path).WithValue(output))
+				m.meta.addMeta(u, m.config.Context, jmc.MappingConfig)
 			}
 		}
 	}
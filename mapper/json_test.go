@@ -9,12 +9,14 @@ import (
 	"github.com/munnik/gosk/message"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 var _ = Describe("DoMap json", func() {
 	mapper, _ := NewJSONMapper(
 		config.MapperConfig{Context: "testingContext"},
 		config.NewJSONMappingConfig("json_test.yaml"),
+		prometheus.NewRegistry(),
 	)
 	now := time.Now()
 
@@ -61,3 +63,48 @@ var _ = Describe("DoMap json", func() {
 		),
 	)
 })
+
+var _ = Describe("DoMap json with meta", func() {
+	var mapper *JSONMapper
+	BeforeEach(func() {
+		mapper, _ = NewJSONMapper(
+			config.MapperConfig{Context: "testingContext"},
+			config.NewJSONMappingConfig("json_meta_test.yaml"),
+			prometheus.NewRegistry(),
+		)
+	})
+	input := message.NewRaw().WithConnector("testingConnector").WithType(config.JSONType).WithValue([]byte(`{"tmp":"299.6"}`))
+
+	It("adds the meta only to the first update", func() {
+		units := "K"
+		displayName := "Main engine temperature"
+		lower := 363.15
+		expected := message.NewMetaValue().WithPath("propulsion.mainEngine.temperature").WithValue(message.Meta{
+			Units:       &units,
+			DisplayName: &displayName,
+			Zones:       []message.Zone{{Lower: &lower, State: "alarm", Message: "Main engine too hot"}},
+		})
+
+		result, err := mapper.DoMap(input)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Updates[0].Meta).To(Equal([]message.MetaValue{*expected}))
+
+		result, err = mapper.DoMap(input)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Updates[0].Meta).To(BeEmpty())
+	})
+	It("adds the meta again for another mapper", func() {
+		result, err := mapper.DoMap(input)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Updates[0].Meta).To(HaveLen(1))
+
+		other, _ := NewJSONMapper(
+			config.MapperConfig{Context: "testingContext"},
+			config.NewJSONMappingConfig("json_meta_test.yaml"),
+			prometheus.NewRegistry(),
+		)
+		result, err = other.DoMap(input)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Updates[0].Meta).To(HaveLen(1))
+	})
+})
@@ -22,7 +22,9 @@ type MappedMapper interface {
 	DoMap(*message.Mapped) (*message.Mapped, error)
 }
 
-func process(subscriber mangos.Socket, publisher mangos.Socket, mapper RealMapper) {
+// process maps the received raw messages and publishes the result, the validator is nil when the mapped values
+// shouldn't be validated
+func process(subscriber mangos.Socket, publisher mangos.Socket, mapper RealMapper, validator *Validator) {
 	raw := &message.Raw{}
 	var mapped *message.Mapped
 	var bytes []byte
@@ -51,6 +53,10 @@ func process(subscriber mangos.Socket, publisher mangos.Socket, mapper RealMappe
 			)
 			continue
 		}
+		mapped = validator.Validate(mapped)
+		if len(mapped.Updates) == 0 {
+			continue // skip the delta, e.g. when waiting for more frames or fragments
+		}
 		if bytes, err = json.Marshal(mapped); err != nil {
 			logger.GetLogger().Warn(
 				"Could not marshal the mapped data",
@@ -69,9 +75,8 @@ func process(subscriber mangos.Socket, publisher mangos.Socket, mapper RealMappe
 	}
 }
 
-func processMapped(subscriber mangos.Socket, publisher mangos.Socket, mapper MappedMapper) {
-	in := &message.Mapped{}
-	var out *message.Mapped
+func processMapped(subscriber mangos.Socket, publisher mangos.Socket, mapper MappedMapper, validator *Validator) {
+	var in, out *message.Mapped
 	var bytes []byte
 	for {
 		received, err := subscriber.Recv()
@@ -82,6 +87,9 @@ func processMapped(subscriber mangos.Socket, publisher mangos.Socket, mapper Map
 			)
 			continue
 		}
+		// decode in a new struct, fields that are missing in the received data (like meta) would otherwise keep the
+		// value of the previous message
+		in = &message.Mapped{}
 		if err := json.Unmarshal(received, in); err != nil {
 			logger.GetLogger().Warn(
 				"Could not unmarshal the received data",
@@ -98,6 +106,7 @@ func processMapped(subscriber mangos.Socket, publisher mangos.Socket, mapper Map
 			)
 			continue
 		}
+		out = validator.Validate(out)
 		if len(out.Updates) == 0 {
 			continue // skip the delta
 		}
@@ -3,6 +3,7 @@ package mapper_test
 import (
 	"testing"
 
+	"github.com/munnik/gosk/message"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 )
@@ -11,3 +12,12 @@ func TestMapper(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Mapper Suite")
 }
+
+// valuesByPath returns the values of all updates of the delta by path
+func valuesByPath(mapped *message.Mapped) map[string]interface{} {
+	result := make(map[string]interface{})
+	for _, svm := range mapped.ToSingleValueMapped() {
+		result[svm.Path] = svm.Value
+	}
+	return result
+}
@@ -0,0 +1,61 @@
+package mapper
+
+import (
+	"sync"
+	"time"
+
+	"github.com/munnik/gosk/config"
+	"github.com/munnik/gosk/message"
+)
+
+// metaInterval is the interval at which the metadata of a path is sent again, so writers that are started after the
+// mapper also receive the metadata
+const metaInterval = 5 * time.Minute
+
+// metaTracker keeps track of when the metadata of a path of a context was last sent, every mapper has its own tracker
+type metaTracker struct {
+	mu   sync.Mutex
+	sent map[string]time.Time
+}
+
+func newMetaTracker() *metaTracker {
+	return &metaTracker{sent: make(map[string]time.Time)}
+}
+
+// due returns true when the metadata of the path of the context should be sent and marks it as sent
+func (t *metaTracker) due(context string, path string, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := context + "|" + path
+	if last, ok := t.sent[key]; ok && now.Sub(last) < metaInterval {
+		return false
+	}
+	t.sent[key] = now
+	return true
+}
+
+// addMeta adds the metadata of the mapping to the update when it is configured and wasn't sent recently for the
+// context
+func (t *metaTracker) addMeta(u *message.Update, context string, mappingConfig config.MappingConfig) {
+	if mappingConfig.Meta == nil || mappingConfig.Path == "" {
+		return
+	}
+	if !t.due(context, mappingConfig.Path, time.Now()) {
+		return
+	}
+	u.AddMeta(message.NewMetaValue().WithPath(mappingConfig.Path).WithValue(*mappingConfig.Meta))
+}
+
+// copyMeta adds the metadata of the input to the result, filters only pass values so the metadata would otherwise
+// be lost
+func copyMeta(result *message.Mapped, input *message.Mapped) {
+	for _, u := range input.Updates {
+		if len(u.Meta) == 0 {
+			continue
+		}
+		meta := message.NewUpdate().WithSource(u.Source).WithTimestamp(u.Timestamp)
+		meta.Meta = u.Meta
+		result.AddUpdate(meta)
+	}
+}
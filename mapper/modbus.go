@@ -2,15 +2,21 @@ package mapper
 
 import (
 	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"math"
+	"strings"
 	"time"
 
 	"github.com/antonmedv/expr/vm"
 	"github.com/munnik/gosk/config"
+	"github.com/munnik/gosk/logger"
 	"github.com/munnik/gosk/message"
 	"github.com/munnik/gosk/protocol"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.nanomsg.org/mangos/v3"
+	"go.uber.org/zap"
 )
 
 type ModbusMapper struct {
@@ -18,19 +24,29 @@ type ModbusMapper struct {
 	protocol             string
 	modbusMappingsConfig []config.ModbusMappingsConfig
 	env                  ExpressionEnvironment
+	validator            *Validator
+	windows              *WindowFunctions
+	meta                 *metaTracker
 }
 
-func NewModbusMapper(c config.MapperConfig, mmc []config.ModbusMappingsConfig) (*ModbusMapper, error) {
+func NewModbusMapper(c config.MapperConfig, mmc []config.ModbusMappingsConfig, reg prometheus.Registerer) (*ModbusMapper, error) {
+	validator, err := NewValidator(c.Validation, reg)
+	if err != nil {
+		return nil, err
+	}
 	return &ModbusMapper{
 		config:               c,
 		protocol:             config.ModbusType,
 		modbusMappingsConfig: mmc,
 		env:                  NewExpressionEnvironment(),
+		validator:            validator,
+		windows:              NewWindowFunctions(),
+		meta:                 newMetaTracker(),
 	}, nil
 }
 
 func (m *ModbusMapper) Map(subscriber mangos.Socket, publisher mangos.Socket) {
-	process(subscriber, publisher, m)
+	process(subscriber, publisher, m, m.validator)
 }
 
 func (m *ModbusMapper) DoMap(r *message.Raw) (*message.Mapped, error) {
@@ -38,6 +54,19 @@ func (m *ModbusMapper) DoMap(r *message.Raw) (*message.Mapped, error) {
 	s := message.NewSource().WithLabel(r.Connector).WithType(m.protocol).WithUuid(r.Uuid)
 	u := message.NewUpdate().WithSource(*s).WithTimestamp(r.Timestamp)
 
+	if r.Type == config.ModbusHealthType {
+		if err := mapModbusHealth(r.Value, u); err != nil {
+			return nil, err
+		}
+		return result.AddUpdate(u), nil
+	}
+	if r.Type == config.ModbusAcknowledgementType {
+		if err := mapModbusAcknowledgement(r.Value, u); err != nil {
+			return nil, err
+		}
+		return result.AddUpdate(u), nil
+	}
+
 	if len(r.Value) <= protocol.MODBUS_HEADER_LENGTH {
 		return nil, fmt.Errorf("no useful data in %v", r.Value)
 	}
@@ -45,6 +74,12 @@ func (m *ModbusMapper) DoMap(r *message.Raw) (*message.Mapped, error) {
 	functionCode := binary.BigEndian.Uint16(r.Value[1:3])
 	address := binary.BigEndian.Uint16(r.Value[3:5])
 	numberOfCoilsOrRegisters := binary.BigEndian.Uint16(r.Value[5:7])
+	if protocol.IsModbusDeviceFunction(functionCode) {
+		if err := mapModbusDevice(slave, functionCode, address, numberOfCoilsOrRegisters, r.Value[protocol.MODBUS_HEADER_LENGTH:], u); err != nil {
+			return nil, err
+		}
+		return result.AddUpdate(u), nil
+	}
 	registerData := make([]uint16, (len(r.Value)-7)/2)
 	for i := range registerData {
 		registerData[i] = binary.BigEndian.Uint16(r.Value[7+i*2 : 9+i*2])
@@ -98,12 +133,32 @@ func (m *ModbusMapper) DoMap(r *message.Raw) (*message.Mapped, error) {
 		if mmc.Address < address || mmc.Address+mmc.NumberOfCoilsOrRegisters > address+numberOfCoilsOrRegisters {
 			continue
 		}
-		output, err := runExpr(vm, m.env, mmc.MappingConfig)
+		if mmc.DataType != "" {
+			start := int(mmc.Address - address)
+			end := start + int(mmc.NumberOfCoilsOrRegisters)
+			if end > len(registerData) {
+				continue
+			}
+			value, err := decodeModbusMapping(mmc, registerData[start:end])
+			if err != nil {
+				logger.GetLogger().Warn(
+					"Could not decode the registers",
+					zap.String("Path", mmc.Path),
+					zap.String("Error", err.Error()),
+				)
+				continue
+			}
+			m.env["value"] = value
+		} else {
+			delete(m.env, "value")
+		}
+		output, err := runExpr(vm, m.env, mmc.MappingConfig, m.windows, m.config.Context, r.Timestamp)
 		if err == nil { // don't insert a path twice
 			if v := u.GetValueByPath(mmc.Path); v != nil {
 				v.WithValue(output)
 			} else {
 				u.AddValue(message.NewValue().WithPath(mmc.Path).WithValue(output))
+				m.meta.addMeta(u, m.config.Context, mmc.MappingConfig)
 			}
 		}
 	}
@@ -114,3 +169,138 @@ func (m *ModbusMapper) DoMap(r *message.Raw) (*message.Mapped, error) {
 
 	return result.AddUpdate(u), nil
 }
+
+// decodeModbusMapping decodes the registers of the mapping with the configured data type, numbers are scaled and the
+// offset is added
+func decodeModbusMapping(mmc config.ModbusMappingsConfig, registers []uint16) (interface{}, error) {
+	value, err := protocol.DecodeRegisters(registers, mmc.DataType, mmc.ByteOrder, mmc.WordOrder)
+	if err != nil {
+		return nil, err
+	}
+	scale := mmc.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	if scale == 1 && mmc.Offset == 0 {
+		return value, nil
+	}
+	switch v := value.(type) {
+	case int64:
+		return float64(v)*scale + mmc.Offset, nil
+	case float64:
+		return v*scale + mmc.Offset, nil
+	}
+	return value, nil
+}
+
+// mapModbusHealth maps the health of a register group to sensors.modbus.<slave>.<function code>.<address>
+func mapModbusHealth(value []byte, u *message.Update) error {
+	var health protocol.ModbusHealth
+	if err := json.Unmarshal(value, &health); err != nil {
+		return err
+	}
+
+	prefix := fmt.Sprintf("sensors.modbus.%d.%d.%d.", health.Slave, health.FunctionCode, health.Address)
+	state := "ok"
+	if health.ConsecutiveErrors > 0 {
+		state = "failing"
+	} else if health.LastSuccess.IsZero() {
+		state = "unknown"
+	}
+	u.AddValue(message.NewValue().WithPath(prefix + "state").WithValue(state))
+	u.AddValue(message.NewValue().WithPath(prefix + "errors").WithValue(health.Errors))
+	u.AddValue(message.NewValue().WithPath(prefix + "consecutiveErrors").WithValue(health.ConsecutiveErrors))
+	if !health.LastSuccess.IsZero() {
+		u.AddValue(message.NewValue().WithPath(prefix + "lastSuccess").WithValue(health.LastSuccess))
+		u.AddValue(message.NewValue().WithPath(prefix + "latency").WithValue(health.Latency.Seconds()))
+	}
+	if health.LastError != "" {
+		u.AddValue(message.NewValue().WithPath(prefix + "lastError").WithValue(health.LastError))
+		u.AddValue(message.NewValue().WithPath(prefix + "lastException").WithValue(health.LastException))
+	}
+	return nil
+}
+
+// modbusDeviceObjectPaths are the paths of the objects of the device identification
+var modbusDeviceObjectPaths = map[uint8]string{
+	protocol.MODBUS_DEVICE_OBJECT_VENDOR_NAME:           "vendorName",
+	protocol.MODBUS_DEVICE_OBJECT_PRODUCT_CODE:          "productCode",
+	protocol.MODBUS_DEVICE_OBJECT_MAJOR_MINOR_REVISION:  "revision",
+	protocol.MODBUS_DEVICE_OBJECT_VENDOR_URL:            "vendorUrl",
+	protocol.MODBUS_DEVICE_OBJECT_PRODUCT_NAME:          "productName",
+	protocol.MODBUS_DEVICE_OBJECT_MODEL_NAME:            "modelName",
+	protocol.MODBUS_DEVICE_OBJECT_USER_APPLICATION_NAME: "userApplicationName",
+}
+
+// modbusDiagnosticsPaths are the paths of the counters of the diagnostics sub-functions
+var modbusDiagnosticsPaths = map[uint16]string{
+	protocol.MODBUS_DIAGNOSTICS_BUS_MESSAGE_COUNT:             "busMessageCount",
+	protocol.MODBUS_DIAGNOSTICS_BUS_COMMUNICATION_ERROR_COUNT: "busCommunicationErrorCount",
+	protocol.MODBUS_DIAGNOSTICS_BUS_EXCEPTION_ERROR_COUNT:     "busExceptionErrorCount",
+	protocol.MODBUS_DIAGNOSTICS_SERVER_MESSAGE_COUNT:          "serverMessageCount",
+	protocol.MODBUS_DIAGNOSTICS_SERVER_NO_RESPONSE_COUNT:      "serverNoResponseCount",
+	protocol.MODBUS_DIAGNOSTICS_SERVER_NAK_COUNT:              "serverNakCount",
+	protocol.MODBUS_DIAGNOSTICS_SERVER_BUSY_COUNT:             "serverBusyCount",
+	protocol.MODBUS_DIAGNOSTICS_BUS_CHARACTER_OVERRUN_COUNT:   "busCharacterOverrunCount",
+}
+
+// mapModbusDevice maps the device information of a slave to sensors.modbus.<slave>.device, the number of coils or
+// registers of the report server id is the length of the server id
+func mapModbusDevice(slave uint8, functionCode uint16, address uint16, numberOfCoilsOrRegisters uint16, data []byte, u *message.Update) error {
+	prefix := fmt.Sprintf("sensors.modbus.%d.device.", slave)
+	switch functionCode {
+	case protocol.READ_DEVICE_IDENTIFICATION_B:
+		objects, err := protocol.DecodeDeviceIdentification(data)
+		if err != nil {
+			return err
+		}
+		for id := uint8(protocol.MODBUS_DEVICE_OBJECT_VENDOR_NAME); id <= protocol.MODBUS_DEVICE_OBJECT_USER_APPLICATION_NAME; id++ {
+			if object, ok := objects[id]; ok {
+				u.AddValue(message.NewValue().WithPath(prefix + modbusDeviceObjectPaths[id]).WithValue(object))
+			}
+		}
+	case protocol.REPORT_SERVER_ID:
+		if len(data) <= int(numberOfCoilsOrRegisters) {
+			return fmt.Errorf("expected a server id of %d bytes and a run indicator but got %x", numberOfCoilsOrRegisters, data)
+		}
+		u.AddValue(message.NewValue().WithPath(prefix + "serverId").WithValue(hex.EncodeToString(data[:numberOfCoilsOrRegisters])))
+		u.AddValue(message.NewValue().WithPath(prefix + "running").WithValue(data[numberOfCoilsOrRegisters] == 0xff))
+		if additional := strings.TrimRight(string(data[numberOfCoilsOrRegisters+1:]), " \x00"); additional != "" {
+			u.AddValue(message.NewValue().WithPath(prefix + "additionalData").WithValue(additional))
+		}
+	case protocol.DIAGNOSTICS:
+		path, ok := modbusDiagnosticsPaths[address]
+		if !ok || len(data) != 2 {
+			return fmt.Errorf("unsupported diagnostics sub-function %d with data %x", address, data)
+		}
+		u.AddValue(message.NewValue().WithPath(prefix + "diagnostics." + path).WithValue(binary.BigEndian.Uint16(data)))
+	case protocol.GET_COMM_EVENT_COUNTER:
+		if len(data) != 4 {
+			return fmt.Errorf("expected a status and an event count but got %x", data)
+		}
+		u.AddValue(message.NewValue().WithPath(prefix + "diagnostics.busy").WithValue(binary.BigEndian.Uint16(data[0:2]) == 0xffff))
+		u.AddValue(message.NewValue().WithPath(prefix + "diagnostics.commEventCount").WithValue(binary.BigEndian.Uint16(data[2:4])))
+	}
+	if len(u.Values) == 0 {
+		return fmt.Errorf("no device information in %x", data)
+	}
+	return nil
+}
+
+// mapModbusAcknowledgement maps the result of a written command to sensors.modbus.<slave>.<function code>.<address>
+func mapModbusAcknowledgement(value []byte, u *message.Update) error {
+	var acknowledgement protocol.ModbusAcknowledgement
+	if err := json.Unmarshal(value, &acknowledgement); err != nil {
+		return err
+	}
+
+	prefix := fmt.Sprintf("sensors.modbus.%d.%d.%d.", acknowledgement.Slave, acknowledgement.FunctionCode, acknowledgement.Address)
+	if acknowledgement.Success {
+		u.AddValue(message.NewValue().WithPath(prefix + "writeState").WithValue("ok"))
+		return nil
+	}
+	u.AddValue(message.NewValue().WithPath(prefix + "writeState").WithValue("failed"))
+	u.AddValue(message.NewValue().WithPath(prefix + "writeError").WithValue(acknowledgement.Error))
+	u.AddValue(message.NewValue().WithPath(prefix + "writeException").WithValue(acknowledgement.Exception))
+	return nil
+}
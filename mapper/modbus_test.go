@@ -9,12 +9,14 @@ import (
 	"github.com/munnik/gosk/message"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 var _ = Describe("DoMap Modbus", func() {
 	mapper, _ := NewModbusMapper(
 		config.MapperConfig{Context: "testingContext"},
 		config.NewModbusMappingsConfig("modbus_test.yaml"),
+		prometheus.NewRegistry(),
 	)
 	now := time.Now()
 	f := false
@@ -226,6 +228,128 @@ var _ = Describe("DoMap Modbus", func() {
 			false,
 		),
 	)
+	Describe("Data types", func() {
+		It("Decodes the registers and makes the result available as value", func() {
+			m := message.NewRaw().WithConnector("testingConnector").WithType(config.ModbusType).WithValue([]byte{
+				0x04, 0x00, 0x03, 0x00, 0x64, 0x00, 0x06, // slave 4, function code 3, address 100, 6 registers
+				0x00, 0x00, 0x3f, 0xc0, // float32 1.5 with the least significant register first
+				0xff, 0xec, // int16 -20
+				0x43, 0x41, 0x54, 0x00, // string CAT
+				0x00, 0x08, // bitfield with bit 3 set
+			})
+			m.Timestamp = now
+			result, err := mapper.DoMap(m)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Updates).To(HaveLen(1))
+			values := make(map[string]interface{})
+			for _, v := range result.Updates[0].Values {
+				values[v.Path] = v.Value
+			}
+			Expect(values).To(HaveLen(4))
+			Expect(values["testingFloatPath"]).To(Equal(1.5))
+			Expect(values["testingScaledPath"]).To(BeNumerically("~", 271.15, 1e-9))
+			Expect(values["testingStringPath"]).To(Equal("CAT"))
+			Expect(values["testingBitPath"]).To(Equal(true))
+		})
+	})
+
+	Describe("Health", func() {
+		It("Maps the health of a register group", func() {
+			lastSuccess := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+			m := message.NewRaw().WithConnector("testingConnector").WithType(config.ModbusHealthType).WithValue([]byte(
+				`{"slave":2,"functionCode":3,"address":100,"lastSuccess":"2022-01-01T12:00:00Z","latency":25000000,"errors":3,"consecutiveErrors":1,"lastError":"illegal data address","lastException":2}`,
+			))
+			m.Timestamp = now
+			result, err := mapper.DoMap(m)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Updates).To(HaveLen(1))
+			values := make(map[string]interface{})
+			for _, v := range result.Updates[0].Values {
+				values[v.Path] = v.Value
+			}
+			Expect(values).To(Equal(map[string]interface{}{
+				"sensors.modbus.2.3.100.state":             "failing",
+				"sensors.modbus.2.3.100.errors":            uint64(3),
+				"sensors.modbus.2.3.100.consecutiveErrors": uint64(1),
+				"sensors.modbus.2.3.100.lastSuccess":       lastSuccess,
+				"sensors.modbus.2.3.100.latency":           0.025,
+				"sensors.modbus.2.3.100.lastError":         "illegal data address",
+				"sensors.modbus.2.3.100.lastException":     uint8(2),
+			}))
+		})
+	})
+
+	Describe("Acknowledgement", func() {
+		It("Maps the result of a failed command", func() {
+			m := message.NewRaw().WithConnector("testingConnector").WithType(config.ModbusAcknowledgementType).WithValue([]byte(
+				`{"request":"00000000-0000-0000-0000-000000000000","slave":2,"functionCode":22,"address":100,"success":false,"error":"illegal data address","exception":2}`,
+			))
+			m.Timestamp = now
+			result, err := mapper.DoMap(m)
+			Expect(err).NotTo(HaveOccurred())
+			values := make(map[string]interface{})
+			for _, v := range result.Updates[0].Values {
+				values[v.Path] = v.Value
+			}
+			Expect(values).To(Equal(map[string]interface{}{
+				"sensors.modbus.2.22.100.writeState":     "failed",
+				"sensors.modbus.2.22.100.writeError":     "illegal data address",
+				"sensors.modbus.2.22.100.writeException": uint8(2),
+			}))
+		})
+	})
+
+	Describe("Device information", func() {
+		mapValues := func(value []byte) (map[string]interface{}, error) {
+			m := message.NewRaw().WithConnector("testingConnector").WithType(config.ModbusType).WithValue(value)
+			m.Timestamp = now
+			result, err := mapper.DoMap(m)
+			if err != nil {
+				return nil, err
+			}
+			values := make(map[string]interface{})
+			for _, v := range result.Updates[0].Values {
+				values[v.Path] = v.Value
+			}
+			return values, nil
+		}
+		It("Maps the device identification", func() {
+			values, err := mapValues([]byte{5, 0, 43, 0, 1, 0, 1, 0, 3, 'A', 'B', 'B', 1, 2, 'X', '1', 2, 4, 'v', '1', '.', '2', 0x80, 1, 'P'})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(values).To(Equal(map[string]interface{}{
+				"sensors.modbus.5.device.vendorName":  "ABB",
+				"sensors.modbus.5.device.productCode": "X1",
+				"sensors.modbus.5.device.revision":    "v1.2",
+			}))
+		})
+		It("Maps the server id", func() {
+			values, err := mapValues([]byte{5, 0, 17, 0, 0, 0, 2, 0x12, 0x34, 0xff, 'P', 'M', 0})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(values).To(Equal(map[string]interface{}{
+				"sensors.modbus.5.device.serverId":       "1234",
+				"sensors.modbus.5.device.running":        true,
+				"sensors.modbus.5.device.additionalData": "PM",
+			}))
+		})
+		It("Maps the communication counters", func() {
+			values, err := mapValues([]byte{5, 0, 8, 0, 12, 0, 1, 0x01, 0x02})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(values).To(Equal(map[string]interface{}{
+				"sensors.modbus.5.device.diagnostics.busCommunicationErrorCount": uint16(258),
+			}))
+			values, err = mapValues([]byte{5, 0, 11, 0, 0, 0, 1, 0x00, 0x00, 0x00, 0x07})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(values).To(Equal(map[string]interface{}{
+				"sensors.modbus.5.device.diagnostics.busy":           false,
+				"sensors.modbus.5.device.diagnostics.commEventCount": uint16(7),
+			}))
+		})
+		It("Fails on a diagnostics sub-function without a counter", func() {
+			_, err := mapValues([]byte{5, 0, 8, 0, 1, 0, 1, 0x00, 0x00})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
 	DescribeTable("deltas", func(m *ModbusMapper, first *message.Raw, second *message.Raw, expected *message.Mapped, expectError bool) {
 		m.DoMap(first)
 		result, err := m.DoMap(second)
@@ -3,25 +3,47 @@ package mapper
 import (
 	"fmt"
 	"strings"
+	"time"
 	"unicode"
 
 	"github.com/munnik/go-nmea"
 	"github.com/munnik/gosk/config"
 	"github.com/munnik/gosk/message"
+	"github.com/munnik/gosk/protocol"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.nanomsg.org/mangos/v3"
 )
 
 type Nmea0183Mapper struct {
-	config   config.MapperConfig
-	protocol string
+	config         config.MapperConfig
+	protocol       string
+	validator      *Validator
+	reassembler    *nmea0183Reassembler
+	tagBlockGroups *protocol.TagBlockGroups
 }
 
-func NewNmea0183Mapper(c config.MapperConfig) (*Nmea0183Mapper, error) {
-	return &Nmea0183Mapper{config: c, protocol: config.NMEA0183Type}, nil
+func NewNmea0183Mapper(c config.MapperConfig, reg prometheus.Registerer) (*Nmea0183Mapper, error) {
+	validator, err := NewValidator(c.Validation, reg)
+	if err != nil {
+		return nil, err
+	}
+	timeout := defaultReassemblyTimeout
+	if timeoutString, ok := c.ProtocolOptions[config.ProtocolOptionNmeaReassemblyTimeout]; ok {
+		if timeout, err = time.ParseDuration(timeoutString); err != nil {
+			return nil, err
+		}
+	}
+	return &Nmea0183Mapper{
+		config:         c,
+		protocol:       config.NMEA0183Type,
+		validator:      validator,
+		reassembler:    newNmea0183Reassembler(timeout),
+		tagBlockGroups: protocol.NewTagBlockGroups(timeout),
+	}, nil
 }
 
 func (m *Nmea0183Mapper) Map(subscriber mangos.Socket, publisher mangos.Socket) {
-	process(subscriber, publisher, m)
+	process(subscriber, publisher, m, m.validator)
 }
 
 func (m *Nmea0183Mapper) DoMap(r *message.Raw) (*message.Mapped, error) {
@@ -35,26 +57,76 @@ func (m *Nmea0183Mapper) DoMap(r *message.Raw) (*message.Mapped, error) {
 		)
 	}
 
-	sentence, err := nmea.Parse(string(r.Value), options...)
+	tagBlock, line, err := protocol.SplitTagBlock(r.Value)
+	if err != nil {
+		return nil, err
+	}
+	sentence, err := nmea.Parse(string(line), options...)
 	if err != nil {
 		return nil, err
 	}
 
+	now := time.Now()
 	result := message.NewMapped().WithContext(m.config.Context).WithOrigin(m.config.Context)
+	s := message.NewSource().WithLabel(r.Connector).WithType(m.protocol).WithUuid(r.Uuid)
+	u := message.NewUpdate().WithSource(*s).WithTimestamp(r.Timestamp)
 
-	// if it is a multi fragment message return without error if it is not the last fragment
+	sentences := []nmea.Sentence{sentence}
+	if tagBlock != nil {
+		// the sentences of a group are mapped together when the last line of the group is received
+		m.tagBlockGroups.Complete(tagBlock, now)
+		if tagBlock.Group != nil {
+			key := reassemblyKey{source: r.Connector, sentenceType: tagBlockGroupType, sequence: tagBlock.Group.ID}
+			parts, complete := m.reassembler.add(key, int64(tagBlock.Group.Total), int64(tagBlock.Group.Line), sentence, now)
+			if !complete {
+				return result, nil
+			}
+			sentences = parts
+		}
+		if tagBlock.Source != "" {
+			u.Source.Label = r.Connector + "." + tagBlock.Source
+		}
+		if !tagBlock.Timestamp.IsZero() {
+			u.Timestamp = tagBlock.Timestamp
+		}
+	}
+
+	incomplete := false
+	for _, sentence := range sentences {
+		if m.mapSentence(sentence, result, u, now) {
+			incomplete = true
+		}
+	}
+
+	if len(u.Values) == 0 && incomplete {
+		return result, nil
+	}
+	if len(u.Values) == 0 {
+		return nil, fmt.Errorf("data cannot be mapped: %s", sentence.String())
+	}
+
+	return result.AddUpdate(u), nil
+}
+
+// mapSentence adds the values of the sentence to the update, true is returned when the sentence is a fragment or part
+// of a sequence that is not complete on its own
+func (m *Nmea0183Mapper) mapSentence(sentence nmea.Sentence, result *message.Mapped, u *message.Update, now time.Time) bool {
+	// if it is a multi fragment message return if it is not the last fragment
 	if aisSentence, ok := sentence.(nmea.VDMVDO); ok {
 		if numFragments, err := aisSentence.NumFragments.GetValue(); err == nil {
 			if fragmentNUmber, err := aisSentence.FragmentNumber.GetValue(); err == nil {
 				if numFragments > fragmentNUmber {
-					return result, nil
+					return true
 				}
 			}
 		}
 	}
 
-	s := message.NewSource().WithLabel(r.Connector).WithType(m.protocol).WithUuid(r.Uuid)
-	u := message.NewUpdate().WithSource(*s).WithTimestamp(r.Timestamp)
+	// sentences that are part of a sequence are only mapped when the sequence is complete
+	values, isSequence := m.reassembler.reassemble(sentence, u.Source.Label, now)
+	for _, v := range values {
+		u.AddValue(v)
+	}
 
 	if v, ok := sentence.(nmea.MMSI); ok {
 		if mmsi, err := v.GetMMSI(); err == nil {
@@ -242,9 +314,5 @@ func (m *Nmea0183Mapper) DoMap(r *message.Raw) (*message.Mapped, error) {
 		u.AddValue(message.NewValue().WithPath("notifications.ais").WithValue(message.Alarm{State: &active, Message: &description}))
 	}
 
-	if len(u.Values) == 0 {
-		return nil, fmt.Errorf("data cannot be mapped: %s", sentence.String())
-	}
-
-	return result.AddUpdate(u), nil
+	return isSequence
 }
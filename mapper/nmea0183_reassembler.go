@@ -0,0 +1,221 @@
+package mapper
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/munnik/go-nmea"
+	"github.com/munnik/gosk/message"
+)
+
+const (
+	defaultReassemblyTimeout = 5 * time.Second
+	typeTXT                  = "TXT"
+	tagBlockGroupType        = "g" // sentences grouped by the g: parameter of the tag block
+)
+
+func init() {
+	// the parser doesn't support TXT sentences
+	nmea.MustRegisterParser(typeTXT, newTXT)
+}
+
+// txt is a text transmission, long texts are split over multiple sentences with the same text identifier
+type txt struct {
+	nmea.BaseSentence
+	TotalSentences nmea.Int64
+	SentenceNumber nmea.Int64
+	Identifier     nmea.String
+	Text           nmea.String
+}
+
+func newTXT(s nmea.BaseSentence) (nmea.Sentence, error) {
+	p := nmea.NewParser(s)
+	p.AssertType(typeTXT)
+	return txt{
+		BaseSentence:   s,
+		TotalSentences: p.Int64(0, "total number of sentences"),
+		SentenceNumber: p.Int64(1, "sentence number"),
+		Identifier:     p.String(2, "text identifier"),
+		Text:           p.String(3, "text"),
+	}, p.Err()
+}
+
+// reassemblyKey identifies a sequence of sentences, the sequence id is empty for sentences that don't have one. The
+// source is the label of the source of the sentences, so receivers with the same talker are reassembled separately.
+type reassemblyKey struct {
+	source       string
+	talker       string
+	sentenceType string
+	sequence     string
+}
+
+type reassembly struct {
+	total   int64
+	parts   map[int64]nmea.Sentence
+	started time.Time
+}
+
+// nmea0183Reassembler combines the sentences of a sequence, incomplete sequences are dropped after the timeout
+type nmea0183Reassembler struct {
+	mu        sync.Mutex
+	timeout   time.Duration
+	pending   map[reassemblyKey]*reassembly
+	waypoints map[string]message.Position // map of waypoint ident to the location of the waypoint
+}
+
+func newNmea0183Reassembler(timeout time.Duration) *nmea0183Reassembler {
+	return &nmea0183Reassembler{
+		timeout:   timeout,
+		pending:   make(map[reassemblyKey]*reassembly),
+		waypoints: make(map[string]message.Position),
+	}
+}
+
+// add stores the part of the sequence and returns all parts in order when the sequence is complete, a sequence is
+// restarted by its first part and parts that don't belong to a started sequence are dropped
+func (r *nmea0183Reassembler) add(key reassemblyKey, total int64, number int64, sentence nmea.Sentence, now time.Time) ([]nmea.Sentence, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for k, p := range r.pending {
+		if now.Sub(p.started) > r.timeout {
+			delete(r.pending, k)
+		}
+	}
+
+	if total < 1 || number < 1 || number > total {
+		return nil, false
+	}
+	p, ok := r.pending[key]
+	if number == 1 || (ok && p.total != total) {
+		p = &reassembly{total: total, parts: make(map[int64]nmea.Sentence, total), started: now}
+		r.pending[key] = p
+	} else if !ok {
+		return nil, false
+	}
+	p.parts[number] = sentence
+	if int64(len(p.parts)) < p.total {
+		return nil, false
+	}
+
+	delete(r.pending, key)
+	result := make([]nmea.Sentence, 0, p.total)
+	for i := int64(1); i <= p.total; i++ {
+		result = append(result, p.parts[i])
+	}
+	return result, true
+}
+
+func (r *nmea0183Reassembler) addWaypoint(ident string, position message.Position) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.waypoints[ident] = position
+}
+
+func (r *nmea0183Reassembler) waypoint(ident string) *message.Position {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if p, ok := r.waypoints[ident]; ok {
+		return &p
+	}
+	return nil
+}
+
+// reassemble maps the sentences of the source that are part of a sequence, false is returned when the sentence is not
+// part of a sequence. The returned values are empty when the sequence is not complete yet.
+func (r *nmea0183Reassembler) reassemble(sentence nmea.Sentence, source string, now time.Time) ([]*message.Value, bool) {
+	switch s := sentence.(type) {
+	case nmea.GSV:
+		parts, complete := r.add(reassemblyKey{source: source, talker: s.Talker, sentenceType: s.Type}, s.TotalMessages.Value, s.MessageNumber.Value, s, now)
+		if !complete {
+			return nil, true
+		}
+		return []*message.Value{message.NewValue().WithPath("navigation.gnss.satellitesInView").WithValue(satellitesInView(parts))}, true
+	case nmea.RTE:
+		parts, complete := r.add(reassemblyKey{source: source, talker: s.Talker, sentenceType: s.Type}, s.NumberOfSentences.Value, s.SentenceNumber.Value, s, now)
+		if !complete || s.ActiveRouteOrWaypointList.Value != nmea.ActiveRoute {
+			return nil, true
+		}
+		return []*message.Value{message.NewValue().WithPath("navigation.activeRoute").WithValue(r.route(parts))}, true
+	case nmea.WPL:
+		ident, err := s.Ident.GetValue()
+		if err != nil {
+			return nil, true
+		}
+		latitude, errLatitude := s.Latitude.GetValue()
+		longitude, errLongitude := s.Longitude.GetValue()
+		if errLatitude == nil && errLongitude == nil {
+			r.addWaypoint(ident, message.Position{Latitude: &latitude, Longitude: &longitude})
+		}
+		return nil, true
+	case txt:
+		parts, complete := r.add(reassemblyKey{source: source, talker: s.Talker, sentenceType: s.Type, sequence: s.Identifier.Value}, s.TotalSentences.Value, s.SentenceNumber.Value, s, now)
+		if !complete {
+			return nil, true
+		}
+		lines := make([]string, 0, len(parts))
+		for _, p := range parts {
+			lines = append(lines, p.(txt).Text.Value)
+		}
+		return []*message.Value{message.NewValue().WithPath("sensors." + s.Talker + ".text").WithValue(strings.Join(lines, "\n"))}, true
+	}
+	return nil, false
+}
+
+// satellitesInView combines the satellites of the GSV sentences, the satellites are sorted by id
+func satellitesInView(parts []nmea.Sentence) message.SatellitesInView {
+	result := message.SatellitesInView{Satellites: make([]message.Satellite, 0)}
+	for _, p := range parts {
+		gsv := p.(nmea.GSV)
+		if count, err := gsv.NumberSVsInView.GetValue(); err == nil {
+			result.Count = &count
+		}
+		for _, info := range gsv.Info {
+			id, err := info.SVPRNNumber.GetValue()
+			if err != nil {
+				continue
+			}
+			satellite := message.Satellite{Id: id}
+			if elevation, err := info.Elevation.GetValue(); err == nil {
+				e := float64(elevation) * math.Pi / 180
+				satellite.Elevation = &e
+			}
+			if azimuth, err := info.Azimuth.GetValue(); err == nil {
+				a := float64(azimuth) * math.Pi / 180
+				satellite.Azimuth = &a
+			}
+			if snr, err := info.SNR.GetValue(); err == nil {
+				s := float64(snr)
+				satellite.SNR = &s
+			}
+			result.Satellites = append(result.Satellites, satellite)
+		}
+	}
+	sort.Slice(result.Satellites, func(i, j int) bool {
+		return result.Satellites[i].Id < result.Satellites[j].Id
+	})
+	return result
+}
+
+// route combines the waypoints of the RTE sentences with the locations of the received WPL sentences
+func (r *nmea0183Reassembler) route(parts []nmea.Sentence) message.Route {
+	result := message.Route{Waypoints: make([]message.Waypoint, 0)}
+	for _, p := range parts {
+		rte := p.(nmea.RTE)
+		if name, err := rte.Name.GetValue(); err == nil && name != "" {
+			result.Name = &name
+		}
+		for _, ident := range rte.Idents.Values {
+			if ident.Value == "" {
+				continue
+			}
+			result.Waypoints = append(result.Waypoints, message.Waypoint{Name: ident.Value, Position: r.waypoint(ident.Value)})
+		}
+	}
+	return result
+}
@@ -9,11 +9,13 @@ import (
 	"github.com/munnik/gosk/message"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 var _ = Describe("DoMap nmea0183", func() {
 	mapper, _ := NewNmea0183Mapper(
 		config.MapperConfig{Context: "testingContext"},
+		prometheus.NewRegistry(),
 	)
 	now := time.Now()
 	m := "AIS: Antenna VSWR exceeds limit"
@@ -99,3 +101,140 @@ var _ = Describe("DoMap nmea0183", func() {
 		),
 	)
 })
+
+var _ = Describe("DoMap nmea0183 sequences", func() {
+	var mapper *Nmea0183Mapper
+	BeforeEach(func() {
+		var err error
+		mapper, err = NewNmea0183Mapper(config.MapperConfig{Context: "testingContext", ProtocolOptions: map[string]string{config.ProtocolOptionNmeaReassemblyTimeout: "1s"}}, prometheus.NewRegistry())
+		Expect(err).NotTo(HaveOccurred())
+	})
+	raw := func(sentence string) *message.Raw {
+		m := message.NewRaw().WithConnector("testingConnector").WithType(config.NMEA0183Type).WithValue([]byte(sentence))
+		m.Uuid = uuid.Nil
+		m.Timestamp = time.Now()
+		return m
+	}
+	It("Combines the satellites of the GSV sentences", func() {
+		result, err := mapper.DoMap(raw("$GPGSV,2,1,06,02,17,310,35,05,62,245,44,12,35,063,41,13,10,181,*70"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(valuesByPath(result)).NotTo(HaveKey("navigation.gnss.satellitesInView"))
+		result, err = mapper.DoMap(raw("$GPGSV,2,2,06,15,07,039,,29,56,141,40*7E"))
+		Expect(err).NotTo(HaveOccurred())
+		sv := valuesByPath(result)["navigation.gnss.satellitesInView"].(message.SatellitesInView)
+		Expect(*sv.Count).To(Equal(int64(6)))
+		Expect(sv.Satellites).To(HaveLen(6))
+		Expect(sv.Satellites[0].Id).To(Equal(int64(2)))
+		Expect(*sv.Satellites[0].Elevation).To(BeNumerically("~", 0.2967, 0.0001))
+		Expect(*sv.Satellites[0].SNR).To(Equal(35.0))
+		Expect(sv.Satellites[3].SNR).To(BeNil())
+	})
+	It("Drops parts that don't belong to a started sequence", func() {
+		result, err := mapper.DoMap(raw("$GPGSV,2,2,06,15,07,039,,29,56,141,40*7E"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(valuesByPath(result)).NotTo(HaveKey("navigation.gnss.satellitesInView"))
+	})
+	It("Drops incomplete sequences after the timeout", func() {
+		mapper.DoMap(raw("$GPGSV,2,1,06,02,17,310,35,05,62,245,44,12,35,063,41,13,10,181,*70"))
+		time.Sleep(1100 * time.Millisecond)
+		result, _ := mapper.DoMap(raw("$GPGSV,2,2,06,15,07,039,,29,56,141,40*7E"))
+		Expect(valuesByPath(result)).NotTo(HaveKey("navigation.gnss.satellitesInView"))
+	})
+	It("Combines the RTE sentences with the WPL locations to the active route", func() {
+		result, err := mapper.DoMap(raw("$GPWPL,5310.2000,N,00524.6000,E,WP1*7D"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Updates).To(BeEmpty())
+		result, err = mapper.DoMap(raw("$GPRTE,2,1,c,HARLINGEN,WP1,WP2*6B"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Updates).To(BeEmpty())
+		result, err = mapper.DoMap(raw("$GPRTE,2,2,c,HARLINGEN,WP3*73"))
+		Expect(err).NotTo(HaveOccurred())
+		route := valuesByPath(result)["navigation.activeRoute"].(message.Route)
+		Expect(*route.Name).To(Equal("HARLINGEN"))
+		Expect(route.Waypoints).To(HaveLen(3))
+		Expect(route.Waypoints[0].Name).To(Equal("WP1"))
+		Expect(*route.Waypoints[0].Position.Latitude).To(BeNumerically("~", 53.17, 0.0001))
+		Expect(*route.Waypoints[0].Position.Longitude).To(BeNumerically("~", 5.41, 0.0001))
+		Expect(route.Waypoints[2].Position).To(BeNil())
+	})
+	It("Combines the sequences of each connector separately", func() {
+		fromConnector := func(connector string, sentence string) *message.Raw {
+			return raw(sentence).WithConnector(connector)
+		}
+		result, err := mapper.DoMap(fromConnector("firstConnector", "$GPGSV,2,1,06,02,17,310,35,05,62,245,44,12,35,063,41,13,10,181,*70"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(valuesByPath(result)).NotTo(HaveKey("navigation.gnss.satellitesInView"))
+		result, err = mapper.DoMap(fromConnector("secondConnector", "$GPGSV,2,1,05,07,17,310,35,08,62,245,44,09,35,063,41,10,10,181,*72"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(valuesByPath(result)).NotTo(HaveKey("navigation.gnss.satellitesInView"))
+
+		result, err = mapper.DoMap(fromConnector("firstConnector", "$GPGSV,2,2,06,15,07,039,,29,56,141,40*7E"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Updates[0].Source.Label).To(Equal("firstConnector"))
+		sv := valuesByPath(result)["navigation.gnss.satellitesInView"].(message.SatellitesInView)
+		Expect(*sv.Count).To(Equal(int64(6)))
+		Expect(sv.Satellites[0].Id).To(Equal(int64(2)))
+
+		result, err = mapper.DoMap(fromConnector("secondConnector", "$GPGSV,2,2,05,11,07,039,*41"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Updates[0].Source.Label).To(Equal("secondConnector"))
+		sv = valuesByPath(result)["navigation.gnss.satellitesInView"].(message.SatellitesInView)
+		Expect(*sv.Count).To(Equal(int64(5)))
+		Expect(sv.Satellites).To(HaveLen(5))
+		Expect(sv.Satellites[0].Id).To(Equal(int64(7)))
+	})
+	It("Combines the TXT sentences with the same identifier", func() {
+		result, err := mapper.DoMap(raw("$GPTXT,02,01,25,ANTENNA OPEN*20"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Updates).To(BeEmpty())
+		result, err = mapper.DoMap(raw("$GPTXT,02,02,25,CHECK CABLE*67"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(valuesByPath(result)).To(HaveKeyWithValue("sensors.GP.text", "ANTENNA OPEN\nCHECK CABLE"))
+	})
+})
+
+var _ = Describe("DoMap nmea0183 tag blocks", func() {
+	var mapper *Nmea0183Mapper
+	BeforeEach(func() {
+		var err error
+		mapper, err = NewNmea0183Mapper(config.MapperConfig{Context: "testingContext"}, prometheus.NewRegistry())
+		Expect(err).NotTo(HaveOccurred())
+	})
+	raw := func(line string) *message.Raw {
+		m := message.NewRaw().WithConnector("testingConnector").WithType(config.NMEA0183Type).WithValue([]byte(line))
+		m.Uuid = uuid.Nil
+		m.Timestamp = time.Now()
+		return m
+	}
+
+	It("Uses the timestamp and the source of the tag block", func() {
+		result, err := mapper.DoMap(raw("\\s:GP0001,c:1577836800*2B\\$GPHDT,123.4,T*31"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Updates).To(HaveLen(1))
+		Expect(result.Updates[0].Timestamp).To(Equal(time.Unix(1577836800, 0)))
+		Expect(result.Updates[0].Source.Label).To(Equal("testingConnector.GP0001"))
+		Expect(result.Updates[0].Source.Type).To(Equal(config.NMEA0183Type))
+		Expect(result.Updates[0].Values).To(HaveLen(1))
+		Expect(result.Updates[0].Values[0].Path).To(Equal("navigation.headingTrue"))
+	})
+	It("Rejects a tag block with an invalid checksum", func() {
+		result, err := mapper.DoMap(raw("\\s:GP0001,c:1577836800*00\\$GPHDT,123.4,T*31"))
+		Expect(err).To(HaveOccurred())
+		Expect(result).To(BeNil())
+	})
+	It("Combines the sentences of a group", func() {
+		result, err := mapper.DoMap(raw("\\g:1-2-7,s:GP0001,c:1577836800*6E\\$GPHDT,123.4,T*31"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Updates).To(BeEmpty())
+		result, err = mapper.DoMap(raw("\\g:2-2-7*6A\\$GPROT,12.0,A*02"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Updates).To(HaveLen(1))
+		Expect(result.Updates[0].Timestamp).To(Equal(time.Unix(1577836800, 0)))
+		Expect(result.Updates[0].Source.Label).To(Equal("testingConnector.GP0001"))
+		paths := []string{}
+		for _, v := range result.Updates[0].Values {
+			paths = append(paths, v.Path)
+		}
+		Expect(paths).To(ConsistOf("navigation.headingTrue", "navigation.rateOfTurn"))
+	})
+})
@@ -0,0 +1,718 @@
+package mapper
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/munnik/gosk/config"
+	"github.com/munnik/gosk/logger"
+	"github.com/munnik/gosk/message"
+	"github.com/munnik/gosk/protocol"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.nanomsg.org/mangos/v3"
+	"go.uber.org/zap"
+)
+
+const (
+	// PGNs used by the ISO 11783 transport protocol to send messages larger than 8 bytes
+	pgnTransportProtocolConnectionManagement = 60416
+	pgnTransportProtocolDataTransfer         = 60160
+
+	transportProtocolRequestToSend = 16
+	transportProtocolBroadcast     = 32
+	transportProtocolAbort         = 255
+
+	// a multi frame message is discarded when the next frame doesn't arrive in time
+	nmea2000FrameTimeout = 750 * time.Millisecond
+)
+
+// fastPacketPGNs contains the PGNs that are sent using the NMEA 2000 fast-packet protocol
+var fastPacketPGNs = map[uint32]struct{}{
+	126208: {}, 126464: {}, 126720: {}, 126983: {}, 126984: {}, 126985: {}, 126986: {}, 126987: {}, 126988: {},
+	126996: {}, 126998: {}, 127233: {}, 127237: {}, 127489: {}, 127496: {}, 127497: {}, 127498: {}, 127503: {},
+	127504: {}, 127506: {}, 127507: {}, 127509: {}, 127510: {}, 127511: {}, 127512: {}, 127513: {}, 127514: {},
+	128275: {}, 128520: {}, 129029: {}, 129038: {}, 129039: {}, 129040: {}, 129041: {}, 129044: {}, 129045: {},
+	129284: {}, 129285: {}, 129301: {}, 129302: {}, 129538: {}, 129540: {}, 129541: {}, 129542: {}, 129545: {},
+	129547: {}, 129549: {}, 129551: {}, 129556: {}, 129792: {}, 129793: {}, 129794: {}, 129795: {}, 129796: {},
+	129797: {}, 129798: {}, 129799: {}, 129800: {}, 129801: {}, 129802: {}, 129803: {}, 129804: {}, 129805: {},
+	129806: {}, 129807: {}, 129808: {}, 129809: {}, 129810: {}, 130052: {}, 130053: {}, 130054: {}, 130060: {},
+	130061: {}, 130064: {}, 130065: {}, 130066: {}, 130067: {}, 130068: {}, 130069: {}, 130070: {}, 130071: {},
+	130072: {}, 130073: {}, 130074: {}, 130320: {}, 130321: {}, 130322: {}, 130323: {}, 130324: {}, 130567: {},
+	130577: {}, 130578: {},
+}
+
+// Nmea2000Header contains the fields encoded in the 29 bit identifier of a NMEA 2000 frame
+type Nmea2000Header struct {
+	Priority    uint8
+	PGN         uint32
+	Source      uint8
+	Destination uint8
+}
+
+// NewNmea2000Header decodes the 29 bit CAN identifier, for PDU1 messages (PF < 240) the PS field is the
+// destination address, for PDU2 messages the PS field is part of the PGN and the message is broadcasted
+func NewNmea2000Header(id uint32) Nmea2000Header {
+	id = id & protocol.CAN_EFF_MASK
+	dataPage := (id >> 24) & 0x03 // includes the extended data page bit
+	pduFormat := (id >> 16) & 0xff
+	pduSpecific := (id >> 8) & 0xff
+
+	header := Nmea2000Header{
+		Priority:    uint8((id >> 26) & 0x07),
+		Source:      uint8(id & 0xff),
+		Destination: 0xff,
+	}
+	if pduFormat < 240 {
+		header.PGN = dataPage<<16 | pduFormat<<8
+		header.Destination = uint8(pduSpecific)
+	} else {
+		header.PGN = dataPage<<16 | pduFormat<<8 | pduSpecific
+	}
+	return header
+}
+
+type fastPacket struct {
+	sequence uint8
+	next     uint8
+	length   int
+	data     []byte
+	updated  time.Time
+}
+
+type transportSession struct {
+	pgn     uint32
+	next    uint8
+	length  int
+	data    []byte
+	updated time.Time
+}
+
+type Nmea2000Mapper struct {
+	config      config.MapperConfig
+	protocol    string
+	fastPackets map[uint32]*fastPacket       // key is source address and PGN
+	sessions    map[uint16]*transportSession // key is source and destination address
+	validator   *Validator
+	canErrors   *canErrorNotifications
+}
+
+func NewNmea2000Mapper(c config.MapperConfig, reg prometheus.Registerer) (*Nmea2000Mapper, error) {
+	validator, err := NewValidator(c.Validation, reg)
+	if err != nil {
+		return nil, err
+	}
+	return &Nmea2000Mapper{
+		config:      c,
+		protocol:    config.NMEA2000Type,
+		fastPackets: make(map[uint32]*fastPacket),
+		sessions:    make(map[uint16]*transportSession),
+		validator:   validator,
+		canErrors:   newCanErrorNotifications(),
+	}, nil
+}
+
+func (m *Nmea2000Mapper) Map(subscriber mangos.Socket, publisher mangos.Socket) {
+	process(subscriber, publisher, m, m.validator)
+}
+
+func (m *Nmea2000Mapper) DoMap(r *message.Raw) (*message.Mapped, error) {
+	frm, err := createFrame(r)
+	if err != nil {
+		return nil, err
+	}
+
+	result := message.NewMapped().WithContext(m.config.Context).WithOrigin(m.config.Context)
+
+	if frm.IsError() {
+		s := message.NewSource().WithLabel(r.Connector).WithType(m.protocol).WithUuid(r.Uuid)
+		u := message.NewUpdate().WithSource(*s).WithTimestamp(r.Timestamp)
+		m.canErrors.mapErrorFrame(frm, r.Connector, u)
+		return result.AddUpdate(u), nil
+	}
+	if !frm.IsExtended() || frm.IsRemote() || frm.IsFD() {
+		return nil, fmt.Errorf("frame with id %x is not a NMEA 2000 data frame", frm.ID)
+	}
+	cleared := message.NewUpdate().WithSource(*message.NewSource().WithLabel(r.Connector).WithType(m.protocol).WithUuid(r.Uuid)).WithTimestamp(r.Timestamp)
+	m.canErrors.clear(frm, r.Connector, cleared)
+	if len(cleared.Values) > 0 {
+		result.AddUpdate(cleared)
+	}
+	header := NewNmea2000Header(frm.ID)
+
+	pgn, data, complete := m.assemble(header, frm.Data, r.Timestamp)
+	if !complete {
+		// wait for the other frames of this message
+		return result, nil
+	}
+
+	decoder, ok := nmea2000Decoders[pgn]
+	if !ok {
+		// most PGNs on a bus are not mapped, skip them without a warning
+		logger.GetLogger().Debug(
+			"No decoder available for the PGN",
+			zap.Uint32("PGN", pgn),
+			zap.Uint8("Source", header.Source),
+		)
+		return result, nil
+	}
+
+	// the source address is added to the label so multiple devices on the same bus can be distinguished
+	s := message.NewSource().WithLabel(fmt.Sprintf("%s.%d", r.Connector, header.Source)).WithType(m.protocol).WithUuid(r.Uuid)
+	u := message.NewUpdate().WithSource(*s).WithTimestamp(r.Timestamp)
+	for _, v := range decoder(nmea2000Data(data)) {
+		u.AddValue(v)
+	}
+
+	if len(u.Values) == 0 {
+		return nil, fmt.Errorf("data cannot be mapped for PGN %d: %v", pgn, data)
+	}
+
+	return result.AddUpdate(u), nil
+}
+
+// assemble returns the PGN and the payload of a message when it is complete, single frame messages are
+// returned immediately, fast-packet and transport protocol messages are returned when the last frame is received
+func (m *Nmea2000Mapper) assemble(header Nmea2000Header, data []byte, timestamp time.Time) (uint32, []byte, bool) {
+	switch header.PGN {
+	case pgnTransportProtocolConnectionManagement:
+		m.handleConnectionManagement(header, data, timestamp)
+		return 0, nil, false
+	case pgnTransportProtocolDataTransfer:
+		return m.handleDataTransfer(header, data, timestamp)
+	}
+	if _, ok := fastPacketPGNs[header.PGN]; ok {
+		return m.handleFastPacket(header, data, timestamp)
+	}
+	return header.PGN, data, true
+}
+
+func (m *Nmea2000Mapper) handleFastPacket(header Nmea2000Header, data []byte, timestamp time.Time) (uint32, []byte, bool) {
+	if len(data) < 2 {
+		return 0, nil, false
+	}
+	key := uint32(header.Source)<<24 | header.PGN
+	sequence := data[0] >> 5
+	counter := data[0] & 0x1f
+
+	if counter == 0 {
+		packet := &fastPacket{
+			sequence: sequence,
+			next:     1,
+			length:   int(data[1]),
+			data:     make([]byte, 0, int(data[1])),
+			updated:  timestamp,
+		}
+		packet.data = append(packet.data, data[2:]...)
+		if len(packet.data) >= packet.length {
+			return header.PGN, packet.data[:packet.length], true
+		}
+		m.fastPackets[key] = packet
+		return 0, nil, false
+	}
+
+	packet, ok := m.fastPackets[key]
+	if !ok {
+		return 0, nil, false
+	}
+	if packet.sequence != sequence || packet.next != counter || timestamp.Sub(packet.updated) > nmea2000FrameTimeout {
+		// missed a frame, the message can't be completed anymore
+		delete(m.fastPackets, key)
+		return 0, nil, false
+	}
+	packet.data = append(packet.data, data[1:]...)
+	packet.next++
+	packet.updated = timestamp
+	if len(packet.data) >= packet.length {
+		delete(m.fastPackets, key)
+		return header.PGN, packet.data[:packet.length], true
+	}
+	return 0, nil, false
+}
+
+func (m *Nmea2000Mapper) handleConnectionManagement(header Nmea2000Header, data []byte, timestamp time.Time) {
+	if len(data) < 8 {
+		return
+	}
+	key := uint16(header.Source)<<8 | uint16(header.Destination)
+	switch data[0] {
+	case transportProtocolRequestToSend, transportProtocolBroadcast:
+		length := int(binary.LittleEndian.Uint16(data[1:3]))
+		m.sessions[key] = &transportSession{
+			pgn:     uint32(data[5]) | uint32(data[6])<<8 | uint32(data[7])<<16,
+			next:    1,
+			length:  length,
+			data:    make([]byte, 0, length),
+			updated: timestamp,
+		}
+	case transportProtocolAbort:
+		delete(m.sessions, key)
+	}
+}
+
+func (m *Nmea2000Mapper) handleDataTransfer(header Nmea2000Header, data []byte, timestamp time.Time) (uint32, []byte, bool) {
+	if len(data) < 2 {
+		return 0, nil, false
+	}
+	key := uint16(header.Source)<<8 | uint16(header.Destination)
+	session, ok := m.sessions[key]
+	if !ok {
+		return 0, nil, false
+	}
+	if session.next != data[0] || timestamp.Sub(session.updated) > nmea2000FrameTimeout {
+		delete(m.sessions, key)
+		return 0, nil, false
+	}
+	session.data = append(session.data, data[1:]...)
+	session.next++
+	session.updated = timestamp
+	if len(session.data) >= session.length {
+		delete(m.sessions, key)
+		return session.pgn, session.data[:session.length], true
+	}
+	return 0, nil, false
+}
+
+// nmea2000Data reads little endian fields from a NMEA 2000 payload, the boolean return value is false when
+// the field is outside of the payload or when the field contains one of the reserved values for not available,
+// out of range or reserved
+type nmea2000Data []byte
+
+func (d nmea2000Data) unsigned(offset int, size int, resolution float64) (float64, bool) {
+	if offset+size > len(d) {
+		return 0, false
+	}
+	var raw uint64
+	for i := size - 1; i >= 0; i-- {
+		raw = raw<<8 | uint64(d[offset+i])
+	}
+	max := uint64(1)<<(8*size) - 1
+	if raw >= max-2 {
+		return 0, false
+	}
+	return float64(raw) * resolution, true
+}
+
+func (d nmea2000Data) signed(offset int, size int, resolution float64) (float64, bool) {
+	if offset+size > len(d) {
+		return 0, false
+	}
+	var raw uint64
+	for i := size - 1; i >= 0; i-- {
+		raw = raw<<8 | uint64(d[offset+i])
+	}
+	shift := 64 - 8*size
+	value := int64(raw<<shift) >> shift // sign extend
+	max := int64(1)<<(8*size-1) - 1
+	if size == 8 {
+		max = math.MaxInt64
+	}
+	if value >= max-2 {
+		return 0, false
+	}
+	return float64(value) * resolution, true
+}
+
+func (d nmea2000Data) bits(offset int, shift int, width int) (uint8, bool) {
+	if offset >= len(d) {
+		return 0, false
+	}
+	mask := uint8(1)<<width - 1
+	value := (d[offset] >> shift) & mask
+	return value, value != mask
+}
+
+type nmea2000Decoder func(d nmea2000Data) []*message.Value
+
+// nmea2000Decoders maps PGNs directly on SignalK paths, all values are converted to SI units
+var nmea2000Decoders = map[uint32]nmea2000Decoder{
+	127245: decodeRudder,
+	127250: decodeVesselHeading,
+	127251: decodeRateOfTurn,
+	127488: decodeEngineParametersRapidUpdate,
+	127489: decodeEngineParametersDynamic,
+	127505: decodeFluidLevel,
+	127508: decodeBatteryStatus,
+	128259: decodeSpeed,
+	128267: decodeWaterDepth,
+	129025: decodePositionRapidUpdate,
+	129026: decodeCogSogRapidUpdate,
+	129029: decodeGnssPositionData,
+	130306: decodeWindData,
+	130310: decodeEnvironmentalParameters,
+	130311: decodeEnvironmentalParametersWithSource,
+	130312: decodeTemperature,
+}
+
+func nmea2000Value(path string, value interface{}) *message.Value {
+	return message.NewValue().WithPath(path).WithValue(value)
+}
+
+func decodeRudder(d nmea2000Data) []*message.Value {
+	result := make([]*message.Value, 0)
+	if position, ok := d.signed(4, 2, 0.0001); ok {
+		result = append(result, nmea2000Value("steering.rudderAngle", position))
+	}
+	return result
+}
+
+func decodeVesselHeading(d nmea2000Data) []*message.Value {
+	result := make([]*message.Value, 0)
+	if heading, ok := d.unsigned(1, 2, 0.0001); ok {
+		if reference, ok := d.bits(7, 0, 2); ok && reference == 1 {
+			result = append(result, nmea2000Value("navigation.headingMagnetic", heading))
+		} else if ok && reference == 0 {
+			result = append(result, nmea2000Value("navigation.headingTrue", heading))
+		}
+	}
+	if deviation, ok := d.signed(3, 2, 0.0001); ok {
+		result = append(result, nmea2000Value("navigation.magneticDeviation", deviation))
+	}
+	if variation, ok := d.signed(5, 2, 0.0001); ok {
+		result = append(result, nmea2000Value("navigation.magneticVariation", variation))
+	}
+	return result
+}
+
+func decodeRateOfTurn(d nmea2000Data) []*message.Value {
+	result := make([]*message.Value, 0)
+	if rate, ok := d.signed(1, 4, 3.125e-08); ok {
+		result = append(result, nmea2000Value("navigation.rateOfTurn", rate))
+	}
+	return result
+}
+
+func decodeEngineParametersRapidUpdate(d nmea2000Data) []*message.Value {
+	result := make([]*message.Value, 0)
+	instance, ok := d.unsigned(0, 1, 1)
+	if !ok {
+		return result
+	}
+	if speed, ok := d.unsigned(1, 2, 0.25); ok {
+		result = append(result, nmea2000Value(fmt.Sprintf("propulsion.%d.revolutions", int(instance)), speed/60))
+	}
+	if boostPressure, ok := d.unsigned(3, 2, 100); ok {
+		result = append(result, nmea2000Value(fmt.Sprintf("propulsion.%d.boostPressure", int(instance)), boostPressure))
+	}
+	return result
+}
+
+func decodeEngineParametersDynamic(d nmea2000Data) []*message.Value {
+	result := make([]*message.Value, 0)
+	instance, ok := d.unsigned(0, 1, 1)
+	if !ok {
+		return result
+	}
+	prefix := fmt.Sprintf("propulsion.%d.", int(instance))
+	if oilPressure, ok := d.unsigned(1, 2, 100); ok {
+		result = append(result, nmea2000Value(prefix+"oilPressure", oilPressure))
+	}
+	if oilTemperature, ok := d.unsigned(3, 2, 0.1); ok {
+		result = append(result, nmea2000Value(prefix+"oilTemperature", oilTemperature))
+	}
+	if temperature, ok := d.unsigned(5, 2, 0.01); ok {
+		result = append(result, nmea2000Value(prefix+"temperature", temperature))
+	}
+	if alternatorVoltage, ok := d.signed(7, 2, 0.01); ok {
+		result = append(result, nmea2000Value(prefix+"alternatorVoltage", alternatorVoltage))
+	}
+	if fuelRate, ok := d.signed(9, 2, 0.1); ok {
+		result = append(result, nmea2000Value(prefix+"fuel.rate", fuelRate/1000/3600)) // L/h to m3/s
+	}
+	if runTime, ok := d.unsigned(11, 4, 1); ok {
+		result = append(result, nmea2000Value(prefix+"runTime", runTime))
+	}
+	if coolantPressure, ok := d.unsigned(15, 2, 100); ok {
+		result = append(result, nmea2000Value(prefix+"coolantPressure", coolantPressure))
+	}
+	if fuelPressure, ok := d.unsigned(17, 2, 1000); ok {
+		result = append(result, nmea2000Value(prefix+"fuel.pressure", fuelPressure))
+	}
+	if engineLoad, ok := d.signed(24, 1, 1); ok {
+		result = append(result, nmea2000Value(prefix+"engineLoad", engineLoad/100))
+	}
+	if engineTorque, ok := d.signed(25, 1, 1); ok {
+		result = append(result, nmea2000Value(prefix+"engineTorque", engineTorque/100))
+	}
+	return result
+}
+
+var nmea2000FluidTypes = map[uint8]string{
+	0: "fuel",
+	1: "freshWater",
+	2: "wasteWater",
+	3: "liveWell",
+	4: "lubrication",
+	5: "blackWater",
+}
+
+func decodeFluidLevel(d nmea2000Data) []*message.Value {
+	result := make([]*message.Value, 0)
+	instance, ok := d.bits(0, 0, 4)
+	if !ok {
+		return result
+	}
+	fluidType, ok := d.bits(0, 4, 4)
+	if !ok {
+		return result
+	}
+	name, ok := nmea2000FluidTypes[fluidType]
+	if !ok {
+		return result
+	}
+	prefix := fmt.Sprintf("tanks.%s.%d.", name, instance)
+	if level, ok := d.signed(1, 2, 0.004); ok {
+		result = append(result, nmea2000Value(prefix+"currentLevel", level/100))
+	}
+	if capacity, ok := d.unsigned(3, 4, 0.1); ok {
+		result = append(result, nmea2000Value(prefix+"capacity", capacity/1000)) // L to m3
+	}
+	return result
+}
+
+func decodeBatteryStatus(d nmea2000Data) []*message.Value {
+	result := make([]*message.Value, 0)
+	instance, ok := d.unsigned(0, 1, 1)
+	if !ok {
+		return result
+	}
+	prefix := fmt.Sprintf("electrical.batteries.%d.", int(instance))
+	if voltage, ok := d.signed(1, 2, 0.01); ok {
+		result = append(result, nmea2000Value(prefix+"voltage", voltage))
+	}
+	if current, ok := d.signed(3, 2, 0.1); ok {
+		result = append(result, nmea2000Value(prefix+"current", current))
+	}
+	if temperature, ok := d.unsigned(5, 2, 0.01); ok {
+		result = append(result, nmea2000Value(prefix+"temperature", temperature))
+	}
+	return result
+}
+
+func decodeSpeed(d nmea2000Data) []*message.Value {
+	result := make([]*message.Value, 0)
+	if speedThroughWater, ok := d.unsigned(1, 2, 0.01); ok {
+		result = append(result, nmea2000Value("navigation.speedThroughWater", speedThroughWater))
+	}
+	return result
+}
+
+func decodeWaterDepth(d nmea2000Data) []*message.Value {
+	result := make([]*message.Value, 0)
+	depth, ok := d.unsigned(1, 4, 0.01)
+	if !ok {
+		return result
+	}
+	result = append(result, nmea2000Value("environment.depth.belowTransducer", depth))
+	if offset, ok := d.signed(5, 2, 0.001); ok {
+		// a positive offset is the distance from the transducer to the water line, a negative offset is the distance to the keel
+		if offset > 0 {
+			result = append(result, nmea2000Value("environment.depth.belowSurface", depth+offset))
+		} else if offset < 0 {
+			result = append(result, nmea2000Value("environment.depth.belowKeel", depth+offset))
+		}
+	}
+	return result
+}
+
+func decodePositionRapidUpdate(d nmea2000Data) []*message.Value {
+	result := make([]*message.Value, 0)
+	latitude, latOk := d.signed(0, 4, 1e-7)
+	longitude, lonOk := d.signed(4, 4, 1e-7)
+	if latOk && lonOk {
+		result = append(result, nmea2000Value("navigation.position", message.Position{Latitude: &latitude, Longitude: &longitude}))
+	}
+	return result
+}
+
+func decodeCogSogRapidUpdate(d nmea2000Data) []*message.Value {
+	result := make([]*message.Value, 0)
+	if courseOverGround, ok := d.unsigned(2, 2, 0.0001); ok {
+		if reference, ok := d.bits(1, 0, 2); ok && reference == 1 {
+			result = append(result, nmea2000Value("navigation.courseOverGroundMagnetic", courseOverGround))
+		} else if ok && reference == 0 {
+			result = append(result, nmea2000Value("navigation.courseOverGroundTrue", courseOverGround))
+		}
+	}
+	if speedOverGround, ok := d.unsigned(4, 2, 0.01); ok {
+		result = append(result, nmea2000Value("navigation.speedOverGround", speedOverGround))
+	}
+	return result
+}
+
+var nmea2000GnssMethods = map[uint8]string{
+	0: "no GPS",
+	1: "GNSS Fix",
+	2: "DGNSS fix",
+	3: "Precise GNSS",
+	4: "RTK fixed integer",
+	5: "RTK float",
+	6: "Estimated (DR) mode",
+	7: "Manual input",
+	8: "Simulator mode",
+}
+
+func decodeGnssPositionData(d nmea2000Data) []*message.Value {
+	result := make([]*message.Value, 0)
+	latitude, latOk := d.signed(7, 8, 1e-16)
+	longitude, lonOk := d.signed(15, 8, 1e-16)
+	if latOk && lonOk {
+		position := message.Position{Latitude: &latitude, Longitude: &longitude}
+		if altitude, ok := d.signed(23, 8, 1e-6); ok {
+			position.Altitude = &altitude
+		}
+		result = append(result, nmea2000Value("navigation.position", position))
+	}
+	if method, ok := d.bits(31, 4, 4); ok {
+		if methodQuality, ok := nmea2000GnssMethods[method]; ok {
+			result = append(result, nmea2000Value("navigation.gnss.methodQuality", methodQuality))
+		}
+	}
+	if satellites, ok := d.unsigned(33, 1, 1); ok {
+		result = append(result, nmea2000Value("navigation.gnss.satellites", int64(satellites)))
+	}
+	if horizontalDilution, ok := d.signed(34, 2, 0.01); ok {
+		result = append(result, nmea2000Value("navigation.gnss.horizontalDilution", horizontalDilution))
+	}
+	if positionDilution, ok := d.signed(36, 2, 0.01); ok {
+		result = append(result, nmea2000Value("navigation.gnss.positionDilution", positionDilution))
+	}
+	if geoidalSeparation, ok := d.signed(38, 4, 0.01); ok {
+		result = append(result, nmea2000Value("navigation.gnss.geoidalSeparation", geoidalSeparation))
+	}
+	return result
+}
+
+func decodeWindData(d nmea2000Data) []*message.Value {
+	result := make([]*message.Value, 0)
+	speed, speedOk := d.unsigned(1, 2, 0.01)
+	angle, angleOk := d.unsigned(3, 2, 0.0001)
+	reference, ok := d.bits(5, 0, 3)
+	if !ok {
+		return result
+	}
+	// SignalK expects angles relative to the bow in the range -pi..pi
+	relativeAngle := angle
+	if relativeAngle > math.Pi {
+		relativeAngle -= 2 * math.Pi
+	}
+	switch reference {
+	case 0: // true, ground referenced to north
+		if angleOk {
+			result = append(result, nmea2000Value("environment.wind.directionTrue", angle))
+		}
+		if speedOk {
+			result = append(result, nmea2000Value("environment.wind.speedOverGround", speed))
+		}
+	case 1: // magnetic, ground referenced to magnetic north
+		if angleOk {
+			result = append(result, nmea2000Value("environment.wind.directionMagnetic", angle))
+		}
+		if speedOk {
+			result = append(result, nmea2000Value("environment.wind.speedOverGround", speed))
+		}
+	case 2: // apparent
+		if angleOk {
+			result = append(result, nmea2000Value("environment.wind.angleApparent", relativeAngle))
+		}
+		if speedOk {
+			result = append(result, nmea2000Value("environment.wind.speedApparent", speed))
+		}
+	case 3: // true, boat referenced
+		if angleOk {
+			result = append(result, nmea2000Value("environment.wind.angleTrueGround", relativeAngle))
+		}
+		if speedOk {
+			result = append(result, nmea2000Value("environment.wind.speedOverGround", speed))
+		}
+	case 4: // true, water referenced
+		if angleOk {
+			result = append(result, nmea2000Value("environment.wind.angleTrueWater", relativeAngle))
+		}
+		if speedOk {
+			result = append(result, nmea2000Value("environment.wind.speedTrue", speed))
+		}
+	}
+	return result
+}
+
+func decodeEnvironmentalParameters(d nmea2000Data) []*message.Value {
+	result := make([]*message.Value, 0)
+	if waterTemperature, ok := d.unsigned(1, 2, 0.01); ok {
+		result = append(result, nmea2000Value("environment.water.temperature", waterTemperature))
+	}
+	if outsideTemperature, ok := d.unsigned(3, 2, 0.01); ok {
+		result = append(result, nmea2000Value("environment.outside.temperature", outsideTemperature))
+	}
+	if pressure, ok := d.unsigned(5, 2, 100); ok {
+		result = append(result, nmea2000Value("environment.outside.pressure", pressure))
+	}
+	return result
+}
+
+func decodeEnvironmentalParametersWithSource(d nmea2000Data) []*message.Value {
+	result := make([]*message.Value, 0)
+	if source, ok := d.bits(1, 0, 6); ok {
+		if temperature, ok := d.unsigned(2, 2, 0.01); ok {
+			if path, ok := nmea2000TemperaturePath(source, 0); ok {
+				result = append(result, nmea2000Value(path, temperature))
+			}
+		}
+	}
+	if source, ok := d.bits(1, 6, 2); ok {
+		if humidity, ok := d.signed(4, 2, 0.004); ok {
+			if source == 0 {
+				result = append(result, nmea2000Value("environment.inside.relativeHumidity", humidity/100))
+			} else if source == 1 {
+				result = append(result, nmea2000Value("environment.outside.relativeHumidity", humidity/100))
+			}
+		}
+	}
+	if pressure, ok := d.unsigned(6, 2, 100); ok {
+		result = append(result, nmea2000Value("environment.outside.pressure", pressure))
+	}
+	return result
+}
+
+func decodeTemperature(d nmea2000Data) []*message.Value {
+	result := make([]*message.Value, 0)
+	instance, instanceOk := d.unsigned(1, 1, 1)
+	source, sourceOk := d.unsigned(2, 1, 1)
+	temperature, temperatureOk := d.unsigned(3, 2, 0.01)
+	if !instanceOk || !sourceOk || !temperatureOk {
+		return result
+	}
+	if path, ok := nmea2000TemperaturePath(uint8(source), uint8(instance)); ok {
+		result = append(result, nmea2000Value(path, temperature))
+	}
+	return result
+}
+
+func nmea2000TemperaturePath(source uint8, instance uint8) (string, bool) {
+	switch source {
+	case 0:
+		return "environment.water.temperature", true
+	case 1:
+		return "environment.outside.temperature", true
+	case 2:
+		return "environment.inside.temperature", true
+	case 3:
+		return "environment.inside.engineRoom.temperature", true
+	case 4:
+		return "environment.inside.mainCabin.temperature", true
+	case 5:
+		return fmt.Sprintf("tanks.liveWell.%d.temperature", instance), true
+	case 6:
+		return fmt.Sprintf("tanks.baitWell.%d.temperature", instance), true
+	case 7:
+		return "environment.inside.refrigerator.temperature", true
+	case 8:
+		return "environment.inside.heating.temperature", true
+	case 9:
+		return "environment.outside.dewPointTemperature", true
+	case 13:
+		return fmt.Sprintf("propulsion.%d.exhaustTemperature", instance), true
+	}
+	return "", false
+}
@@ -0,0 +1,154 @@
+package mapper_test
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+
+	"github.com/brutella/can"
+	"github.com/google/uuid"
+	"github.com/munnik/gosk/config"
+	. "github.com/munnik/gosk/mapper"
+	"github.com/munnik/gosk/message"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func nmea2000Frame(priority uint32, pgn uint32, source uint32, data []byte, timestamp time.Time) *message.Raw {
+	value := make([]byte, 16)
+	binary.BigEndian.PutUint32(value[0:4], can.MaskEff|priority<<26|pgn<<8|source)
+	value[4] = uint8(len(data))
+	copy(value[8:], data)
+	m := message.NewRaw().WithConnector("testingConnector").WithType(config.NMEA2000Type).WithValue(value)
+	m.Uuid = uuid.Nil
+	m.Timestamp = timestamp
+	return m
+}
+
+var _ = Describe("Nmea2000Header", func() {
+	DescribeTable("Identifiers",
+		func(id uint32, expected Nmea2000Header) {
+			Expect(NewNmea2000Header(id)).To(Equal(expected))
+		},
+		Entry("PDU2 broadcast", uint32(0x09F11223), Nmea2000Header{Priority: 2, PGN: 127250, Source: 0x23, Destination: 0xff}),
+		Entry("PDU1 addressed", uint32(0x18EA2301), Nmea2000Header{Priority: 6, PGN: 59904, Source: 0x01, Destination: 0x23}),
+		Entry("Extended frame flag is ignored", uint32(0x89F80117), Nmea2000Header{Priority: 2, PGN: 129025, Source: 0x17, Destination: 0xff}),
+	)
+})
+
+var _ = Describe("DoMap nmea2000", func() {
+	now := time.Now()
+	// gnssPositionPayload is the payload of PGN 129029 with a position of 52 N 4.5 E and no altitude
+	gnssPositionPayload := func() []byte {
+		payload := make([]byte, 43)
+		for i := range payload {
+			payload[i] = 0xff
+		}
+		binary.LittleEndian.PutUint64(payload[7:15], uint64(520000000000000000))
+		binary.LittleEndian.PutUint64(payload[15:23], uint64(45000000000000000))
+		binary.LittleEndian.PutUint64(payload[23:31], uint64(math.MaxInt64))
+		binary.LittleEndian.PutUint16(payload[34:36], uint16(math.MaxInt16))
+		binary.LittleEndian.PutUint16(payload[36:38], uint16(math.MaxInt16))
+		binary.LittleEndian.PutUint32(payload[38:42], uint32(math.MaxInt32))
+		return payload
+	}
+	// dataTransferFrames splits the payload in frames of the transport protocol, the last frame is padded with 0xff
+	dataTransferFrames := func(payload []byte) [][]byte {
+		frames := [][]byte{}
+		for sequence, offset := uint8(1), 0; offset < len(payload); sequence, offset = sequence+1, offset+7 {
+			frame := []byte{sequence, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+			end := offset + 7
+			if end > len(payload) {
+				end = len(payload)
+			}
+			copy(frame[1:], payload[offset:end])
+			frames = append(frames, frame)
+		}
+		return frames
+	}
+
+	It("Maps a single frame PGN", func() {
+		mapper, _ := NewNmea2000Mapper(config.MapperConfig{Context: "testingContext"}, prometheus.NewRegistry())
+		result, err := mapper.DoMap(nmea2000Frame(2, 127250, 0x23, []byte{0x00, 0x10, 0x27, 0xff, 0x7f, 0xff, 0x7f, 0xfc}, now))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Updates).To(HaveLen(1))
+		Expect(result.Updates[0].Source.Label).To(Equal("testingConnector.35"))
+		Expect(result.Updates[0].Values).To(HaveLen(1))
+		Expect(result.Updates[0].Values[0].Path).To(Equal("navigation.headingTrue"))
+		Expect(result.Updates[0].Values[0].Value).To(BeNumerically("~", 1.0, 0.00001))
+	})
+
+	It("Skips a PGN without decoder", func() {
+		mapper, _ := NewNmea2000Mapper(config.MapperConfig{Context: "testingContext"}, prometheus.NewRegistry())
+		result, err := mapper.DoMap(nmea2000Frame(6, 65280, 0x23, []byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07}, now))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Updates).To(BeEmpty())
+	})
+
+	It("Reassembles a fast-packet PGN", func() {
+		mapper, _ := NewNmea2000Mapper(config.MapperConfig{Context: "testingContext"}, prometheus.NewRegistry())
+		payload := gnssPositionPayload()
+
+		frames := [][]byte{append([]byte{0x40, uint8(len(payload))}, payload[:6]...)}
+		for counter, offset := uint8(1), 6; offset < len(payload); counter, offset = counter+1, offset+7 {
+			end := offset + 7
+			if end > len(payload) {
+				end = len(payload)
+			}
+			frames = append(frames, append([]byte{0x40 | counter}, payload[offset:end]...))
+		}
+
+		for i, frame := range frames {
+			result, err := mapper.DoMap(nmea2000Frame(3, 129029, 0x17, frame, now.Add(time.Duration(i)*time.Millisecond)))
+			Expect(err).ToNot(HaveOccurred())
+			if i < len(frames)-1 {
+				Expect(result.Updates).To(BeEmpty())
+				continue
+			}
+			Expect(result.Updates).To(HaveLen(1))
+			Expect(result.Updates[0].Values).To(HaveLen(1))
+			Expect(result.Updates[0].Values[0].Path).To(Equal("navigation.position"))
+			position := result.Updates[0].Values[0].Value.(message.Position)
+			Expect(*position.Latitude).To(BeNumerically("~", 52.0, 0.0000001))
+			Expect(*position.Longitude).To(BeNumerically("~", 4.5, 0.0000001))
+			Expect(position.Altitude).To(BeNil())
+		}
+	})
+
+	It("Reassembles a transport protocol PGN after a broadcast announce", func() {
+		mapper, _ := NewNmea2000Mapper(config.MapperConfig{Context: "testingContext"}, prometheus.NewRegistry())
+		payload := gnssPositionPayload()
+		frames := dataTransferFrames(payload)
+		// connection management to the global address, broadcast announce with the size, the number of packets and the PGN
+		announce := []byte{0x20, uint8(len(payload)), 0x00, uint8(len(frames)), 0xff, 0x05, 0xf8, 0x01}
+		result, err := mapper.DoMap(nmea2000Frame(7, 60416|0xff, 0x17, announce, now))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Updates).To(BeEmpty())
+
+		for i, frame := range frames {
+			result, err := mapper.DoMap(nmea2000Frame(7, 60160|0xff, 0x17, frame, now.Add(time.Duration(i+1)*50*time.Millisecond)))
+			Expect(err).ToNot(HaveOccurred())
+			if i < len(frames)-1 {
+				Expect(result.Updates).To(BeEmpty())
+				continue
+			}
+			Expect(result.Updates).To(HaveLen(1))
+			Expect(result.Updates[0].Source.Label).To(Equal("testingConnector.23"))
+			Expect(result.Updates[0].Values).To(HaveLen(1))
+			Expect(result.Updates[0].Values[0].Path).To(Equal("navigation.position"))
+			position := result.Updates[0].Values[0].Value.(message.Position)
+			Expect(*position.Latitude).To(BeNumerically("~", 52.0, 0.0000001))
+			Expect(*position.Longitude).To(BeNumerically("~", 4.5, 0.0000001))
+		}
+	})
+
+	It("Skips transport protocol data without a broadcast announce", func() {
+		mapper, _ := NewNmea2000Mapper(config.MapperConfig{Context: "testingContext"}, prometheus.NewRegistry())
+		for i, frame := range dataTransferFrames(gnssPositionPayload()) {
+			result, err := mapper.DoMap(nmea2000Frame(7, 60160|0xff, 0x17, frame, now.Add(time.Duration(i)*50*time.Millisecond)))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.Updates).To(BeEmpty())
+		}
+	})
+})
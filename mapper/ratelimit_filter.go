@@ -23,7 +23,7 @@ func NewRateLimitFilter(c *config.RateLimitFilterConfig) (*RateLimitFilter, erro
 }
 
 func (f *RateLimitFilter) Map(subscriber mangos.Socket, publisher mangos.Socket) {
-	processMapped(subscriber, publisher, f)
+	processMapped(subscriber, publisher, f, nil)
 }
 
 func (m *RateLimitFilter) DoMap(delta *message.Mapped) (*message.Mapped, error) {
@@ -59,6 +59,7 @@ func (m *RateLimitFilter) DoMap(delta *message.Mapped) (*message.Mapped, error)
 			continue
 		}
 	}
+	copyMeta(result, delta)
 
 	return result, nil
 }
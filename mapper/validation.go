@@ -0,0 +1,308 @@
+package mapper
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/munnik/gosk/config"
+	"github.com/munnik/gosk/logger"
+	"github.com/munnik/gosk/message"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// built-in subset of keyswithmetadata.json of the SignalK specification
+//
+//go:embed signalk_metadata.json
+var defaultMetadata []byte
+
+// conversion converts a value from a unit to the SignalK unit
+type conversion struct {
+	unit    string // SignalK unit
+	convert func(float64) float64
+}
+
+var conversions = map[string]conversion{
+	"kn":      {"m/s", func(v float64) float64 { return v * 1852 / 3600 }},
+	"km/h":    {"m/s", func(v float64) float64 { return v / 3.6 }},
+	"deg":     {"rad", func(v float64) float64 { return v * math.Pi / 180 }},
+	"deg/s":   {"rad/s", func(v float64) float64 { return v * math.Pi / 180 }},
+	"deg/min": {"rad/s", func(v float64) float64 { return v * math.Pi / 180 / 60 }},
+	"C":       {"K", func(v float64) float64 { return v + 273.15 }},
+	"F":       {"K", func(v float64) float64 { return (v-32)*5/9 + 273.15 }},
+	"%":       {"ratio", func(v float64) float64 { return v / 100 }},
+	"rpm":     {"Hz", func(v float64) float64 { return v / 60 }},
+	"bar":     {"Pa", func(v float64) float64 { return v * 100000 }},
+	"kPa":     {"Pa", func(v float64) float64 { return v * 1000 }},
+	"psi":     {"Pa", func(v float64) float64 { return v * 6894.757 }},
+	"ft":      {"m", func(v float64) float64 { return v * 0.3048 }},
+	"fathom":  {"m", func(v float64) float64 { return v * 1.8288 }},
+	"nm":      {"m", func(v float64) float64 { return v * 1852 }},
+	"l":       {"m3", func(v float64) float64 { return v / 1000 }},
+	"l/h":     {"m3/s", func(v float64) float64 { return v / 1000 / 3600 }},
+	"h":       {"s", func(v float64) float64 { return v * 3600 }},
+}
+
+// valueRange is the range of plausible values of a SignalK unit, a value outside the range is converted from the likely
+// unit when the converted value is inside the range
+type valueRange struct {
+	min    float64
+	max    float64
+	likely string // unit of the values outside the range, empty when the unit can't be guessed
+}
+
+// unitRanges are the default ranges of the SignalK units, values in another unit are only detected when they are
+// outside the range, e.g. a heading of 5 degrees or a speed in knots below 100 pass as rad and m/s. Configure the unit
+// or the range of a path to detect these values.
+var unitRanges = map[string]valueRange{
+	"rad":   {-2 * math.Pi, 2 * math.Pi, "deg"},
+	"rad/s": {-math.Pi, math.Pi, "deg/s"},
+	"ratio": {0, 1, "%"},
+	"K":     {150, 2000, "C"}, // temperatures in Celsius below -123 °C aren't detected
+	"m/s":   {-100, 100, ""},
+	"Hz":    {0, math.Inf(1), ""},
+	"m3":    {0, math.Inf(1), ""},
+}
+
+type pathMetadata struct {
+	Description string   `json:"description"`
+	Units       string   `json:"units"`
+	Enum        []string `json:"enum"`
+}
+
+type metadataPattern struct {
+	segments []string // a segment * matches any single segment of a path
+	metadata pathMetadata
+}
+
+// Validator checks the mapped values against the allowed values and the range of the unit of the SignalK metadata
+type Validator struct {
+	config     *config.ValidationConfig
+	patterns   []metadataPattern
+	units      map[string]string     // map of path to the unit of the mapped value
+	ranges     map[string]valueRange // map of path to the configured range
+	violations *prometheus.CounterVec
+}
+
+// NewValidator returns nil when no validation is configured, the metrics are registered with reg
+func NewValidator(c *config.ValidationConfig, reg prometheus.Registerer) (*Validator, error) {
+	if c == nil {
+		return nil, nil
+	}
+	switch c.Policy {
+	case config.ValidationPolicyReject, config.ValidationPolicyFlag, config.ValidationPolicyConvert:
+	case "":
+		c.Policy = config.ValidationPolicyFlag
+	default:
+		return nil, fmt.Errorf("the validation policy %s is not supported", c.Policy)
+	}
+
+	bytes := defaultMetadata
+	if c.MetadataFile != "" {
+		var err error
+		if bytes, err = os.ReadFile(c.MetadataFile); err != nil {
+			return nil, err
+		}
+	}
+	var metadata map[string]pathMetadata
+	if err := json.Unmarshal(bytes, &metadata); err != nil {
+		return nil, err
+	}
+
+	result := &Validator{config: c, patterns: make([]metadataPattern, 0, len(metadata)), units: make(map[string]string), ranges: make(map[string]valueRange)}
+	for key, m := range metadata {
+		// keys look like /vessels/*/propulsion/RegExp/temperature
+		if !strings.HasPrefix(key, "/vessels/*/") {
+			continue
+		}
+		segments := strings.Split(strings.TrimPrefix(key, "/vessels/*/"), "/")
+		for i := range segments {
+			if segments[i] == "RegExp" {
+				segments[i] = "*"
+			}
+		}
+		result.patterns = append(result.patterns, metadataPattern{segments: segments, metadata: m})
+	}
+	// prefer the most specific pattern when multiple patterns match a path
+	sort.SliceStable(result.patterns, func(i, j int) bool {
+		return wildcards(result.patterns[i].segments) < wildcards(result.patterns[j].segments)
+	})
+	for _, u := range c.Units {
+		conversion, ok := conversions[u.Unit]
+		if !ok {
+			return nil, fmt.Errorf("unit %s of path %s can't be converted", u.Unit, u.Path)
+		}
+		if m, ok := result.lookup(u.Path); ok && m.Units != conversion.unit {
+			return nil, fmt.Errorf("unit %s of path %s can't be converted to %s", u.Unit, u.Path, m.Units)
+		}
+		result.units[u.Path] = u.Unit
+	}
+	for _, r := range c.Ranges {
+		if r.Min > r.Max {
+			return nil, fmt.Errorf("the minimum %g of path %s is larger than the maximum %g", r.Min, r.Path, r.Max)
+		}
+		m, ok := result.lookup(r.Path)
+		if !ok || m.Units == "" {
+			return nil, fmt.Errorf("the range of path %s can't be checked because the path has no unit in the metadata", r.Path)
+		}
+		result.ranges[r.Path] = valueRange{min: r.Min, max: r.Max, likely: unitRanges[m.Units].likely}
+	}
+	result.violations = promauto.With(reg).NewCounterVec(
+		prometheus.CounterOpts{Name: "gosk_validation_violations_total", Help: "total number of mapped values that don't match the SignalK metadata"},
+		[]string{"path", "source"},
+	)
+	return result, nil
+}
+
+// Validate removes, flags or converts the values that don't match the metadata depending on the policy, the
+// updates are changed in place
+func (v *Validator) Validate(mapped *message.Mapped) *message.Mapped {
+	if v == nil {
+		return mapped
+	}
+	updates := make([]message.Update, 0, len(mapped.Updates))
+	for _, u := range mapped.Updates {
+		values := make([]message.Value, 0, len(u.Values))
+		for _, value := range u.Values {
+			value = v.normalise(value)
+			converted, err := v.validate(value)
+			if err == nil {
+				values = append(values, value)
+				continue
+			}
+			v.violations.WithLabelValues(value.Path, u.Source.Label).Inc()
+			logger.GetLogger().Warn(
+				"The mapped value doesn't match the SignalK metadata",
+				zap.String("Path", value.Path),
+				zap.String("Source", u.Source.Label),
+				zap.String("Value", fmt.Sprintf("%v", value.Value)),
+				zap.String("Policy", v.config.Policy),
+				zap.String("Error", err.Error()),
+			)
+			switch v.config.Policy {
+			case config.ValidationPolicyFlag:
+				values = append(values, value)
+			case config.ValidationPolicyConvert:
+				if converted != nil {
+					values = append(values, *converted)
+				}
+			}
+		}
+		if len(values) > 0 || len(u.Meta) > 0 {
+			u.Values = values
+			updates = append(updates, u)
+		}
+	}
+	mapped.Updates = updates
+	return mapped
+}
+
+// normalise converts the value when the unit of the path is configured
+func (v *Validator) normalise(value message.Value) message.Value {
+	unit, ok := v.units[value.Path]
+	if !ok {
+		return value
+	}
+	floats, err := ListToFloats([]interface{}{value.Value})
+	if err != nil {
+		return value
+	}
+	return *message.NewValue().WithPath(value.Path).WithValue(conversions[unit].convert(floats[0]))
+}
+
+// validate returns an error when the value doesn't match the metadata, the returned value is the converted value or
+// nil when the value can't be converted
+func (v *Validator) validate(value message.Value) (*message.Value, error) {
+	m, ok := v.lookup(value.Path)
+	if !ok {
+		return nil, nil
+	}
+
+	if len(m.Enum) > 0 {
+		s, ok := value.Value.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected one of %v but got %v", m.Enum, value.Value)
+		}
+		for _, e := range m.Enum {
+			if e == s {
+				return nil, nil
+			}
+		}
+		return nil, fmt.Errorf("expected one of %v but got %s", m.Enum, s)
+	}
+	if m.Units == "" {
+		return nil, nil
+	}
+
+	if s, ok := value.Value.(string); ok {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("expected a number in %s but got %s", m.Units, s)
+		}
+		converted := message.NewValue().WithPath(value.Path).WithValue(f)
+		if result, err := v.validate(*converted); err != nil {
+			return result, err
+		}
+		return converted, fmt.Errorf("expected a number in %s but got a string", m.Units)
+	}
+	floats, err := ListToFloats([]interface{}{value.Value})
+	if err != nil {
+		return nil, fmt.Errorf("expected a number in %s but got %v", m.Units, value.Value)
+	}
+	number := floats[0]
+
+	r, ok := v.ranges[value.Path]
+	if !ok {
+		if r, ok = unitRanges[m.Units]; !ok {
+			return nil, nil
+		}
+	}
+	if number >= r.min && number <= r.max {
+		return nil, nil
+	}
+	err = fmt.Errorf("%g is out of range [%g, %g] for %s", number, r.min, r.max, m.Units)
+	if r.likely == "" {
+		return nil, err
+	}
+	if converted := conversions[r.likely].convert(number); converted >= r.min && converted <= r.max {
+		return message.NewValue().WithPath(value.Path).WithValue(converted), err
+	}
+	return nil, err
+}
+
+func (v *Validator) lookup(path string) (pathMetadata, bool) {
+	segments := strings.Split(path, ".")
+	for _, p := range v.patterns {
+		if len(p.segments) != len(segments) {
+			continue
+		}
+		match := true
+		for i := range segments {
+			if p.segments[i] != "*" && p.segments[i] != segments[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return p.metadata, true
+		}
+	}
+	return pathMetadata{}, false
+}
+
+func wildcards(segments []string) int {
+	result := 0
+	for _, s := range segments {
+		if s == "*" {
+			result++
+		}
+	}
+	return result
+}
@@ -0,0 +1,158 @@
+package mapper_test
+
+import (
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/munnik/gosk/config"
+	. "github.com/munnik/gosk/mapper"
+	"github.com/munnik/gosk/message"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ = Describe("Validator", func() {
+	now := time.Now()
+	delta := func(values ...*message.Value) *message.Mapped {
+		u := message.NewUpdate().WithSource(
+			*message.NewSource().WithLabel("testingConnector").WithType(config.NMEA0183Type).WithUuid(uuid.Nil),
+		).WithTimestamp(now)
+		for _, v := range values {
+			u.AddValue(v)
+		}
+		return message.NewMapped().WithContext("testingContext").WithOrigin("testingContext").AddUpdate(u)
+	}
+	input := func() *message.Mapped {
+		return delta(
+			message.NewValue().WithPath("navigation.speedOverGround").WithValue(3.2),
+			message.NewValue().WithPath("navigation.headingTrue").WithValue(270.0),
+			message.NewValue().WithPath("propulsion.main.state").WithValue("running"),
+			message.NewValue().WithPath("tanks.fuel.portAft.currentLevel").WithValue("0.5"),
+			message.NewValue().WithPath("some.unknown.path").WithValue("anything"),
+		)
+	}
+
+	It("Returns the delta unchanged without a configuration", func() {
+		v, err := NewValidator(nil, prometheus.NewRegistry())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(v.Validate(input())).To(Equal(input()))
+	})
+	It("Fails for an unknown policy or unit", func() {
+		_, err := NewValidator(&config.ValidationConfig{Policy: "ignore"}, prometheus.NewRegistry())
+		Expect(err).To(HaveOccurred())
+		_, err = NewValidator(&config.ValidationConfig{Units: []config.UnitConfig{{Path: "navigation.speedOverGround", Unit: "furlong/fortnight"}}}, prometheus.NewRegistry())
+		Expect(err).To(HaveOccurred())
+		_, err = NewValidator(&config.ValidationConfig{Units: []config.UnitConfig{{Path: "navigation.speedOverGround", Unit: "deg"}}}, prometheus.NewRegistry())
+		Expect(err).To(HaveOccurred())
+	})
+	It("Removes invalid values with the reject policy", func() {
+		v, err := NewValidator(&config.ValidationConfig{Policy: config.ValidationPolicyReject}, prometheus.NewRegistry())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(valuesByPath(v.Validate(input()))).To(Equal(map[string]interface{}{
+			"navigation.speedOverGround": 3.2,
+			"some.unknown.path":          "anything",
+		}))
+	})
+	It("Keeps invalid values with the flag policy", func() {
+		v, err := NewValidator(&config.ValidationConfig{Policy: config.ValidationPolicyFlag}, prometheus.NewRegistry())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(v.Validate(input())).To(Equal(input()))
+	})
+	It("Converts invalid values with the convert policy", func() {
+		v, err := NewValidator(&config.ValidationConfig{Policy: config.ValidationPolicyConvert}, prometheus.NewRegistry())
+		Expect(err).NotTo(HaveOccurred())
+		result := valuesByPath(v.Validate(input()))
+		Expect(result).To(HaveLen(4))
+		Expect(result["navigation.headingTrue"]).To(BeNumerically("~", 1.5*math.Pi, 0.0001))
+		Expect(result["tanks.fuel.portAft.currentLevel"]).To(Equal(0.5))
+		Expect(result).NotTo(HaveKey("propulsion.main.state"))
+	})
+	It("Removes the update when all values are removed", func() {
+		v, err := NewValidator(&config.ValidationConfig{Policy: config.ValidationPolicyReject}, prometheus.NewRegistry())
+		Expect(err).NotTo(HaveOccurred())
+		result := v.Validate(delta(message.NewValue().WithPath("environment.water.temperature").WithValue(-3.0)))
+		Expect(result.Updates).To(BeEmpty())
+	})
+	It("Converts values of paths with a configured unit", func() {
+		v, err := NewValidator(&config.ValidationConfig{
+			Policy: config.ValidationPolicyReject,
+			Units: []config.UnitConfig{
+				{Path: "navigation.speedOverGround", Unit: "kn"},
+				{Path: "propulsion.main.revolutions", Unit: "rpm"},
+			},
+		}, prometheus.NewRegistry())
+		Expect(err).NotTo(HaveOccurred())
+		result := valuesByPath(v.Validate(delta(
+			message.NewValue().WithPath("navigation.speedOverGround").WithValue(10.0),
+			message.NewValue().WithPath("propulsion.main.revolutions").WithValue(int64(1200)),
+		)))
+		Expect(result["navigation.speedOverGround"]).To(BeNumerically("~", 5.1444, 0.0001))
+		Expect(result["propulsion.main.revolutions"]).To(Equal(20.0))
+	})
+	DescribeTable("Checks the range of the SignalK unit",
+		func(path string, input float64, expected interface{}) {
+			v, err := NewValidator(&config.ValidationConfig{Policy: config.ValidationPolicyConvert}, prometheus.NewRegistry())
+			Expect(err).NotTo(HaveOccurred())
+			result := valuesByPath(v.Validate(delta(message.NewValue().WithPath(path).WithValue(input))))
+			if expected == nil {
+				Expect(result).NotTo(HaveKey(path))
+				return
+			}
+			Expect(result[path]).To(BeNumerically("~", expected, 0.0001))
+		},
+		Entry("radians", "navigation.headingTrue", 1.5, 1.5),
+		Entry("degrees", "navigation.headingTrue", 270.0, 1.5*math.Pi),
+		Entry("degrees out of range", "navigation.headingTrue", 400.0, nil),
+		Entry("radians per second", "navigation.rateOfTurn", 0.1, 0.1),
+		Entry("degrees per second", "navigation.rateOfTurn", 30.0, 30*math.Pi/180),
+		Entry("Kelvin", "environment.water.temperature", 288.15, 288.15),
+		Entry("Celsius", "environment.water.temperature", 15.0, 288.15),
+		Entry("Celsius below the range", "environment.water.temperature", -200.0, nil),
+		Entry("ratio", "tanks.fuel.portAft.currentLevel", 0.25, 0.25),
+		Entry("percentage", "tanks.fuel.portAft.currentLevel", 25.0, 0.25),
+		Entry("negative ratio", "tanks.fuel.portAft.currentLevel", -0.1, nil),
+		Entry("speed", "navigation.speedOverGround", 5.0, 5.0),
+		Entry("speed out of range", "navigation.speedOverGround", 120.0, nil),
+	)
+	It("Checks the configured range of a path", func() {
+		v, err := NewValidator(&config.ValidationConfig{
+			Policy: config.ValidationPolicyReject,
+			Ranges: []config.RangeConfig{{Path: "navigation.speedOverGround", Min: 0, Max: 15}},
+		}, prometheus.NewRegistry())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(valuesByPath(v.Validate(delta(message.NewValue().WithPath("navigation.speedOverGround").WithValue(10.0))))).To(HaveKey("navigation.speedOverGround"))
+		Expect(v.Validate(delta(message.NewValue().WithPath("navigation.speedOverGround").WithValue(20.0))).Updates).To(BeEmpty())
+	})
+	It("Fails for an invalid range", func() {
+		_, err := NewValidator(&config.ValidationConfig{Ranges: []config.RangeConfig{{Path: "navigation.speedOverGround", Min: 15, Max: 0}}}, prometheus.NewRegistry())
+		Expect(err).To(HaveOccurred())
+		_, err = NewValidator(&config.ValidationConfig{Ranges: []config.RangeConfig{{Path: "some.unknown.path", Min: 0, Max: 15}}}, prometheus.NewRegistry())
+		Expect(err).To(HaveOccurred())
+	})
+	It("Counts the violations per path and source with the registerer", func() {
+		reg := prometheus.NewRegistry()
+		v, err := NewValidator(&config.ValidationConfig{Policy: config.ValidationPolicyFlag}, reg)
+		Expect(err).NotTo(HaveOccurred())
+		v.Validate(input())
+
+		families, err := reg.Gather()
+		Expect(err).NotTo(HaveOccurred())
+		counts := make(map[string]float64)
+		for _, family := range families {
+			for _, metric := range family.GetMetric() {
+				labels := make(map[string]string)
+				for _, label := range metric.GetLabel() {
+					labels[label.GetName()] = label.GetValue()
+				}
+				counts[labels["path"]+" "+labels["source"]] += metric.GetCounter().GetValue()
+			}
+		}
+		Expect(counts).To(Equal(map[string]float64{
+			"navigation.headingTrue testingConnector":          1,
+			"propulsion.main.state testingConnector":           1,
+			"tanks.fuel.portAft.currentLevel testingConnector": 1,
+		}))
+	})
+})
@@ -0,0 +1,213 @@
+package mapper
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/antonmedv/expr/ast"
+	"github.com/munnik/gosk/config"
+)
+
+type windowSample struct {
+	timestamp time.Time
+	value     float64
+}
+
+// windowState is the state of a single call of a time-window function in an expression
+type windowState struct {
+	samples []windowSample // samples within the window, ordered from old to new
+	last    *windowSample
+	result  float64
+}
+
+// add stores the sample and returns false when the sample is older than the previous sample
+func (s *windowState) add(value float64, timestamp time.Time, window time.Duration) bool {
+	if s.last != nil && timestamp.Before(s.last.timestamp) {
+		return false
+	}
+	s.samples = append(s.samples, windowSample{timestamp: timestamp, value: value})
+	i := 0
+	for i < len(s.samples)-1 && !s.samples[i].timestamp.After(timestamp.Add(-window)) {
+		i++
+	}
+	s.samples = s.samples[i:]
+	return true
+}
+
+// windowFunctionNames are the names of the time-window functions in the expressions
+var windowFunctionNames = map[string]struct{}{
+	"movingAverage": {},
+	"movingMin":     {},
+	"movingMax":     {},
+	"ema":           {},
+	"derivative":    {},
+	"integral":      {},
+}
+
+// WindowFunctions keeps the state of the time-window functions that can be used in expressions, like movingAverage
+// and derivative. Every mapper has its own state which is kept per context and mapping, so the same function can be
+// used in multiple mappings and for multiple vessels.
+type WindowFunctions struct {
+	mu     sync.Mutex
+	states map[string]*windowState
+}
+
+func NewWindowFunctions() *WindowFunctions {
+	return &WindowFunctions{states: make(map[string]*windowState)}
+}
+
+// Environment returns the functions for a single evaluation of the expression of the mapping, the timestamp is the
+// time of the value. When a function is used multiple times in an expression each call has its own state, the calls
+// are identified by the call site that is added as the first argument when the expression is compiled, see
+// windowCallSites. In the expression the functions are called without the call site.
+//
+//	movingAverage(value, window) average of the values in the window, the window is in seconds
+//	movingMin(value, window)     minimum of the values in the window
+//	movingMax(value, window)     maximum of the values in the window
+//	ema(value, timeConstant)     exponential moving average, the time constant is in seconds
+//	derivative(value)            change of the value per second
+//	integral(value)              integral of the value over time in seconds
+func (w *WindowFunctions) Environment(key string, timestamp time.Time) ExpressionEnvironment {
+	state := func(site int) *windowState {
+		k := fmt.Sprintf("%s|%d", key, site)
+		s, ok := w.states[k]
+		if !ok {
+			s = &windowState{}
+			w.states[k] = s
+		}
+		return s
+	}
+	windowed := func(name string, aggregate func([]windowSample) float64) func(int, interface{}, interface{}) (float64, error) {
+		return func(site int, value interface{}, window interface{}) (float64, error) {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+
+			s := state(site)
+			floats, err := ListToFloats([]interface{}{value, window})
+			if err != nil {
+				return 0, err
+			}
+			if floats[1] <= 0 {
+				return 0, fmt.Errorf("the window of %s should be positive", name)
+			}
+			if s.add(floats[0], timestamp, time.Duration(floats[1]*float64(time.Second))) {
+				s.result = aggregate(s.samples)
+				last := s.samples[len(s.samples)-1]
+				s.last = &last
+			}
+			return s.result, nil
+		}
+	}
+
+	return ExpressionEnvironment{
+		"movingAverage": windowed("movingAverage", func(samples []windowSample) float64 {
+			sum := 0.0
+			for _, sample := range samples {
+				sum += sample.value
+			}
+			return sum / float64(len(samples))
+		}),
+		"movingMin": windowed("movingMin", func(samples []windowSample) float64 {
+			result := math.Inf(1)
+			for _, sample := range samples {
+				result = math.Min(result, sample.value)
+			}
+			return result
+		}),
+		"movingMax": windowed("movingMax", func(samples []windowSample) float64 {
+			result := math.Inf(-1)
+			for _, sample := range samples {
+				result = math.Max(result, sample.value)
+			}
+			return result
+		}),
+		"ema": func(site int, value interface{}, timeConstant interface{}) (float64, error) {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+
+			s := state(site)
+			floats, err := ListToFloats([]interface{}{value, timeConstant})
+			if err != nil {
+				return 0, err
+			}
+			if floats[1] <= 0 {
+				return 0, fmt.Errorf("the time constant of ema should be positive")
+			}
+			if s.last == nil {
+				s.result = floats[0]
+			} else if elapsed := timestamp.Sub(s.last.timestamp).Seconds(); elapsed > 0 {
+				s.result += (1 - math.Exp(-elapsed/floats[1])) * (floats[0] - s.result)
+			} else {
+				return s.result, nil
+			}
+			s.last = &windowSample{timestamp: timestamp, value: floats[0]}
+			return s.result, nil
+		},
+		"derivative": func(site int, value interface{}) (float64, error) {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+
+			s := state(site)
+			floats, err := ListToFloats([]interface{}{value})
+			if err != nil {
+				return 0, err
+			}
+			if s.last != nil {
+				elapsed := timestamp.Sub(s.last.timestamp).Seconds()
+				if elapsed <= 0 {
+					return s.result, nil
+				}
+				s.result = (floats[0] - s.last.value) / elapsed
+			}
+			s.last = &windowSample{timestamp: timestamp, value: floats[0]}
+			return s.result, nil
+		},
+		"integral": func(site int, value interface{}) (float64, error) {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+
+			s := state(site)
+			floats, err := ListToFloats([]interface{}{value})
+			if err != nil {
+				return 0, err
+			}
+			if s.last != nil {
+				elapsed := timestamp.Sub(s.last.timestamp).Seconds()
+				if elapsed <= 0 {
+					return s.result, nil
+				}
+				// trapezoidal rule
+				s.result += (floats[0] + s.last.value) / 2 * elapsed
+			}
+			s.last = &windowSample{timestamp: timestamp, value: floats[0]}
+			return s.result, nil
+		},
+	}
+}
+
+// mappingKey identifies the state of the time-window functions of the mapping for the context
+func mappingKey(context string, mappingConfig config.MappingConfig) string {
+	return context + "|" + mappingConfig.Path + "|" + mappingConfig.Expression
+}
+
+// windowCallSites adds the call site as the first argument of every call of a time-window function, the call sites
+// are numbered in the order of the expression so a call that is skipped, e.g. in a conditional, doesn't change the
+// state of the other calls
+type windowCallSites struct {
+	sites int
+}
+
+func (v *windowCallSites) Visit(node *ast.Node) {
+	call, ok := (*node).(*ast.CallNode)
+	if !ok {
+		return
+	}
+	if callee, ok := call.Callee.(*ast.IdentifierNode); ok {
+		if _, ok := windowFunctionNames[callee.Value]; ok {
+			call.Arguments = append([]ast.Node{&ast.IntegerNode{Value: v.sites}}, call.Arguments...)
+			v.sites++
+		}
+	}
+}
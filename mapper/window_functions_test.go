@@ -0,0 +1,135 @@
+package mapper_test
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/munnik/gosk/config"
+	. "github.com/munnik/gosk/mapper"
+	"github.com/munnik/gosk/message"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ = Describe("WindowFunctions", func() {
+	start := time.Date(2022, 2, 9, 12, 0, 0, 0, time.UTC)
+	var w *WindowFunctions
+	BeforeEach(func() {
+		w = NewWindowFunctions()
+	})
+	call := func(key string, seconds int, name string, args ...interface{}) float64 {
+		env := w.Environment(key, start.Add(time.Duration(seconds)*time.Second))
+		var result float64
+		var err error
+		switch f := env[name].(type) {
+		case func(int, interface{}, interface{}) (float64, error):
+			result, err = f(0, args[0], args[1])
+		case func(int, interface{}) (float64, error):
+			result, err = f(0, args[0])
+		}
+		Expect(err).NotTo(HaveOccurred())
+		return result
+	}
+
+	It("Calculates the moving average over the window", func() {
+		Expect(call("a", 0, "movingAverage", 1.0, 10)).To(Equal(1.0))
+		Expect(call("a", 5, "movingAverage", 3.0, 10)).To(Equal(2.0))
+		Expect(call("a", 10, "movingAverage", 5.0, 10)).To(Equal(4.0))
+		Expect(call("a", 30, "movingAverage", 7.0, 10)).To(Equal(7.0))
+	})
+	It("Calculates the minimum and maximum over the window", func() {
+		Expect(call("a", 0, "movingMin", 4, 10)).To(Equal(4.0))
+		Expect(call("a", 5, "movingMin", 2, 10)).To(Equal(2.0))
+		Expect(call("a", 20, "movingMin", 6, 10)).To(Equal(6.0))
+		Expect(call("b", 0, "movingMax", 4, 10)).To(Equal(4.0))
+		Expect(call("b", 5, "movingMax", 2, 10)).To(Equal(4.0))
+		Expect(call("b", 20, "movingMax", 1, 10)).To(Equal(1.0))
+	})
+	It("Calculates the exponential moving average", func() {
+		Expect(call("a", 0, "ema", 0.0, 10)).To(Equal(0.0))
+		Expect(call("a", 10, "ema", 1.0, 10)).To(BeNumerically("~", 0.632, 0.001))
+		Expect(call("a", 10, "ema", 5.0, 10)).To(BeNumerically("~", 0.632, 0.001))
+	})
+	It("Calculates the derivative and the integral", func() {
+		Expect(call("a", 0, "derivative", 10.0)).To(Equal(0.0))
+		Expect(call("a", 10, "derivative", 15.0)).To(Equal(0.5))
+		Expect(call("a", 20, "derivative", 10.0)).To(Equal(-0.5))
+		Expect(call("b", 0, "integral", 2.0)).To(Equal(0.0))
+		Expect(call("b", 10, "integral", 4.0)).To(Equal(30.0))
+		Expect(call("b", 20, "integral", 4.0)).To(Equal(70.0))
+	})
+	It("Keeps the state per key and per call site", func() {
+		call("a", 0, "movingAverage", 1.0, 10)
+		Expect(call("b", 5, "movingAverage", 3.0, 10)).To(Equal(3.0))
+
+		env := w.Environment("c", start)
+		f := env["movingAverage"].(func(int, interface{}, interface{}) (float64, error))
+		f(0, 1.0, 10)
+		f(1, 100.0, 10)
+		env = w.Environment("c", start.Add(time.Second))
+		f = env["movingAverage"].(func(int, interface{}, interface{}) (float64, error))
+		Expect(f(1, 200.0, 10)).To(Equal(150.0))
+		Expect(f(0, 3.0, 10)).To(Equal(2.0))
+	})
+	It("Ignores values older than the previous value", func() {
+		Expect(call("a", 10, "movingAverage", 1.0, 10)).To(Equal(1.0))
+		Expect(call("a", 5, "movingAverage", 3.0, 10)).To(Equal(1.0))
+	})
+})
+
+var _ = Describe("Window functions in mappings", func() {
+	start := time.Date(2022, 2, 9, 12, 0, 0, 0, time.UTC)
+	newMapper := func(expression string) *AggregateMapper {
+		m, err := NewAggregateMapper(
+			config.MapperConfig{},
+			[]config.ExpressionMappingConfig{
+				{MappingConfig: config.MappingConfig{Path: "result", Expression: expression}, SourcePaths: []string{"input"}},
+			},
+			prometheus.NewRegistry(),
+		)
+		Expect(err).NotTo(HaveOccurred())
+		return m
+	}
+	doMap := func(m *AggregateMapper, context string, seconds int, value float64) interface{} {
+		input := message.NewMapped().WithContext(context).WithOrigin(context).AddUpdate(
+			message.NewUpdate().WithSource(
+				*message.NewSource().WithLabel("testingConnector").WithType(config.SignalKType).WithUuid(uuid.Nil),
+			).WithTimestamp(start.Add(time.Duration(seconds) * time.Second)).AddValue(
+				message.NewValue().WithPath("input").WithValue(value),
+			),
+		)
+		result, err := m.DoMap(input)
+		Expect(err).NotTo(HaveOccurred())
+		for _, u := range result.Updates {
+			for _, v := range u.Values {
+				if v.Path == "result" {
+					return v.Value
+				}
+			}
+		}
+		return nil
+	}
+
+	It("Keeps the state per context", func() {
+		m := newMapper("movingAverage(input.Value, 60)")
+		Expect(doMap(m, "vessels.urn:mrn:imo:mmsi:244000001", 0, 10.0)).To(Equal(10.0))
+		Expect(doMap(m, "vessels.urn:mrn:imo:mmsi:244000002", 1, 20.0)).To(Equal(20.0))
+		Expect(doMap(m, "vessels.urn:mrn:imo:mmsi:244000001", 2, 30.0)).To(Equal(20.0))
+	})
+	It("Keeps the state per mapper", func() {
+		first := newMapper("derivative(input.Value)")
+		second := newMapper("derivative(input.Value)")
+		doMap(first, "vessels.self", 0, 0.0)
+		doMap(second, "vessels.self", 0, 100.0)
+		Expect(doMap(first, "vessels.self", 10, 10.0)).To(Equal(1.0))
+		Expect(doMap(second, "vessels.self", 10, 50.0)).To(Equal(-5.0))
+	})
+	It("Keeps the state per call site when a call is skipped by a conditional", func() {
+		m := newMapper("input.Value > 0 ? movingMax(input.Value, 60) : movingMin(input.Value, 60) + movingMax(-input.Value, 60)")
+		Expect(doMap(m, "vessels.self", 0, 5.0)).To(Equal(5.0))
+		Expect(doMap(m, "vessels.self", 1, -2.0)).To(Equal(0.0))
+		Expect(doMap(m, "vessels.self", 2, 3.0)).To(Equal(5.0))
+		Expect(doMap(m, "vessels.self", 3, -4.0)).To(Equal(0.0))
+	})
+})
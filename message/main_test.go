@@ -195,6 +195,7 @@ var _ = Describe("Mapped", func() {
 	})
 	Describe("Unmarshal", func() {
 		JustBeforeEach(func() {
+			mapped = &Mapped{}
 			err = json.Unmarshal(marshaled, mapped)
 		})
 		Context("with no updates", func() {
@@ -272,6 +273,26 @@ var _ = Describe("Mapped", func() {
 				Expect(mapped).To(Equal(expected))
 			})
 		})
+		Context("with meta", func() {
+			BeforeEach(func() {
+				units := "K"
+				displayName := "Engine temperature"
+				upper := 363.15
+				s := NewSource().WithLabel("engine").WithType(config.ModbusType).WithUuid(uuid.MustParse("84679362-f963-405f-aa37-a6a8ed961417"))
+				v := NewValue().WithPath("propulsion.main.temperature").WithValue(353.15)
+				m := NewMetaValue().WithPath("propulsion.main.temperature").WithValue(Meta{Units: &units, DisplayName: &displayName, Zones: []Zone{{Lower: &upper, State: "alarm", Message: "Engine too hot"}}})
+				u := NewUpdate().WithSource(*s).AddValue(v).AddMeta(m)
+				u.Timestamp = time.Date(2022, time.Month(2), 9, 12, 3, 57, 431272983, time.UTC)
+				expected = NewMapped().WithContext("vessels.urn:mrn:imo:mmsi:234567890").WithOrigin("vessels.urn:mrn:imo:mmsi:123456789").AddUpdate(u)
+				marshaled = []byte(`{"context":"vessels.urn:mrn:imo:mmsi:234567890","origin":"vessels.urn:mrn:imo:mmsi:123456789","updates":[{"source":{"label":"engine","type":"modbus","uuid":"84679362-f963-405f-aa37-a6a8ed961417"},"timestamp":"2022-02-09T12:03:57.431272983Z","values":[{"path":"propulsion.main.temperature","value":353.15}],"meta":[{"path":"propulsion.main.temperature","value":{"units":"K","displayName":"Engine temperature","zones":[{"lower":363.15,"state":"alarm","message":"Engine too hot"}]}}]}]}`)
+			})
+			It("returns no errors", func() {
+				Expect(err).NotTo(HaveOccurred())
+			})
+			It("equals a valid Mapped struct", func() {
+				Expect(mapped).To(Equal(expected))
+			})
+		})
 		Context("real data", func() {
 			BeforeEach(func() {
 				lat := 51.89874666666666
@@ -3,9 +3,10 @@ package message
 import "time"
 
 type Update struct {
-	Source    Source    `json:"source"`
-	Timestamp time.Time `json:"timestamp"`
-	Values    []Value   `json:"values"`
+	Source    Source      `json:"source"`
+	Timestamp time.Time   `json:"timestamp"`
+	Values    []Value     `json:"values"`
+	Meta      []MetaValue `json:"meta,omitempty"`
 }
 
 func NewUpdate() *Update {
@@ -37,6 +38,11 @@ func (u *Update) AddValue(v *Value) *Update {
 	return u
 }
 
+func (u *Update) AddMeta(m *MetaValue) *Update {
+	u.Meta = append(u.Meta, *m)
+	return u
+}
+
 func (u Update) Equals(other Update) bool {
 	if len(u.Values) != len(other.Values) {
 		return false
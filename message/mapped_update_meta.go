@@ -0,0 +1,73 @@
+package message
+
+import "fmt"
+
+// Meta is the SignalK metadata of a path, it tells consumers the unit of the value, how to display it and in which
+// zones the value should raise a notification
+type Meta struct {
+	Units       *string  `json:"units,omitempty" mapstructure:"units"`
+	Description *string  `json:"description,omitempty" mapstructure:"description"`
+	DisplayName *string  `json:"displayName,omitempty" mapstructure:"displayName"`
+	ShortName   *string  `json:"shortName,omitempty" mapstructure:"shortName"`
+	LongName    *string  `json:"longName,omitempty" mapstructure:"longName"`
+	Timeout     *float64 `json:"timeout,omitempty" mapstructure:"timeout"` // in seconds
+	Zones       []Zone   `json:"zones,omitempty" mapstructure:"zones"`
+}
+
+// Zone is a range of values with the state of the notification that should be raised when the value is in the range
+type Zone struct {
+	Lower   *float64 `json:"lower,omitempty" mapstructure:"lower"`
+	Upper   *float64 `json:"upper,omitempty" mapstructure:"upper"`
+	State   string   `json:"state" mapstructure:"state"`
+	Message string   `json:"message,omitempty" mapstructure:"message"`
+}
+
+func (left Meta) Merge(right Merger) (Merger, error) {
+	var err error
+	if right, ok := right.(Meta); !ok {
+		err = fmt.Errorf("right has type %T but should be type %T", right, left)
+	} else {
+		if right.Units != nil {
+			left.Units = right.Units
+		}
+		if right.Description != nil {
+			left.Description = right.Description
+		}
+		if right.DisplayName != nil {
+			left.DisplayName = right.DisplayName
+		}
+		if right.ShortName != nil {
+			left.ShortName = right.ShortName
+		}
+		if right.LongName != nil {
+			left.LongName = right.LongName
+		}
+		if right.Timeout != nil {
+			left.Timeout = right.Timeout
+		}
+		if right.Zones != nil {
+			left.Zones = right.Zones
+		}
+	}
+	return left, err
+}
+
+// MetaValue is an entry in the meta list of an update
+type MetaValue struct {
+	Path  string `json:"path"`
+	Value Meta   `json:"value"`
+}
+
+func NewMetaValue() *MetaValue {
+	return &MetaValue{}
+}
+
+func (m *MetaValue) WithPath(p string) *MetaValue {
+	m.Path = p
+	return m
+}
+
+func (m *MetaValue) WithValue(val Meta) *MetaValue {
+	m.Value = val
+	return m
+}
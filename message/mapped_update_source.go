@@ -30,3 +30,8 @@ func (s *Source) WithTransferUuid(u uuid.UUID) *Source {
 	s.TransferUuid = u
 	return s
 }
+
+// Ref returns the reference of the source, it is used to keep the values of multiple sources of the same path apart
+func (s Source) Ref() string {
+	return s.Label
+}
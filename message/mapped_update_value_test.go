@@ -1,6 +1,8 @@
 package message_test
 
 import (
+	"encoding/json"
+
 	. "github.com/munnik/gosk/message"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -9,6 +11,15 @@ import (
 var _ = Describe("Value", func() {
 	f := false
 	t := true
+	distance := 1852.0
+	timeTo := 600.0
+	count := int64(2)
+	elevation := 0.5
+	azimuth := 1.2
+	snr := 42.0
+	routeName := "Harlingen"
+	latitude := 53.17
+	longitude := 5.41
 	DescribeTable(
 		"Equals",
 		func(left *Value, right *Value, expected bool) {
@@ -45,4 +56,32 @@ var _ = Describe("Value", func() {
 			false,
 		),
 	)
+	DescribeTable(
+		"UnmarshalJSON",
+		func(data string, expected *Value) {
+			var v Value
+			Expect(json.Unmarshal([]byte(data), &v)).To(Succeed())
+			Expect(v).To(Equal(*expected))
+		},
+		Entry("with a number",
+			`{"path":"navigation.speedOverGround","value":3.2}`,
+			NewValue().WithPath("navigation.speedOverGround").WithValue(3.2),
+		),
+		Entry("with a bool",
+			`{"path":"electrical.switches.bank.0.1.state","value":true}`,
+			NewValue().WithPath("electrical.switches.bank.0.1.state").WithValue(true),
+		),
+		Entry("with a closest approach",
+			`{"path":"navigation.closestApproach","value":{"distance":1852,"timeTo":600}}`,
+			NewValue().WithPath("navigation.closestApproach").WithValue(ClosestApproach{Distance: &distance, TimeTo: &timeTo}),
+		),
+		Entry("with satellites in view",
+			`{"path":"navigation.gnss.satellitesInView","value":{"count":2,"satellites":[{"id":5,"elevation":0.5,"azimuth":1.2,"SNR":42},{"id":7}]}}`,
+			NewValue().WithPath("navigation.gnss.satellitesInView").WithValue(SatellitesInView{Count: &count, Satellites: []Satellite{{Id: 5, Elevation: &elevation, Azimuth: &azimuth, SNR: &snr}, {Id: 7}}}),
+		),
+		Entry("with a route",
+			`{"path":"navigation.activeRoute","value":{"name":"Harlingen","waypoints":[{"name":"WP1","position":{"latitude":53.17,"longitude":5.41}},{"name":"WP2"}]}}`,
+			NewValue().WithPath("navigation.activeRoute").WithValue(Route{Name: &routeName, Waypoints: []Waypoint{{Name: "WP1", Position: &Position{Latitude: &latitude, Longitude: &longitude}}, {Name: "WP2"}}}),
+		),
+	)
 })
@@ -104,6 +104,32 @@ func (left Alarm) Merge(right Merger) (Merger, error) {
 	return left, err
 }
 
+// Notification is a SignalK notification, the state is one of normal, alert, warn, alarm or emergency and the
+// method lists how the notification should be presented, an empty method means the notification is silenced
+type Notification struct {
+	State   *string  `json:"state,omitempty"`
+	Method  []string `json:"method"`
+	Message *string  `json:"message,omitempty"`
+}
+
+func (left Notification) Merge(right Merger) (Merger, error) {
+	var err error
+	if right, ok := right.(Notification); !ok {
+		err = fmt.Errorf("right has type %T but should be type %T", right, left)
+	} else {
+		if right.State != nil {
+			left.State = right.State
+		}
+		if right.Method != nil {
+			left.Method = right.Method
+		}
+		if right.Message != nil {
+			left.Message = right.Message
+		}
+	}
+	return left, err
+}
+
 type Draft struct {
 	Current          *float64  `json:"current,omitempty"`
 	CurrentPort      []float64 `json:"currentPort,omitempty"`
@@ -128,6 +154,83 @@ func (left Draft) Merge(right Merger) (Merger, error) {
 	return left, err
 }
 
+// ClosestApproach is the closest point of approach of another vessel, the distance is in meters and the time to the
+// closest point of approach is in seconds, a negative time means the closest point of approach has passed
+type ClosestApproach struct {
+	Distance *float64 `json:"distance,omitempty"`
+	TimeTo   *float64 `json:"timeTo,omitempty"`
+}
+
+func (left ClosestApproach) Merge(right Merger) (Merger, error) {
+	var err error
+	if right, ok := right.(ClosestApproach); !ok {
+		err = fmt.Errorf("right has type %T but should be type %T", right, left)
+	} else {
+		if right.Distance != nil {
+			left.Distance = right.Distance
+		}
+		if right.TimeTo != nil {
+			left.TimeTo = right.TimeTo
+		}
+	}
+	return left, err
+}
+
+// SatellitesInView are the satellites in view of a GNSS receiver, the elevation and azimuth are in radians and the
+// signal to noise ratio is in dB
+type SatellitesInView struct {
+	Count      *int64      `json:"count,omitempty"`
+	Satellites []Satellite `json:"satellites"`
+}
+
+type Satellite struct {
+	Id        int64    `json:"id"`
+	Elevation *float64 `json:"elevation,omitempty"`
+	Azimuth   *float64 `json:"azimuth,omitempty"`
+	SNR       *float64 `json:"SNR,omitempty"`
+}
+
+func (left SatellitesInView) Merge(right Merger) (Merger, error) {
+	var err error
+	if right, ok := right.(SatellitesInView); !ok {
+		err = fmt.Errorf("right has type %T but should be type %T", right, left)
+	} else {
+		if right.Count != nil {
+			left.Count = right.Count
+		}
+		if right.Satellites != nil {
+			left.Satellites = right.Satellites
+		}
+	}
+	return left, err
+}
+
+// Route is the active route of a navigation system, the waypoints are in the order they are sailed
+type Route struct {
+	Name      *string    `json:"name,omitempty"`
+	Waypoints []Waypoint `json:"waypoints"`
+}
+
+type Waypoint struct {
+	Name     string    `json:"name"`
+	Position *Position `json:"position,omitempty"` // nil when the location of the waypoint was not received
+}
+
+func (left Route) Merge(right Merger) (Merger, error) {
+	var err error
+	if right, ok := right.(Route); !ok {
+		err = fmt.Errorf("right has type %T but should be type %T", right, left)
+	} else {
+		if right.Name != nil {
+			left.Name = right.Name
+		}
+		if right.Waypoints != nil {
+			left.Waypoints = right.Waypoints
+		}
+	}
+	return left, err
+}
+
 func Decode(input interface{}) (interface{}, error) {
 	if i, ok := input.(int64); ok {
 		return i, nil
@@ -138,6 +241,9 @@ func Decode(input interface{}) (interface{}, error) {
 	if s, ok := input.(string); ok {
 		return s, nil
 	}
+	if b, ok := input.(bool); ok {
+		return b, nil
+	}
 
 	var metadata mapstructure.Metadata
 	p := Position{}
@@ -164,11 +270,35 @@ func Decode(input interface{}) (interface{}, error) {
 		return a, nil
 	}
 
+	n := Notification{}
+	metadata = mapstructure.Metadata{}
+	if err := mapstructure.DecodeMetadata(input, &n, &metadata); err == nil && len(metadata.Unused) == 0 {
+		return n, nil
+	}
+
 	d := Draft{}
 	metadata = mapstructure.Metadata{}
 	if err := mapstructure.DecodeMetadata(input, &d, &metadata); err == nil && len(metadata.Unused) == 0 {
 		return d, nil
 	}
 
+	c := ClosestApproach{}
+	metadata = mapstructure.Metadata{}
+	if err := mapstructure.DecodeMetadata(input, &c, &metadata); err == nil && len(metadata.Unused) == 0 {
+		return c, nil
+	}
+
+	sv := SatellitesInView{}
+	metadata = mapstructure.Metadata{}
+	if err := mapstructure.DecodeMetadata(input, &sv, &metadata); err == nil && len(metadata.Unused) == 0 {
+		return sv, nil
+	}
+
+	r := Route{}
+	metadata = mapstructure.Metadata{}
+	if err := mapstructure.DecodeMetadata(input, &r, &metadata); err == nil && len(metadata.Unused) == 0 {
+		return r, nil
+	}
+
 	return input, fmt.Errorf("don't know how to decode %v", input)
 }
@@ -42,7 +42,8 @@ func New(config *config.MQTTConfig, publishHandler paho.MessageHandler, topic st
 	return result
 }
 
-func (c *Client) Publish(topic string, qos byte, retained bool, bytes []byte) {
+// Publish sends the bytes to the broker, for QoS 1 and 2 the error is nil once the broker confirmed the message
+func (c *Client) Publish(topic string, qos byte, retained bool, bytes []byte) error {
 	if token := (*c.pahoClient).Publish(topic, qos, retained, bytes); token.Wait() && token.Error() != nil {
 		logger.GetLogger().Warn(
 			"Could not publish a message via MQTT",
@@ -50,7 +51,14 @@ func (c *Client) Publish(topic string, qos byte, retained bool, bytes []byte) {
 			zap.String("Topic", topic),
 			zap.ByteString("Bytes", bytes),
 		)
+		return token.Error()
 	}
+	return nil
+}
+
+// IsConnected returns true when the connection to the broker is established
+func (c *Client) IsConnected() bool {
+	return (*c.pahoClient).IsConnectionOpen()
 }
 
 func (c *Client) Disconnect() {
@@ -20,3 +20,19 @@ func NewSub(url string, topic []byte) (mangos.Socket, error) {
 	socket.SetOption(mangos.OptionSubscribe, topic)
 	return socket, nil
 }
+
+// NewSubAsync creates a new subscriber socket connected to all URLs, the connections are established in the
+// background so the publishers don't have to be available yet
+func NewSubAsync(urls []string, topic []byte) (mangos.Socket, error) {
+	socket, err := sub.NewSocket()
+	if err != nil {
+		return nil, err
+	}
+	for _, url := range urls {
+		if err := socket.DialOptions(url, map[string]interface{}{mangos.OptionDialAsynch: true}); err != nil {
+			return nil, err
+		}
+	}
+	socket.SetOption(mangos.OptionSubscribe, topic)
+	return socket, nil
+}
@@ -0,0 +1,165 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// CAN_*_FLAG are the flags in the identifier of a frame as used by SocketCAN
+	CAN_EFF_FLAG = 0x80000000 // extended frame format, the identifier has 29 bits
+	CAN_RTR_FLAG = 0x40000000 // remote transmission request
+	CAN_ERR_FLAG = 0x20000000 // error frame, the identifier contains the error classes
+	CAN_SFF_MASK = 0x000007FF
+	CAN_EFF_MASK = 0x1FFFFFFF
+	CAN_ERR_MASK = 0x1FFFFFFF
+
+	// CANFD_* are the flags of a CAN FD frame as used by SocketCAN
+	CANFD_BRS = 0x01 // bit rate switch
+	CANFD_ESI = 0x02 // error state indicator of the transmitting node
+	CANFD_FDF = 0x04 // the frame is a CAN FD frame
+
+	CAN_MAX_DLEN   = 8
+	CANFD_MAX_DLEN = 64
+	// CAN_MTU and CANFD_MTU are the sizes of the classic and CAN FD frames that are read from a SocketCAN socket
+	CAN_MTU   = 16
+	CANFD_MTU = 72
+
+	// CANBUS_RAW_VERSION_1 is the first byte of the raw format with the flags, length and interface name, the first
+	// byte of the legacy format is the most significant byte of the identifier which is never 0x01
+	CANBUS_RAW_VERSION_1 = 0x01
+	// CANBUS_RAW_LEGACY_LENGTH is the length of the legacy raw format, the identifier, length, flags, two reserved
+	// bytes and 8 data bytes
+	CANBUS_RAW_LEGACY_LENGTH = 16
+)
+
+const (
+	// CAN_ERR_* are the error classes in the identifier of an error frame
+	CAN_ERR_TX_TIMEOUT = 0x00000001
+	CAN_ERR_LOSTARB    = 0x00000002
+	CAN_ERR_CRTL       = 0x00000004 // controller problems, the details are in data[1]
+	CAN_ERR_PROT       = 0x00000008 // protocol violations, the details are in data[2] and data[3]
+	CAN_ERR_TRX        = 0x00000010 // transceiver status, the details are in data[4]
+	CAN_ERR_ACK        = 0x00000020
+	CAN_ERR_BUSOFF     = 0x00000040
+	CAN_ERR_BUSERROR   = 0x00000080
+	CAN_ERR_RESTARTED  = 0x00000100
+
+	// CAN_ERR_CRTL_* are the controller problems in data[1] of an error frame
+	CAN_ERR_CRTL_RX_OVERFLOW = 0x01
+	CAN_ERR_CRTL_TX_OVERFLOW = 0x02
+	CAN_ERR_CRTL_RX_WARNING  = 0x04
+	CAN_ERR_CRTL_TX_WARNING  = 0x08
+	CAN_ERR_CRTL_RX_PASSIVE  = 0x10
+	CAN_ERR_CRTL_TX_PASSIVE  = 0x20
+	CAN_ERR_CRTL_ACTIVE      = 0x40
+)
+
+// CanFrame is a classic CAN or CAN FD frame, the identifier contains the flags as used by SocketCAN
+type CanFrame struct {
+	ID        uint32
+	Flags     uint8 // CANFD_BRS, CANFD_ESI and CANFD_FDF
+	Interface string
+	Data      []byte
+}
+
+// Identifier returns the 11 or 29 bit identifier without the flags
+func (f CanFrame) Identifier() uint32 {
+	if f.IsExtended() {
+		return f.ID & CAN_EFF_MASK
+	}
+	return f.ID & CAN_SFF_MASK
+}
+
+func (f CanFrame) IsExtended() bool {
+	return f.ID&CAN_EFF_FLAG != 0
+}
+
+func (f CanFrame) IsRemote() bool {
+	return f.ID&CAN_RTR_FLAG != 0
+}
+
+func (f CanFrame) IsError() bool {
+	return f.ID&CAN_ERR_FLAG != 0
+}
+
+func (f CanFrame) IsFD() bool {
+	return f.Flags&CANFD_FDF != 0
+}
+
+// CanFrameToBytes encodes the frame in the raw format: the version, the flags, the identifier, the data length, the
+// length of the interface name, the interface name and the data
+func CanFrameToBytes(f CanFrame) []byte {
+	name := f.Interface
+	if len(name) > 0xff {
+		name = name[:0xff]
+	}
+	bytes := make([]byte, 8, 8+len(name)+len(f.Data))
+	bytes[0] = CANBUS_RAW_VERSION_1
+	bytes[1] = f.Flags
+	binary.BigEndian.PutUint32(bytes[2:6], f.ID)
+	bytes[6] = uint8(len(f.Data))
+	bytes[7] = uint8(len(name))
+	bytes = append(bytes, name...)
+	return append(bytes, f.Data...)
+}
+
+// BytesToCanFrame decodes a frame in the raw format, the legacy format of 16 bytes without a version is decoded as well
+func BytesToCanFrame(bytes []byte) (CanFrame, error) {
+	if len(bytes) > 0 && bytes[0] == CANBUS_RAW_VERSION_1 {
+		if len(bytes) < 8 {
+			return CanFrame{}, fmt.Errorf("expected at least 8 bytes but got %d", len(bytes))
+		}
+		length, nameLength := int(bytes[6]), int(bytes[7])
+		if len(bytes) != 8+nameLength+length {
+			return CanFrame{}, fmt.Errorf("expected %d bytes but got %d", 8+nameLength+length, len(bytes))
+		}
+		f := CanFrame{
+			ID:        binary.BigEndian.Uint32(bytes[2:6]),
+			Flags:     bytes[1],
+			Interface: string(bytes[8 : 8+nameLength]),
+			Data:      append([]byte{}, bytes[8+nameLength:]...),
+		}
+		if (f.IsFD() && length > CANFD_MAX_DLEN) || (!f.IsFD() && length > CAN_MAX_DLEN) {
+			return CanFrame{}, fmt.Errorf("invalid data length %d", length)
+		}
+		return f, nil
+	}
+
+	if len(bytes) != CANBUS_RAW_LEGACY_LENGTH {
+		return CanFrame{}, fmt.Errorf("expected %d bytes but got %d", CANBUS_RAW_LEGACY_LENGTH, len(bytes))
+	}
+	length := int(bytes[4])
+	if length > CAN_MAX_DLEN {
+		length = CAN_MAX_DLEN
+	}
+	return CanFrame{
+		ID:   binary.BigEndian.Uint32(bytes[0:4]),
+		Data: append([]byte{}, bytes[8:8+length]...),
+	}, nil
+}
+
+// SocketCanToFrame decodes a classic or CAN FD frame as it is read from a SocketCAN socket, the identifier is in the
+// byte order of the host which is little endian on the supported platforms
+func SocketCanToFrame(bytes []byte, name string) (CanFrame, error) {
+	maximum := CAN_MAX_DLEN
+	flags := uint8(0)
+	switch len(bytes) {
+	case CAN_MTU:
+	case CANFD_MTU:
+		maximum = CANFD_MAX_DLEN
+		flags = bytes[5] | CANFD_FDF
+	default:
+		return CanFrame{}, fmt.Errorf("expected %d or %d bytes but got %d", CAN_MTU, CANFD_MTU, len(bytes))
+	}
+	length := int(bytes[4])
+	if length > maximum {
+		return CanFrame{}, fmt.Errorf("invalid data length %d", length)
+	}
+	return CanFrame{
+		ID:        binary.LittleEndian.Uint32(bytes[0:4]),
+		Flags:     flags,
+		Interface: name,
+		Data:      append([]byte{}, bytes[8:8+length]...),
+	}, nil
+}
@@ -0,0 +1,91 @@
+package protocol_test
+
+import (
+	. "github.com/munnik/gosk/protocol"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CanBus frames", func() {
+	DescribeTable(
+		"BytesToCanFrame",
+		func(bytes []byte, expected CanFrame, expectError bool) {
+			result, err := BytesToCanFrame(bytes)
+			if expectError {
+				Expect(err).To(HaveOccurred())
+			} else {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result).To(Equal(expected))
+			}
+		},
+		Entry("legacy format",
+			[]byte{0x89, 0xf8, 0x01, 0x17, 0x03, 0x00, 0x00, 0x00, 0x01, 0x02, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00},
+			CanFrame{ID: 0x89f80117, Data: []byte{0x01, 0x02, 0x03}},
+			false,
+		),
+		Entry("legacy format with a too long length",
+			[]byte{0x00, 0x00, 0x01, 0x23, 0x0f, 0x00, 0x00, 0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08},
+			CanFrame{ID: 0x123, Data: []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08}},
+			false,
+		),
+		Entry("legacy format with a wrong length", []byte{0x00, 0x00, 0x01, 0x23, 0x01, 0x00, 0x00, 0x00, 0x01}, CanFrame{}, true),
+		Entry("version 1 with an interface name",
+			[]byte{CANBUS_RAW_VERSION_1, 0x00, 0x00, 0x00, 0x01, 0x23, 0x02, 0x04, 'c', 'a', 'n', '0', 0xaa, 0xbb},
+			CanFrame{ID: 0x123, Interface: "can0", Data: []byte{0xaa, 0xbb}},
+			false,
+		),
+		Entry("version 1 with a truncated payload",
+			[]byte{CANBUS_RAW_VERSION_1, 0x00, 0x00, 0x00, 0x01, 0x23, 0x02, 0x00, 0xaa},
+			CanFrame{},
+			true,
+		),
+		Entry("version 1 with a classic frame longer than 8 bytes",
+			append([]byte{CANBUS_RAW_VERSION_1, 0x00, 0x00, 0x00, 0x01, 0x23, 0x09, 0x00}, make([]byte, 9)...),
+			CanFrame{},
+			true,
+		),
+	)
+	It("encodes and decodes a CAN FD frame", func() {
+		frame := CanFrame{ID: CAN_EFF_FLAG | 0x1234567, Flags: CANFD_FDF | CANFD_BRS, Interface: "can1", Data: make([]byte, 64)}
+		frame.Data[63] = 0xff
+		result, err := BytesToCanFrame(CanFrameToBytes(frame))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(frame))
+		Expect(result.IsFD()).To(BeTrue())
+		Expect(result.IsExtended()).To(BeTrue())
+		Expect(result.Identifier()).To(Equal(uint32(0x1234567)))
+	})
+	DescribeTable(
+		"SocketCanToFrame",
+		func(bytes []byte, expected CanFrame, expectError bool) {
+			result, err := SocketCanToFrame(bytes, "vcan0")
+			if expectError {
+				Expect(err).To(HaveOccurred())
+			} else {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result).To(Equal(expected))
+			}
+		},
+		Entry("classic frame",
+			[]byte{0x23, 0x01, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0xaa, 0xbb, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+			CanFrame{ID: 0x123, Interface: "vcan0", Data: []byte{0xaa, 0xbb}},
+			false,
+		),
+		Entry("error frame",
+			[]byte{0x40, 0x00, 0x00, 0x20, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+			CanFrame{ID: CAN_ERR_FLAG | CAN_ERR_BUSOFF, Interface: "vcan0", Data: make([]byte, 8)},
+			false,
+		),
+		Entry("CAN FD frame",
+			append([]byte{0x23, 0x01, 0x00, 0x00, 0x0c, CANFD_BRS, 0x00, 0x00}, make([]byte, 64)...),
+			CanFrame{ID: 0x123, Flags: CANFD_FDF | CANFD_BRS, Interface: "vcan0", Data: make([]byte, 12)},
+			false,
+		),
+		Entry("classic frame longer than 8 bytes",
+			[]byte{0x23, 0x01, 0x00, 0x00, 0x09, 0x00, 0x00, 0x00, 0xaa, 0xbb, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+			CanFrame{},
+			true,
+		),
+		Entry("unknown frame size", make([]byte, 20), CanFrame{}, true),
+	)
+})
@@ -2,7 +2,11 @@ package protocol
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"math"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -16,6 +20,9 @@ const (
 	// TODO: this should be checked when register groups are created
 	MODBUS_MAXIMUM_NUMBER_OF_REGISTERS = 125
 	MODBUS_MAXIMUM_NUMBER_OF_COILS     = 2000
+	// MODBUS_MAXIMUM_NUMBER_OF_READ_WRITE_REGISTERS is the maximum number of registers that can be written with the
+	// read/write multiple registers function
+	MODBUS_MAXIMUM_NUMBER_OF_READ_WRITE_REGISTERS = 121
 )
 
 const (
@@ -45,9 +52,47 @@ const (
 	MASK_WRITE_REGISTERS = 0x16
 	// 23 (0x17) Read/Write Multiple Registers
 	READ_WRITE_MULTIPLE_REGISTERS = 0x17
-	// 43 / 14 (0x2B / 0x0E) Read Device Identification
+	// 43 / 14 (0x2B / 0x0E) Read Device Identification, A is the MEI type and B the function code
 	READ_DEVICE_IDENTIFICATION_A = 0x0E
-	READ_DEVICE_IDENTIFICATION_B = 0x43
+	READ_DEVICE_IDENTIFICATION_B = 0x2B
+)
+
+const (
+	MODBUS_ENCODING_INT16   = "int16"
+	MODBUS_ENCODING_UINT16  = "uint16"
+	MODBUS_ENCODING_INT32   = "int32"
+	MODBUS_ENCODING_UINT32  = "uint32"
+	MODBUS_ENCODING_FLOAT32 = "float32"
+	MODBUS_ENCODING_INT64   = "int64"
+	MODBUS_ENCODING_FLOAT64 = "float64"
+	// MODBUS_ENCODING_STRING has two characters per register, trailing spaces and null characters are removed
+	MODBUS_ENCODING_STRING = "string"
+	// MODBUS_ENCODING_BCD has four decimal digits per register
+	MODBUS_ENCODING_BCD = "bcd"
+	// MODBUS_ENCODING_BITFIELD is a list of bools, the first bool is the least significant bit
+	MODBUS_ENCODING_BITFIELD = "bitfield"
+
+	// MODBUS_BYTE_ORDER_BIG_ENDIAN stores the most significant byte of a register first, this is the default
+	MODBUS_BYTE_ORDER_BIG_ENDIAN = "bigEndian"
+	// MODBUS_BYTE_ORDER_LITTLE_ENDIAN stores the least significant byte of a register first
+	MODBUS_BYTE_ORDER_LITTLE_ENDIAN = "littleEndian"
+
+	// MODBUS_WORD_ORDER_BIG_ENDIAN stores the most significant register first, this is the default
+	MODBUS_WORD_ORDER_BIG_ENDIAN = "bigEndian"
+	// MODBUS_WORD_ORDER_LITTLE_ENDIAN stores the least significant register first
+	MODBUS_WORD_ORDER_LITTLE_ENDIAN = "littleEndian"
+)
+
+const (
+	MODBUS_EXCEPTION_ILLEGAL_FUNCTION                        = 0x01
+	MODBUS_EXCEPTION_ILLEGAL_DATA_ADDRESS                    = 0x02
+	MODBUS_EXCEPTION_ILLEGAL_DATA_VALUE                      = 0x03
+	MODBUS_EXCEPTION_SERVER_DEVICE_FAILURE                   = 0x04
+	MODBUS_EXCEPTION_ACKNOWLEDGE                             = 0x05
+	MODBUS_EXCEPTION_SERVER_DEVICE_BUSY                      = 0x06
+	MODBUS_EXCEPTION_MEMORY_PARITY_ERROR                     = 0x08
+	MODBUS_EXCEPTION_GATEWAY_PATH_UNAVAILABLE                = 0x0A
+	MODBUS_EXCEPTION_GATEWAY_TARGET_DEVICE_FAILED_TO_RESPOND = 0x0B
 )
 
 type ModbusHeader struct {
@@ -59,6 +104,7 @@ type ModbusHeader struct {
 
 type ModbusClient struct {
 	realClient *modbus.ModbusClient
+	transport  *ModbusTransport
 	header     *ModbusHeader
 	lock       sync.Mutex
 }
@@ -70,117 +116,308 @@ func NewModbusClient(realClient *modbus.ModbusClient, header *ModbusHeader) *Mod
 	}
 }
 
+// WithTransport sets the transport that is used to read the device information function codes
+func (m *ModbusClient) WithTransport(transport *ModbusTransport) *ModbusClient {
+	m.transport = transport
+	return m
+}
+
+// Read reads the register group in bytes, an error is returned when the result doesn't fit in the capacity of bytes
 func (m *ModbusClient) Read(bytes []byte) (n int, err error) {
+	result, err := m.ReadRegisterGroup()
+	if err != nil {
+		return 0, err
+	}
+	if len(result) > cap(bytes) {
+		return 0, fmt.Errorf("the result of %d bytes doesn't fit in the buffer of %d bytes", len(result), cap(bytes))
+	}
+	return copy(bytes[:cap(bytes)], result), nil
+}
+
+// ReadRegisterGroup reads the register group and returns it as the value of a raw message, the header followed by the
+// data
+func (m *ModbusClient) ReadRegisterGroup() ([]byte, error) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
+	if IsModbusDeviceFunction(m.header.FunctionCode) {
+		// the device information function codes are not supported by the Modbus library
+		result, err := m.readDevice()
+		if err != nil {
+			return nil, fmt.Errorf("error while reading the device information with address %v and function code %v, the error that occurred was %w", m.header.Address, m.header.FunctionCode, err)
+		}
+		return InjectModbusHeader(m.header, result), nil
+	}
+
 	m.realClient.SetUnitId(m.header.Slave)
 	switch m.header.FunctionCode {
 	case READ_COILS:
 		result, err := m.realClient.ReadCoils(m.header.Address, m.header.NumberOfCoilsOrRegisters)
 		if err != nil {
-			return 0, fmt.Errorf("error while reading coils %v, with length %v and function code %v, the error that occurred was %v", m.header.Address, m.header.NumberOfCoilsOrRegisters, m.header.FunctionCode, err)
+			return nil, fmt.Errorf("error while reading coils %v, with length %v and function code %v, the error that occurred was %w", m.header.Address, m.header.NumberOfCoilsOrRegisters, m.header.FunctionCode, err)
 		}
-		bytes = bytes[:0]
-		bytes = append(bytes, InjectModbusHeader(m.header, CoilsToBytes(result))...)
+		return InjectModbusHeader(m.header, CoilsToBytes(result)), nil
 	case READ_DISCRETE_INPUTS:
 		result, err := m.realClient.ReadDiscreteInputs(m.header.Address, m.header.NumberOfCoilsOrRegisters)
 		if err != nil {
-			return 0, fmt.Errorf("error while reading discrete inputs %v, with length %v and function code %v, the error that occurred was %v", m.header.Address, m.header.NumberOfCoilsOrRegisters, m.header.FunctionCode, err)
+			return nil, fmt.Errorf("error while reading discrete inputs %v, with length %v and function code %v, the error that occurred was %w", m.header.Address, m.header.NumberOfCoilsOrRegisters, m.header.FunctionCode, err)
 		}
-		bytes = bytes[:0]
-		bytes = append(bytes, InjectModbusHeader(m.header, CoilsToBytes(result))...)
+		return InjectModbusHeader(m.header, CoilsToBytes(result)), nil
 	case READ_HOLDING_REGISTERS:
 		result, err := m.realClient.ReadRegisters(m.header.Address, m.header.NumberOfCoilsOrRegisters, modbus.HOLDING_REGISTER)
 		if err != nil {
-			return 0, fmt.Errorf("error while reading holding register %v, with length %v and function code %v, the error that occurred was %v", m.header.Address, m.header.NumberOfCoilsOrRegisters, m.header.FunctionCode, err)
+			return nil, fmt.Errorf("error while reading holding register %v, with length %v and function code %v, the error that occurred was %w", m.header.Address, m.header.NumberOfCoilsOrRegisters, m.header.FunctionCode, err)
 		}
-		bytes = bytes[:0]
-		bytes = append(bytes, InjectModbusHeader(m.header, RegistersToBytes(result))...)
+		return InjectModbusHeader(m.header, RegistersToBytes(result)), nil
 	case READ_INPUT_REGISTERS:
 		result, err := m.realClient.ReadRegisters(m.header.Address, m.header.NumberOfCoilsOrRegisters, modbus.INPUT_REGISTER)
 		if err != nil {
-			return 0, fmt.Errorf("error while reading input register %v, with length %v and function code %v, the error that occurred was %v", m.header.Address, m.header.NumberOfCoilsOrRegisters, m.header.FunctionCode, err)
+			return nil, fmt.Errorf("error while reading input register %v, with length %v and function code %v, the error that occurred was %w", m.header.Address, m.header.NumberOfCoilsOrRegisters, m.header.FunctionCode, err)
 		}
-		bytes = bytes[:0]
-		bytes = append(bytes, InjectModbusHeader(m.header, RegistersToBytes(result))...)
-	default:
-		return 0, fmt.Errorf("unsupported function code type %v", m.header.FunctionCode)
+		return InjectModbusHeader(m.header, RegistersToBytes(result)), nil
 	}
-	return len(bytes), nil
+	return nil, fmt.Errorf("unsupported function code type %v", m.header.FunctionCode)
+}
+
+// readDevice returns the data of the device information function codes as it is stored after the header of a raw
+// message:
+//   - report server id: the server id, the run indicator and the additional data
+//   - read device identification: the objects as id, length and value, the address is the read device id code
+//   - diagnostics: the counter of the sub-function, the address is the sub-function
+//   - get comm event counter: the status and the event count
+func (m *ModbusClient) readDevice() ([]byte, error) {
+	if m.transport == nil {
+		return nil, fmt.Errorf("no transport to read function code %v", m.header.FunctionCode)
+	}
+	switch m.header.FunctionCode {
+	case REPORT_SERVER_ID:
+		response, err := m.transport.Execute(m.header.Slave, REPORT_SERVER_ID, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(response) < 1 || int(response[0]) != len(response)-1 {
+			return nil, fmt.Errorf("invalid report server id response %x", response)
+		}
+		return response[1:], nil
+	case READ_DEVICE_IDENTIFICATION_B:
+		code := uint8(m.header.Address)
+		if code < MODBUS_DEVICE_IDENTIFICATION_BASIC || code > MODBUS_DEVICE_IDENTIFICATION_EXTENDED {
+			code = MODBUS_DEVICE_IDENTIFICATION_BASIC
+		}
+		return m.transport.ReadDeviceIdentification(m.header.Slave, code)
+	case DIAGNOSTICS:
+		// only the sub-functions that return a counter are allowed, the others change the state of the device
+		if m.header.Address < MODBUS_DIAGNOSTICS_BUS_MESSAGE_COUNT || m.header.Address > MODBUS_DIAGNOSTICS_BUS_CHARACTER_OVERRUN_COUNT {
+			return nil, fmt.Errorf("unsupported diagnostics sub-function %v", m.header.Address)
+		}
+		response, err := m.transport.Execute(m.header.Slave, DIAGNOSTICS, []byte{byte(m.header.Address >> 8), byte(m.header.Address), 0x00, 0x00})
+		if err != nil {
+			return nil, err
+		}
+		if len(response) != 4 || binary.BigEndian.Uint16(response[0:2]) != m.header.Address {
+			return nil, fmt.Errorf("invalid diagnostics response %x", response)
+		}
+		return response[2:], nil
+	case GET_COMM_EVENT_COUNTER:
+		response, err := m.transport.Execute(m.header.Slave, GET_COMM_EVENT_COUNTER, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(response) != 4 {
+			return nil, fmt.Errorf("invalid get comm event counter response %x", response)
+		}
+		return response, nil
+	}
+	return nil, fmt.Errorf("unsupported function code type %v", m.header.FunctionCode)
 }
 
 func (m *ModbusClient) Write(bytes []byte) (n int, err error) {
+	if _, err := m.Execute(bytes); err != nil {
+		return 0, err
+	}
+	return len(bytes), nil
+}
+
+// Execute writes the coils or registers of the raw message, the registers that are read by the read/write multiple
+// registers function are returned. The data after the header of the raw message is:
+//   - write single coil and write multiple coils: the coils
+//   - write single register and write multiple registers: the registers
+//   - mask write register: the and mask followed by the or mask
+//   - read/write multiple registers: the read address and the read quantity followed by the registers that are written
+//     at the address of the header
+func (m *ModbusClient) Execute(bytes []byte) ([]uint16, error) {
 	header, bytes, err := ExtractModbusHeader(bytes)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
+	switch header.FunctionCode {
+	case MASK_WRITE_REGISTERS:
+		if header.NumberOfCoilsOrRegisters != 1 || len(bytes) != 4 {
+			return nil, fmt.Errorf("expected 1 register with an and mask and an or mask but got %d registers and %d bytes", header.NumberOfCoilsOrRegisters, len(bytes))
+		}
+		return nil, m.maskWriteRegister(header, bytes)
+	case READ_WRITE_MULTIPLE_REGISTERS:
+		if len(bytes) < 4 {
+			return nil, fmt.Errorf("expected a read address and a read quantity but got %d bytes", len(bytes))
+		}
+		return m.readWriteMultipleRegisters(header, binary.BigEndian.Uint16(bytes[0:2]), binary.BigEndian.Uint16(bytes[2:4]), bytes[4:])
+	}
+
 	m.realClient.SetUnitId(header.Slave)
 	switch header.FunctionCode {
 	case WRITE_SINGLE_COIL:
 		if header.NumberOfCoilsOrRegisters != 1 {
-			return 0, fmt.Errorf("expected only 1 register but got %d", header.NumberOfCoilsOrRegisters)
+			return nil, fmt.Errorf("expected only 1 register but got %d", header.NumberOfCoilsOrRegisters)
 		}
 		coils, err := BytesToCoils(bytes)
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
-		m.realClient.WriteCoil(header.Address, coils[0])
+		return nil, m.realClient.WriteCoil(header.Address, coils[0])
 	case WRITE_SINGLE_REGISTER:
 		if header.NumberOfCoilsOrRegisters != 1 {
-			return 0, fmt.Errorf("expected only 1 register but got %d", header.NumberOfCoilsOrRegisters)
+			return nil, fmt.Errorf("expected only 1 register but got %d", header.NumberOfCoilsOrRegisters)
 		}
 		registers, err := BytesToRegisters(bytes)
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
 		if len(registers) != int(header.NumberOfCoilsOrRegisters) {
-			return 0, fmt.Errorf("Expected %d registers but got %d register", header.NumberOfCoilsOrRegisters, len(registers))
+			return nil, fmt.Errorf("Expected %d registers but got %d register", header.NumberOfCoilsOrRegisters, len(registers))
 		}
-		m.realClient.WriteRegister(header.Address, registers[0])
+		return nil, m.realClient.WriteRegister(header.Address, registers[0])
 	case WRITE_MULTIPLE_COILS:
 		coils, err := BytesToCoils(bytes)
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
-		m.realClient.WriteCoils(header.Address, coils)
+		return nil, m.realClient.WriteCoils(header.Address, coils)
 	case WRITE_MULTIPLE_REGISTERS:
 		registers, err := BytesToRegisters(bytes)
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
 		if len(registers) != int(header.NumberOfCoilsOrRegisters) {
-			return 0, fmt.Errorf("Expected %d registers but got %d register", header.NumberOfCoilsOrRegisters, len(registers))
+			return nil, fmt.Errorf("Expected %d registers but got %d register", header.NumberOfCoilsOrRegisters, len(registers))
 		}
-		m.realClient.WriteRegisters(header.Address, registers)
-	default:
-		return 0, fmt.Errorf("unsupported function code type %v", header.FunctionCode)
+		return nil, m.realClient.WriteRegisters(header.Address, registers)
 	}
-	return len(bytes), nil
+	return nil, fmt.Errorf("unsupported function code type %v", header.FunctionCode)
 }
 
-func (m *ModbusClient) Poll(stream chan<- []byte, pollingInterval time.Duration) error {
-	ticker := time.NewTicker(pollingInterval)
-	done := make(chan struct{})
-	bytes := make([]byte, 0, m.header.NumberOfCoilsOrRegisters*2+MODBUS_HEADER_LENGTH)
-	for {
-		select {
-		case <-ticker.C:
-			n, err := m.Read(bytes)
-			// TODO: how to handle failed reads, never attempt again or keep trying
-			if err != nil {
-				return err
-			}
-			stream <- bytes[:n]
-		case <-done:
-			ticker.Stop()
-			return nil
-		}
+// maskWriteRegister changes the bits of the register, the result is (register AND andMask) OR (orMask AND NOT andMask)
+func (m *ModbusClient) maskWriteRegister(header *ModbusHeader, masks []byte) error {
+	if m.transport == nil {
+		return fmt.Errorf("no transport to write function code %v", header.FunctionCode)
+	}
+	request := append([]byte{byte(header.Address >> 8), byte(header.Address)}, masks...)
+	response, err := m.transport.Execute(header.Slave, MASK_WRITE_REGISTERS, request)
+	if err != nil {
+		return err
+	}
+	// the response echoes the request
+	if string(response) != string(request) {
+		return fmt.Errorf("expected the response %x but got %x", request, response)
+	}
+	return nil
+}
+
+// readWriteMultipleRegisters writes the registers at the address of the header and reads the registers at the read
+// address in one request, the write is done before the read
+func (m *ModbusClient) readWriteMultipleRegisters(header *ModbusHeader, readAddress uint16, readQuantity uint16, bytes []byte) ([]uint16, error) {
+	if m.transport == nil {
+		return nil, fmt.Errorf("no transport to write function code %v", header.FunctionCode)
+	}
+	if readQuantity == 0 || readQuantity > MODBUS_MAXIMUM_NUMBER_OF_REGISTERS {
+		return nil, fmt.Errorf("expected to read 1 to %d registers but got %d", MODBUS_MAXIMUM_NUMBER_OF_REGISTERS, readQuantity)
+	}
+	if header.NumberOfCoilsOrRegisters == 0 || header.NumberOfCoilsOrRegisters > MODBUS_MAXIMUM_NUMBER_OF_READ_WRITE_REGISTERS || len(bytes) != int(header.NumberOfCoilsOrRegisters)*2 {
+		return nil, fmt.Errorf("expected to write 1 to %d registers but got %d registers and %d bytes", MODBUS_MAXIMUM_NUMBER_OF_READ_WRITE_REGISTERS, header.NumberOfCoilsOrRegisters, len(bytes))
+	}
+	request := []byte{
+		byte(readAddress >> 8), byte(readAddress),
+		byte(readQuantity >> 8), byte(readQuantity),
+		byte(header.Address >> 8), byte(header.Address),
+		byte(header.NumberOfCoilsOrRegisters >> 8), byte(header.NumberOfCoilsOrRegisters),
+		byte(len(bytes)),
+	}
+	response, err := m.transport.Execute(header.Slave, READ_WRITE_MULTIPLE_REGISTERS, append(request, bytes...))
+	if err != nil {
+		return nil, err
+	}
+	if len(response) != 1+int(readQuantity)*2 || int(response[0]) != int(readQuantity)*2 {
+		return nil, fmt.Errorf("expected %d registers but got %x", readQuantity, response)
+	}
+	return BytesToRegisters(response[1:])
+}
+
+// MaskWriteRegisterCommand returns the raw message value that changes the bits of the register with the mask write
+// register function
+func MaskWriteRegisterCommand(slave uint8, address uint16, andMask uint16, orMask uint16) []byte {
+	header := &ModbusHeader{Slave: slave, FunctionCode: MASK_WRITE_REGISTERS, Address: address, NumberOfCoilsOrRegisters: 1}
+	return InjectModbusHeader(header, RegistersToBytes([]uint16{andMask, orMask}))
+}
+
+// ReadWriteMultipleRegistersCommand returns the raw message value that writes the registers at the write address and
+// reads the registers at the read address with the read/write multiple registers function
+func ReadWriteMultipleRegistersCommand(slave uint8, readAddress uint16, readQuantity uint16, writeAddress uint16, registers []uint16) []byte {
+	header := &ModbusHeader{Slave: slave, FunctionCode: READ_WRITE_MULTIPLE_REGISTERS, Address: writeAddress, NumberOfCoilsOrRegisters: uint16(len(registers))}
+	return InjectModbusHeader(header, RegistersToBytes(append([]uint16{readAddress, readQuantity}, registers...)))
+}
+
+// ModbusAcknowledgement is the result of a command that is written to a slave
+type ModbusAcknowledgement struct {
+	Request      string   `json:"request"` // uuid of the raw message with the command
+	Slave        uint8    `json:"slave"`
+	FunctionCode uint16   `json:"functionCode"`
+	Address      uint16   `json:"address"`
+	Success      bool     `json:"success"`
+	Registers    []uint16 `json:"registers,omitempty"` // registers that are read by the read/write multiple registers function
+	Error        string   `json:"error,omitempty"`
+	Exception    uint8    `json:"exception,omitempty"` // exception code of the error, 0 when it was not an exception
+}
+
+// ModbusException returns the exception code of an exception response of the server, 0 is returned when the error is
+// not an exception, e.g. a timeout or a connection error
+func ModbusException(err error) uint8 {
+	switch {
+	case errors.Is(err, modbus.ErrIllegalFunction):
+		return MODBUS_EXCEPTION_ILLEGAL_FUNCTION
+	case errors.Is(err, modbus.ErrIllegalDataAddress):
+		return MODBUS_EXCEPTION_ILLEGAL_DATA_ADDRESS
+	case errors.Is(err, modbus.ErrIllegalDataValue):
+		return MODBUS_EXCEPTION_ILLEGAL_DATA_VALUE
+	case errors.Is(err, modbus.ErrServerDeviceFailure):
+		return MODBUS_EXCEPTION_SERVER_DEVICE_FAILURE
+	case errors.Is(err, modbus.ErrAcknowledge):
+		return MODBUS_EXCEPTION_ACKNOWLEDGE
+	case errors.Is(err, modbus.ErrServerDeviceBusy):
+		return MODBUS_EXCEPTION_SERVER_DEVICE_BUSY
+	case errors.Is(err, modbus.ErrMemoryParityError):
+		return MODBUS_EXCEPTION_MEMORY_PARITY_ERROR
+	case errors.Is(err, modbus.ErrGWPathUnavailable):
+		return MODBUS_EXCEPTION_GATEWAY_PATH_UNAVAILABLE
+	case errors.Is(err, modbus.ErrGWTargetFailedToRespond):
+		return MODBUS_EXCEPTION_GATEWAY_TARGET_DEVICE_FAILED_TO_RESPOND
 	}
+	return 0
+}
+
+// ModbusHealth is the health of a polled register group
+type ModbusHealth struct {
+	Slave             uint8         `json:"slave"`
+	FunctionCode      uint16        `json:"functionCode"`
+	Address           uint16        `json:"address"`
+	LastSuccess       time.Time     `json:"lastSuccess"`
+	Latency           time.Duration `json:"latency"` // duration of the last successful read
+	Errors            uint64        `json:"errors"`
+	ConsecutiveErrors uint64        `json:"consecutiveErrors"`
+	LastError         string        `json:"lastError,omitempty"`
+	LastException     uint8         `json:"lastException,omitempty"` // exception code of the last error, 0 when it was not an exception
 }
 
 func CoilsToBytes(values []bool) []byte {
@@ -273,3 +510,244 @@ func ExtractModbusHeader(bytes []byte) (*ModbusHeader, []byte, error) {
 
 	return header, bytes[MODBUS_HEADER_LENGTH:], nil
 }
+
+// RegistersForEncoding returns the number of registers that are used to store a value with the encoding, strings, BCD
+// numbers and bitfields have a variable number of registers
+func RegistersForEncoding(encoding string) (int, error) {
+	switch encoding {
+	case MODBUS_ENCODING_INT16, MODBUS_ENCODING_UINT16:
+		return 1, nil
+	case MODBUS_ENCODING_INT32, MODBUS_ENCODING_UINT32, MODBUS_ENCODING_FLOAT32:
+		return 2, nil
+	case MODBUS_ENCODING_INT64, MODBUS_ENCODING_FLOAT64:
+		return 4, nil
+	}
+	return 0, fmt.Errorf("unsupported encoding %q", encoding)
+}
+
+// EncodeRegisters converts the value to registers, integers are rounded and values that don't fit the encoding result
+// in an error
+func EncodeRegisters(value float64, encoding string, wordOrder string) ([]uint16, error) {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, fmt.Errorf("the value %v can't be encoded", value)
+	}
+
+	var bits uint64
+	switch encoding {
+	case MODBUS_ENCODING_INT16:
+		if v := math.Round(value); v >= math.MinInt16 && v <= math.MaxInt16 {
+			return []uint16{uint16(int16(v))}, nil
+		}
+		return nil, fmt.Errorf("the value %v doesn't fit in an %s", value, encoding)
+	case MODBUS_ENCODING_UINT16:
+		if v := math.Round(value); v >= 0 && v <= math.MaxUint16 {
+			return []uint16{uint16(v)}, nil
+		}
+		return nil, fmt.Errorf("the value %v doesn't fit in an %s", value, encoding)
+	case MODBUS_ENCODING_INT32:
+		v := math.Round(value)
+		if v < math.MinInt32 || v > math.MaxInt32 {
+			return nil, fmt.Errorf("the value %v doesn't fit in an %s", value, encoding)
+		}
+		bits = uint64(uint32(int32(v)))
+	case MODBUS_ENCODING_UINT32:
+		v := math.Round(value)
+		if v < 0 || v > math.MaxUint32 {
+			return nil, fmt.Errorf("the value %v doesn't fit in an %s", value, encoding)
+		}
+		bits = uint64(v)
+	case MODBUS_ENCODING_INT64:
+		// 2^63 is the first float that doesn't fit, math.MaxInt64 itself can't be represented as a float
+		v := math.Round(value)
+		if v < math.MinInt64 || v >= -math.MinInt64 {
+			return nil, fmt.Errorf("the value %v doesn't fit in an %s", value, encoding)
+		}
+		bits = uint64(int64(v))
+	case MODBUS_ENCODING_FLOAT32:
+		if math.Abs(value) > math.MaxFloat32 {
+			return nil, fmt.Errorf("the value %v doesn't fit in a %s", value, encoding)
+		}
+		bits = uint64(math.Float32bits(float32(value)))
+	case MODBUS_ENCODING_FLOAT64:
+		bits = math.Float64bits(value)
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", encoding)
+	}
+
+	n, _ := RegistersForEncoding(encoding)
+	registers := make([]uint16, n)
+	for i := range registers {
+		registers[n-1-i] = uint16(bits >> (16 * i))
+	}
+	switch wordOrder {
+	case "", MODBUS_WORD_ORDER_BIG_ENDIAN:
+		return registers, nil
+	case MODBUS_WORD_ORDER_LITTLE_ENDIAN:
+		for i, j := 0, n-1; i < j; i, j = i+1, j-1 {
+			registers[i], registers[j] = registers[j], registers[i]
+		}
+		return registers, nil
+	}
+	return nil, fmt.Errorf("unsupported word order %q", wordOrder)
+}
+
+// CoalesceModbusHeaders combines the headers of the same slave and function code in as few requests as possible, the
+// addresses in between two headers are read as well when there are at most maximumGap of them. A request is never longer
+// than maximumLength or the Modbus maximum, 0 means the Modbus maximum. The result is sorted on slave, function code
+// and address.
+func CoalesceModbusHeaders(headers []ModbusHeader, maximumGap uint16, maximumLength uint16) []ModbusHeader {
+	sorted := append([]ModbusHeader{}, headers...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Slave != sorted[j].Slave {
+			return sorted[i].Slave < sorted[j].Slave
+		}
+		if sorted[i].FunctionCode != sorted[j].FunctionCode {
+			return sorted[i].FunctionCode < sorted[j].FunctionCode
+		}
+		if sorted[i].Address != sorted[j].Address {
+			return sorted[i].Address < sorted[j].Address
+		}
+		return sorted[i].NumberOfCoilsOrRegisters > sorted[j].NumberOfCoilsOrRegisters
+	})
+
+	result := make([]ModbusHeader, 0, len(sorted))
+	for _, h := range sorted {
+		if h.NumberOfCoilsOrRegisters == 0 {
+			h.NumberOfCoilsOrRegisters = 1
+		}
+		if len(result) > 0 {
+			last := &result[len(result)-1]
+			lastEnd := int(last.Address) + int(last.NumberOfCoilsOrRegisters)
+			end := int(h.Address) + int(h.NumberOfCoilsOrRegisters)
+			if last.Slave == h.Slave && last.FunctionCode == h.FunctionCode && int(h.Address) <= lastEnd+int(maximumGap) {
+				if end <= lastEnd {
+					// already read by the last request
+					continue
+				}
+				if end-int(last.Address) <= maximumRequestLength(h.FunctionCode, maximumLength) {
+					last.NumberOfCoilsOrRegisters = uint16(end - int(last.Address))
+					continue
+				}
+			}
+		}
+		result = append(result, h)
+	}
+	return result
+}
+
+// maximumRequestLength is the maximum length limited to the maximum number of coils or registers of the function code
+func maximumRequestLength(functionCode uint16, maximumLength uint16) int {
+	result := MODBUS_MAXIMUM_NUMBER_OF_REGISTERS
+	if functionCode == READ_COILS || functionCode == READ_DISCRETE_INPUTS {
+		result = MODBUS_MAXIMUM_NUMBER_OF_COILS
+	}
+	if maximumLength > 0 && int(maximumLength) < result {
+		return int(maximumLength)
+	}
+	return result
+}
+
+// PackCoils packs the coils in bytes as they are sent over the wire, the first coil is the least significant bit of the
+// first byte
+func PackCoils(coils []bool) []byte {
+	bytes := make([]byte, (len(coils)+7)/8)
+	for i, c := range coils {
+		if c {
+			bytes[i/8] |= 1 << (i % 8)
+		}
+	}
+	return bytes
+}
+
+// ModbusCRC is the CRC-16/MODBUS checksum of a RTU frame, it is sent with the least significant byte first
+func ModbusCRC(bytes []byte) uint16 {
+	crc := uint16(0xffff)
+	for _, b := range bytes {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&0x0001 != 0 {
+				crc = (crc >> 1) ^ 0xa001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// DecodeRegisters converts the registers to a value, integers and BCD numbers are returned as int64, floats as float64,
+// strings as string and bitfields as []bool
+func DecodeRegisters(registers []uint16, encoding string, byteOrder string, wordOrder string) (interface{}, error) {
+	ordered := make([]uint16, len(registers))
+	for i, r := range registers {
+		switch byteOrder {
+		case "", MODBUS_BYTE_ORDER_BIG_ENDIAN:
+			ordered[i] = r
+		case MODBUS_BYTE_ORDER_LITTLE_ENDIAN:
+			ordered[i] = r<<8 | r>>8
+		default:
+			return nil, fmt.Errorf("unsupported byte order %q", byteOrder)
+		}
+	}
+	switch wordOrder {
+	case "", MODBUS_WORD_ORDER_BIG_ENDIAN:
+	case MODBUS_WORD_ORDER_LITTLE_ENDIAN:
+		for i, j := 0, len(ordered)-1; i < j; i, j = i+1, j-1 {
+			ordered[i], ordered[j] = ordered[j], ordered[i]
+		}
+	default:
+		return nil, fmt.Errorf("unsupported word order %q", wordOrder)
+	}
+
+	if n, err := RegistersForEncoding(encoding); err == nil && n != len(ordered) {
+		return nil, fmt.Errorf("expected %d registers for %s but got %d", n, encoding, len(ordered))
+	}
+	if len(ordered) == 0 {
+		return nil, fmt.Errorf("no registers to decode")
+	}
+
+	// the registers as one unsigned number, the most significant register first
+	var bits uint64
+	for _, r := range ordered {
+		bits = bits<<16 | uint64(r)
+	}
+	switch encoding {
+	case MODBUS_ENCODING_INT16:
+		return int64(int16(bits)), nil
+	case MODBUS_ENCODING_UINT16, MODBUS_ENCODING_UINT32:
+		return int64(bits), nil
+	case MODBUS_ENCODING_INT32:
+		return int64(int32(bits)), nil
+	case MODBUS_ENCODING_INT64:
+		return int64(bits), nil
+	case MODBUS_ENCODING_FLOAT32:
+		return float64(math.Float32frombits(uint32(bits))), nil
+	case MODBUS_ENCODING_FLOAT64:
+		return math.Float64frombits(bits), nil
+	case MODBUS_ENCODING_STRING:
+		bytes := RegistersToBytes(ordered)
+		return strings.TrimRight(string(bytes), " \x00"), nil
+	case MODBUS_ENCODING_BCD:
+		if len(ordered) > 4 {
+			return nil, fmt.Errorf("a bcd number of %d registers doesn't fit in an int64", len(ordered))
+		}
+		var result int64
+		for i := len(ordered)*4 - 1; i >= 0; i-- {
+			digit := int64(bits>>(i*4)) & 0x0f
+			if digit > 9 {
+				return nil, fmt.Errorf("invalid bcd digit %x", digit)
+			}
+			result = result*10 + digit
+		}
+		return result, nil
+	case MODBUS_ENCODING_BITFIELD:
+		result := make([]bool, 0, len(ordered)*16)
+		for i := len(ordered) - 1; i >= 0; i-- {
+			for bit := 0; bit < 16; bit++ {
+				result = append(result, ordered[i]&(1<<bit) != 0)
+			}
+		}
+		return result, nil
+	}
+	return nil, fmt.Errorf("unsupported encoding %q", encoding)
+}
@@ -0,0 +1,356 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goburrow/serial"
+	"github.com/simonvetter/modbus"
+)
+
+const (
+	// MODBUS_DEVICE_IDENTIFICATION_* are the read device id codes, the code is the address of the register group
+	MODBUS_DEVICE_IDENTIFICATION_BASIC    = 0x01
+	MODBUS_DEVICE_IDENTIFICATION_REGULAR  = 0x02
+	MODBUS_DEVICE_IDENTIFICATION_EXTENDED = 0x03
+
+	// MODBUS_DEVICE_OBJECT_* are the object ids of the basic and regular device identification
+	MODBUS_DEVICE_OBJECT_VENDOR_NAME           = 0x00
+	MODBUS_DEVICE_OBJECT_PRODUCT_CODE          = 0x01
+	MODBUS_DEVICE_OBJECT_MAJOR_MINOR_REVISION  = 0x02
+	MODBUS_DEVICE_OBJECT_VENDOR_URL            = 0x03
+	MODBUS_DEVICE_OBJECT_PRODUCT_NAME          = 0x04
+	MODBUS_DEVICE_OBJECT_MODEL_NAME            = 0x05
+	MODBUS_DEVICE_OBJECT_USER_APPLICATION_NAME = 0x06
+
+	// MODBUS_DIAGNOSTICS_* are the sub-functions of the diagnostics function that return a counter, the sub-function
+	// is the address of the register group
+	MODBUS_DIAGNOSTICS_BUS_MESSAGE_COUNT             = 0x0B
+	MODBUS_DIAGNOSTICS_BUS_COMMUNICATION_ERROR_COUNT = 0x0C
+	MODBUS_DIAGNOSTICS_BUS_EXCEPTION_ERROR_COUNT     = 0x0D
+	MODBUS_DIAGNOSTICS_SERVER_MESSAGE_COUNT          = 0x0E
+	MODBUS_DIAGNOSTICS_SERVER_NO_RESPONSE_COUNT      = 0x0F
+	MODBUS_DIAGNOSTICS_SERVER_NAK_COUNT              = 0x10
+	MODBUS_DIAGNOSTICS_SERVER_BUSY_COUNT             = 0x11
+	MODBUS_DIAGNOSTICS_BUS_CHARACTER_OVERRUN_COUNT   = 0x12
+)
+
+// IsModbusDeviceFunction returns true for the function codes that read information about the device instead of coils
+// or registers
+func IsModbusDeviceFunction(functionCode uint16) bool {
+	switch functionCode {
+	case REPORT_SERVER_ID, READ_DEVICE_IDENTIFICATION_B, DIAGNOSTICS, GET_COMM_EVENT_COUNTER:
+		return true
+	}
+	return false
+}
+
+// ErrModbusClientClosed is returned when the client could not be reopened after the serial port was borrowed, the
+// client must be opened again before it is used
+var ErrModbusClientClosed = errors.New("the modbus client is closed")
+
+// ModbusTransport executes the requests of the function codes that are not supported by the Modbus library. Over TCP
+// it opens a second connection to the slave next to the connection of the library, the connection is kept open for the
+// next request and only replaced after an error. On a serial line the port is borrowed from the client, the client must
+// be open.
+type ModbusTransport struct {
+	conf          modbus.ClientConfiguration
+	realClient    *modbus.ModbusClient
+	lock          sync.Mutex
+	conn          net.Conn
+	transactionId uint16
+}
+
+func NewModbusTransport(conf *modbus.ClientConfiguration, realClient *modbus.ModbusClient) *ModbusTransport {
+	return &ModbusTransport{
+		conf:       *conf,
+		realClient: realClient,
+	}
+}
+
+// Execute sends the request and returns the response without the function code, an exception response is returned as
+// the matching error of the Modbus library. When the client can't be reopened after a request on a serial line the
+// error wraps ErrModbusClientClosed, the response is lost in that case.
+func (t *ModbusTransport) Execute(slave uint8, functionCode uint8, data []byte) ([]byte, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	scheme, address := "", t.conf.URL
+	if split := strings.SplitN(t.conf.URL, "://", 2); len(split) == 2 {
+		scheme, address = split[0], split[1]
+	}
+	timeout := t.conf.Timeout
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+
+	var pdu []byte
+	var err error
+	switch scheme {
+	case "tcp":
+		pdu, err = t.executeTCP(address, timeout, slave, functionCode, data)
+	case "rtu":
+		pdu, err = t.executeRTU(address, timeout, slave, functionCode, data)
+	default:
+		return nil, fmt.Errorf("unsupported connection scheme %v", scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pdu) == 2 && pdu[0] == functionCode|0x80 {
+		return nil, modbusExceptionError(pdu[1])
+	}
+	if len(pdu) == 0 || pdu[0] != functionCode {
+		return nil, fmt.Errorf("expected a response with function code %d but got %x", functionCode, pdu)
+	}
+	return pdu[1:], nil
+}
+
+// Close closes the connection that is kept open for the next request
+func (t *ModbusTransport) Close() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.conn == nil {
+		return nil
+	}
+	err := t.conn.Close()
+	t.conn = nil
+	return err
+}
+
+// executeTCP sends the request on the open connection or on a new connection when there is none, the connection is
+// closed after an error because the next response on it can belong to this request
+func (t *ModbusTransport) executeTCP(address string, timeout time.Duration, slave uint8, functionCode uint8, data []byte) ([]byte, error) {
+	if t.conn == nil {
+		conn, err := net.DialTimeout("tcp", address, timeout)
+		if err != nil {
+			return nil, err
+		}
+		t.conn = conn
+	}
+	pdu, err := t.exchangeTCP(t.conn, timeout, slave, functionCode, data)
+	if err != nil {
+		t.conn.Close()
+		t.conn = nil
+		return nil, err
+	}
+	return pdu, nil
+}
+
+func (t *ModbusTransport) exchangeTCP(conn net.Conn, timeout time.Duration, slave uint8, functionCode uint8, data []byte) ([]byte, error) {
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	t.transactionId++
+	request := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint16(request[0:2], t.transactionId)
+	binary.BigEndian.PutUint16(request[4:6], uint16(2+len(data)))
+	request[6] = slave
+	request[7] = functionCode
+	if _, err := conn.Write(append(request, data...)); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 7)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint16(header[0:2]) != t.transactionId {
+		return nil, fmt.Errorf("expected transaction id %d but got %d", t.transactionId, binary.BigEndian.Uint16(header[0:2]))
+	}
+	length := binary.BigEndian.Uint16(header[4:6])
+	if length < 2 || length > 254 {
+		return nil, fmt.Errorf("invalid length %d of the response", length)
+	}
+	pdu := make([]byte, length-1)
+	if _, err := io.ReadFull(conn, pdu); err != nil {
+		return nil, err
+	}
+	return pdu, nil
+}
+
+// executeRTU closes the client to use the serial port, the client is reopened afterwards
+func (t *ModbusTransport) executeRTU(address string, timeout time.Duration, slave uint8, functionCode uint8, data []byte) (pdu []byte, err error) {
+	t.realClient.Close()
+	defer func() {
+		if openErr := t.realClient.Open(); openErr != nil {
+			if err != nil {
+				openErr = fmt.Errorf("%v after the request failed with %v", openErr, err)
+			}
+			pdu, err = nil, fmt.Errorf("%w, the error that occurred was %v", ErrModbusClientClosed, openErr)
+		}
+	}()
+
+	parity := "N"
+	switch t.conf.Parity {
+	case modbus.PARITY_EVEN:
+		parity = "E"
+	case modbus.PARITY_ODD:
+		parity = "O"
+	}
+	stopBits := int(t.conf.StopBits)
+	if stopBits == 0 {
+		stopBits = 1
+	}
+	dataBits := int(t.conf.DataBits)
+	if dataBits == 0 {
+		dataBits = 8
+	}
+	port, err := serial.Open(&serial.Config{
+		Address:  address,
+		BaudRate: int(t.conf.Speed),
+		DataBits: dataBits,
+		StopBits: stopBits,
+		Parity:   parity,
+		Timeout:  timeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer port.Close()
+
+	request := append([]byte{slave, functionCode}, data...)
+	crc := ModbusCRC(request)
+	if _, err := port.Write(append(request, byte(crc), byte(crc>>8))); err != nil {
+		return nil, err
+	}
+
+	frame := make([]byte, 0, 256)
+	for {
+		length, err := modbusRTUResponseLength(frame)
+		if err != nil {
+			return nil, err
+		}
+		if length == len(frame) {
+			break
+		}
+		buffer := make([]byte, length-len(frame))
+		if _, err := io.ReadFull(port, buffer); err != nil {
+			if errors.Is(err, serial.ErrTimeout) {
+				return nil, modbus.ErrRequestTimedOut
+			}
+			return nil, err
+		}
+		frame = append(frame, buffer...)
+	}
+	crc = ModbusCRC(frame[:len(frame)-2])
+	if frame[len(frame)-2] != byte(crc) || frame[len(frame)-1] != byte(crc>>8) {
+		return nil, fmt.Errorf("invalid crc of frame %x", frame)
+	}
+	if frame[0] != slave {
+		return nil, modbus.ErrBadUnitId
+	}
+	return frame[1 : len(frame)-2], nil
+}
+
+// modbusRTUResponseLength returns the length of the response frame including the crc, when more bytes are needed to
+// determine the length the returned length is larger than the frame
+func modbusRTUResponseLength(frame []byte) (int, error) {
+	if len(frame) < 2 {
+		return 2, nil
+	}
+	if frame[1]&0x80 != 0 {
+		// slave, function code, exception code and crc
+		return 5, nil
+	}
+	switch frame[1] {
+	case DIAGNOSTICS, GET_COMM_EVENT_COUNTER:
+		return 8, nil
+	case MASK_WRITE_REGISTERS:
+		// slave, function code, address, and mask, or mask and crc
+		return 10, nil
+	case REPORT_SERVER_ID, READ_WRITE_MULTIPLE_REGISTERS:
+		if len(frame) < 3 {
+			return 3, nil
+		}
+		return 3 + int(frame[2]) + 2, nil
+	case READ_DEVICE_IDENTIFICATION_B:
+		// slave, function code, mei type, read device id code, conformity level, more follows, next object id and
+		// number of objects followed by the objects
+		length := 8
+		if len(frame) < length {
+			return length, nil
+		}
+		for i := 0; i < int(frame[7]); i++ {
+			if len(frame) < length+2 {
+				return length + 2, nil
+			}
+			length += 2 + int(frame[length+1])
+		}
+		return length + 2, nil
+	}
+	return 0, fmt.Errorf("unsupported function code %d", frame[1])
+}
+
+// modbusExceptionError returns the error of the Modbus library for the exception code
+func modbusExceptionError(exception uint8) error {
+	switch exception {
+	case MODBUS_EXCEPTION_ILLEGAL_FUNCTION:
+		return modbus.ErrIllegalFunction
+	case MODBUS_EXCEPTION_ILLEGAL_DATA_ADDRESS:
+		return modbus.ErrIllegalDataAddress
+	case MODBUS_EXCEPTION_ILLEGAL_DATA_VALUE:
+		return modbus.ErrIllegalDataValue
+	case MODBUS_EXCEPTION_SERVER_DEVICE_FAILURE:
+		return modbus.ErrServerDeviceFailure
+	case MODBUS_EXCEPTION_ACKNOWLEDGE:
+		return modbus.ErrAcknowledge
+	case MODBUS_EXCEPTION_SERVER_DEVICE_BUSY:
+		return modbus.ErrServerDeviceBusy
+	case MODBUS_EXCEPTION_MEMORY_PARITY_ERROR:
+		return modbus.ErrMemoryParityError
+	case MODBUS_EXCEPTION_GATEWAY_PATH_UNAVAILABLE:
+		return modbus.ErrGWPathUnavailable
+	case MODBUS_EXCEPTION_GATEWAY_TARGET_DEVICE_FAILED_TO_RESPOND:
+		return modbus.ErrGWTargetFailedToRespond
+	}
+	return fmt.Errorf("unknown exception code %d", exception)
+}
+
+// ReadDeviceIdentification returns all objects of the read device id code as id, length and value, the objects are
+// requested again from the next object id until the device has no more objects
+func (t *ModbusTransport) ReadDeviceIdentification(slave uint8, code uint8) ([]byte, error) {
+	var result []byte
+	objectId := uint8(0)
+	for {
+		response, err := t.Execute(slave, READ_DEVICE_IDENTIFICATION_B, []byte{READ_DEVICE_IDENTIFICATION_A, code, objectId})
+		if err != nil {
+			return nil, err
+		}
+		if len(response) < 6 || response[0] != READ_DEVICE_IDENTIFICATION_A {
+			return nil, fmt.Errorf("invalid device identification response %x", response)
+		}
+		objects := response[6:]
+		if _, err := DecodeDeviceIdentification(objects); err != nil {
+			return nil, err
+		}
+		result = append(result, objects...)
+		if response[3] != 0xff || response[4] <= objectId {
+			return result, nil
+		}
+		objectId = response[4]
+	}
+}
+
+// DecodeDeviceIdentification decodes the objects of a device identification response, the values are mapped on the
+// object id
+func DecodeDeviceIdentification(objects []byte) (map[uint8]string, error) {
+	result := make(map[uint8]string)
+	for i := 0; i < len(objects); {
+		if i+2 > len(objects) || i+2+int(objects[i+1]) > len(objects) {
+			return nil, fmt.Errorf("object %d of the device identification is truncated", i)
+		}
+		result[objects[i]] = strings.TrimRight(string(objects[i+2:i+2+int(objects[i+1])]), " \x00")
+		i += 2 + int(objects[i+1])
+	}
+	return result, nil
+}
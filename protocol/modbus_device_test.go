@@ -0,0 +1,202 @@
+package protocol_test
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync/atomic"
+
+	. "github.com/munnik/gosk/protocol"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/simonvetter/modbus"
+)
+
+// serveModbusTCP answers every request on the listener with the response pdu of the handler, the number of accepted
+// connections is counted in connections
+func serveModbusTCP(listener net.Listener, handler func(request []byte) []byte, connections ...*int32) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		for _, c := range connections {
+			atomic.AddInt32(c, 1)
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			for {
+				header := make([]byte, 7)
+				if _, err := io.ReadFull(conn, header); err != nil {
+					return
+				}
+				request := make([]byte, binary.BigEndian.Uint16(header[4:6])-1)
+				if _, err := io.ReadFull(conn, request); err != nil {
+					return
+				}
+				response := handler(request)
+				binary.BigEndian.PutUint16(header[4:6], uint16(len(response)+1))
+				conn.Write(append(header, response...))
+			}
+		}(conn)
+	}
+}
+
+var _ = Describe("Modbus device information", func() {
+	var listener net.Listener
+	var transport *ModbusTransport
+	BeforeEach(func() {
+		var err error
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		transport = NewModbusTransport(&modbus.ClientConfiguration{URL: "tcp://" + listener.Addr().String()}, nil)
+	})
+	AfterEach(func() {
+		transport.Close()
+		listener.Close()
+	})
+
+	read := func(header *ModbusHeader) ([]byte, error) {
+		bytes := make([]byte, 0, 256)
+		n, err := NewModbusClient(nil, header).WithTransport(transport).Read(bytes)
+		if err != nil {
+			return nil, err
+		}
+		return bytes[:n], nil
+	}
+
+	It("reads the device identification until no more objects follow", func() {
+		go serveModbusTCP(listener, func(request []byte) []byte {
+			Expect(request[:3]).To(Equal([]byte{READ_DEVICE_IDENTIFICATION_B, READ_DEVICE_IDENTIFICATION_A, MODBUS_DEVICE_IDENTIFICATION_BASIC}))
+			if request[3] == 0 {
+				return []byte{READ_DEVICE_IDENTIFICATION_B, READ_DEVICE_IDENTIFICATION_A, 0x01, 0x01, 0xff, 0x02, 0x02, 0x00, 0x03, 'A', 'B', 'B', 0x01, 0x02, 'X', '1'}
+			}
+			return []byte{READ_DEVICE_IDENTIFICATION_B, READ_DEVICE_IDENTIFICATION_A, 0x01, 0x01, 0x00, 0x00, 0x01, 0x02, 0x04, 'v', '1', '.', '2'}
+		})
+		result, err := read(&ModbusHeader{Slave: 1, FunctionCode: READ_DEVICE_IDENTIFICATION_B, Address: MODBUS_DEVICE_IDENTIFICATION_BASIC, NumberOfCoilsOrRegisters: 1})
+		Expect(err).NotTo(HaveOccurred())
+		_, objects, err := ExtractModbusHeader(result)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(DecodeDeviceIdentification(objects)).To(Equal(map[uint8]string{
+			MODBUS_DEVICE_OBJECT_VENDOR_NAME:          "ABB",
+			MODBUS_DEVICE_OBJECT_PRODUCT_CODE:         "X1",
+			MODBUS_DEVICE_OBJECT_MAJOR_MINOR_REVISION: "v1.2",
+		}))
+	})
+	It("reads a device identification that is longer than one buffer", func() {
+		value := make([]byte, 200)
+		for i := range value {
+			value[i] = 'A'
+		}
+		go serveModbusTCP(listener, func(request []byte) []byte {
+			objectId := request[3]
+			moreFollows, nextObjectId := byte(0xff), objectId+1
+			if objectId == 5 {
+				moreFollows, nextObjectId = 0x00, 0x00
+			}
+			response := []byte{READ_DEVICE_IDENTIFICATION_B, READ_DEVICE_IDENTIFICATION_A, MODBUS_DEVICE_IDENTIFICATION_REGULAR, 0x02, moreFollows, nextObjectId, 0x01, objectId, byte(len(value))}
+			return append(response, value...)
+		})
+		header := &ModbusHeader{Slave: 1, FunctionCode: READ_DEVICE_IDENTIFICATION_B, Address: MODBUS_DEVICE_IDENTIFICATION_REGULAR, NumberOfCoilsOrRegisters: 1}
+		result, err := NewModbusClient(nil, header).WithTransport(transport).ReadRegisterGroup()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(len(result)).To(BeNumerically(">", 1024))
+		_, objects, err := ExtractModbusHeader(result)
+		Expect(err).NotTo(HaveOccurred())
+		identification, err := DecodeDeviceIdentification(objects)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(identification).To(HaveLen(6))
+
+		_, err = NewModbusClient(nil, header).WithTransport(transport).Read(make([]byte, 0, 1024))
+		Expect(err).To(HaveOccurred())
+	})
+	It("reads a diagnostics counter", func() {
+		go serveModbusTCP(listener, func(request []byte) []byte {
+			return []byte{DIAGNOSTICS, request[1], request[2], 0x01, 0x02}
+		})
+		result, err := read(&ModbusHeader{Slave: 1, FunctionCode: DIAGNOSTICS, Address: MODBUS_DIAGNOSTICS_BUS_MESSAGE_COUNT, NumberOfCoilsOrRegisters: 1})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result[MODBUS_HEADER_LENGTH:]).To(Equal([]byte{0x01, 0x02}))
+	})
+	It("refuses diagnostics sub-functions that change the state of the device", func() {
+		_, err := read(&ModbusHeader{Slave: 1, FunctionCode: DIAGNOSTICS, Address: 0x01, NumberOfCoilsOrRegisters: 1})
+		Expect(err).To(HaveOccurred())
+	})
+	It("reads the server id", func() {
+		go serveModbusTCP(listener, func(request []byte) []byte {
+			return []byte{REPORT_SERVER_ID, 0x04, 0x2a, 0xff, 'P', 'M'}
+		})
+		result, err := read(&ModbusHeader{Slave: 1, FunctionCode: REPORT_SERVER_ID, NumberOfCoilsOrRegisters: 1})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result[MODBUS_HEADER_LENGTH:]).To(Equal([]byte{0x2a, 0xff, 'P', 'M'}))
+	})
+	It("returns the exception of the server", func() {
+		go serveModbusTCP(listener, func(request []byte) []byte {
+			return []byte{GET_COMM_EVENT_COUNTER | 0x80, MODBUS_EXCEPTION_ILLEGAL_FUNCTION}
+		})
+		_, err := read(&ModbusHeader{Slave: 1, FunctionCode: GET_COMM_EVENT_COUNTER, NumberOfCoilsOrRegisters: 1})
+		Expect(ModbusException(err)).To(Equal(uint8(MODBUS_EXCEPTION_ILLEGAL_FUNCTION)))
+	})
+	It("fails on truncated device identification objects", func() {
+		_, err := DecodeDeviceIdentification([]byte{0x00, 0x05, 'A'})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("Modbus commands", func() {
+	var listener net.Listener
+	var transport *ModbusTransport
+	var client *ModbusClient
+	BeforeEach(func() {
+		var err error
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		transport = NewModbusTransport(&modbus.ClientConfiguration{URL: "tcp://" + listener.Addr().String()}, nil)
+		client = NewModbusClient(nil, nil).WithTransport(transport)
+	})
+	AfterEach(func() {
+		transport.Close()
+		listener.Close()
+	})
+
+	It("writes the masks of a register", func() {
+		go serveModbusTCP(listener, func(request []byte) []byte {
+			Expect(request).To(Equal([]byte{MASK_WRITE_REGISTERS, 0x00, 0x04, 0x00, 0xf2, 0x00, 0x25}))
+			return request
+		})
+		registers, err := client.Execute(MaskWriteRegisterCommand(1, 4, 0x00f2, 0x0025))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(registers).To(BeNil())
+	})
+	It("writes and reads multiple registers", func() {
+		go serveModbusTCP(listener, func(request []byte) []byte {
+			Expect(request).To(Equal([]byte{READ_WRITE_MULTIPLE_REGISTERS, 0x00, 0x03, 0x00, 0x02, 0x00, 0x0e, 0x00, 0x01, 0x02, 0x00, 0xff}))
+			return []byte{READ_WRITE_MULTIPLE_REGISTERS, 0x04, 0x00, 0xfe, 0x0a, 0xcd}
+		})
+		registers, err := client.Execute(ReadWriteMultipleRegistersCommand(1, 3, 2, 14, []uint16{0x00ff}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(registers).To(Equal([]uint16{0x00fe, 0x0acd}))
+	})
+	It("uses one connection for all requests", func() {
+		var connections int32
+		go serveModbusTCP(listener, func(request []byte) []byte {
+			return request
+		}, &connections)
+		for i := 0; i < 3; i++ {
+			_, err := client.Execute(MaskWriteRegisterCommand(1, 4, 0x00f2, 0x0025))
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(atomic.LoadInt32(&connections)).To(Equal(int32(1)))
+	})
+	It("returns the exception of the server", func() {
+		go serveModbusTCP(listener, func(request []byte) []byte {
+			return []byte{MASK_WRITE_REGISTERS | 0x80, MODBUS_EXCEPTION_ILLEGAL_DATA_ADDRESS}
+		})
+		_, err := client.Execute(MaskWriteRegisterCommand(1, 4, 0x00f2, 0x0025))
+		Expect(ModbusException(err)).To(Equal(uint8(MODBUS_EXCEPTION_ILLEGAL_DATA_ADDRESS)))
+	})
+	It("refuses to write more registers than allowed", func() {
+		_, err := client.Execute(ReadWriteMultipleRegistersCommand(1, 3, 2, 14, make([]uint16, MODBUS_MAXIMUM_NUMBER_OF_READ_WRITE_REGISTERS+1)))
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -1,10 +1,13 @@
 package protocol_test
 
 import (
+	"fmt"
+
 	"github.com/munnik/gosk/protocol"
 	. "github.com/munnik/gosk/protocol"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/simonvetter/modbus"
 )
 
 var _ = Describe("Modbus protocol functions", func() {
@@ -122,4 +125,128 @@ var _ = Describe("Modbus protocol functions", func() {
 			})
 		})
 	})
+	DescribeTable(
+		"EncodeRegisters",
+		func(value float64, encoding string, wordOrder string, expected []uint16, expectError bool) {
+			result, err := EncodeRegisters(value, encoding, wordOrder)
+			if expectError {
+				Expect(err).To(HaveOccurred())
+			} else {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result).To(Equal(expected))
+			}
+		},
+		Entry("negative int16", -2.0, MODBUS_ENCODING_INT16, MODBUS_WORD_ORDER_BIG_ENDIAN, []uint16{0xfffe}, false),
+		Entry("rounded uint16", 122.6, MODBUS_ENCODING_UINT16, MODBUS_WORD_ORDER_BIG_ENDIAN, []uint16{123}, false),
+		Entry("uint16 out of range", 70000.0, MODBUS_ENCODING_UINT16, MODBUS_WORD_ORDER_BIG_ENDIAN, nil, true),
+		Entry("negative uint16", -1.0, MODBUS_ENCODING_UINT16, MODBUS_WORD_ORDER_BIG_ENDIAN, nil, true),
+		Entry("negative int32", -2.0, MODBUS_ENCODING_INT32, MODBUS_WORD_ORDER_BIG_ENDIAN, []uint16{0xffff, 0xfffe}, false),
+		Entry("uint32 big endian", 123456.0, MODBUS_ENCODING_UINT32, MODBUS_WORD_ORDER_BIG_ENDIAN, []uint16{0x0001, 0xe240}, false),
+		Entry("uint32 little endian", 123456.0, MODBUS_ENCODING_UINT32, MODBUS_WORD_ORDER_LITTLE_ENDIAN, []uint16{0xe240, 0x0001}, false),
+		Entry("float32 big endian", 1.5, MODBUS_ENCODING_FLOAT32, MODBUS_WORD_ORDER_BIG_ENDIAN, []uint16{0x3fc0, 0x0000}, false),
+		Entry("float32 little endian", 1.5, MODBUS_ENCODING_FLOAT32, MODBUS_WORD_ORDER_LITTLE_ENDIAN, []uint16{0x0000, 0x3fc0}, false),
+		Entry("int64 little endian", -2.0, MODBUS_ENCODING_INT64, MODBUS_WORD_ORDER_LITTLE_ENDIAN, []uint16{0xfffe, 0xffff, 0xffff, 0xffff}, false),
+		Entry("float64 big endian", 1.5, MODBUS_ENCODING_FLOAT64, MODBUS_WORD_ORDER_BIG_ENDIAN, []uint16{0x3ff8, 0x0000, 0x0000, 0x0000}, false),
+		Entry("unsupported encoding", 1.5, MODBUS_ENCODING_STRING, MODBUS_WORD_ORDER_BIG_ENDIAN, nil, true),
+		Entry("unsupported word order", 1.5, MODBUS_ENCODING_FLOAT32, "middleEndian", nil, true),
+	)
+	DescribeTable(
+		"DecodeRegisters",
+		func(registers []uint16, encoding string, byteOrder string, wordOrder string, expected interface{}, expectError bool) {
+			result, err := DecodeRegisters(registers, encoding, byteOrder, wordOrder)
+			if expectError {
+				Expect(err).To(HaveOccurred())
+			} else {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result).To(Equal(expected))
+			}
+		},
+		Entry("negative int16", []uint16{0xfffe}, MODBUS_ENCODING_INT16, "", "", int64(-2), false),
+		Entry("uint16", []uint16{0xfffe}, MODBUS_ENCODING_UINT16, "", "", int64(65534), false),
+		Entry("int32 with swapped words", []uint16{0xfffe, 0xffff}, MODBUS_ENCODING_INT32, MODBUS_BYTE_ORDER_BIG_ENDIAN, MODBUS_WORD_ORDER_LITTLE_ENDIAN, int64(-2), false),
+		Entry("uint32", []uint16{0x0001, 0xe240}, MODBUS_ENCODING_UINT32, "", "", int64(123456), false),
+		Entry("float32 with swapped bytes", []uint16{0xc03f, 0x0000}, MODBUS_ENCODING_FLOAT32, MODBUS_BYTE_ORDER_LITTLE_ENDIAN, MODBUS_WORD_ORDER_BIG_ENDIAN, 1.5, false),
+		Entry("float32 with swapped bytes and words", []uint16{0x0000, 0xc03f}, MODBUS_ENCODING_FLOAT32, MODBUS_BYTE_ORDER_LITTLE_ENDIAN, MODBUS_WORD_ORDER_LITTLE_ENDIAN, 1.5, false),
+		Entry("int64", []uint16{0xffff, 0xffff, 0xffff, 0xfffe}, MODBUS_ENCODING_INT64, "", "", int64(-2), false),
+		Entry("float64", []uint16{0x3ff8, 0x0000, 0x0000, 0x0000}, MODBUS_ENCODING_FLOAT64, "", "", 1.5, false),
+		Entry("string", []uint16{0x4341, 0x5400, 0x0000}, MODBUS_ENCODING_STRING, "", "", "CAT", false),
+		Entry("string with swapped bytes", []uint16{0x4143, 0x2054}, MODBUS_ENCODING_STRING, MODBUS_BYTE_ORDER_LITTLE_ENDIAN, "", "CAT", false),
+		Entry("bcd", []uint16{0x0012, 0x3456}, MODBUS_ENCODING_BCD, "", "", int64(123456), false),
+		Entry("invalid bcd", []uint16{0x001a}, MODBUS_ENCODING_BCD, "", "", nil, true),
+		Entry("bitfield", []uint16{0x0001, 0x8002}, MODBUS_ENCODING_BITFIELD, "", "", func() []bool {
+			result := make([]bool, 32)
+			result[1] = true
+			result[15] = true
+			result[16] = true
+			return result
+		}(), false),
+		Entry("wrong number of registers", []uint16{0x0001}, MODBUS_ENCODING_FLOAT32, "", "", nil, true),
+		Entry("unsupported encoding", []uint16{0x0001}, "int8", "", "", nil, true),
+		Entry("unsupported byte order", []uint16{0x0001}, MODBUS_ENCODING_INT16, "middleEndian", "", nil, true),
+	)
+	Describe("ModbusException", func() {
+		It("returns the exception code of a wrapped exception", func() {
+			Expect(ModbusException(fmt.Errorf("error while reading: %w", modbus.ErrIllegalDataAddress))).To(Equal(uint8(MODBUS_EXCEPTION_ILLEGAL_DATA_ADDRESS)))
+			Expect(ModbusException(modbus.ErrServerDeviceBusy)).To(Equal(uint8(MODBUS_EXCEPTION_SERVER_DEVICE_BUSY)))
+		})
+		It("returns 0 when the error is not an exception", func() {
+			Expect(ModbusException(modbus.ErrRequestTimedOut)).To(BeZero())
+		})
+	})
+	DescribeTable(
+		"CoalesceModbusHeaders",
+		func(headers []ModbusHeader, maximumGap uint16, maximumLength uint16, expected []ModbusHeader) {
+			Expect(CoalesceModbusHeaders(headers, maximumGap, maximumLength)).To(Equal(expected))
+		},
+		Entry("adjacent registers",
+			[]ModbusHeader{{1, READ_HOLDING_REGISTERS, 12, 2}, {1, READ_HOLDING_REGISTERS, 10, 2}},
+			uint16(0), uint16(0),
+			[]ModbusHeader{{1, READ_HOLDING_REGISTERS, 10, 4}},
+		),
+		Entry("gap within the threshold",
+			[]ModbusHeader{{1, READ_HOLDING_REGISTERS, 10, 1}, {1, READ_HOLDING_REGISTERS, 15, 2}},
+			uint16(4), uint16(0),
+			[]ModbusHeader{{1, READ_HOLDING_REGISTERS, 10, 7}},
+		),
+		Entry("gap exceeding the threshold",
+			[]ModbusHeader{{1, READ_HOLDING_REGISTERS, 10, 1}, {1, READ_HOLDING_REGISTERS, 16, 2}},
+			uint16(4), uint16(0),
+			[]ModbusHeader{{1, READ_HOLDING_REGISTERS, 10, 1}, {1, READ_HOLDING_REGISTERS, 16, 2}},
+		),
+		Entry("overlapping and duplicate registers",
+			[]ModbusHeader{{1, READ_INPUT_REGISTERS, 10, 4}, {1, READ_INPUT_REGISTERS, 11, 1}, {1, READ_INPUT_REGISTERS, 10, 4}, {1, READ_INPUT_REGISTERS, 13, 2}},
+			uint16(0), uint16(0),
+			[]ModbusHeader{{1, READ_INPUT_REGISTERS, 10, 5}},
+		),
+		Entry("different slaves and function codes",
+			[]ModbusHeader{{2, READ_HOLDING_REGISTERS, 10, 1}, {1, READ_INPUT_REGISTERS, 11, 1}, {1, READ_HOLDING_REGISTERS, 11, 1}},
+			uint16(10), uint16(0),
+			[]ModbusHeader{{1, READ_HOLDING_REGISTERS, 11, 1}, {1, READ_INPUT_REGISTERS, 11, 1}, {2, READ_HOLDING_REGISTERS, 10, 1}},
+		),
+		Entry("maximum length",
+			[]ModbusHeader{{1, READ_HOLDING_REGISTERS, 0, 2}, {1, READ_HOLDING_REGISTERS, 2, 2}, {1, READ_HOLDING_REGISTERS, 4, 2}},
+			uint16(0), uint16(4),
+			[]ModbusHeader{{1, READ_HOLDING_REGISTERS, 0, 4}, {1, READ_HOLDING_REGISTERS, 4, 2}},
+		),
+		Entry("maximum number of registers",
+			[]ModbusHeader{{1, READ_HOLDING_REGISTERS, 0, 100}, {1, READ_HOLDING_REGISTERS, 100, 30}},
+			uint16(0), uint16(1000),
+			[]ModbusHeader{{1, READ_HOLDING_REGISTERS, 0, 100}, {1, READ_HOLDING_REGISTERS, 100, 30}},
+		),
+		Entry("coils exceed the maximum number of registers",
+			[]ModbusHeader{{1, READ_COILS, 0, 100}, {1, READ_COILS, 100, 30}, {1, READ_COILS, 131, 0}},
+			uint16(1), uint16(0),
+			[]ModbusHeader{{1, READ_COILS, 0, 132}},
+		),
+	)
+	Describe("PackCoils", func() {
+		It("packs the first coil in the least significant bit", func() {
+			Expect(PackCoils([]bool{true, false, true, false, false, false, false, false, false, true})).To(Equal([]byte{0x05, 0x02}))
+		})
+	})
+	Describe("ModbusCRC", func() {
+		It("calculates the checksum of a frame", func() {
+			Expect(ModbusCRC([]byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x0a})).To(Equal(uint16(0xcdc5)))
+		})
+	})
 })
@@ -0,0 +1,209 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// LWE_HEADER starts a datagram with NMEA 0183 sentences according to IEC 61162-450
+	LWE_HEADER = "UdPbC\x00"
+
+	TAG_BLOCK_DELIMITER = '\\'
+
+	// timestamps in tag blocks are in seconds, some devices use milliseconds which is detected by the size
+	tagBlockMillisecondsThreshold = 1e11
+)
+
+// TagBlock contains the parameters of a NMEA 4.x / IEC 61162-450 tag block, e.g. \s:GP0001,c:1577836800*5B\
+type TagBlock struct {
+	Source       string    // s: source identification
+	Destination  string    // d: destination identification
+	Timestamp    time.Time // c: time of the sentence, zero when not set
+	LineCount    int64     // n: line count, -1 when not set
+	Text         string    // t: text
+	Group        *TagBlockGroup
+	RelativeTime int64 // r: relative time, -1 when not set
+}
+
+// TagBlockGroup identifies a sentence that is part of a group of sentences, g:1-2-123 is line 1 of 2 of group 123
+type TagBlockGroup struct {
+	Line  int
+	Total int
+	ID    string
+}
+
+// SplitTagBlock removes the tag block from the line and validates the checksum of the tag block, nil is returned when
+// the line has no tag block
+func SplitTagBlock(line []byte) (*TagBlock, []byte, error) {
+	if len(line) == 0 || line[0] != TAG_BLOCK_DELIMITER {
+		return nil, line, nil
+	}
+	end := bytes.IndexByte(line[1:], TAG_BLOCK_DELIMITER)
+	if end == -1 {
+		return nil, line, fmt.Errorf("the tag block of %q is not closed", line)
+	}
+	tagBlock, err := ParseTagBlock(string(line[1 : end+1]))
+	return tagBlock, line[end+2:], err
+}
+
+// ParseTagBlock parses the content of a tag block, without the delimiters
+func ParseTagBlock(s string) (*TagBlock, error) {
+	checksumIndex := strings.LastIndexByte(s, '*')
+	if checksumIndex == -1 {
+		return nil, fmt.Errorf("the tag block %q has no checksum", s)
+	}
+	content := s[:checksumIndex]
+	if checksum := TagBlockChecksum(content); !strings.EqualFold(checksum, s[checksumIndex+1:]) {
+		return nil, fmt.Errorf("the checksum of the tag block %q should be %s", s, checksum)
+	}
+
+	result := &TagBlock{LineCount: -1, RelativeTime: -1}
+	for _, parameter := range strings.Split(content, ",") {
+		key, value, ok := strings.Cut(parameter, ":")
+		if !ok {
+			return nil, fmt.Errorf("the parameter %q of the tag block %q is not valid", parameter, s)
+		}
+		var err error
+		switch key {
+		case "s":
+			result.Source = value
+		case "d":
+			result.Destination = value
+		case "t":
+			result.Text = value
+		case "c":
+			var c int64
+			if c, err = strconv.ParseInt(value, 10, 64); err == nil {
+				if c > tagBlockMillisecondsThreshold {
+					result.Timestamp = time.UnixMilli(c)
+				} else {
+					result.Timestamp = time.Unix(c, 0)
+				}
+			}
+		case "n":
+			result.LineCount, err = strconv.ParseInt(value, 10, 64)
+		case "r":
+			result.RelativeTime, err = strconv.ParseInt(value, 10, 64)
+		case "g":
+			result.Group, err = parseTagBlockGroup(value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("the parameter %q of the tag block %q is not valid: %v", parameter, s, err)
+		}
+	}
+	return result, nil
+}
+
+func parseTagBlockGroup(s string) (*TagBlockGroup, error) {
+	parts := strings.SplitN(s, "-", 3)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("expected line-total-id")
+	}
+	line, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	total, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	if line < 1 || line > total {
+		return nil, fmt.Errorf("line %d is not part of a group of %d lines", line, total)
+	}
+	return &TagBlockGroup{Line: line, Total: total, ID: parts[2]}, nil
+}
+
+// TagBlockChecksum is the hexadecimal XOR of all characters of the tag block content
+func TagBlockChecksum(content string) string {
+	var checksum byte
+	for i := 0; i < len(content); i++ {
+		checksum ^= content[i]
+	}
+	return fmt.Sprintf("%02X", checksum)
+}
+
+// TagBlockGroups completes the tag blocks of grouped sentences, only the first line of a group has the source and the
+// timestamp so these are copied to the other lines of the group
+type TagBlockGroups struct {
+	mu      sync.Mutex
+	timeout time.Duration
+	groups  map[string]tagBlockGroupState
+}
+
+type tagBlockGroupState struct {
+	first    TagBlock
+	received time.Time
+}
+
+func NewTagBlockGroups(timeout time.Duration) *TagBlockGroups {
+	return &TagBlockGroups{timeout: timeout, groups: make(map[string]tagBlockGroupState)}
+}
+
+// Complete fills the missing source and timestamp of the tag block with the values of the first line of the group,
+// the returned bool is true when the tag block is the last line of the group
+func (g *TagBlockGroups) Complete(t *TagBlock, now time.Time) bool {
+	if t == nil || t.Group == nil {
+		return true
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for id, state := range g.groups {
+		if now.Sub(state.received) > g.timeout {
+			delete(g.groups, id)
+		}
+	}
+
+	if t.Group.Line == 1 {
+		g.groups[t.Group.ID] = tagBlockGroupState{first: *t, received: now}
+	} else if state, ok := g.groups[t.Group.ID]; ok {
+		if t.Source == "" {
+			t.Source = state.first.Source
+		}
+		if t.Timestamp.IsZero() {
+			t.Timestamp = state.first.Timestamp
+		}
+	}
+	if t.Group.Line == t.Group.Total {
+		delete(g.groups, t.Group.ID)
+		return true
+	}
+	return false
+}
+
+// LineCounts detects duplicate sentences by the line count of the source, IEC 61162-450 networks can be redundant so
+// the same sentence is received more than once
+type LineCounts struct {
+	mu     sync.Mutex
+	size   int
+	recent map[string][]int64
+}
+
+func NewLineCounts(size int) *LineCounts {
+	return &LineCounts{size: size, recent: make(map[string][]int64)}
+}
+
+// IsDuplicate returns true when the line count of the source was recently received, the line count is stored
+// otherwise
+func (l *LineCounts) IsDuplicate(source string, lineCount int64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	recent := l.recent[source]
+	for _, n := range recent {
+		if n == lineCount {
+			return true
+		}
+	}
+	if len(recent) >= l.size {
+		recent = recent[1:]
+	}
+	l.recent[source] = append(recent, lineCount)
+	return false
+}
@@ -0,0 +1,83 @@
+package protocol_test
+
+import (
+	"time"
+
+	. "github.com/munnik/gosk/protocol"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NMEA 0183 protocol functions", func() {
+	tagBlock := func(content string) string {
+		return "\\" + content + "*" + TagBlockChecksum(content) + "\\"
+	}
+
+	Describe("SplitTagBlock", func() {
+		It("returns the line when there is no tag block", func() {
+			t, sentence, err := SplitTagBlock([]byte("$GPHDT,123.4,T*31"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(t).To(BeNil())
+			Expect(sentence).To(Equal([]byte("$GPHDT,123.4,T*31")))
+		})
+		It("parses the parameters of the tag block", func() {
+			t, sentence, err := SplitTagBlock([]byte(tagBlock("s:GP0001,d:EI0002,c:1577836800,n:42,g:1-2-7") + "$GPHDT,123.4,T*31"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sentence).To(Equal([]byte("$GPHDT,123.4,T*31")))
+			Expect(t.Source).To(Equal("GP0001"))
+			Expect(t.Destination).To(Equal("EI0002"))
+			Expect(t.Timestamp).To(Equal(time.Unix(1577836800, 0)))
+			Expect(t.LineCount).To(Equal(int64(42)))
+			Expect(t.RelativeTime).To(Equal(int64(-1)))
+			Expect(*t.Group).To(Equal(TagBlockGroup{Line: 1, Total: 2, ID: "7"}))
+		})
+		It("detects timestamps in milliseconds", func() {
+			t, _, err := SplitTagBlock([]byte(tagBlock("c:1577836800123") + "$GPHDT,123.4,T*31"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(t.Timestamp).To(Equal(time.UnixMilli(1577836800123)))
+		})
+		It("rejects a tag block with an invalid checksum", func() {
+			_, _, err := SplitTagBlock([]byte("\\s:GP0001*00\\$GPHDT,123.4,T*31"))
+			Expect(err).To(HaveOccurred())
+		})
+		It("rejects a tag block that is not closed", func() {
+			_, _, err := SplitTagBlock([]byte("\\s:GP0001*00$GPHDT,123.4,T*31"))
+			Expect(err).To(HaveOccurred())
+		})
+		It("rejects an invalid group", func() {
+			_, _, err := SplitTagBlock([]byte(tagBlock("g:3-2-7") + "$GPHDT,123.4,T*31"))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Describe("TagBlockGroups", func() {
+		It("copies the source and timestamp of the first line to the other lines of the group", func() {
+			now := time.Now()
+			groups := NewTagBlockGroups(time.Second)
+			first := &TagBlock{Source: "AI0001", Timestamp: time.Unix(1577836800, 0), Group: &TagBlockGroup{Line: 1, Total: 2, ID: "7"}}
+			second := &TagBlock{Group: &TagBlockGroup{Line: 2, Total: 2, ID: "7"}}
+			Expect(groups.Complete(first, now)).To(BeFalse())
+			Expect(groups.Complete(second, now)).To(BeTrue())
+			Expect(second.Source).To(Equal("AI0001"))
+			Expect(second.Timestamp).To(Equal(first.Timestamp))
+		})
+		It("doesn't complete lines after the timeout", func() {
+			now := time.Now()
+			groups := NewTagBlockGroups(time.Second)
+			groups.Complete(&TagBlock{Source: "AI0001", Group: &TagBlockGroup{Line: 1, Total: 2, ID: "7"}}, now)
+			second := &TagBlock{Group: &TagBlockGroup{Line: 2, Total: 2, ID: "7"}}
+			groups.Complete(second, now.Add(2*time.Second))
+			Expect(second.Source).To(BeEmpty())
+		})
+	})
+	Describe("LineCounts", func() {
+		It("detects recently received line counts of the same source", func() {
+			lineCounts := NewLineCounts(2)
+			Expect(lineCounts.IsDuplicate("GP0001", 1)).To(BeFalse())
+			Expect(lineCounts.IsDuplicate("GP0002", 1)).To(BeFalse())
+			Expect(lineCounts.IsDuplicate("GP0001", 1)).To(BeTrue())
+			Expect(lineCounts.IsDuplicate("GP0001", 2)).To(BeFalse())
+			Expect(lineCounts.IsDuplicate("GP0001", 3)).To(BeFalse())
+			Expect(lineCounts.IsDuplicate("GP0001", 1)).To(BeFalse())
+		})
+	})
+})
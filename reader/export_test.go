@@ -0,0 +1,36 @@
+package reader
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/munnik/gosk/message"
+)
+
+var FullDataModelToUpdates = fullDataModelToUpdates
+
+func IsPatternMatch(path string, pattern string) bool {
+	return compilePathPattern(pattern).MatchString(path)
+}
+
+// ToMapped converts the JSON encoded delta of a server
+func (r *SignalKReader) ToMapped(delta string, now time.Time) (*message.Mapped, error) {
+	var d signalKDelta
+	if err := json.Unmarshal([]byte(delta), &d); err != nil {
+		return nil, err
+	}
+	return r.toMapped(d, now), nil
+}
+
+// FullDataModelToMapped converts the JSON encoded full data model of self
+func (r *SignalKReader) FullDataModelToMapped(model string, now time.Time) (*message.Mapped, error) {
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(model), &m); err != nil {
+		return nil, err
+	}
+	return r.fullDataModelToMapped(m, now), nil
+}
+
+func (r *SignalKReader) SetSelf(self string) {
+	r.setSelf(self)
+}
@@ -35,16 +35,18 @@ type MqttReader struct {
 	mqttTransferRequestUpdatesSent prometheus.Counter
 }
 
-func NewMqttReader(c *config.MQTTConfig) *MqttReader {
+// NewMqttReader creates the reader, the metrics are registered with reg
+func NewMqttReader(c *config.MQTTConfig, reg prometheus.Registerer) *MqttReader {
+	factory := promauto.With(reg)
 	decoder, _ := zstd.NewReader(nil)
 	return &MqttReader{
 		mqttConfig:                     c,
 		decoder:                        decoder,
-		mqttMessagesReceived:           promauto.NewCounter(prometheus.CounterOpts{Name: "gosk_mqtt_messages_received_total", Help: "total number of received mqtt messages"}),
-		mqttMessagesDecompressed:       promauto.NewCounter(prometheus.CounterOpts{Name: "gosk_mqtt_messages_decompressed_total", Help: "total number of decompressed mqtt messages"}),
-		mqttMessagesUnmarshalled:       promauto.NewCounter(prometheus.CounterOpts{Name: "gosk_mqtt_messages_unmarshalled_total", Help: "total number of unmarshalled mqtt messages"}),
-		mqttTotalUpdatesSent:           promauto.NewCounter(prometheus.CounterOpts{Name: "gosk_mqtt_updates_sent_total", Help: "total number of updates sent"}),
-		mqttTransferRequestUpdatesSent: promauto.NewCounter(prometheus.CounterOpts{Name: "gosk_mqtt_updates_sent_transfer_request", Help: "number of updates sent via a transfer request"}),
+		mqttMessagesReceived:           factory.NewCounter(prometheus.CounterOpts{Name: "gosk_mqtt_messages_received_total", Help: "total number of received mqtt messages"}),
+		mqttMessagesDecompressed:       factory.NewCounter(prometheus.CounterOpts{Name: "gosk_mqtt_messages_decompressed_total", Help: "total number of decompressed mqtt messages"}),
+		mqttMessagesUnmarshalled:       factory.NewCounter(prometheus.CounterOpts{Name: "gosk_mqtt_messages_unmarshalled_total", Help: "total number of unmarshalled mqtt messages"}),
+		mqttTotalUpdatesSent:           factory.NewCounter(prometheus.CounterOpts{Name: "gosk_mqtt_updates_sent_total", Help: "total number of updates sent"}),
+		mqttTransferRequestUpdatesSent: factory.NewCounter(prometheus.CounterOpts{Name: "gosk_mqtt_updates_sent_transfer_request", Help: "number of updates sent via a transfer request"}),
 	}
 }
 
@@ -0,0 +1,408 @@
+package reader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/munnik/gosk/config"
+	"github.com/munnik/gosk/logger"
+	"github.com/munnik/gosk/message"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.nanomsg.org/mangos/v3"
+	"go.uber.org/zap"
+	"nhooyr.io/websocket"
+	"nhooyr.io/websocket/wsjson"
+)
+
+const (
+	signalKStreamPath  = "/signalk/v1/stream"
+	signalKAPIPath     = "/signalk/v1/api/vessels/self"
+	signalKSelfContext = "vessels.self"
+	signalKSourceType  = "signalk" // type of the source when the server doesn't send one
+
+	// maximum size of a message from the server, the default of the websocket library is too small for the cached
+	// values that are sent after connecting
+	signalKReadLimit = 1024 * 1024
+)
+
+// signalKDelta is a delta or a hello message of a SignalK server
+type signalKDelta struct {
+	Context string          `json:"context"`
+	Self    string          `json:"self"` // only set in the hello message
+	Updates []signalKUpdate `json:"updates"`
+}
+
+type signalKUpdate struct {
+	Source    *signalKSource      `json:"source"`
+	SourceRef string              `json:"$source"`
+	Timestamp time.Time           `json:"timestamp"`
+	Values    []signalKValue      `json:"values"`
+	Meta      []message.MetaValue `json:"meta"`
+}
+
+type signalKSource struct {
+	Label string `json:"label"`
+	Type  string `json:"type"`
+}
+
+// signalKValue is decoded by the reader, values that can't be decoded are skipped instead of the whole delta
+type signalKValue struct {
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+type signalKSubscribeMessage struct {
+	Context   string                    `json:"context"`
+	Subscribe []signalKSubscribeSection `json:"subscribe"`
+}
+
+type signalKSubscribeSection struct {
+	Path      string `json:"path"`
+	Period    int64  `json:"period,omitempty"`    // in milliseconds
+	MinPeriod int64  `json:"minPeriod,omitempty"` // in milliseconds
+	Policy    string `json:"policy,omitempty"`
+}
+
+// SignalKReader receives the deltas of another SignalK server, like signalk-server or another gosk instance
+type SignalKReader struct {
+	config         *config.SignalKReaderConfig
+	publisher      mangos.Socket
+	mu             sync.Mutex
+	self           string           // self context of the server, received in the hello message
+	paths          []*regexp.Regexp // compiled path patterns of the subscriptions, in the order of the configuration
+	deltasReceived prometheus.Counter
+	updatesSent    prometheus.Counter
+	reconnects     prometheus.Counter
+}
+
+// NewSignalKReader creates the reader, the metrics are registered with reg
+func NewSignalKReader(c *config.SignalKReaderConfig, reg prometheus.Registerer) *SignalKReader {
+	factory := promauto.With(reg)
+	paths := make([]*regexp.Regexp, 0, len(c.Subscribe))
+	for _, s := range c.Subscribe {
+		paths = append(paths, compilePathPattern(s.Path))
+	}
+	return &SignalKReader{
+		config:         c,
+		self:           c.Context,
+		paths:          paths,
+		deltasReceived: factory.NewCounter(prometheus.CounterOpts{Name: "gosk_signalk_deltas_received_total", Help: "total number of deltas received from the SignalK server"}),
+		updatesSent:    factory.NewCounter(prometheus.CounterOpts{Name: "gosk_signalk_updates_sent_total", Help: "total number of updates sent"}),
+		reconnects:     factory.NewCounter(prometheus.CounterOpts{Name: "gosk_signalk_reconnects_total", Help: "total number of reconnects to the SignalK server"}),
+	}
+}
+
+func (r *SignalKReader) ReadMapped(publisher mangos.Socket) {
+	r.publisher = publisher
+
+	if r.config.PollInterval > 0 {
+		go r.poll()
+	}
+
+	backoff := r.config.MinBackoff
+	for {
+		connected, err := r.stream()
+		if connected {
+			backoff = r.config.MinBackoff
+		}
+		logger.GetLogger().Warn(
+			"Connection with the SignalK server lost, reconnecting",
+			zap.String("URL", r.streamURL()),
+			zap.Duration("Backoff", backoff),
+			zap.String("Error", err.Error()),
+		)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > r.config.MaxBackoff {
+			backoff = r.config.MaxBackoff
+		}
+		r.reconnects.Inc()
+	}
+}
+
+// stream reads the deltas from the websocket until the connection is lost, the returned bool indicates that the
+// connection was established
+func (r *SignalKReader) stream() (bool, error) {
+	ctx := context.Background()
+	c, _, err := websocket.Dial(ctx, r.streamURL(), nil)
+	if err != nil {
+		return false, err
+	}
+	defer c.Close(websocket.StatusNormalClosure, "")
+	c.SetReadLimit(signalKReadLimit)
+
+	for _, m := range r.subscribeMessages() {
+		if err := wsjson.Write(ctx, c, m); err != nil {
+			return true, err
+		}
+	}
+
+	for {
+		_, data, err := c.Read(ctx)
+		if err != nil {
+			return true, err
+		}
+		var delta signalKDelta
+		if err := json.Unmarshal(data, &delta); err != nil {
+			logger.GetLogger().Warn(
+				"Could not unmarshal the received data",
+				zap.ByteString("Received", data),
+				zap.String("Error", err.Error()),
+			)
+			continue
+		}
+		if delta.Self != "" {
+			r.setSelf(delta.Self)
+		}
+		if len(delta.Updates) == 0 {
+			continue
+		}
+		r.deltasReceived.Inc()
+		r.publish(r.toMapped(delta, time.Now()))
+	}
+}
+
+func (r *SignalKReader) streamURL() string {
+	u := *r.config.URL
+	if u.Scheme == "https" {
+		u.Scheme = "wss"
+	} else {
+		u.Scheme = "ws"
+	}
+	u.Path = signalKStreamPath
+	if len(r.config.Subscribe) > 0 {
+		// the subscriptions are sent after connecting
+		u.RawQuery = "subscribe=none"
+	}
+	return u.String()
+}
+
+// subscribeMessages creates a subscribe message per context
+func (r *SignalKReader) subscribeMessages() []signalKSubscribeMessage {
+	result := make([]signalKSubscribeMessage, 0)
+	contexts := make(map[string]int)
+	for _, s := range r.config.Subscribe {
+		i, ok := contexts[s.Context]
+		if !ok {
+			result = append(result, signalKSubscribeMessage{Context: s.Context})
+			i = len(result) - 1
+			contexts[s.Context] = i
+		}
+		result[i].Subscribe = append(result[i].Subscribe, signalKSubscribeSection{
+			Path:      s.Path,
+			Period:    s.Period.Milliseconds(),
+			MinPeriod: s.MinPeriod.Milliseconds(),
+			Policy:    s.Policy,
+		})
+	}
+	return result
+}
+
+// setSelf stores the self context of the server, unless the context is configured
+func (r *SignalKReader) setSelf(self string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.config.Context != "" {
+		return
+	}
+	if !strings.HasPrefix(self, "vessels.") {
+		self = "vessels." + self
+	}
+	r.self = self
+}
+
+func (r *SignalKReader) selfContext() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.self == "" {
+		return signalKSelfContext
+	}
+	return r.self
+}
+
+// toMapped converts the delta of the server, deltas without a context are about self and updates without a timestamp
+// get the current time
+func (r *SignalKReader) toMapped(delta signalKDelta, now time.Time) *message.Mapped {
+	self := r.selfContext()
+	mappedContext := delta.Context
+	if mappedContext == "" || mappedContext == signalKSelfContext {
+		mappedContext = self
+	}
+
+	result := message.NewMapped().WithContext(mappedContext).WithOrigin(self)
+	for _, u := range delta.Updates {
+		s := message.NewSource().WithLabel(u.SourceRef).WithType(signalKSourceType).WithUuid(uuid.New())
+		if u.Source != nil {
+			if s.Label == "" {
+				s.WithLabel(u.Source.Label)
+			}
+			if u.Source.Type != "" {
+				s.WithType(u.Source.Type)
+			}
+		}
+		timestamp := u.Timestamp
+		if timestamp.IsZero() {
+			timestamp = now
+		}
+
+		update := message.NewUpdate().WithSource(*s).WithTimestamp(timestamp)
+		for _, v := range u.Values {
+			value, err := message.Decode(v.Value)
+			if err != nil {
+				logger.GetLogger().Warn(
+					"Could not decode the value",
+					zap.String("Path", v.Path),
+					zap.String("Error", err.Error()),
+				)
+				continue
+			}
+			update.AddValue(message.NewValue().WithPath(v.Path).WithValue(value))
+		}
+		update.Meta = u.Meta
+		if len(update.Values) > 0 || len(update.Meta) > 0 {
+			result.AddUpdate(update)
+		}
+	}
+	return result
+}
+
+func (r *SignalKReader) publish(mapped *message.Mapped) {
+	if len(mapped.Updates) == 0 {
+		return
+	}
+	bytes, err := json.Marshal(mapped)
+	if err != nil {
+		logger.GetLogger().Warn(
+			"Could not marshal delta",
+			zap.String("Error", err.Error()),
+		)
+		return
+	}
+	if err := r.publisher.Send(bytes); err != nil {
+		logger.GetLogger().Warn(
+			"Unable to send the message using NanoMSG",
+			zap.ByteString("Message", bytes),
+			zap.String("Error", err.Error()),
+		)
+		return
+	}
+	r.updatesSent.Add(float64(len(mapped.Updates)))
+}
+
+// poll requests the full data model of self periodically, for values that are not sent in the stream
+func (r *SignalKReader) poll() {
+	client := &http.Client{Timeout: r.config.PollInterval}
+	ticker := time.NewTicker(r.config.PollInterval)
+	for range ticker.C {
+		if err := r.requestFullDataModel(client); err != nil {
+			logger.GetLogger().Warn(
+				"Could not request the full data model",
+				zap.String("URL", r.config.URLString),
+				zap.String("Error", err.Error()),
+			)
+		}
+	}
+}
+
+func (r *SignalKReader) requestFullDataModel(client *http.Client) error {
+	u := *r.config.URL
+	u.Path = signalKAPIPath
+	response, err := client.Get(u.String())
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", response.Status)
+	}
+
+	var model map[string]interface{}
+	if err := json.NewDecoder(response.Body).Decode(&model); err != nil {
+		return err
+	}
+	r.publish(r.fullDataModelToMapped(model, time.Now()))
+	return nil
+}
+
+// fullDataModelToMapped converts the full data model of self, only the subscribed paths are used
+func (r *SignalKReader) fullDataModelToMapped(model map[string]interface{}, now time.Time) *message.Mapped {
+	delta := signalKDelta{Context: signalKSelfContext}
+	for _, u := range fullDataModelToUpdates("", model) {
+		if r.isSubscribedTo(u.Values[0].Path) {
+			delta.Updates = append(delta.Updates, u)
+		}
+	}
+	return r.toMapped(delta, now)
+}
+
+// isSubscribedTo returns true when the path of self matches one of the subscriptions, or no subscriptions are
+// configured
+func (r *SignalKReader) isSubscribedTo(path string) bool {
+	if len(r.config.Subscribe) == 0 {
+		return true
+	}
+	for i, s := range r.config.Subscribe {
+		if s.Context != signalKSelfContext && s.Context != r.selfContext() {
+			continue
+		}
+		if r.paths[i].MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// fullDataModelToUpdates creates an update for each object with a value in the full data model
+func fullDataModelToUpdates(prefix string, node map[string]interface{}) []signalKUpdate {
+	if value, ok := node["value"]; ok {
+		u := signalKUpdate{Values: []signalKValue{{Path: prefix, Value: value}}}
+		if timestamp, ok := node["timestamp"].(string); ok {
+			u.Timestamp, _ = time.Parse(time.RFC3339Nano, timestamp)
+		}
+		if sourceRef, ok := node["$source"].(string); ok {
+			u.SourceRef = sourceRef
+		}
+		if source, ok := node["source"].(map[string]interface{}); ok {
+			u.Source = &signalKSource{}
+			u.Source.Label, _ = source["label"].(string)
+			u.Source.Type, _ = source["type"].(string)
+		}
+		return []signalKUpdate{u}
+	}
+
+	keys := make([]string, 0, len(node))
+	for key := range node {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := make([]signalKUpdate, 0)
+	for _, key := range keys {
+		child, ok := node[key].(map[string]interface{})
+		if !ok || key == "meta" {
+			continue
+		}
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		result = append(result, fullDataModelToUpdates(path, child)...)
+	}
+	return result
+}
+
+// compilePathPattern returns the expression of the pattern of a subscription, a * in the pattern matches any number of
+// characters
+func compilePathPattern(pattern string) *regexp.Regexp {
+	return regexp.MustCompile("^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, `.*`) + "$")
+}
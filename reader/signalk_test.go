@@ -0,0 +1,178 @@
+package reader_test
+
+import (
+	"time"
+
+	"github.com/munnik/gosk/config"
+	"github.com/munnik/gosk/message"
+	. "github.com/munnik/gosk/reader"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ = Describe("SignalK reader deltas", func() {
+	now := time.Date(2022, 5, 10, 12, 0, 0, 0, time.UTC)
+	newReader := func(context string) *SignalKReader {
+		return NewSignalKReader(&config.SignalKReaderConfig{Context: context}, prometheus.NewRegistry())
+	}
+
+	DescribeTable("Context",
+		func(configured string, hello string, deltaContext string, expectedContext string, expectedOrigin string) {
+			r := newReader(configured)
+			if hello != "" {
+				r.SetSelf(hello)
+			}
+			result, err := r.ToMapped(`{"context":"`+deltaContext+`","updates":[{"values":[{"path":"navigation.speedOverGround","value":3.2}]}]}`, now)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.Context).To(Equal(expectedContext))
+			Expect(result.Origin).To(Equal(expectedOrigin))
+		},
+		Entry("Without self", "", "", "", "vessels.self", "vessels.self"),
+		Entry("Self of the hello message", "", "urn:mrn:imo:mmsi:123456789", "", "vessels.urn:mrn:imo:mmsi:123456789", "vessels.urn:mrn:imo:mmsi:123456789"),
+		Entry("Self of the hello message with vessels prefix", "", "vessels.urn:mrn:imo:mmsi:123456789", "vessels.self", "vessels.urn:mrn:imo:mmsi:123456789", "vessels.urn:mrn:imo:mmsi:123456789"),
+		Entry("Configured self replaces the hello message", "vessels.urn:mrn:imo:mmsi:987654321", "urn:mrn:imo:mmsi:123456789", "vessels.self", "vessels.urn:mrn:imo:mmsi:987654321", "vessels.urn:mrn:imo:mmsi:987654321"),
+		Entry("Other context is kept", "vessels.urn:mrn:imo:mmsi:987654321", "", "vessels.urn:mrn:imo:mmsi:244123456", "vessels.urn:mrn:imo:mmsi:244123456", "vessels.urn:mrn:imo:mmsi:987654321"),
+	)
+
+	DescribeTable("Source",
+		func(update string, expectedLabel string, expectedType string) {
+			result, err := newReader("").ToMapped(`{"updates":[`+update+`]}`, now)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.Updates).To(HaveLen(1))
+			Expect(result.Updates[0].Source.Label).To(Equal(expectedLabel))
+			Expect(result.Updates[0].Source.Type).To(Equal(expectedType))
+		},
+		Entry("$source", `{"$source":"nmea.GP","values":[{"path":"navigation.speedOverGround","value":3.2}]}`, "nmea.GP", "signalk"),
+		Entry("source", `{"source":{"label":"nmea","type":"NMEA0183"},"values":[{"path":"navigation.speedOverGround","value":3.2}]}`, "nmea", "NMEA0183"),
+		Entry("$source has precedence over the label of source", `{"$source":"nmea.GP","source":{"label":"nmea","type":"NMEA0183"},"values":[{"path":"navigation.speedOverGround","value":3.2}]}`, "nmea.GP", "NMEA0183"),
+		Entry("source without type", `{"source":{"label":"nmea"},"values":[{"path":"navigation.speedOverGround","value":3.2}]}`, "nmea", "signalk"),
+		Entry("Without source", `{"values":[{"path":"navigation.speedOverGround","value":3.2}]}`, "", "signalk"),
+	)
+
+	It("uses the timestamp of the update", func() {
+		result, err := newReader("").ToMapped(`{"updates":[{"timestamp":"2022-05-10T11:59:58.123Z","values":[{"path":"navigation.speedOverGround","value":3.2}]}]}`, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Updates[0].Timestamp).To(Equal(time.Date(2022, 5, 10, 11, 59, 58, 123000000, time.UTC)))
+	})
+	It("uses the current time for updates without timestamp", func() {
+		result, err := newReader("").ToMapped(`{"updates":[{"values":[{"path":"navigation.speedOverGround","value":3.2}]}]}`, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Updates[0].Timestamp).To(Equal(now))
+	})
+	It("decodes the values", func() {
+		result, err := newReader("").ToMapped(`{"updates":[{"values":[{"path":"navigation.speedOverGround","value":3.2},{"path":"navigation.position","value":{"latitude":52.5,"longitude":4.25}},{"path":"name","value":"Gosk"}]}]}`, now)
+		Expect(err).ToNot(HaveOccurred())
+		latitude, longitude := 52.5, 4.25
+		Expect(result.Updates[0].Values).To(Equal([]message.Value{
+			*message.NewValue().WithPath("navigation.speedOverGround").WithValue(3.2),
+			*message.NewValue().WithPath("navigation.position").WithValue(message.Position{Latitude: &latitude, Longitude: &longitude}),
+			*message.NewValue().WithPath("name").WithValue("Gosk"),
+		}))
+	})
+	It("keeps updates with only metadata and skips empty updates", func() {
+		result, err := newReader("").ToMapped(`{"updates":[{"values":[]},{"meta":[{"path":"navigation.speedOverGround","value":{"units":"m/s"}}]}]}`, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Updates).To(HaveLen(1))
+		Expect(result.Updates[0].Values).To(BeEmpty())
+		Expect(result.Updates[0].Meta).To(HaveLen(1))
+		Expect(result.Updates[0].Meta[0].Path).To(Equal("navigation.speedOverGround"))
+	})
+})
+
+var _ = Describe("SignalK reader full data model", func() {
+	now := time.Date(2022, 5, 10, 12, 0, 0, 0, time.UTC)
+	model := map[string]interface{}{
+		"uuid": "urn:mrn:signalk:uuid:c0d79334-4e25-4245-8892-54e8ccc8021d",
+		"name": "Gosk",
+		"navigation": map[string]interface{}{
+			"speedOverGround": map[string]interface{}{
+				"value":     3.2,
+				"timestamp": "2022-05-10T11:59:58.123Z",
+				"$source":   "nmea.GP",
+				"meta":      map[string]interface{}{"units": "m/s"},
+			},
+			"headingTrue": map[string]interface{}{
+				"value":  1.0,
+				"source": map[string]interface{}{"label": "compass", "type": "NMEA2000"},
+			},
+		},
+		"propulsion": map[string]interface{}{
+			"port": map[string]interface{}{
+				"revolutions": map[string]interface{}{"value": 12.5},
+			},
+		},
+	}
+
+	It("creates an update for each value", func() {
+		result := FullDataModelToUpdates("", model)
+		Expect(result).To(HaveLen(3))
+
+		// the keys are sorted
+		Expect(result[0].Values[0].Path).To(Equal("navigation.headingTrue"))
+		Expect(result[0].Values[0].Value).To(Equal(1.0))
+		Expect(result[0].SourceRef).To(BeEmpty())
+		Expect(result[0].Source.Label).To(Equal("compass"))
+		Expect(result[0].Source.Type).To(Equal("NMEA2000"))
+		Expect(result[0].Timestamp.IsZero()).To(BeTrue())
+
+		Expect(result[1].Values[0].Path).To(Equal("navigation.speedOverGround"))
+		Expect(result[1].Values[0].Value).To(Equal(3.2))
+		Expect(result[1].SourceRef).To(Equal("nmea.GP"))
+		Expect(result[1].Source).To(BeNil())
+		Expect(result[1].Timestamp).To(Equal(time.Date(2022, 5, 10, 11, 59, 58, 123000000, time.UTC)))
+
+		Expect(result[2].Values[0].Path).To(Equal("propulsion.port.revolutions"))
+	})
+
+	It("uses the prefix for the paths", func() {
+		result := FullDataModelToUpdates("vessels.self", map[string]interface{}{"navigation": model["navigation"]})
+		Expect(result).To(HaveLen(2))
+		Expect(result[0].Values[0].Path).To(Equal("vessels.self.navigation.headingTrue"))
+	})
+
+	fullDataModel := `{"navigation":{"speedOverGround":{"value":3.2,"timestamp":"2022-05-10T11:59:58.123Z","$source":"nmea.GP"},"headingTrue":{"value":1.0,"source":{"label":"compass","type":"NMEA2000"}}},"propulsion":{"port":{"revolutions":{"value":12.5}}}}`
+	paths := func(mapped *message.Mapped) []string {
+		result := make([]string, 0)
+		for _, u := range mapped.Updates {
+			for _, v := range u.Values {
+				result = append(result, v.Path)
+			}
+		}
+		return result
+	}
+
+	DescribeTable("Subscriptions",
+		func(subscriptions []config.SignalKSubscribeConfig, expected []string) {
+			r := NewSignalKReader(&config.SignalKReaderConfig{Context: "vessels.urn:mrn:imo:mmsi:123456789", Subscribe: subscriptions}, prometheus.NewRegistry())
+			result, err := r.FullDataModelToMapped(fullDataModel, now)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.Context).To(Equal("vessels.urn:mrn:imo:mmsi:123456789"))
+			Expect(paths(result)).To(Equal(expected))
+		},
+		Entry("Without subscriptions", nil, []string{"navigation.headingTrue", "navigation.speedOverGround", "propulsion.port.revolutions"}),
+		Entry("Single path", []config.SignalKSubscribeConfig{{Context: "vessels.self", Path: "navigation.headingTrue"}}, []string{"navigation.headingTrue"}),
+		Entry("Wildcard", []config.SignalKSubscribeConfig{{Context: "vessels.self", Path: "navigation.*"}}, []string{"navigation.headingTrue", "navigation.speedOverGround"}),
+		Entry("Wildcard in the middle", []config.SignalKSubscribeConfig{{Context: "vessels.self", Path: "propulsion.*.revolutions"}}, []string{"propulsion.port.revolutions"}),
+		Entry("Everything", []config.SignalKSubscribeConfig{{Context: "vessels.self", Path: "*"}}, []string{"navigation.headingTrue", "navigation.speedOverGround", "propulsion.port.revolutions"}),
+		Entry("Self context", []config.SignalKSubscribeConfig{{Context: "vessels.urn:mrn:imo:mmsi:123456789", Path: "navigation.headingTrue"}}, []string{"navigation.headingTrue"}),
+		Entry("Other context", []config.SignalKSubscribeConfig{{Context: "vessels.*", Path: "*"}}, []string{}),
+	)
+})
+
+var _ = Describe("SignalK reader pattern match", func() {
+	DescribeTable("Match",
+		func(path string, pattern string, expected bool) {
+			Expect(IsPatternMatch(path, pattern)).To(Equal(expected))
+		},
+		Entry("Equal", "navigation.headingTrue", "navigation.headingTrue", true),
+		Entry("Different", "navigation.headingTrue", "navigation.headingMagnetic", false),
+		Entry("Prefix only", "navigation.headingTrue", "navigation", false),
+		Entry("Wildcard at the end", "navigation.headingTrue", "navigation.*", true),
+		Entry("Wildcard matches multiple levels", "propulsion.port.revolutions", "propulsion.*", true),
+		Entry("Wildcard in the middle", "propulsion.port.revolutions", "propulsion.*.revolutions", true),
+		Entry("Wildcard in the middle without match", "propulsion.port.temperature", "propulsion.*.revolutions", false),
+		Entry("Dot is not a wildcard", "navigationXheadingTrue", "navigation.headingTrue", false),
+		Entry("Only a wildcard", "navigation.headingTrue", "*", true),
+	)
+})
@@ -0,0 +1,344 @@
+package spool
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/munnik/gosk/config"
+	"github.com/munnik/gosk/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+const (
+	segmentExtension = ".seg"
+	positionFileName = "position"
+
+	// each record starts with the length of the payload and a checksum of the payload
+	recordHeaderLength = 8
+
+	megaByte = 1024 * 1024
+)
+
+// ErrEmpty is returned when all records are acknowledged
+var ErrEmpty = errors.New("spool is empty")
+
+var (
+	appended = promauto.NewCounter(prometheus.CounterOpts{Name: "gosk_spool_appended_total", Help: "total number of records appended to the spool"})
+	evicted  = promauto.NewCounter(prometheus.CounterOpts{Name: "gosk_spool_evicted_total", Help: "total number of segments removed before all records were acknowledged"})
+)
+
+type segment struct {
+	sequence uint64
+	size     int64
+}
+
+// Spool is a segmented append only log on disk. Records are read in the order they are appended and removed
+// when they are acknowledged. When the maximum size is reached the oldest segment is removed.
+type Spool struct {
+	config   *config.SpoolConfig
+	mu       sync.Mutex
+	segments []segment // ordered from old to new, the last segment is used to append records
+	writer   *os.File
+	dirty    bool // true when the writer has unsynced writes
+
+	readSequence uint64
+	readOffset   int64
+	readLength   int64 // length of the last record returned by Peek
+}
+
+// New opens the spool in the directory and continues at the last acknowledged record
+func New(c *config.SpoolConfig) (*Spool, error) {
+	if err := os.MkdirAll(c.Directory, 0o755); err != nil {
+		return nil, err
+	}
+	s := &Spool{
+		config:   c,
+		segments: make([]segment, 0),
+	}
+
+	entries, err := os.ReadDir(c.Directory)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), segmentExtension) {
+			continue
+		}
+		sequence, err := strconv.ParseUint(strings.TrimSuffix(entry.Name(), segmentExtension), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		s.segments = append(s.segments, segment{sequence: sequence, size: info.Size()})
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].sequence < s.segments[j].sequence })
+	if len(s.segments) == 0 {
+		s.segments = append(s.segments, segment{sequence: 1})
+	} else if s.segments[len(s.segments)-1].size > 0 {
+		// never append to a segment of a previous run, it might end with an incomplete record
+		s.segments = append(s.segments, segment{sequence: s.segments[len(s.segments)-1].sequence + 1})
+	}
+	if err := s.openWriter(); err != nil {
+		return nil, err
+	}
+
+	s.readSequence = s.segments[0].sequence
+	if bytes, err := os.ReadFile(filepath.Join(c.Directory, positionFileName)); err == nil {
+		var sequence uint64
+		var offset int64
+		if _, err := fmt.Sscanf(string(bytes), "%d %d", &sequence, &offset); err == nil && s.indexOf(sequence) != -1 {
+			s.readSequence = sequence
+			s.readOffset = offset
+		}
+	}
+
+	if c.Fsync == config.FsyncInterval {
+		go s.syncPeriodically()
+	}
+
+	return s, nil
+}
+
+// Append adds the record at the end of the spool
+func (s *Spool) Append(record []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	length := int64(recordHeaderLength + len(record))
+	last := &s.segments[len(s.segments)-1]
+	if last.size > 0 && last.size+length > s.config.SegmentSize*megaByte {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+		last = &s.segments[len(s.segments)-1]
+	}
+
+	header := make([]byte, recordHeaderLength)
+	binary.BigEndian.PutUint32(header[0:4], uint32(len(record)))
+	binary.BigEndian.PutUint32(header[4:8], crc32.ChecksumIEEE(record))
+	if _, err := s.writer.Write(append(header, record...)); err != nil {
+		return err
+	}
+	last.size += length
+	s.dirty = true
+	appended.Inc()
+	if s.config.Fsync == config.FsyncAlways {
+		if err := s.sync(); err != nil {
+			return err
+		}
+	}
+
+	s.evict()
+	return nil
+}
+
+// Peek returns the oldest record that is not acknowledged, ErrEmpty is returned when there are no records
+func (s *Spool) Peek() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for {
+		i := s.indexOf(s.readSequence)
+		if i == -1 {
+			return nil, ErrEmpty
+		}
+		if s.readOffset < s.segments[i].size {
+			record, err := s.read(s.readSequence, s.readOffset)
+			if err == nil {
+				s.readLength = int64(recordHeaderLength + len(record))
+				return record, nil
+			}
+			logger.GetLogger().Warn(
+				"Skipping the remainder of a corrupt segment",
+				zap.Uint64("Segment", s.readSequence),
+				zap.Int64("Offset", s.readOffset),
+				zap.String("Error", err.Error()),
+			)
+			if i == len(s.segments)-1 {
+				// start a new segment, the corrupt tail can't be appended to
+				if err := s.rotate(); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if i == len(s.segments)-1 {
+			return nil, ErrEmpty
+		}
+
+		// all records in the segment are read, continue with the next segment
+		s.remove(i)
+		s.readSequence = s.segments[i].sequence
+		s.readOffset = 0
+	}
+}
+
+// Ack removes the record that was returned by the last call to Peek
+func (s *Spool) Ack() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.readLength == 0 {
+		return nil
+	}
+	s.readOffset += s.readLength
+	s.readLength = 0
+
+	position := filepath.Join(s.config.Directory, positionFileName)
+	f, err := os.OpenFile(position+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "%d %d", s.readSequence, s.readOffset); err != nil {
+		f.Close()
+		return err
+	}
+	if s.config.Fsync != config.FsyncNever {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(position+".tmp", position)
+}
+
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.sync(); err != nil {
+		return err
+	}
+	return s.writer.Close()
+}
+
+func (s *Spool) indexOf(sequence uint64) int {
+	for i, seg := range s.segments {
+		if seg.sequence == sequence {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Spool) path(sequence uint64) string {
+	return filepath.Join(s.config.Directory, fmt.Sprintf("%020d%s", sequence, segmentExtension))
+}
+
+func (s *Spool) openWriter() error {
+	last := s.segments[len(s.segments)-1]
+	f, err := os.OpenFile(s.path(last.sequence), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	s.writer = f
+	return nil
+}
+
+func (s *Spool) rotate() error {
+	if err := s.sync(); err != nil {
+		return err
+	}
+	if err := s.writer.Close(); err != nil {
+		return err
+	}
+	s.segments = append(s.segments, segment{sequence: s.segments[len(s.segments)-1].sequence + 1})
+	return s.openWriter()
+}
+
+func (s *Spool) sync() error {
+	if !s.dirty {
+		return nil
+	}
+	s.dirty = false
+	return s.writer.Sync()
+}
+
+func (s *Spool) syncPeriodically() {
+	ticker := time.NewTicker(s.config.FsyncInterval)
+	for range ticker.C {
+		s.mu.Lock()
+		if err := s.sync(); err != nil {
+			logger.GetLogger().Warn(
+				"Could not sync the spool",
+				zap.String("Error", err.Error()),
+			)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// evict removes the oldest segments until the total size is below the maximum size, the segment that is used to
+// append records is never removed
+func (s *Spool) evict() {
+	var total int64
+	for _, seg := range s.segments {
+		total += seg.size
+	}
+	for total > s.config.MaxSize*megaByte && len(s.segments) > 1 {
+		total -= s.segments[0].size
+		logger.GetLogger().Warn(
+			"Spool is full, removing the oldest segment",
+			zap.Uint64("Segment", s.segments[0].sequence),
+		)
+		evicted.Inc()
+		s.remove(0)
+		if s.indexOf(s.readSequence) == -1 {
+			s.readSequence = s.segments[0].sequence
+			s.readOffset = 0
+			s.readLength = 0
+		}
+	}
+}
+
+func (s *Spool) remove(i int) {
+	if err := os.Remove(s.path(s.segments[i].sequence)); err != nil {
+		logger.GetLogger().Warn(
+			"Could not remove the segment",
+			zap.Uint64("Segment", s.segments[i].sequence),
+			zap.String("Error", err.Error()),
+		)
+	}
+	s.segments = append(s.segments[:i], s.segments[i+1:]...)
+}
+
+func (s *Spool) read(sequence uint64, offset int64) ([]byte, error) {
+	f, err := os.Open(s.path(sequence))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header := make([]byte, recordHeaderLength)
+	if _, err := f.ReadAt(header, offset); err != nil {
+		return nil, err
+	}
+	record := make([]byte, binary.BigEndian.Uint32(header[0:4]))
+	if _, err := f.ReadAt(record, offset+recordHeaderLength); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(record) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, fmt.Errorf("checksum mismatch")
+	}
+	return record, nil
+}
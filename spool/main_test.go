@@ -0,0 +1,143 @@
+package spool_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/munnik/gosk/config"
+	. "github.com/munnik/gosk/spool"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Spool", func() {
+	var c *config.SpoolConfig
+	BeforeEach(func() {
+		c = &config.SpoolConfig{
+			Directory:     GinkgoT().TempDir(),
+			MaxSize:       1024,
+			SegmentSize:   16,
+			Fsync:         config.FsyncAlways,
+			FsyncInterval: time.Second,
+		}
+	})
+	segments := func() []string {
+		result, err := filepath.Glob(filepath.Join(c.Directory, "*.seg"))
+		Expect(err).NotTo(HaveOccurred())
+		return result
+	}
+
+	It("Returns ErrEmpty when nothing is appended", func() {
+		s, err := New(c)
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+		_, err = s.Peek()
+		Expect(err).To(Equal(ErrEmpty))
+	})
+	It("Returns the records in the order they are appended", func() {
+		s, err := New(c)
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+		Expect(s.Append([]byte("first"))).To(Succeed())
+		Expect(s.Append([]byte("second"))).To(Succeed())
+
+		record, err := s.Peek()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(record).To(Equal([]byte("first")))
+		record, err = s.Peek()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(record).To(Equal([]byte("first")))
+		Expect(s.Ack()).To(Succeed())
+
+		record, err = s.Peek()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(record).To(Equal([]byte("second")))
+		Expect(s.Ack()).To(Succeed())
+
+		_, err = s.Peek()
+		Expect(err).To(Equal(ErrEmpty))
+	})
+	It("Continues after the last acknowledged record when reopened", func() {
+		s, err := New(c)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.Append([]byte("first"))).To(Succeed())
+		Expect(s.Append([]byte("second"))).To(Succeed())
+		_, err = s.Peek()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.Ack()).To(Succeed())
+		Expect(s.Close()).To(Succeed())
+
+		s, err = New(c)
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+		Expect(s.Append([]byte("third"))).To(Succeed())
+		record, err := s.Peek()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(record).To(Equal([]byte("second")))
+		Expect(s.Ack()).To(Succeed())
+		record, err = s.Peek()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(record).To(Equal([]byte("third")))
+	})
+	It("Skips a record with an invalid checksum", func() {
+		s, err := New(c)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.Append([]byte("first"))).To(Succeed())
+		Expect(s.Close()).To(Succeed())
+
+		f, err := os.OpenFile(segments()[0], os.O_WRONLY, 0o644)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = f.WriteAt([]byte("X"), 8)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(f.Close()).To(Succeed())
+
+		s, err = New(c)
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+		Expect(s.Append([]byte("second"))).To(Succeed())
+		record, err := s.Peek()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(record).To(Equal([]byte("second")))
+	})
+	Context("With large records", func() {
+		record := bytes.Repeat([]byte("x"), 600*1024)
+		BeforeEach(func() {
+			c.SegmentSize = 1
+		})
+		It("Starts a new segment when the segment size is reached", func() {
+			s, err := New(c)
+			Expect(err).NotTo(HaveOccurred())
+			defer s.Close()
+			Expect(s.Append(record)).To(Succeed())
+			Expect(s.Append(record)).To(Succeed())
+			Expect(s.Append(record)).To(Succeed())
+			Expect(segments()).To(HaveLen(3))
+
+			for i := 0; i < 3; i++ {
+				result, err := s.Peek()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result).To(Equal(record))
+				Expect(s.Ack()).To(Succeed())
+			}
+			_, err = s.Peek()
+			Expect(err).To(Equal(ErrEmpty))
+			Expect(segments()).To(HaveLen(1))
+		})
+		It("Removes the oldest segments when the maximum size is reached", func() {
+			c.MaxSize = 1
+			s, err := New(c)
+			Expect(err).NotTo(HaveOccurred())
+			defer s.Close()
+			Expect(s.Append(append([]byte("1"), record...))).To(Succeed())
+			Expect(s.Append(append([]byte("2"), record...))).To(Succeed())
+			Expect(s.Append(append([]byte("3"), record...))).To(Succeed())
+			Expect(segments()).To(HaveLen(1))
+
+			result, err := s.Peek()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result[0]).To(Equal(byte('3')))
+		})
+	})
+})
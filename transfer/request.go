@@ -43,7 +43,7 @@ type TransferRequester struct {
 
 func NewTransferRequester(c *config.TransferConfig) *TransferRequester {
 	result := &TransferRequester{
-		db:                        database.NewPostgresqlDatabase(&c.PostgresqlConfig),
+		db:                        database.NewPostgresqlDatabase(&c.PostgresqlConfig, prometheus.DefaultRegisterer),
 		mqttConfig:                &c.MQTTConfig,
 		sleepBetweenCountRequests: c.SleepBetweenCountRequests,
 		sleepBetweenDataRequests:  c.SleepBetweenDataRequests,
@@ -34,7 +34,7 @@ type TransferResponder struct {
 
 func NewTransferResponder(c *config.TransferConfig) *TransferResponder {
 	return &TransferResponder{
-		db:                    database.NewPostgresqlDatabase(&c.PostgresqlConfig),
+		db:                    database.NewPostgresqlDatabase(&c.PostgresqlConfig, prometheus.DefaultRegisterer),
 		config:                c,
 		countRequestsReceived: promauto.NewCounter(prometheus.CounterOpts{Name: "gosk_transfer_count_requests_received_total", Help: "total number of count requests received"}),
 		countRequestsHandled:  promauto.NewCounter(prometheus.CounterOpts{Name: "gosk_transfer_count_requests_handled_total", Help: "total number of count requests reponded to"}),
@@ -0,0 +1,113 @@
+package writer
+
+import (
+	"io"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/munnik/gosk/config"
+	"github.com/munnik/gosk/mapper"
+	"github.com/munnik/gosk/message"
+)
+
+var (
+	FormatLatitude  = formatLatitude
+	FormatLongitude = formatLongitude
+)
+
+// EncodeSentence encodes the sentence with the values of the paths, all values have the same timestamp
+func EncodeSentence(c config.NMEA0183SentenceConfig, values map[string]interface{}, timestamp time.Time) (string, bool) {
+	v := make(nmea0183Values, len(values))
+	for path, value := range values {
+		v[path] = nmea0183Value{value: value, timestamp: timestamp}
+	}
+	return encodeSentence(c, v)
+}
+
+// AcceptTCPClients accepts connections on the listener until the listener is closed
+func AcceptTCPClients(listener net.Listener) {
+	newTCPClients().accept(listener)
+}
+
+var (
+	ToRegister            = toRegister
+	CreateNmea0183Command = createNmea0183Command
+)
+
+// CreatePutRaw compiles the expression of the mapping and creates the raw command for the value
+func CreatePutRaw(mapping config.PutMappingConfig, value interface{}) (*message.Raw, error) {
+	var expression *mapper.ExpressionRunner
+	if mapping.Expression != "" {
+		var err error
+		if expression, err = mapper.NewExpressionRunner("", mapping.MappingConfig); err != nil {
+			return nil, err
+		}
+	}
+	return createPutRaw(mapping, expression, value)
+}
+
+type Subscriptions = subscriptions
+
+var NewSubscriptions = newSubscriptions
+
+func (s *subscriptions) Handle(m SubscribeMessage) {
+	s.handle(m)
+}
+
+func (s *subscriptions) IsSubscribedTo(context string, path string) bool {
+	return s.isSubscribedTo(context, path)
+}
+
+func (s *subscriptions) Filter(m message.Mapped, now time.Time) []message.SingleValueMapped {
+	return s.filter(m, now)
+}
+
+func (s *subscriptions) Snapshot(cached []message.Mapped, now time.Time) []message.SingleValueMapped {
+	return s.snapshot(cached, now)
+}
+
+func (s *subscriptions) Due(now time.Time) []message.SingleValueMapped {
+	return s.due(now)
+}
+
+// AddWebsocketClient adds a client that receives the deltas in a channel with the size of buffer
+func (w *SignalKWriter) AddWebsocketClient(host string, buffer int) <-chan message.Mapped {
+	client := websocketClient{host: host, deltas: make(chan message.Mapped, buffer), subscriptions: newSubscriptions(w.config.SelfContext)}
+	w.addClient(client)
+	return client.deltas
+}
+
+func (w *SignalKWriter) UpdateWebsocket(m message.Mapped) {
+	w.updateWebsocket(m)
+}
+
+var ParseResolution = parseResolution
+
+// ParseHistoryRequest parses the query of a history request, the paths are returned as path:method
+func ParseHistoryRequest(query string, self string, now time.Time) (string, time.Time, time.Time, time.Duration, []string, error) {
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return "", time.Time{}, time.Time{}, 0, nil, err
+	}
+	request, err := parseHistoryRequest(values, self, now)
+	paths := make([]string, 0, len(request.paths))
+	for _, p := range request.paths {
+		paths = append(paths, p.Path+":"+p.Method)
+	}
+	return request.context, request.from, request.to, request.resolution, paths, err
+}
+
+func (w *ModbusWriter) Update(mapped message.Mapped) {
+	w.update(mapped)
+}
+
+func (w *ModbusWriter) HandleRTURequest(frame []byte) []byte {
+	return w.handleRTURequest(frame)
+}
+
+func (w *ModbusWriter) ServeRTU(port io.ReadWriter) {
+	w.serveRTU(port)
+}
+
+var ReadRTURequest = readRTURequest
@@ -0,0 +1,301 @@
+package writer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/goburrow/serial"
+	"github.com/munnik/gosk/config"
+	"github.com/munnik/gosk/logger"
+	"github.com/munnik/gosk/message"
+	"github.com/munnik/gosk/protocol"
+	"github.com/simonvetter/modbus"
+	"go.nanomsg.org/mangos/v3"
+	"go.uber.org/zap"
+)
+
+// rtuReadTimeout is the time the serial port waits for data, it is also used to detect the end of an invalid frame
+const rtuReadTimeout = 100 * time.Millisecond
+
+// ModbusWriter exposes the received mapped values as coils and registers of a Modbus TCP or RTU server, addresses that
+// are not configured read as zero and clients can't write
+type ModbusWriter struct {
+	config *config.ModbusServerConfig
+	paths  map[string][]config.ModbusRegisterConfig
+
+	mu               sync.RWMutex
+	coils            map[uint16]bool
+	discreteInputs   map[uint16]bool
+	holdingRegisters map[uint16]uint16
+	inputRegisters   map[uint16]uint16
+}
+
+func NewModbusWriter(c *config.ModbusServerConfig) *ModbusWriter {
+	w := &ModbusWriter{
+		config:           c,
+		paths:            make(map[string][]config.ModbusRegisterConfig),
+		coils:            make(map[uint16]bool),
+		discreteInputs:   make(map[uint16]bool),
+		holdingRegisters: make(map[uint16]uint16),
+		inputRegisters:   make(map[uint16]uint16),
+	}
+	for _, r := range c.Registers {
+		if err := w.add(r); err != nil {
+			logger.GetLogger().Warn(
+				"Invalid register configuration",
+				zap.String("Path", r.Path),
+				zap.Uint16("Address", r.Address),
+				zap.String("Error", err.Error()),
+			)
+			continue
+		}
+		w.paths[r.Path] = append(w.paths[r.Path], r)
+	}
+	return w
+}
+
+// add initializes the addresses of the register to zero, so they can be read before a value is received
+func (w *ModbusWriter) add(r config.ModbusRegisterConfig) error {
+	switch r.FunctionCode {
+	case protocol.READ_COILS:
+		w.coils[r.Address] = false
+	case protocol.READ_DISCRETE_INPUTS:
+		w.discreteInputs[r.Address] = false
+	case protocol.READ_HOLDING_REGISTERS, protocol.READ_INPUT_REGISTERS:
+		n, err := protocol.RegistersForEncoding(r.Encoding)
+		if err != nil {
+			return err
+		}
+		if int(r.Address)+n > 0x10000 {
+			return fmt.Errorf("the %s at address %d exceeds the address range", r.Encoding, r.Address)
+		}
+		table := w.holdingRegisters
+		if r.FunctionCode == protocol.READ_INPUT_REGISTERS {
+			table = w.inputRegisters
+		}
+		for i := 0; i < n; i++ {
+			table[r.Address+uint16(i)] = 0
+		}
+	default:
+		return fmt.Errorf("function code %d can't be used to read", r.FunctionCode)
+	}
+	return nil
+}
+
+func (w *ModbusWriter) WriteMapped(subscriber mangos.Socket) {
+	if err := w.serve(); err != nil {
+		logger.GetLogger().Fatal(
+			"Could not start the Modbus server",
+			zap.String("URL", w.config.URLString),
+			zap.String("Error", err.Error()),
+		)
+	}
+
+	for {
+		received, err := subscriber.Recv()
+		if err != nil {
+			logger.GetLogger().Warn(
+				"Could not receive a message from the publisher",
+				zap.String("Error", err.Error()),
+			)
+			continue
+		}
+		var mapped message.Mapped
+		if err := json.Unmarshal(received, &mapped); err != nil {
+			logger.GetLogger().Warn(
+				"Could not unmarshal the received data",
+				zap.ByteString("Received", received),
+				zap.String("Error", err.Error()),
+			)
+			continue
+		}
+		w.update(mapped)
+	}
+}
+
+func (w *ModbusWriter) serve() error {
+	if w.config.URL == nil {
+		return fmt.Errorf("no url is configured")
+	}
+	switch w.config.URL.Scheme {
+	case "tcp":
+		server, err := modbus.NewServer(&modbus.ServerConfiguration{URL: w.config.URLString}, w)
+		if err != nil {
+			return err
+		}
+		return server.Start()
+	case "file":
+		port, err := serial.Open(&serial.Config{
+			Address:  w.config.URL.Path,
+			BaudRate: w.config.BaudRate,
+			DataBits: w.config.DataBits,
+			StopBits: w.config.StopBits,
+			Parity:   string(config.ParityMap[w.config.Parity]),
+			Timeout:  rtuReadTimeout,
+		})
+		if err != nil {
+			return err
+		}
+		go w.serveRTU(port)
+		return nil
+	}
+	return fmt.Errorf("unsupported connection scheme %v", w.config.URL.Scheme)
+}
+
+// update encodes the values of the configured paths in the registers
+func (w *ModbusWriter) update(mapped message.Mapped) {
+	if w.config.Context != "" && mapped.Context != w.config.Context {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, u := range mapped.Updates {
+		for _, v := range u.Values {
+			for _, r := range w.paths[v.Path] {
+				if err := w.set(r, v.Value); err != nil {
+					logger.GetLogger().Warn(
+						"Could not encode the value",
+						zap.String("Path", v.Path),
+						zap.Uint16("Address", r.Address),
+						zap.String("Error", err.Error()),
+					)
+				}
+			}
+		}
+	}
+}
+
+func (w *ModbusWriter) set(r config.ModbusRegisterConfig, value interface{}) error {
+	switch r.FunctionCode {
+	case protocol.READ_COILS, protocol.READ_DISCRETE_INPUTS:
+		b, err := toBool(value)
+		if err != nil {
+			return err
+		}
+		if r.FunctionCode == protocol.READ_COILS {
+			w.coils[r.Address] = b
+		} else {
+			w.discreteInputs[r.Address] = b
+		}
+	case protocol.READ_HOLDING_REGISTERS, protocol.READ_INPUT_REGISTERS:
+		f, err := toFloat(value)
+		if err != nil {
+			return err
+		}
+		registers, err := protocol.EncodeRegisters(f*r.Scale, r.Encoding, r.WordOrder)
+		if err != nil {
+			return err
+		}
+		table := w.holdingRegisters
+		if r.FunctionCode == protocol.READ_INPUT_REGISTERS {
+			table = w.inputRegisters
+		}
+		for i, register := range registers {
+			table[r.Address+uint16(i)] = register
+		}
+	}
+	return nil
+}
+
+// toBool converts a bool or a number to a bool, numbers other than zero are true
+func toBool(v interface{}) (bool, error) {
+	switch b := v.(type) {
+	case bool:
+		return b, nil
+	case float64:
+		return b != 0, nil
+	case int:
+		return b != 0, nil
+	case int64:
+		return b != 0, nil
+	}
+	return false, fmt.Errorf("expected a bool or a number but got %v", v)
+}
+
+// toFloat converts a number or a bool to a float, true is 1
+func toFloat(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case int:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case bool:
+		if n {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return 0, fmt.Errorf("expected a number but got %v", v)
+}
+
+// HandleCoils implements the modbus.RequestHandler interface
+func (w *ModbusWriter) HandleCoils(req *modbus.CoilsRequest) ([]bool, error) {
+	if req.IsWrite {
+		return nil, modbus.ErrIllegalFunction
+	}
+	return w.readBits(w.coils, req.Addr, req.Quantity)
+}
+
+// HandleDiscreteInputs implements the modbus.RequestHandler interface
+func (w *ModbusWriter) HandleDiscreteInputs(req *modbus.DiscreteInputsRequest) ([]bool, error) {
+	return w.readBits(w.discreteInputs, req.Addr, req.Quantity)
+}
+
+// HandleHoldingRegisters implements the modbus.RequestHandler interface
+func (w *ModbusWriter) HandleHoldingRegisters(req *modbus.HoldingRegistersRequest) ([]uint16, error) {
+	if req.IsWrite {
+		return nil, modbus.ErrIllegalFunction
+	}
+	return w.readRegisters(w.holdingRegisters, req.Addr, req.Quantity)
+}
+
+// HandleInputRegisters implements the modbus.RequestHandler interface
+func (w *ModbusWriter) HandleInputRegisters(req *modbus.InputRegistersRequest) ([]uint16, error) {
+	return w.readRegisters(w.inputRegisters, req.Addr, req.Quantity)
+}
+
+// readBits returns the requested bits, an illegal data address error is returned when none of the addresses is
+// configured
+func (w *ModbusWriter) readBits(table map[uint16]bool, address uint16, quantity uint16) ([]bool, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	result := make([]bool, quantity)
+	found := false
+	for i := range result {
+		if b, ok := table[address+uint16(i)]; ok {
+			result[i] = b
+			found = true
+		}
+	}
+	if !found {
+		return nil, modbus.ErrIllegalDataAddress
+	}
+	return result, nil
+}
+
+// readRegisters returns the requested registers, an illegal data address error is returned when none of the addresses
+// is configured
+func (w *ModbusWriter) readRegisters(table map[uint16]uint16, address uint16, quantity uint16) ([]uint16, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	result := make([]uint16, quantity)
+	found := false
+	for i := range result {
+		if r, ok := table[address+uint16(i)]; ok {
+			result[i] = r
+			found = true
+		}
+	}
+	if !found {
+		return nil, modbus.ErrIllegalDataAddress
+	}
+	return result, nil
+}
@@ -0,0 +1,221 @@
+package writer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/goburrow/serial"
+	"github.com/munnik/gosk/logger"
+	"github.com/munnik/gosk/protocol"
+	"github.com/simonvetter/modbus"
+	"go.uber.org/zap"
+)
+
+// errUnsupportedRTUFunction is returned when the length of the request frame is unknown because the function code is
+// not supported
+var errUnsupportedRTUFunction = errors.New("unsupported function code")
+
+// serveRTU answers the requests on the serial line that are addressed to the configured slave, the Modbus library only
+// has a TCP server so the RTU frames are handled here with the same request handler
+func (w *ModbusWriter) serveRTU(port io.ReadWriter) {
+	for {
+		request, err := readRTURequest(port)
+		if err == serial.ErrTimeout {
+			continue
+		}
+		if errors.Is(err, errUnsupportedRTUFunction) {
+			// the rest of the frame is discarded before the exception is sent, so the crc of the frame can't be checked
+			discardRTU(port)
+			w.writeRTU(port, w.rtuResponse(request[0], []byte{request[1] | 0x80, protocol.MODBUS_EXCEPTION_ILLEGAL_FUNCTION}))
+			continue
+		}
+		if err != nil {
+			logger.GetLogger().Warn(
+				"Could not read the Modbus RTU request",
+				zap.String("URL", w.config.URLString),
+				zap.String("Error", err.Error()),
+			)
+			discardRTU(port)
+			continue
+		}
+		w.writeRTU(port, w.handleRTURequest(request))
+	}
+}
+
+// writeRTU writes the response frame, nothing is written when the response is nil
+func (w *ModbusWriter) writeRTU(port io.Writer, response []byte) {
+	if response == nil {
+		return
+	}
+	if _, err := port.Write(response); err != nil {
+		logger.GetLogger().Warn(
+			"Could not write the Modbus RTU response",
+			zap.String("URL", w.config.URLString),
+			zap.String("Error", err.Error()),
+		)
+	}
+}
+
+// readRTURequest reads a request frame, the length of the frame is determined by the function code. The slave and the
+// function code are returned with errUnsupportedRTUFunction when the function code is not supported.
+func readRTURequest(reader io.Reader) ([]byte, error) {
+	frame := make([]byte, 0, 256)
+	read := func(n int) error {
+		buffer := make([]byte, n)
+		if _, err := io.ReadFull(reader, buffer); err != nil {
+			return err
+		}
+		frame = append(frame, buffer...)
+		return nil
+	}
+
+	// slave and function code
+	if err := read(2); err != nil {
+		return nil, err
+	}
+	switch frame[1] {
+	case protocol.READ_COILS, protocol.READ_DISCRETE_INPUTS, protocol.READ_HOLDING_REGISTERS, protocol.READ_INPUT_REGISTERS, protocol.WRITE_SINGLE_COIL, protocol.WRITE_SINGLE_REGISTER:
+		// address, quantity or value and crc
+		if err := read(6); err != nil {
+			return nil, err
+		}
+	case protocol.WRITE_MULTIPLE_COILS, protocol.WRITE_MULTIPLE_REGISTERS:
+		// address, quantity and byte count
+		if err := read(5); err != nil {
+			return nil, err
+		}
+		// values and crc
+		if err := read(int(frame[6]) + 2); err != nil {
+			return nil, err
+		}
+	default:
+		return frame, fmt.Errorf("%w %d", errUnsupportedRTUFunction, frame[1])
+	}
+
+	crc := protocol.ModbusCRC(frame[:len(frame)-2])
+	if frame[len(frame)-2] != byte(crc) || frame[len(frame)-1] != byte(crc>>8) {
+		return nil, fmt.Errorf("invalid crc of frame %x", frame)
+	}
+	return frame, nil
+}
+
+// discardRTU reads until the line is silent, so the next request starts at the beginning of a frame
+func discardRTU(reader io.Reader) {
+	buffer := make([]byte, 256)
+	for {
+		if _, err := reader.Read(buffer); err != nil {
+			return
+		}
+	}
+}
+
+// handleRTURequest returns the response frame of the request, nil is returned when the request is not addressed to
+// this slave or when it is a broadcast
+func (w *ModbusWriter) handleRTURequest(frame []byte) []byte {
+	slave := frame[0]
+	if slave != w.config.Slave && slave != 0 {
+		return nil
+	}
+
+	functionCode := frame[1]
+	address := binary.BigEndian.Uint16(frame[2:4])
+	quantity := binary.BigEndian.Uint16(frame[4:6])
+	var values []byte
+	if len(frame) > 8 {
+		// the write multiple functions have a byte count followed by the values
+		values = frame[7 : len(frame)-2]
+	}
+	pdu, err := w.handleRTUFunction(slave, functionCode, address, quantity, values)
+	if err != nil {
+		exception := protocol.ModbusException(err)
+		if exception == 0 {
+			exception = protocol.MODBUS_EXCEPTION_SERVER_DEVICE_FAILURE
+		}
+		pdu = []byte{functionCode | 0x80, exception}
+	}
+	return w.rtuResponse(slave, pdu)
+}
+
+// rtuResponse returns the response frame of the pdu, nil is returned when the request is not addressed to this slave or
+// when it is a broadcast
+func (w *ModbusWriter) rtuResponse(slave uint8, pdu []byte) []byte {
+	if slave != w.config.Slave {
+		return nil
+	}
+	response := append([]byte{slave}, pdu...)
+	crc := protocol.ModbusCRC(response)
+	return append(response, byte(crc), byte(crc>>8))
+}
+
+// handleRTUFunction returns the response pdu of the function, values are the bytes of the write multiple functions
+func (w *ModbusWriter) handleRTUFunction(slave uint8, functionCode uint8, address uint16, quantity uint16, values []byte) ([]byte, error) {
+	// the response of write functions echoes the address and the quantity or value
+	echo := []byte{functionCode, byte(address >> 8), byte(address), byte(quantity >> 8), byte(quantity)}
+
+	switch functionCode {
+	case protocol.READ_COILS, protocol.READ_DISCRETE_INPUTS:
+		if quantity == 0 || quantity > protocol.MODBUS_MAXIMUM_NUMBER_OF_COILS {
+			return nil, modbus.ErrIllegalDataValue
+		}
+		var bits []bool
+		var err error
+		if functionCode == protocol.READ_COILS {
+			bits, err = w.HandleCoils(&modbus.CoilsRequest{UnitId: slave, Addr: address, Quantity: quantity})
+		} else {
+			bits, err = w.HandleDiscreteInputs(&modbus.DiscreteInputsRequest{UnitId: slave, Addr: address, Quantity: quantity})
+		}
+		if err != nil {
+			return nil, err
+		}
+		bytes := protocol.PackCoils(bits)
+		return append([]byte{functionCode, byte(len(bytes))}, bytes...), nil
+	case protocol.READ_HOLDING_REGISTERS, protocol.READ_INPUT_REGISTERS:
+		if quantity == 0 || quantity > protocol.MODBUS_MAXIMUM_NUMBER_OF_REGISTERS {
+			return nil, modbus.ErrIllegalDataValue
+		}
+		var registers []uint16
+		var err error
+		if functionCode == protocol.READ_HOLDING_REGISTERS {
+			registers, err = w.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{UnitId: slave, Addr: address, Quantity: quantity})
+		} else {
+			registers, err = w.HandleInputRegisters(&modbus.InputRegistersRequest{UnitId: slave, Addr: address, Quantity: quantity})
+		}
+		if err != nil {
+			return nil, err
+		}
+		bytes := protocol.RegistersToBytes(registers)
+		return append([]byte{functionCode, byte(len(bytes))}, bytes...), nil
+	case protocol.WRITE_SINGLE_COIL:
+		if quantity != 0x0000 && quantity != 0xff00 {
+			return nil, modbus.ErrIllegalDataValue
+		}
+		_, err := w.HandleCoils(&modbus.CoilsRequest{UnitId: slave, Addr: address, Quantity: 1, IsWrite: true, Args: []bool{quantity == 0xff00}})
+		return echo, err
+	case protocol.WRITE_SINGLE_REGISTER:
+		_, err := w.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{UnitId: slave, Addr: address, Quantity: 1, IsWrite: true, Args: []uint16{quantity}})
+		return echo, err
+	case protocol.WRITE_MULTIPLE_COILS:
+		if quantity == 0 || int(quantity) > len(values)*8 {
+			return nil, modbus.ErrIllegalDataValue
+		}
+		args := make([]bool, quantity)
+		for i := range args {
+			args[i] = values[i/8]&(1<<(i%8)) != 0
+		}
+		_, err := w.HandleCoils(&modbus.CoilsRequest{UnitId: slave, Addr: address, Quantity: quantity, IsWrite: true, Args: args})
+		return echo, err
+	case protocol.WRITE_MULTIPLE_REGISTERS:
+		if quantity == 0 || int(quantity)*2 != len(values) {
+			return nil, modbus.ErrIllegalDataValue
+		}
+		args, err := protocol.BytesToRegisters(values)
+		if err != nil {
+			return nil, modbus.ErrIllegalDataValue
+		}
+		_, err = w.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{UnitId: slave, Addr: address, Quantity: quantity, IsWrite: true, Args: args})
+		return echo, err
+	}
+	return nil, modbus.ErrIllegalFunction
+}
@@ -0,0 +1,194 @@
+package writer_test
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/goburrow/serial"
+	"github.com/munnik/gosk/config"
+	"github.com/munnik/gosk/message"
+	"github.com/munnik/gosk/protocol"
+	. "github.com/munnik/gosk/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/simonvetter/modbus"
+)
+
+// rtuFrame appends the crc to the bytes
+func rtuFrame(bytes ...byte) []byte {
+	crc := protocol.ModbusCRC(bytes)
+	return append(bytes, byte(crc), byte(crc>>8))
+}
+
+// rtuPort returns the requests to the reader and a timeout when all requests are read, the responses are collected
+type rtuPort struct {
+	mu        sync.Mutex
+	requests  *bytes.Buffer
+	responses [][]byte
+}
+
+func (p *rtuPort) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.requests.Len() == 0 {
+		time.Sleep(time.Millisecond)
+		return 0, serial.ErrTimeout
+	}
+	return p.requests.Read(b)
+}
+
+func (p *rtuPort) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.responses = append(p.responses, append([]byte{}, b...))
+	return len(b), nil
+}
+
+func (p *rtuPort) Responses() [][]byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.responses
+}
+
+var _ = Describe("Modbus writer", func() {
+	var writer *ModbusWriter
+	BeforeEach(func() {
+		writer = NewModbusWriter(&config.ModbusServerConfig{
+			Context: "testingContext",
+			Slave:   1,
+			Registers: []config.ModbusRegisterConfig{
+				{Path: "propulsion.main.revolutions", FunctionCode: protocol.READ_HOLDING_REGISTERS, Address: 0, Encoding: protocol.MODBUS_ENCODING_UINT16, Scale: 10},
+				{Path: "environment.depth.belowKeel", FunctionCode: protocol.READ_HOLDING_REGISTERS, Address: 1, Encoding: protocol.MODBUS_ENCODING_INT16, Scale: 1},
+				{Path: "navigation.speedOverGround", FunctionCode: protocol.READ_INPUT_REGISTERS, Address: 10, Encoding: protocol.MODBUS_ENCODING_FLOAT32, WordOrder: protocol.MODBUS_WORD_ORDER_BIG_ENDIAN, Scale: 1},
+				{Path: "navigation.speedOverGround", FunctionCode: protocol.READ_INPUT_REGISTERS, Address: 20, Encoding: protocol.MODBUS_ENCODING_FLOAT32, WordOrder: protocol.MODBUS_WORD_ORDER_LITTLE_ENDIAN, Scale: 1},
+				{Path: "electrical.switches.anchorLight.state", FunctionCode: protocol.READ_COILS, Address: 5},
+				{Path: "propulsion.main.state", FunctionCode: protocol.READ_DISCRETE_INPUTS, Address: 6},
+			},
+		})
+	})
+	delta := func(context string, values map[string]interface{}) message.Mapped {
+		u := message.NewUpdate().WithSource(*message.NewSource().WithLabel("testingConnector").WithType("testingType")).WithTimestamp(time.Now())
+		for path, value := range values {
+			u.AddValue(message.NewValue().WithPath(path).WithValue(value))
+		}
+		return *message.NewMapped().WithContext(context).WithOrigin(context).AddUpdate(u)
+	}
+
+	Describe("Update", func() {
+		It("encodes the values with the scale, encoding and word order", func() {
+			writer.Update(delta("testingContext", map[string]interface{}{
+				"propulsion.main.revolutions":           1.5,
+				"environment.depth.belowKeel":           int64(-2),
+				"navigation.speedOverGround":            1.0,
+				"electrical.switches.anchorLight.state": true,
+				"propulsion.main.state":                 1.0,
+			}))
+
+			holding, err := writer.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{Addr: 0, Quantity: 2})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(holding).To(Equal([]uint16{15, 0xfffe}))
+			input, err := writer.HandleInputRegisters(&modbus.InputRegistersRequest{Addr: 10, Quantity: 2})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(input).To(Equal([]uint16{0x3f80, 0x0000}))
+			input, err = writer.HandleInputRegisters(&modbus.InputRegistersRequest{Addr: 20, Quantity: 2})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(input).To(Equal([]uint16{0x0000, 0x3f80}))
+			coils, err := writer.HandleCoils(&modbus.CoilsRequest{Addr: 5, Quantity: 1})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(coils).To(Equal([]bool{true}))
+			discreteInputs, err := writer.HandleDiscreteInputs(&modbus.DiscreteInputsRequest{Addr: 6, Quantity: 1})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(discreteInputs).To(Equal([]bool{true}))
+		})
+		It("ignores the values of other contexts", func() {
+			writer.Update(delta("otherContext", map[string]interface{}{"propulsion.main.revolutions": 1.5}))
+
+			holding, err := writer.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{Addr: 0, Quantity: 1})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(holding).To(Equal([]uint16{0}))
+		})
+		It("keeps the registers when a value can't be encoded", func() {
+			writer.Update(delta("testingContext", map[string]interface{}{"propulsion.main.revolutions": 1.5}))
+			writer.Update(delta("testingContext", map[string]interface{}{"propulsion.main.revolutions": "fast"}))
+
+			holding, err := writer.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{Addr: 0, Quantity: 1})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(holding).To(Equal([]uint16{15}))
+		})
+	})
+
+	Describe("Read", func() {
+		It("reads the addresses that are not configured as zero", func() {
+			holding, err := writer.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{Addr: 1, Quantity: 3})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(holding).To(Equal([]uint16{0, 0, 0}))
+		})
+		It("returns an illegal data address when none of the addresses is configured", func() {
+			_, err := writer.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{Addr: 100, Quantity: 2})
+			Expect(err).To(Equal(modbus.ErrIllegalDataAddress))
+			_, err = writer.HandleInputRegisters(&modbus.InputRegistersRequest{Addr: 0, Quantity: 2})
+			Expect(err).To(Equal(modbus.ErrIllegalDataAddress))
+			_, err = writer.HandleCoils(&modbus.CoilsRequest{Addr: 6, Quantity: 8})
+			Expect(err).To(Equal(modbus.ErrIllegalDataAddress))
+			_, err = writer.HandleDiscreteInputs(&modbus.DiscreteInputsRequest{Addr: 0, Quantity: 6})
+			Expect(err).To(Equal(modbus.ErrIllegalDataAddress))
+		})
+		It("refuses writes", func() {
+			_, err := writer.HandleHoldingRegisters(&modbus.HoldingRegistersRequest{Addr: 0, Quantity: 1, IsWrite: true, Args: []uint16{1}})
+			Expect(err).To(Equal(modbus.ErrIllegalFunction))
+			_, err = writer.HandleCoils(&modbus.CoilsRequest{Addr: 5, Quantity: 1, IsWrite: true, Args: []bool{true}})
+			Expect(err).To(Equal(modbus.ErrIllegalFunction))
+		})
+	})
+
+	Describe("RTU", func() {
+		It("reads a request with a valid crc", func() {
+			request, err := ReadRTURequest(bytes.NewReader([]byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x0a, 0xc5, 0xcd}))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(request).To(Equal([]byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x0a, 0xc5, 0xcd}))
+		})
+		It("fails on a request with an invalid crc", func() {
+			_, err := ReadRTURequest(bytes.NewReader([]byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x0a, 0xc5, 0xce}))
+			Expect(err).To(HaveOccurred())
+		})
+		It("fails on a truncated request", func() {
+			_, err := ReadRTURequest(bytes.NewReader([]byte{0x01, 0x03, 0x00, 0x00}))
+			Expect(err).To(Equal(io.ErrUnexpectedEOF))
+		})
+		It("answers a read with the registers", func() {
+			writer.Update(delta("testingContext", map[string]interface{}{"propulsion.main.revolutions": 1.5}))
+			response := writer.HandleRTURequest(rtuFrame(0x01, protocol.READ_HOLDING_REGISTERS, 0x00, 0x00, 0x00, 0x02))
+			Expect(response).To(Equal(rtuFrame(0x01, protocol.READ_HOLDING_REGISTERS, 0x04, 0x00, 0x0f, 0x00, 0x00)))
+		})
+		It("answers a read of coils with the packed bits", func() {
+			writer.Update(delta("testingContext", map[string]interface{}{"electrical.switches.anchorLight.state": true}))
+			response := writer.HandleRTURequest(rtuFrame(0x01, protocol.READ_COILS, 0x00, 0x00, 0x00, 0x08))
+			Expect(response).To(Equal(rtuFrame(0x01, protocol.READ_COILS, 0x01, 0x20)))
+		})
+		DescribeTable("answers with an exception",
+			func(request []byte, expected []byte) {
+				Expect(writer.HandleRTURequest(request)).To(Equal(expected))
+			},
+			Entry("illegal data address", rtuFrame(0x01, protocol.READ_HOLDING_REGISTERS, 0x00, 0x64, 0x00, 0x01), rtuFrame(0x01, protocol.READ_HOLDING_REGISTERS|0x80, protocol.MODBUS_EXCEPTION_ILLEGAL_DATA_ADDRESS)),
+			Entry("illegal data value", rtuFrame(0x01, protocol.READ_HOLDING_REGISTERS, 0x00, 0x00, 0x00, 0x00), rtuFrame(0x01, protocol.READ_HOLDING_REGISTERS|0x80, protocol.MODBUS_EXCEPTION_ILLEGAL_DATA_VALUE)),
+			Entry("write single register", rtuFrame(0x01, protocol.WRITE_SINGLE_REGISTER, 0x00, 0x00, 0x00, 0x01), rtuFrame(0x01, protocol.WRITE_SINGLE_REGISTER|0x80, protocol.MODBUS_EXCEPTION_ILLEGAL_FUNCTION)),
+			Entry("write multiple registers", rtuFrame(0x01, protocol.WRITE_MULTIPLE_REGISTERS, 0x00, 0x00, 0x00, 0x01, 0x02, 0x00, 0x01), rtuFrame(0x01, protocol.WRITE_MULTIPLE_REGISTERS|0x80, protocol.MODBUS_EXCEPTION_ILLEGAL_FUNCTION)),
+		)
+		It("doesn't answer requests for other slaves or broadcasts", func() {
+			Expect(writer.HandleRTURequest(rtuFrame(0x02, protocol.READ_HOLDING_REGISTERS, 0x00, 0x00, 0x00, 0x01))).To(BeNil())
+			Expect(writer.HandleRTURequest(rtuFrame(0x00, protocol.WRITE_SINGLE_REGISTER, 0x00, 0x00, 0x00, 0x01))).To(BeNil())
+		})
+		It("answers an unsupported function with an illegal function exception", func() {
+			port := &rtuPort{requests: bytes.NewBuffer(rtuFrame(0x01, 0x07))}
+			go writer.ServeRTU(port)
+			Eventually(port.Responses, time.Second).Should(Equal([][]byte{rtuFrame(0x01, 0x07|0x80, protocol.MODBUS_EXCEPTION_ILLEGAL_FUNCTION)}))
+		})
+		It("doesn't answer an unsupported function for another slave", func() {
+			port := &rtuPort{requests: bytes.NewBuffer(rtuFrame(0x02, 0x07))}
+			go writer.ServeRTU(port)
+			Consistently(port.Responses, 100*time.Millisecond).Should(BeEmpty())
+		})
+	})
+})
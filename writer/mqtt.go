@@ -12,6 +12,7 @@ import (
 	"github.com/munnik/gosk/logger"
 	"github.com/munnik/gosk/message"
 	"github.com/munnik/gosk/mqtt"
+	"github.com/munnik/gosk/spool"
 	"go.nanomsg.org/mangos/v3"
 	"go.uber.org/zap"
 )
@@ -20,6 +21,9 @@ const (
 	disconnectWait = 5000 // time to wait before disconnect in ms
 	keepAlive      = 30 * time.Second
 	writeTopic     = "vessels/urn:mrn:imo:mmsi:%s"
+
+	// spooled batches are also replayed periodically in case a signal was missed while the connection was down
+	replayInterval = 5 * time.Second
 )
 
 type MqttWriter struct {
@@ -32,6 +36,8 @@ type MqttWriter struct {
 	lastFlush      time.Time
 	encoder        *zstd.Encoder
 	writeMutex     sync.Mutex
+	spool          *spool.Spool
+	spooled        chan struct{} // signals the replay that a batch was added to the spool
 }
 
 func NewMqttWriter(c *config.MQTTConfig) *MqttWriter {
@@ -43,6 +49,18 @@ func NewMqttWriter(c *config.MQTTConfig) *MqttWriter {
 	w.bufferB = make([]*[]byte, 0, w.bufferCapacity)
 	w.writeMutex = sync.Mutex{}
 	w.lastFlush = time.Now()
+	if c.Spool != nil && c.Spool.Directory != "" {
+		s, err := spool.New(c.Spool)
+		if err != nil {
+			logger.GetLogger().Fatal(
+				"Could not open the spool",
+				zap.String("Directory", c.Spool.Directory),
+				zap.String("Error", err.Error()),
+			)
+		}
+		w.spool = s
+		w.spooled = make(chan struct{}, 1)
+	}
 	return w
 }
 
@@ -55,15 +73,70 @@ func (w *MqttWriter) sendMQTT(deltas []message.Mapped) {
 		)
 		return
 	}
-	go func(context string, bytes []byte) {
-		compressed := w.encoder.EncodeAll(bytes, make([]byte, 0, len(bytes)))
-		w.mqttClient.Publish(context, 0, true, compressed)
-	}(fmt.Sprintf(writeTopic, w.mqttConfig.Username), bytes)
+	if w.spool == nil {
+		go func(context string, bytes []byte) {
+			compressed := w.encoder.EncodeAll(bytes, make([]byte, 0, len(bytes)))
+			w.mqttClient.Publish(context, 0, true, compressed)
+		}(fmt.Sprintf(writeTopic, w.mqttConfig.Username), bytes)
+		return
+	}
+
+	// the batch is only removed from the spool when the broker confirmed it, see replaySpool
+	if err := w.spool.Append(w.encoder.EncodeAll(bytes, make([]byte, 0, len(bytes)))); err != nil {
+		logger.GetLogger().Warn(
+			"Could not append the deltas to the spool",
+			zap.String("Error", err.Error()),
+		)
+		return
+	}
+	select {
+	case w.spooled <- struct{}{}:
+	default:
+	}
+}
+
+// replaySpool publishes the spooled batches in order, a batch is removed from the spool when the broker confirmed it
+func (w *MqttWriter) replaySpool(topic string) {
+	ticker := time.NewTicker(replayInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.spooled:
+		case <-ticker.C:
+		}
+		for w.mqttClient.IsConnected() {
+			compressed, err := w.spool.Peek()
+			if err == spool.ErrEmpty {
+				break
+			}
+			if err != nil {
+				logger.GetLogger().Warn(
+					"Could not read from the spool",
+					zap.String("Error", err.Error()),
+				)
+				break
+			}
+			if err := w.mqttClient.Publish(topic, 1, true, compressed); err != nil {
+				break
+			}
+			if err := w.spool.Ack(); err != nil {
+				logger.GetLogger().Warn(
+					"Could not acknowledge the batch in the spool",
+					zap.String("Error", err.Error()),
+				)
+				break
+			}
+		}
+	}
 }
 
 func (w *MqttWriter) WriteMapped(subscriber mangos.Socket) {
 	w.mqttClient = mqtt.New(w.mqttConfig, nil, "")
 	defer w.mqttClient.Disconnect()
+	if w.spool != nil {
+		defer w.spool.Close()
+		go w.replaySpool(fmt.Sprintf(writeTopic, w.mqttConfig.Username))
+	}
 
 	for {
 		received, err := subscriber.Recv()
@@ -0,0 +1,239 @@
+package writer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/goburrow/serial"
+	"github.com/munnik/gosk/config"
+	"github.com/munnik/gosk/logger"
+	"github.com/munnik/gosk/message"
+	"go.nanomsg.org/mangos/v3"
+	"go.uber.org/zap"
+)
+
+// NMEA0183Writer encodes the received mapped values as NMEA 0183 sentences
+type NMEA0183Writer struct {
+	config    *config.NMEA0183WriterConfig
+	publisher mangos.Socket
+	mu        sync.Mutex
+	values    nmea0183Values
+	output    io.Writer
+}
+
+func NewNMEA0183Writer(c *config.NMEA0183WriterConfig) *NMEA0183Writer {
+	return &NMEA0183Writer{
+		config: c,
+		values: make(nmea0183Values),
+	}
+}
+
+// WithPublisher sets the socket the sentences are published on as raw messages, this is used when no URL is configured
+func (w *NMEA0183Writer) WithPublisher(p mangos.Socket) *NMEA0183Writer {
+	w.publisher = p
+	return w
+}
+
+func (w *NMEA0183Writer) WriteMapped(subscriber mangos.Socket) {
+	output, err := w.openOutput()
+	if err != nil {
+		logger.GetLogger().Fatal(
+			"Could not open the output",
+			zap.String("URL", w.config.URLString),
+			zap.String("Error", err.Error()),
+		)
+	}
+	w.output = output
+
+	for _, s := range w.config.Sentences {
+		if _, ok := nmea0183Encoders[s.Type]; !ok {
+			logger.GetLogger().Warn(
+				"Unsupported sentence type",
+				zap.String("Type", s.Type),
+			)
+			continue
+		}
+		go w.send(s)
+	}
+
+	for {
+		received, err := subscriber.Recv()
+		if err != nil {
+			logger.GetLogger().Warn(
+				"Could not receive a message from the publisher",
+				zap.String("Error", err.Error()),
+			)
+			continue
+		}
+		var mapped message.Mapped
+		if err := json.Unmarshal(received, &mapped); err != nil {
+			logger.GetLogger().Warn(
+				"Could not unmarshal the received data",
+				zap.ByteString("Received", received),
+				zap.String("Error", err.Error()),
+			)
+			continue
+		}
+		w.update(mapped)
+	}
+}
+
+// update stores the values of the configured context
+func (w *NMEA0183Writer) update(mapped message.Mapped) {
+	if w.config.Context != "" && mapped.Context != w.config.Context {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, u := range mapped.Updates {
+		for _, v := range u.Values {
+			if current, ok := w.values[v.Path]; ok && current.timestamp.After(u.Timestamp) {
+				continue
+			}
+			w.values[v.Path] = nmea0183Value{value: v.Value, timestamp: u.Timestamp}
+		}
+	}
+}
+
+// recentValues returns the values that are not older than the timeout
+func (w *NMEA0183Writer) recentValues(timeout time.Duration, now time.Time) nmea0183Values {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	result := make(nmea0183Values, len(w.values))
+	for path, v := range w.values {
+		if now.Sub(v.timestamp) <= timeout {
+			result[path] = v
+		}
+	}
+	return result
+}
+
+// send encodes the sentence at the configured interval, nothing is sent when the required values are not available
+func (w *NMEA0183Writer) send(c config.NMEA0183SentenceConfig) {
+	ticker := time.NewTicker(c.Interval)
+	for now := range ticker.C {
+		sentence, ok := encodeSentence(c, w.recentValues(c.Timeout, now))
+		if !ok {
+			continue
+		}
+		if w.output == nil {
+			w.publish(sentence)
+			continue
+		}
+		if _, err := w.output.Write([]byte(sentence + "\r\n")); err != nil {
+			logger.GetLogger().Warn(
+				"Could not write the sentence",
+				zap.String("Sentence", sentence),
+				zap.String("Error", err.Error()),
+			)
+		}
+	}
+}
+
+// publish sends the sentence as a raw message, so it can be used by other writers like the LWE writer
+func (w *NMEA0183Writer) publish(sentence string) {
+	raw := message.NewRaw().WithConnector(w.config.Connector).WithType(config.NMEA0183Type).WithValue([]byte(sentence))
+	bytes, err := json.Marshal(raw)
+	if err != nil {
+		logger.GetLogger().Warn(
+			"Could not marshal the raw message",
+			zap.String("Error", err.Error()),
+		)
+		return
+	}
+	if err := w.publisher.Send(bytes); err != nil {
+		logger.GetLogger().Warn(
+			"Unable to send the message using NanoMSG",
+			zap.ByteString("Message", bytes),
+			zap.String("Error", err.Error()),
+		)
+	}
+}
+
+// openOutput returns the writer for the configured URL, nil is returned when the sentences should be published
+func (w *NMEA0183Writer) openOutput() (io.Writer, error) {
+	if w.config.URL == nil || w.config.URL.Scheme == "" {
+		if w.publisher == nil {
+			return nil, fmt.Errorf("no URL is configured and there is no publisher for the raw messages")
+		}
+		return nil, nil
+	}
+	switch w.config.URL.Scheme {
+	case "tcp":
+		listener, err := net.Listen("tcp", w.config.URL.Host)
+		if err != nil {
+			return nil, err
+		}
+		clients := newTCPClients()
+		go clients.accept(listener)
+		return clients, nil
+	case "udp":
+		address, err := net.ResolveUDPAddr("udp4", w.config.URL.Host)
+		if err != nil {
+			return nil, err
+		}
+		return net.DialUDP("udp4", nil, address)
+	case "file":
+		return serial.Open(&serial.Config{
+			Address:  w.config.URL.Path,
+			BaudRate: w.config.BaudRate,
+			DataBits: 8,
+			StopBits: 1,
+			Parity:   "N",
+			Timeout:  time.Second,
+		})
+	}
+	return nil, fmt.Errorf("unsupported scheme %v", w.config.URL.Scheme)
+}
+
+// tcpClients writes to all connected clients, clients that can't be written to are disconnected
+type tcpClients struct {
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+func newTCPClients() *tcpClients {
+	return &tcpClients{conns: make(map[net.Conn]struct{})}
+}
+
+func (c *tcpClients) accept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			logger.GetLogger().Warn(
+				"Could not accept the connection, retrying in 5 seconds",
+				zap.String("Error", err.Error()),
+			)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		c.mu.Lock()
+		c.conns[conn] = struct{}{}
+		c.mu.Unlock()
+	}
+}
+
+func (c *tcpClients) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for conn := range c.conns {
+		conn.SetWriteDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Write(p); err != nil {
+			conn.Close()
+			delete(c.conns, conn)
+		}
+	}
+	return len(p), nil
+}
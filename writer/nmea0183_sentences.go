@@ -0,0 +1,305 @@
+package writer
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/munnik/go-nmea"
+	"github.com/munnik/gosk/config"
+	"github.com/munnik/gosk/message"
+)
+
+const (
+	metersPerSecondToKnots = 3600.0 / 1852.0
+	metersPerSecondToKmh   = 3.6
+	metersToFeet           = 1 / 0.3048
+	metersToFathoms        = 1 / 1.8288
+	kelvinOffset           = 273.15
+)
+
+// nmea0183Value is the last received value of a path
+type nmea0183Value struct {
+	value     interface{}
+	timestamp time.Time
+}
+
+// nmea0183Values are the values that can be used to encode a sentence
+type nmea0183Values map[string]nmea0183Value
+
+func (v nmea0183Values) float(path string) (float64, bool) {
+	switch f := v[path].value.(type) {
+	case float64:
+		return f, true
+	case int64:
+		return float64(f), true
+	}
+	return 0, false
+}
+
+func (v nmea0183Values) position() (message.Position, bool) {
+	p, ok := v["navigation.position"].value.(message.Position)
+	return p, ok && p.Latitude != nil && p.Longitude != nil
+}
+
+// common talker IDs of the sentences, used when no talker ID is configured
+var nmea0183TalkerIDs = map[string]string{
+	"RMC": "GP",
+	"GGA": "GP",
+	"VTG": "GP",
+	"HDT": "HE",
+	"DBT": "SD",
+	"MWV": "WI",
+	"XDR": "II",
+	"RSA": "II",
+	"ROT": "TI",
+}
+
+// nmea0183Encoder returns the fields of the sentence, false is returned when the required values are not available
+type nmea0183Encoder func(c config.NMEA0183SentenceConfig, v nmea0183Values) ([]string, bool)
+
+var nmea0183Encoders = map[string]nmea0183Encoder{
+	"RMC": encodeRMC,
+	"GGA": encodeGGA,
+	"VTG": encodeVTG,
+	"HDT": encodeHDT,
+	"DBT": encodeDBT,
+	"MWV": encodeMWV,
+	"XDR": encodeXDR,
+	"RSA": encodeRSA,
+	"ROT": encodeROT,
+}
+
+// encodeSentence creates the sentence including the checksum, without the line ending
+func encodeSentence(c config.NMEA0183SentenceConfig, v nmea0183Values) (string, bool) {
+	encoder, ok := nmea0183Encoders[c.Type]
+	if !ok {
+		return "", false
+	}
+	fields, ok := encoder(c, v)
+	if !ok {
+		return "", false
+	}
+	talkerID := c.TalkerID
+	if talkerID == "" {
+		talkerID = nmea0183TalkerIDs[c.Type]
+	}
+	sentence := "$" + talkerID + c.Type + "," + strings.Join(fields, ",")
+	return sentence + "*" + nmea.Checksum(sentence[1:]), true
+}
+
+func encodeRMC(c config.NMEA0183SentenceConfig, v nmea0183Values) ([]string, bool) {
+	p, ok := v.position()
+	if !ok {
+		return nil, false
+	}
+	t := v["navigation.position"].timestamp.UTC()
+	latitude, ns := formatLatitude(*p.Latitude)
+	longitude, ew := formatLongitude(*p.Longitude)
+	variation, variationDirection := "", ""
+	if f, ok := v.float("navigation.magneticVariation"); ok {
+		variation, variationDirection = formatFloat(math.Abs(radToDeg(f)), 1), "E"
+		if f < 0 {
+			variationDirection = "W"
+		}
+	}
+	return []string{
+		t.Format("150405.00"),
+		"A",
+		latitude, ns,
+		longitude, ew,
+		formatOptional(v, "navigation.speedOverGround", metersPerSecondToKnots, 1),
+		formatOptional(v, "navigation.courseOverGroundTrue", 180/math.Pi, 1),
+		t.Format("020106"),
+		variation, variationDirection,
+		"A",
+	}, true
+}
+
+// SignalK method quality values and the matching GGA fix quality
+var ggaFixQualities = map[string]string{
+	"no GPS":              "0",
+	"GNSS Fix":            "1",
+	"DGNSS fix":           "2",
+	"Precise GNSS":        "3",
+	"RTK fixed integer":   "4",
+	"RTK float":           "5",
+	"Estimated (DR) mode": "6",
+	"Manual input":        "7",
+	"Simulator mode":      "8",
+}
+
+func encodeGGA(c config.NMEA0183SentenceConfig, v nmea0183Values) ([]string, bool) {
+	p, ok := v.position()
+	if !ok {
+		return nil, false
+	}
+	latitude, ns := formatLatitude(*p.Latitude)
+	longitude, ew := formatLongitude(*p.Longitude)
+	quality := "1"
+	if s, ok := v["navigation.gnss.methodQuality"].value.(string); ok {
+		if q, ok := ggaFixQualities[s]; ok {
+			quality = q
+		} else if _, err := strconv.Atoi(s); err == nil {
+			quality = s // the NMEA 0183 mapper uses the fix quality of the GGA sentence
+		}
+	}
+	altitude := formatOptional(v, "navigation.gnss.antennaAltitude", 1, 1)
+	if altitude == "" && p.Altitude != nil {
+		altitude = formatFloat(*p.Altitude, 1)
+	}
+	separation := formatOptional(v, "navigation.gnss.geoidalSeparation", 1, 1)
+	return []string{
+		v["navigation.position"].timestamp.UTC().Format("150405.00"),
+		latitude, ns,
+		longitude, ew,
+		quality,
+		formatOptional(v, "navigation.gnss.satellites", 1, 0),
+		formatOptional(v, "navigation.gnss.horizontalDilution", 1, 1),
+		altitude, "M",
+		separation, "M",
+		"", "",
+	}, true
+}
+
+func encodeVTG(c config.NMEA0183SentenceConfig, v nmea0183Values) ([]string, bool) {
+	_, sogOk := v.float("navigation.speedOverGround")
+	_, cogOk := v.float("navigation.courseOverGroundTrue")
+	if !sogOk && !cogOk {
+		return nil, false
+	}
+	return []string{
+		formatOptional(v, "navigation.courseOverGroundTrue", 180/math.Pi, 1), "T",
+		formatOptional(v, "navigation.courseOverGroundMagnetic", 180/math.Pi, 1), "M",
+		formatOptional(v, "navigation.speedOverGround", metersPerSecondToKnots, 1), "N",
+		formatOptional(v, "navigation.speedOverGround", metersPerSecondToKmh, 1), "K",
+		"A",
+	}, true
+}
+
+func encodeHDT(c config.NMEA0183SentenceConfig, v nmea0183Values) ([]string, bool) {
+	heading, ok := v.float("navigation.headingTrue")
+	if !ok {
+		return nil, false
+	}
+	return []string{formatFloat(radToDeg(heading), 1), "T"}, true
+}
+
+func encodeDBT(c config.NMEA0183SentenceConfig, v nmea0183Values) ([]string, bool) {
+	depth, ok := v.float("environment.depth.belowTransducer")
+	if !ok {
+		return nil, false
+	}
+	return []string{
+		formatFloat(depth*metersToFeet, 1), "f",
+		formatFloat(depth, 1), "M",
+		formatFloat(depth*metersToFathoms, 1), "F",
+	}, true
+}
+
+func encodeMWV(c config.NMEA0183SentenceConfig, v nmea0183Values) ([]string, bool) {
+	angle, angleOk := v.float("environment.wind.angleApparent")
+	speed, speedOk := v.float("environment.wind.speedApparent")
+	if !angleOk || !speedOk {
+		return nil, false
+	}
+	// the apparent wind angle is -π to π, MWV uses 0 to 360 degrees
+	return []string{formatFloat(math.Mod(radToDeg(angle)+360, 360), 1), "R", formatFloat(speed, 1), "M", "A"}, true
+}
+
+// units of the XDR transducer types and the conversion from the SignalK unit
+var xdrUnits = map[string]struct {
+	unit    string
+	convert func(float64) float64
+}{
+	"C": {"C", func(f float64) float64 { return f - kelvinOffset }},
+	"P": {"P", func(f float64) float64 { return f }},
+	"A": {"D", radToDeg},
+	"U": {"V", func(f float64) float64 { return f }},
+	"I": {"A", func(f float64) float64 { return f }},
+	"H": {"P", func(f float64) float64 { return f * 100 }},
+	"T": {"R", func(f float64) float64 { return f * 60 }},
+	"G": {"", func(f float64) float64 { return f }},
+}
+
+func encodeXDR(c config.NMEA0183SentenceConfig, v nmea0183Values) ([]string, bool) {
+	result := make([]string, 0, 4*len(c.Transducers))
+	for _, t := range c.Transducers {
+		f, ok := v.float(t.Path)
+		if !ok {
+			continue
+		}
+		unit, ok := xdrUnits[t.Type]
+		if !ok {
+			continue
+		}
+		result = append(result, t.Type, formatFloat(unit.convert(f), 2), unit.unit, t.Name)
+	}
+	return result, len(result) > 0
+}
+
+func encodeRSA(c config.NMEA0183SentenceConfig, v nmea0183Values) ([]string, bool) {
+	angle, ok := v.float("steering.rudderAngle")
+	if !ok {
+		return nil, false
+	}
+	return []string{formatFloat(radToDeg(angle), 1), "A", "", "V"}, true
+}
+
+func encodeROT(c config.NMEA0183SentenceConfig, v nmea0183Values) ([]string, bool) {
+	rateOfTurn, ok := v.float("navigation.rateOfTurn")
+	if !ok {
+		return nil, false
+	}
+	// rad/s to degrees per minute
+	return []string{formatFloat(radToDeg(rateOfTurn)*60, 1), "A"}, true
+}
+
+func radToDeg(f float64) float64 {
+	return f * 180 / math.Pi
+}
+
+func formatFloat(f float64, precision int) string {
+	return strconv.FormatFloat(f, 'f', precision, 64)
+}
+
+// formatOptional returns an empty field when the value of the path is not available
+func formatOptional(v nmea0183Values, path string, factor float64, precision int) string {
+	f, ok := v.float(path)
+	if !ok {
+		return ""
+	}
+	return formatFloat(f*factor, precision)
+}
+
+func formatLatitude(f float64) (string, string) {
+	direction := "N"
+	if f < 0 {
+		direction = "S"
+	}
+	degrees, minutes := degreesMinutes(f)
+	return fmt.Sprintf("%02d%08.5f", degrees, minutes), direction
+}
+
+func formatLongitude(f float64) (string, string) {
+	direction := "E"
+	if f < 0 {
+		direction = "W"
+	}
+	degrees, minutes := degreesMinutes(f)
+	return fmt.Sprintf("%03d%08.5f", degrees, minutes), direction
+}
+
+// degreesMinutes splits the absolute value of the coordinate in degrees and minutes, the minutes are rounded to the 5
+// decimals of the sentence first so 60 minutes is carried into the degrees
+func degreesMinutes(f float64) (int, float64) {
+	degrees, fraction := math.Modf(math.Abs(f))
+	minutes := math.Round(fraction*60*1e5) / 1e5
+	if minutes >= 60 {
+		degrees++
+		minutes -= 60
+	}
+	return int(degrees), minutes
+}
@@ -0,0 +1,114 @@
+package writer_test
+
+import (
+	"math"
+	"time"
+
+	"github.com/munnik/gosk/config"
+	"github.com/munnik/gosk/message"
+	. "github.com/munnik/gosk/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NMEA 0183 coordinates", func() {
+	DescribeTable("Latitude",
+		func(input float64, expected string, expectedDirection string) {
+			result, direction := FormatLatitude(input)
+			Expect(result).To(Equal(expected))
+			Expect(direction).To(Equal(expectedDirection))
+		},
+		Entry("Zero", 0.0, "0000.00000", "N"),
+		Entry("Half a degree", 52.5, "5230.00000", "N"),
+		Entry("Southern hemisphere", -33.25, "3315.00000", "S"),
+		Entry("Minutes are rounded to 60", 52.99999999, "5300.00000", "N"),
+		Entry("Minutes are rounded to 60 on the southern hemisphere", -52.99999999, "5300.00000", "S"),
+		Entry("Minutes just below 60", 52.9999998, "5259.99999", "N"),
+	)
+	DescribeTable("Longitude",
+		func(input float64, expected string, expectedDirection string) {
+			result, direction := FormatLongitude(input)
+			Expect(result).To(Equal(expected))
+			Expect(direction).To(Equal(expectedDirection))
+		},
+		Entry("Zero", 0.0, "00000.00000", "E"),
+		Entry("Quarter of a degree", 4.25, "00415.00000", "E"),
+		Entry("Western hemisphere", -122.5, "12230.00000", "W"),
+		Entry("Minutes are rounded to 60", 4.99999999, "00500.00000", "E"),
+		Entry("Minutes are rounded to 60 at the antimeridian", -179.99999999, "18000.00000", "W"),
+	)
+})
+
+var _ = Describe("NMEA 0183 sentences", func() {
+	timestamp := time.Date(2022, 5, 10, 12, 34, 56, 780000000, time.UTC)
+	latitude := 52.5
+	longitude := 4.25
+	values := map[string]interface{}{
+		"navigation.position":                message.Position{Latitude: &latitude, Longitude: &longitude},
+		"navigation.speedOverGround":         5.0,
+		"navigation.courseOverGroundTrue":    1.0,
+		"navigation.magneticVariation":       -0.05,
+		"navigation.gnss.methodQuality":      "DGNSS fix",
+		"navigation.gnss.satellites":         int64(8),
+		"navigation.gnss.horizontalDilution": 0.9,
+		"navigation.gnss.antennaAltitude":    12.34,
+		"navigation.gnss.geoidalSeparation":  47.0,
+		"navigation.headingTrue":             1.0,
+		"environment.depth.belowTransducer":  10.0,
+		"environment.wind.angleApparent":     -math.Pi / 2,
+		"environment.wind.speedApparent":     7.3,
+		"environment.outside.temperature":    293.15,
+		"environment.outside.pressure":       101325.0,
+		"steering.rudderAngle":               0.1,
+		"navigation.rateOfTurn":              0.01,
+	}
+
+	DescribeTable("Encode",
+		func(c config.NMEA0183SentenceConfig, expected string) {
+			result, ok := EncodeSentence(c, values, timestamp)
+			Expect(ok).To(BeTrue())
+			Expect(result).To(Equal(expected))
+		},
+		Entry("RMC", config.NMEA0183SentenceConfig{Type: "RMC"}, "$GPRMC,123456.78,A,5230.00000,N,00415.00000,E,9.7,57.3,100522,2.9,W,A*1B"),
+		Entry("GGA", config.NMEA0183SentenceConfig{Type: "GGA"}, "$GPGGA,123456.78,5230.00000,N,00415.00000,E,2,8,0.9,12.3,M,47.0,M,,*61"),
+		Entry("HDT", config.NMEA0183SentenceConfig{Type: "HDT"}, "$HEHDT,57.3,T*1E"),
+		Entry("VTG", config.NMEA0183SentenceConfig{Type: "VTG"}, "$GPVTG,57.3,T,,M,9.7,N,18.0,K,A*0B"),
+		Entry("DBT", config.NMEA0183SentenceConfig{Type: "DBT"}, "$SDDBT,32.8,f,10.0,M,5.5,F*0E"),
+		Entry("MWV", config.NMEA0183SentenceConfig{Type: "MWV"}, "$WIMWV,270.0,R,7.3,M,A*21"),
+		Entry("XDR", config.NMEA0183SentenceConfig{Type: "XDR", Transducers: []config.XDRTransducerConfig{
+			{Path: "environment.outside.temperature", Type: "C", Name: "AIRTEMP"},
+			{Path: "environment.outside.pressure", Type: "P", Name: "BARO"},
+			{Path: "environment.outside.humidity", Type: "H", Name: "HUMIDITY"},
+		}}, "$IIXDR,C,20.00,C,AIRTEMP,P,101325.00,P,BARO*00"),
+		Entry("RSA", config.NMEA0183SentenceConfig{Type: "RSA"}, "$IIRSA,5.7,A,,V*7B"),
+		Entry("ROT", config.NMEA0183SentenceConfig{Type: "ROT"}, "$TIROT,34.4,A*08"),
+		Entry("Configured talker ID", config.NMEA0183SentenceConfig{Type: "HDT", TalkerID: "HC"}, "$HCHDT,57.3,T*18"),
+	)
+
+	DescribeTable("Missing values",
+		func(c config.NMEA0183SentenceConfig) {
+			_, ok := EncodeSentence(c, map[string]interface{}{}, timestamp)
+			Expect(ok).To(BeFalse())
+		},
+		Entry("RMC", config.NMEA0183SentenceConfig{Type: "RMC"}),
+		Entry("GGA", config.NMEA0183SentenceConfig{Type: "GGA"}),
+		Entry("HDT", config.NMEA0183SentenceConfig{Type: "HDT"}),
+		Entry("VTG", config.NMEA0183SentenceConfig{Type: "VTG"}),
+		Entry("DBT", config.NMEA0183SentenceConfig{Type: "DBT"}),
+		Entry("MWV", config.NMEA0183SentenceConfig{Type: "MWV"}),
+		Entry("XDR", config.NMEA0183SentenceConfig{Type: "XDR", Transducers: []config.XDRTransducerConfig{{Path: "environment.outside.temperature", Type: "C", Name: "AIRTEMP"}}}),
+		Entry("RSA", config.NMEA0183SentenceConfig{Type: "RSA"}),
+		Entry("ROT", config.NMEA0183SentenceConfig{Type: "ROT"}),
+	)
+
+	It("Fails on an unknown sentence type", func() {
+		_, ok := EncodeSentence(config.NMEA0183SentenceConfig{Type: "XYZ"}, values, timestamp)
+		Expect(ok).To(BeFalse())
+	})
+	It("Fails on a position without longitude", func() {
+		_, ok := EncodeSentence(config.NMEA0183SentenceConfig{Type: "RMC"}, map[string]interface{}{
+			"navigation.position": message.Position{Latitude: &latitude},
+		}, timestamp)
+		Expect(ok).To(BeFalse())
+	})
+})
@@ -0,0 +1,27 @@
+package writer_test
+
+import (
+	"net"
+	"time"
+
+	. "github.com/munnik/gosk/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NMEA 0183 TCP clients", func() {
+	It("stops accepting connections when the listener is closed", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		done := make(chan struct{})
+		go func() {
+			AcceptTCPClients(listener)
+			close(done)
+		}()
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close()
+		listener.Close()
+		Eventually(done, time.Second).Should(BeClosed())
+	})
+})
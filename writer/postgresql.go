@@ -25,15 +25,17 @@ type PostgresqlWriter struct {
 	messagesWritten      prometheus.Counter
 }
 
-func NewPostgresqlWriter(c *config.PostgresqlConfig) *PostgresqlWriter {
+// NewPostgresqlWriter creates the writer, the metrics are registered with reg
+func NewPostgresqlWriter(c *config.PostgresqlConfig, reg prometheus.Registerer) *PostgresqlWriter {
+	factory := promauto.With(reg)
 	return &PostgresqlWriter{
-		db:                   database.NewPostgresqlDatabase(c),
+		db:                   database.NewPostgresqlDatabase(c, reg),
 		mappedChannel:        make(chan message.Mapped, c.BufferSize),
 		rawChannel:           make(chan message.Raw, c.BufferSize),
 		numberOfWorkers:      c.NumberOfWorkers,
-		messagesReceived:     promauto.NewCounter(prometheus.CounterOpts{Name: "gosk_psql_messages_received_total", Help: "total number of received nano messages"}),
-		messagesUnmarshalled: promauto.NewCounter(prometheus.CounterOpts{Name: "gosk_psql_messages_unmarshalled_total", Help: "total number of unmarshalled nano messages"}),
-		messagesWritten:      promauto.NewCounter(prometheus.CounterOpts{Name: "gosk_psql_messages_written_total", Help: "total number of nano messages sent to db"}),
+		messagesReceived:     factory.NewCounter(prometheus.CounterOpts{Name: "gosk_psql_messages_received_total", Help: "total number of received nano messages"}),
+		messagesUnmarshalled: factory.NewCounter(prometheus.CounterOpts{Name: "gosk_psql_messages_unmarshalled_total", Help: "total number of unmarshalled nano messages"}),
+		messagesWritten:      factory.NewCounter(prometheus.CounterOpts{Name: "gosk_psql_messages_written_total", Help: "total number of nano messages sent to db"}),
 	}
 }
 
@@ -11,7 +11,10 @@ import (
 	"github.com/munnik/gosk/config"
 	"github.com/munnik/gosk/database"
 	"github.com/munnik/gosk/logger"
+	"github.com/munnik/gosk/mapper"
 	"github.com/munnik/gosk/message"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"go.nanomsg.org/mangos/v3"
 	"go.uber.org/zap"
 )
@@ -26,18 +29,32 @@ type SignalKWriter struct {
 	config           *config.SignalKConfig
 	database         *database.PostgresqlDatabase
 	cache            *database.BigCache
+	meta             *metaStore
+	sources          sourcePriorities
 	wg               *sync.WaitGroup
 	mu               sync.Mutex
 	websocketClients map[string]websocketClient
+	publishers       map[string]mangos.Socket   // map of URL to publisher, used to send PUT requests to connectors
+	putExpressions   []*mapper.ExpressionRunner // compiled expressions of the PUT mappings, nil when a mapping has no expression
+	requestsMu       sync.Mutex
+	requests         map[string]requestStatus
+	deltasDropped    prometheus.Counter
 }
 
-func NewSignalKWriter(c *config.SignalKConfig) *SignalKWriter {
+// NewSignalKWriter creates the writer, the metrics are registered with reg
+func NewSignalKWriter(c *config.SignalKConfig, reg prometheus.Registerer) *SignalKWriter {
 	return &SignalKWriter{
 		config:           c,
-		database:         database.NewPostgresqlDatabase(c.PostgresqlConfig),
+		putExpressions:   createPutExpressions(c),
+		database:         database.NewPostgresqlDatabase(c.PostgresqlConfig, reg),
 		cache:            database.NewBigCache(c.BigCacheConfig),
+		meta:             newMetaStore(c),
+		sources:          newSourcePriorities(c.SourcePriorities),
 		wg:               &sync.WaitGroup{},
 		websocketClients: make(map[string]websocketClient, 0),
+		publishers:       make(map[string]mangos.Socket),
+		requests:         make(map[string]requestStatus),
+		deltasDropped:    promauto.With(reg).NewCounter(prometheus.CounterOpts{Name: "gosk_signalk_ws_deltas_dropped_total", Help: "total number of deltas that are not sent to a websocket client because the client can't keep up"}),
 	}
 }
 
@@ -46,11 +63,16 @@ func (w *SignalKWriter) WriteMapped(subscriber mangos.Socket) {
 	w.wg.Add(1)
 	go w.readFromDatabase()
 	go w.receive(subscriber)
+	w.createPublishers()
 
 	router := chi.NewRouter()
 	router.Use(middleware.Compress(5))
 
 	router.Get(SignalKHTTPPath+"*", w.serveFullDataModel)
+	router.Put(SignalKHTTPPath+"*", w.servePut)
+	router.Get(SignalKRequestsPath+"{id}", w.serveRequest)
+	router.Get(SignalKHistoryPath, w.serveHistory)
+	router.Get(SignalKPlaybackPath, w.servePlayback)
 	router.Get(SignalKEndpointsPath, w.serveEndpoints)
 	router.Get(SignalKWSPath, w.serveWebsocket)
 
@@ -84,6 +106,8 @@ func (w *SignalKWriter) receive(subscriber mangos.Socket) {
 			)
 			continue
 		}
+		// update the metadata before the delta is sent to the clients, the clients use the store to send metadata
+		w.meta.update(mapped)
 		go w.updateFullDataModel(mapped)
 		go w.updateWebsocket(mapped)
 	}
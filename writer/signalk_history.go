@@ -0,0 +1,274 @@
+package writer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/munnik/gosk/database"
+	"github.com/munnik/gosk/logger"
+	"github.com/munnik/gosk/message"
+	"go.uber.org/zap"
+	"nhooyr.io/websocket"
+)
+
+const (
+	SignalKHistoryPath  = "/signalk/v1/history/values"
+	SignalKPlaybackPath = "/signalk/v1/playback"
+
+	defaultHistoryResolution = time.Minute
+	defaultHistoryRange      = time.Hour
+
+	// stored deltas are read from the database in windows of this duration during playback
+	playbackWindow = time.Minute
+)
+
+type historyRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+type historyPath struct {
+	Path   string `json:"path"`
+	Method string `json:"method"`
+}
+
+type historyValues struct {
+	Context string          `json:"context"`
+	Range   historyRange    `json:"range"`
+	Values  []historyPath   `json:"values"`
+	Data    [][]interface{} `json:"data"`
+}
+
+// historyRequest contains the parameters of a history request
+type historyRequest struct {
+	context    string
+	from       time.Time
+	to         time.Time
+	resolution time.Duration
+	paths      []historyPath
+}
+
+type playbackHello struct {
+	hello
+	StartTime    time.Time `json:"startTime"`
+	PlaybackRate float64   `json:"playbackRate"`
+}
+
+// serveHistory handles requests like ?paths=navigation.speedOverGround:max,navigation.position&from=...&to=...&resolution=60,
+// the values of the paths are aggregated in buckets of the resolution and returned as rows
+func (w *SignalKWriter) serveHistory(rw http.ResponseWriter, r *http.Request) {
+	request, err := parseHistoryRequest(r.URL.Query(), w.config.SelfContext, time.Now())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result := historyValues{
+		Context: request.context,
+		Range:   historyRange{From: request.from, To: request.to},
+		Values:  make([]historyPath, 0),
+		Data:    make([][]interface{}, 0),
+	}
+	rows := make(map[time.Time][]interface{})
+	for _, hp := range request.paths {
+		values, err := w.database.SelectHistory(request.context, hp.Path, hp.Method, request.from, request.to, request.resolution)
+		if err != nil {
+			logger.GetLogger().Warn(
+				"Could not retrieve the history from the database",
+				zap.String("Path", hp.Path),
+				zap.String("Error", err.Error()),
+			)
+			http.Error(rw, "could not retrieve the history", http.StatusInternalServerError)
+			return
+		}
+		for _, v := range values {
+			row := rows[v.Timestamp]
+			// fill the columns of previous paths without a value in this bucket
+			for len(row) < len(result.Values) {
+				row = append(row, nil)
+			}
+			rows[v.Timestamp] = append(row, v.Value)
+		}
+		result.Values = append(result.Values, hp)
+	}
+
+	for timestamp, values := range rows {
+		for len(values) < len(result.Values) {
+			values = append(values, nil)
+		}
+		result.Data = append(result.Data, append([]interface{}{timestamp}, values...))
+	}
+	sort.Slice(result.Data, func(i, j int) bool {
+		return result.Data[i][0].(time.Time).Before(result.Data[j][0].(time.Time))
+	})
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(result)
+}
+
+// parseHistoryRequest parses the query parameters of a history request, the range ends at now when to is missing and
+// starts an hour before the end when from is missing
+func parseHistoryRequest(query url.Values, self string, now time.Time) (historyRequest, error) {
+	result := historyRequest{context: self, to: now}
+	var err error
+	if query.Get("to") != "" {
+		if result.to, err = time.Parse(time.RFC3339, query.Get("to")); err != nil {
+			return historyRequest{}, err
+		}
+	}
+	result.from = result.to.Add(-defaultHistoryRange)
+	if query.Get("from") != "" {
+		if result.from, err = time.Parse(time.RFC3339, query.Get("from")); err != nil {
+			return historyRequest{}, err
+		}
+	}
+	if !result.from.Before(result.to) {
+		return historyRequest{}, fmt.Errorf("from should be before to")
+	}
+	if result.resolution, err = parseResolution(query.Get("resolution")); err != nil {
+		return historyRequest{}, err
+	}
+	if query.Get("context") != "" && query.Get("context") != selfContext {
+		result.context = query.Get("context")
+	}
+
+	for _, p := range strings.Split(query.Get("paths"), ",") {
+		if p == "" {
+			continue
+		}
+		hp := historyPath{Path: p, Method: database.HistoryMethodAverage}
+		if i := strings.LastIndex(p, ":"); i != -1 {
+			hp = historyPath{Path: p[:i], Method: p[i+1:]}
+			if hp.Method == "avg" {
+				hp.Method = database.HistoryMethodAverage
+			}
+		}
+		if !database.IsHistoryMethod(hp.Method) {
+			return historyRequest{}, fmt.Errorf("unsupported method %s of path %s", hp.Method, hp.Path)
+		}
+		result.paths = append(result.paths, hp)
+	}
+	if len(result.paths) == 0 {
+		return historyRequest{}, fmt.Errorf("expected at least one path")
+	}
+	return result, nil
+}
+
+// parseResolution accepts a number of seconds or a duration like 5m
+func parseResolution(resolution string) (time.Duration, error) {
+	if resolution == "" {
+		return defaultHistoryResolution, nil
+	}
+	result, err := time.ParseDuration(resolution)
+	if seconds, parseErr := strconv.ParseFloat(resolution, 64); parseErr == nil {
+		result, err = time.Duration(seconds*float64(time.Second)), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if result <= 0 {
+		return 0, fmt.Errorf("resolution should be positive")
+	}
+	return result, nil
+}
+
+// servePlayback replays the stored deltas from the start time, the playback rate is the speed compared to real time
+func (w *SignalKWriter) servePlayback(rw http.ResponseWriter, r *http.Request) {
+	startTime, err := time.Parse(time.RFC3339, r.URL.Query().Get("startTime"))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	playbackRate := 1.0
+	if r.URL.Query().Get("playbackRate") != "" {
+		if playbackRate, err = strconv.ParseFloat(r.URL.Query().Get("playbackRate"), 64); err != nil || playbackRate <= 0 {
+			http.Error(rw, "playbackRate should be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+
+	c, err := websocket.Accept(rw, r, nil)
+	if err != nil {
+		logger.GetLogger().Warn(
+			"Unable to accept a websocket connection",
+			zap.String("Error", err.Error()),
+			zap.String("Request", r.RequestURI),
+		)
+		return
+	}
+	defer c.Close(websocket.StatusInternalError, "the sky is falling")
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	err = w.writeToWebsocket(ctx, c, playbackHello{hello: w.createHello(), StartTime: startTime, PlaybackRate: playbackRate})
+	if err != nil {
+		logger.GetLogger().Warn(
+			"Error while writing hello message",
+			zap.String("Error", err.Error()),
+		)
+		return
+	}
+
+	client := w.createWebsocketClient(r)
+
+	go func() {
+		defer cancel()
+		w.readFromWebsocket(ctx, c, client)
+	}()
+
+	playbackStart := time.Now()
+	for windowStart := startTime; windowStart.Before(time.Now()) && ctx.Err() == nil; windowStart = windowStart.Add(playbackWindow) {
+		mapped, err := w.database.ReadMapped(`WHERE "time" >= $1 AND "time" < $2 ORDER BY "time"`, windowStart, windowStart.Add(playbackWindow))
+		if err != nil {
+			logger.GetLogger().Warn(
+				"Could not retrieve the stored deltas from the database",
+				zap.String("Error", err.Error()),
+			)
+			c.Close(websocket.StatusInternalError, "could not read from the database")
+			return
+		}
+
+		for i := 0; i < len(mapped); {
+			// send all values with the same timestamp at once
+			timestamp := mapped[i].Updates[0].Timestamp
+			values := make([]message.SingleValueMapped, 0)
+			for ; i < len(mapped) && mapped[i].Updates[0].Timestamp.Equal(timestamp); i++ {
+				for _, svm := range mapped[i].ToSingleValueMapped() {
+					if client.subscriptions.isSubscribedTo(svm.Context, svm.Path) {
+						values = append(values, svm)
+					}
+				}
+			}
+			if len(values) == 0 {
+				continue
+			}
+
+			wait := time.Until(playbackStart.Add(time.Duration(float64(timestamp.Sub(startTime)) / playbackRate)))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+			for _, delta := range toDeltas(values) {
+				if err := w.writeToWebsocket(ctx, c, delta); err != nil {
+					logger.GetLogger().Warn(
+						"Error while writing delta message to the client, closing the connection",
+						zap.String("Error", err.Error()),
+						zap.String("Client address", r.RemoteAddr),
+					)
+					c.Close(websocket.StatusGoingAway, "error while writing")
+					return
+				}
+			}
+		}
+	}
+	c.Close(websocket.StatusNormalClosure, "playback finished")
+}
@@ -0,0 +1,86 @@
+package writer_test
+
+import (
+	"time"
+
+	. "github.com/munnik/gosk/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("History resolution", func() {
+	DescribeTable("Parse",
+		func(input string, expected time.Duration, expectError bool) {
+			result, err := ParseResolution(input)
+			if expectError {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result).To(Equal(expected))
+		},
+		Entry("Default", "", time.Minute, false),
+		Entry("Seconds", "60", time.Minute, false),
+		Entry("Fraction of a second", "0.5", 500*time.Millisecond, false),
+		Entry("Duration", "5m", 5*time.Minute, false),
+		Entry("Zero seconds", "0", time.Duration(0), true),
+		Entry("Negative seconds", "-60", time.Duration(0), true),
+		Entry("Negative duration", "-5m", time.Duration(0), true),
+		Entry("Invalid", "five minutes", time.Duration(0), true),
+	)
+})
+
+var _ = Describe("History request", func() {
+	now := time.Date(2022, 5, 10, 12, 0, 0, 0, time.UTC)
+	self := "vessels.urn:mrn:imo:mmsi:123456789"
+
+	It("uses the defaults", func() {
+		context, from, to, resolution, paths, err := ParseHistoryRequest("paths=navigation.speedOverGround", self, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(context).To(Equal(self))
+		Expect(from).To(Equal(now.Add(-time.Hour)))
+		Expect(to).To(Equal(now))
+		Expect(resolution).To(Equal(time.Minute))
+		Expect(paths).To(Equal([]string{"navigation.speedOverGround:average"}))
+	})
+	It("parses all parameters", func() {
+		context, from, to, resolution, paths, err := ParseHistoryRequest(
+			"context=vessels.urn:mrn:imo:mmsi:244123456&from=2022-05-10T10:00:00Z&to=2022-05-10T11:00:00Z&resolution=300&paths=navigation.speedOverGround:max,navigation.position:last,navigation.headingTrue:avg,environment.depth.belowTransducer",
+			self, now,
+		)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(context).To(Equal("vessels.urn:mrn:imo:mmsi:244123456"))
+		Expect(from).To(Equal(time.Date(2022, 5, 10, 10, 0, 0, 0, time.UTC)))
+		Expect(to).To(Equal(time.Date(2022, 5, 10, 11, 0, 0, 0, time.UTC)))
+		Expect(resolution).To(Equal(5 * time.Minute))
+		Expect(paths).To(Equal([]string{
+			"navigation.speedOverGround:max",
+			"navigation.position:last",
+			"navigation.headingTrue:average",
+			"environment.depth.belowTransducer:average",
+		}))
+	})
+	It("starts an hour before to when from is missing", func() {
+		_, from, _, _, _, err := ParseHistoryRequest("to=2022-05-10T11:00:00Z&paths=navigation.speedOverGround", self, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(from).To(Equal(time.Date(2022, 5, 10, 10, 0, 0, 0, time.UTC)))
+	})
+	It("replaces vessels.self with the self context", func() {
+		context, _, _, _, _, err := ParseHistoryRequest("context=vessels.self&paths=navigation.speedOverGround", self, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(context).To(Equal(self))
+	})
+	DescribeTable("Invalid",
+		func(query string) {
+			_, _, _, _, _, err := ParseHistoryRequest(query, self, now)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("Without paths", "from=2022-05-10T10:00:00Z"),
+		Entry("Empty paths", "paths=,"),
+		Entry("Invalid from", "from=yesterday&paths=navigation.speedOverGround"),
+		Entry("Invalid to", "to=today&paths=navigation.speedOverGround"),
+		Entry("From after to", "from=2022-05-10T11:00:00Z&to=2022-05-10T10:00:00Z&paths=navigation.speedOverGround"),
+		Entry("Invalid resolution", "resolution=-1&paths=navigation.speedOverGround"),
+		Entry("Unsupported method", "paths=navigation.speedOverGround:median"),
+	)
+})
@@ -51,13 +51,14 @@ func (w *SignalKWriter) serveFullDataModel(rw http.ResponseWriter, r *http.Reque
 	jsonObj.Set(w.config.Version, "version")
 	jsonObj.Set(w.config.SelfContext, "self")
 
+	source := r.URL.Query().Get(sourceQueryParameter)
 	var jsonPath []string
+	values := make(map[string][]message.SingleValueMapped) // map of context and path to the values of all sources
 	for _, m := range mapped {
 		for _, sm := range m.ToSingleValueMapped() {
-			jsonPath = strings.SplitN(sm.Context, ".", 2)
-
 			if sm.Path == "" {
 				// if path is empty don't include source and timestamp
+				jsonPath = strings.SplitN(sm.Context, ".", 2)
 				if vesselInfo, ok := sm.Value.(message.VesselInfo); ok {
 					if vesselInfo.MMSI != nil {
 						jsonObj.Set(vesselInfo.MMSI, append(jsonPath, "mmsi")...)
@@ -68,18 +69,36 @@ func (w *SignalKWriter) serveFullDataModel(rw http.ResponseWriter, r *http.Reque
 				}
 				continue
 			}
+			if source != "" && sm.Source.Ref() != source {
+				continue
+			}
+			key := sm.Context + "." + sm.Path
+			values[key] = append(values[key], sm)
+		}
+	}
 
-			jsonPath = append(jsonPath, strings.Split(sm.Path, ".")...)
+	now := time.Now()
+	for _, v := range values {
+		sm := w.sources.canonical(v, now)
+		jsonPath = append(strings.SplitN(sm.Context, ".", 2), strings.Split(sm.Path, ".")...)
 
-			jsonObj.Set(sm.Value, append(jsonPath, "value")...)
-			jsonObj.Set(sm.Timestamp, append(jsonPath, "timestamp")...)
-			jsonObj.Set(sm.Source.Label, append(jsonPath, "source", "label")...)
-			jsonObj.Set(sm.Source.Type, append(jsonPath, "source", "type")...)
-			jsonObj.Set(sm.Source.Uuid, append(jsonPath, "source", "uuid")...)
+		jsonObj.Set(sm.Value, append(jsonPath, "value")...)
+		jsonObj.Set(sm.Timestamp, append(jsonPath, "timestamp")...)
+		jsonObj.Set(sm.Source.Label, append(jsonPath, "source", "label")...)
+		jsonObj.Set(sm.Source.Type, append(jsonPath, "source", "type")...)
+		jsonObj.Set(sm.Source.Uuid, append(jsonPath, "source", "uuid")...)
+		jsonObj.Set(sm.Source.Ref(), append(jsonPath, "$source")...)
+		for _, other := range v {
+			jsonObj.Set(other.Value, append(jsonPath, "values", other.Source.Ref(), "value")...)
+			jsonObj.Set(other.Timestamp, append(jsonPath, "values", other.Source.Ref(), "timestamp")...)
 		}
 	}
+	for key, meta := range w.meta.all() {
+		jsonPath = append(strings.SplitN(key.context, ".", 2), strings.Split(key.path, ".")...)
+		jsonObj.Set(meta, append(jsonPath, "meta")...)
+	}
 
-	searchPath := strings.Replace(r.URL.String(), SignalKHTTPPath, "", 1)
+	searchPath := strings.Replace(r.URL.Path, SignalKHTTPPath, "", 1)
 	if searchPath == "" {
 		rw.Header().Set("Content-Type", "application/json")
 		fmt.Fprintf(rw, jsonObj.String())
@@ -97,22 +116,26 @@ func (w *SignalKWriter) serveFullDataModel(rw http.ResponseWriter, r *http.Reque
 }
 
 func (w *SignalKWriter) readFromDatabase() {
+	defer w.wg.Done()
+
 	appendToQuery := `
 		INNER JOIN 
 			(
 				SELECT  
 					"context" AS "max_context", 
 					"path" AS "max_path",
+					"connector" AS "max_connector",
 					MAX("time") AS "max_time"
 				FROM 
 					"mapped_data" 
 				GROUP BY 
-					1, 2
+					1, 2, 3
 			) "max" 
 		ON 
 			"time" = "max_time" AND 
 			"context" = "max_context" AND 
-			"path" = "max_path"
+			"path" = "max_path" AND 
+			"connector" = "max_connector"
 		WHERE
 			"time" > $1
 		;
@@ -126,7 +149,18 @@ func (w *SignalKWriter) readFromDatabase() {
 		return
 	}
 	w.cache.WriteMapped(mapped...)
-	w.wg.Done()
+
+	meta, err := w.database.ReadMeta()
+	if err != nil {
+		logger.GetLogger().Warn(
+			"Could not retrieve the metadata from database",
+			zap.String("Error", err.Error()),
+		)
+		return
+	}
+	for _, m := range meta {
+		w.meta.update(m)
+	}
 }
 
 func (w *SignalKWriter) updateFullDataModel(mapped message.Mapped) {
@@ -0,0 +1,101 @@
+package writer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/munnik/gosk/config"
+	"github.com/munnik/gosk/message"
+)
+
+type metaKey struct {
+	context string
+	path    string
+}
+
+// metaStore keeps the metadata of the paths, the metadata is received from the mappers and the database and can be
+// overridden in the configuration
+type metaStore struct {
+	mu         sync.RWMutex
+	received   map[metaKey]message.Meta
+	configured map[metaKey]message.Meta
+}
+
+func newMetaStore(c *config.SignalKConfig) *metaStore {
+	result := &metaStore{
+		received:   make(map[metaKey]message.Meta),
+		configured: make(map[metaKey]message.Meta),
+	}
+	for _, mc := range c.Meta {
+		result.configured[metaKey{context: c.SelfContext, path: mc.Path}] = mc.Meta
+	}
+	return result
+}
+
+// update merges the metadata of the delta with the stored metadata
+func (s *metaStore) update(mapped message.Mapped) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range mapped.Updates {
+		for _, m := range u.Meta {
+			key := metaKey{context: mapped.Context, path: m.Path}
+			merged, _ := s.received[key].Merge(m.Value)
+			s.received[key] = merged.(message.Meta)
+		}
+	}
+}
+
+// get returns the metadata of the path, false is returned when there is no metadata
+func (s *metaStore) get(key metaKey) (message.Meta, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.lookup(key)
+}
+
+// all returns the metadata of all paths
+func (s *metaStore) all() map[metaKey]message.Meta {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make(map[metaKey]message.Meta, len(s.received)+len(s.configured))
+	for key := range s.received {
+		result[key], _ = s.lookup(key)
+	}
+	for key := range s.configured {
+		result[key], _ = s.lookup(key)
+	}
+	return result
+}
+
+func (s *metaStore) lookup(key metaKey) (message.Meta, bool) {
+	received, receivedOk := s.received[key]
+	configured, configuredOk := s.configured[key]
+	if !configuredOk {
+		return received, receivedOk
+	}
+	merged, _ := received.Merge(configured)
+	return merged.(message.Meta), true
+}
+
+// metaDeltas creates a delta per context with the stored metadata of the paths
+func (w *SignalKWriter) metaDeltas(keys []metaKey) []message.Mapped {
+	result := make([]message.Mapped, 0)
+	contexts := make(map[string]int)
+	now := time.Now()
+	for _, key := range keys {
+		meta, ok := w.meta.get(key)
+		if !ok {
+			continue
+		}
+		i, ok := contexts[key.context]
+		if !ok {
+			result = append(result, *message.NewMapped().WithContext(key.context).AddUpdate(message.NewUpdate().WithTimestamp(now)))
+			i = len(result) - 1
+			contexts[key.context] = i
+		}
+		result[i].Updates[0].AddMeta(message.NewMetaValue().WithPath(key.path).WithValue(meta))
+	}
+	return result
+}
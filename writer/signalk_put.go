@@ -0,0 +1,331 @@
+package writer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/munnik/go-nmea"
+	"github.com/munnik/gosk/config"
+	"github.com/munnik/gosk/logger"
+	"github.com/munnik/gosk/mapper"
+	"github.com/munnik/gosk/message"
+	"github.com/munnik/gosk/nanomsg"
+	"github.com/munnik/gosk/protocol"
+	"go.uber.org/zap"
+)
+
+const (
+	SignalKRequestsPath = "/signalk/v1/requests/"
+
+	requestStatePending   = "PENDING"
+	requestStateCompleted = "COMPLETED"
+
+	// completed requests are kept for this duration so clients can query the status
+	requestLifetime = 10 * time.Minute
+
+	// PUT requests on notifications acknowledge the notification
+	notificationsPrefix = "notifications."
+	acknowledgedValue   = "acknowledged"
+)
+
+type putValue struct {
+	Value  interface{} `json:"value"`
+	Source string      `json:"source,omitempty"`
+}
+
+type putMessage struct {
+	Context   string `json:"context"`
+	RequestId string `json:"requestId"`
+	Put       *struct {
+		Path string `json:"path"`
+		putValue
+	} `json:"put"`
+}
+
+type requestStatus struct {
+	RequestId  string `json:"requestId"`
+	State      string `json:"state"`
+	StatusCode int    `json:"statusCode"`
+	Message    string `json:"message,omitempty"`
+	Href       string `json:"href,omitempty"`
+	created    time.Time
+}
+
+// createPublishers creates a publisher for each distinct URL in the put mappings, connectors subscribe to these URLs
+func (w *SignalKWriter) createPublishers() {
+	for _, pmc := range w.config.PutMappings {
+		if _, ok := w.publishers[pmc.URLString]; ok || pmc.URLString == "" {
+			continue
+		}
+		w.publishers[pmc.URLString] = nanomsg.NewPub(pmc.URLString)
+	}
+	if _, ok := w.publishers[w.config.AcknowledgeURL]; !ok && w.config.AcknowledgeURL != "" {
+		w.publishers[w.config.AcknowledgeURL] = nanomsg.NewPub(w.config.AcknowledgeURL)
+	}
+}
+
+func (w *SignalKWriter) servePut(rw http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.Replace(r.URL.Path, SignalKHTTPPath, "", 1), "/"), "/")
+	if len(parts) < 3 {
+		http.Error(rw, "expected a context and a path", http.StatusBadRequest)
+		return
+	}
+
+	var v putValue
+	if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	status := w.handlePut(parts[0]+"."+parts[1], strings.Join(parts[2:], "."), v.Value, "")
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status.StatusCode)
+	json.NewEncoder(rw).Encode(status)
+}
+
+func (w *SignalKWriter) serveRequest(rw http.ResponseWriter, r *http.Request) {
+	w.requestsMu.Lock()
+	status, ok := w.requests[chi.URLParam(r, "id")]
+	w.requestsMu.Unlock()
+	if !ok {
+		http.NotFound(rw, r)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(status)
+}
+
+// handlePut converts the value to a raw command and publishes it to the connector, the connector doesn't acknowledge
+// the command so the request is completed once the command is published
+func (w *SignalKWriter) handlePut(context string, path string, value interface{}, requestId string) requestStatus {
+	if requestId == "" {
+		requestId = uuid.NewString()
+	}
+	status := requestStatus{
+		RequestId:  requestId,
+		State:      requestStatePending,
+		StatusCode: http.StatusAccepted,
+		Href:       SignalKRequestsPath + requestId,
+		created:    time.Now(),
+	}
+	defer w.storeRequest(&status)
+
+	if context == "" || context == selfContext {
+		context = w.config.SelfContext
+	}
+	if context != w.config.SelfContext {
+		return status.complete(http.StatusForbidden, "only the self context can be written")
+	}
+
+	var mapping *config.PutMappingConfig
+	var expression *mapper.ExpressionRunner
+	for i := range w.config.PutMappings {
+		if w.config.PutMappings[i].Path == path {
+			mapping = &w.config.PutMappings[i]
+			expression = w.putExpressions[i]
+			break
+		}
+	}
+	if mapping == nil && strings.HasPrefix(path, notificationsPrefix) && w.config.AcknowledgeURL != "" {
+		return w.acknowledge(&status, context, path)
+	}
+	if mapping == nil {
+		return status.complete(http.StatusMethodNotAllowed, fmt.Sprintf("path %s is not writable", path))
+	}
+	publisher, ok := w.publishers[mapping.URLString]
+	if !ok {
+		return status.complete(http.StatusBadGateway, fmt.Sprintf("no connector configured for path %s", path))
+	}
+
+	if mapping.Expression != "" && expression == nil {
+		return status.complete(http.StatusInternalServerError, fmt.Sprintf("the expression of path %s could not be compiled", path))
+	}
+	raw, err := createPutRaw(*mapping, expression, value)
+	if err != nil {
+		return status.complete(http.StatusBadRequest, err.Error())
+	}
+	bytes, err := json.Marshal(raw)
+	if err != nil {
+		return status.complete(http.StatusInternalServerError, err.Error())
+	}
+	if err := publisher.Send(bytes); err != nil {
+		logger.GetLogger().Warn(
+			"Unable to send the message",
+			zap.String("Error", err.Error()),
+		)
+		return status.complete(http.StatusBadGateway, err.Error())
+	}
+	return status.complete(http.StatusOK, "")
+}
+
+// acknowledge forwards the PUT request on a notification to the alarm stage, the value of the request is ignored
+func (w *SignalKWriter) acknowledge(status *requestStatus, context string, path string) requestStatus {
+	s := message.NewSource().WithLabel("signalk").WithType(config.SignalKType).WithUuid(uuid.Nil)
+	u := message.NewUpdate().WithSource(*s).WithTimestamp(time.Now())
+	u.AddValue(message.NewValue().WithPath(path).WithValue(acknowledgedValue))
+	bytes, err := json.Marshal(message.NewMapped().WithContext(context).WithOrigin(w.config.SelfContext).AddUpdate(u))
+	if err != nil {
+		return status.complete(http.StatusInternalServerError, err.Error())
+	}
+	if err := w.publishers[w.config.AcknowledgeURL].Send(bytes); err != nil {
+		logger.GetLogger().Warn(
+			"Unable to send the message",
+			zap.String("Error", err.Error()),
+		)
+		return status.complete(http.StatusBadGateway, err.Error())
+	}
+	return status.complete(http.StatusOK, "")
+}
+
+func (s *requestStatus) complete(statusCode int, message string) requestStatus {
+	s.State = requestStateCompleted
+	s.StatusCode = statusCode
+	s.Message = message
+	return *s
+}
+
+func (w *SignalKWriter) storeRequest(status *requestStatus) {
+	w.requestsMu.Lock()
+	defer w.requestsMu.Unlock()
+
+	for id, s := range w.requests {
+		if time.Since(s.created) > requestLifetime {
+			delete(w.requests, id)
+		}
+	}
+	w.requests[status.RequestId] = *status
+}
+
+// createPutExpressions compiles the expressions of the PUT mappings, the index of the result matches the index of the
+// mapping
+func createPutExpressions(c *config.SignalKConfig) []*mapper.ExpressionRunner {
+	result := make([]*mapper.ExpressionRunner, len(c.PutMappings))
+	for i, pmc := range c.PutMappings {
+		if pmc.Expression == "" {
+			continue
+		}
+		expression, err := mapper.NewExpressionRunner(c.SelfContext, pmc.MappingConfig)
+		if err != nil {
+			logger.GetLogger().Warn(
+				"Could not compile the expression of the put mapping",
+				zap.String("Path", pmc.Path),
+				zap.String("Expression", pmc.Expression),
+				zap.String("Error", err.Error()),
+			)
+			continue
+		}
+		result[i] = expression
+	}
+	return result
+}
+
+// createPutRaw runs the expression on the value and encodes the result for the connector, the value is used as is when
+// expression is nil
+func createPutRaw(mapping config.PutMappingConfig, expression *mapper.ExpressionRunner, value interface{}) (*message.Raw, error) {
+	output := value
+	var err error
+	if expression != nil {
+		if output, err = expression.Run(mapper.ExpressionEnvironment{"value": value}, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	var bytes []byte
+	switch mapping.Protocol {
+	case config.ModbusType:
+		bytes, err = createModbusCommand(mapping.ModbusHeader, output)
+	case config.NMEA0183Type:
+		bytes, err = createNmea0183Command(mapping.Template, output)
+	default:
+		err = fmt.Errorf("protocol %s is not supported", mapping.Protocol)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return message.NewRaw().WithConnector(mapping.Connector).WithType(mapping.Protocol).WithValue(bytes), nil
+}
+
+func createModbusCommand(header protocol.ModbusHeader, output interface{}) ([]byte, error) {
+	values, ok := output.([]interface{})
+	if !ok {
+		values = []interface{}{output}
+	}
+
+	var bytes []byte
+	switch header.FunctionCode {
+	case protocol.WRITE_SINGLE_COIL, protocol.WRITE_MULTIPLE_COILS:
+		coils := make([]bool, 0, len(values))
+		for _, v := range values {
+			coil, ok := v.(bool)
+			if !ok {
+				return nil, fmt.Errorf("expected a bool for a coil but got %v", v)
+			}
+			coils = append(coils, coil)
+		}
+		bytes = protocol.CoilsToBytes(coils)
+	case protocol.WRITE_SINGLE_REGISTER, protocol.WRITE_MULTIPLE_REGISTERS:
+		registers := make([]uint16, 0, len(values))
+		for _, v := range values {
+			register, err := toRegister(v)
+			if err != nil {
+				return nil, err
+			}
+			registers = append(registers, register)
+		}
+		bytes = protocol.RegistersToBytes(registers)
+	default:
+		return nil, fmt.Errorf("function code %d can't be used to write", header.FunctionCode)
+	}
+
+	header.NumberOfCoilsOrRegisters = uint16(len(values))
+	return protocol.InjectModbusHeader(&header, bytes), nil
+}
+
+// toRegister converts a number to a register, negative numbers are stored as two's complement
+func toRegister(v interface{}) (uint16, error) {
+	var f float64
+	switch n := v.(type) {
+	case int:
+		f = float64(n)
+	case int64:
+		f = float64(n)
+	case float64:
+		f = math.Round(n)
+	default:
+		return 0, fmt.Errorf("expected a number for a register but got %v", v)
+	}
+	if f < math.MinInt16 || f > math.MaxUint16 {
+		return 0, fmt.Errorf("value %v doesn't fit in a register", v)
+	}
+	if f < 0 {
+		return uint16(int16(f)), nil
+	}
+	return uint16(f), nil
+}
+
+func createNmea0183Command(sentenceTemplate string, output interface{}) ([]byte, error) {
+	t, err := template.New("sentence").Parse(sentenceTemplate)
+	if err != nil {
+		return nil, err
+	}
+	var buffer bytes.Buffer
+	if err := t.Execute(&buffer, output); err != nil {
+		return nil, err
+	}
+
+	sentence := buffer.String()
+	if (strings.HasPrefix(sentence, "$") || strings.HasPrefix(sentence, "!")) && !strings.Contains(sentence, "*") {
+		sentence = sentence + "*" + nmea.Checksum(sentence[1:])
+	}
+	return []byte(sentence), nil
+}
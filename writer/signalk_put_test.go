@@ -0,0 +1,93 @@
+package writer_test
+
+import (
+	"github.com/munnik/gosk/config"
+	"github.com/munnik/gosk/protocol"
+	. "github.com/munnik/gosk/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PUT registers", func() {
+	DescribeTable("Convert",
+		func(input interface{}, expected uint16, expectError bool) {
+			result, err := ToRegister(input)
+			if expectError {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result).To(Equal(expected))
+		},
+		Entry("Zero", 0.0, uint16(0), false),
+		Entry("Int", 42, uint16(42), false),
+		Entry("Int64", int64(42), uint16(42), false),
+		Entry("Float is rounded", 41.5, uint16(42), false),
+		Entry("Maximum unsigned", 65535.0, uint16(0xffff), false),
+		Entry("Negative is two's complement", -1.0, uint16(0xffff), false),
+		Entry("Minimum signed", -32768.0, uint16(0x8000), false),
+		Entry("Too large", 65536.0, uint16(0), true),
+		Entry("Too small", -32769, uint16(0), true),
+		Entry("Not a number", "42", uint16(0), true),
+		Entry("Bool", true, uint16(0), true),
+	)
+})
+
+var _ = Describe("PUT NMEA 0183 commands", func() {
+	DescribeTable("Template",
+		func(template string, input interface{}, expected string, expectError bool) {
+			result, err := CreateNmea0183Command(template, input)
+			if expectError {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(result)).To(Equal(expected))
+		},
+		Entry("Checksum is added", "$IIXDR,A,{{.}},D,RUDDER", 12.5, "$IIXDR,A,12.5,D,RUDDER*43", false),
+		Entry("Checksum of a template with a list", "$GPAPB,A,A,{{index . 0}},R,N,V,V,{{index . 1}},T,001", []interface{}{"0.10", 11.0}, "$GPAPB,A,A,0.10,R,N,V,V,11,T,001*22", false),
+		Entry("Existing checksum is kept", "$IIXDR,A,{{.}},D,RUDDER*00", 12.5, "$IIXDR,A,12.5,D,RUDDER*00", false),
+		Entry("No checksum without start delimiter", "IIXDR,A,{{.}},D,RUDDER", 12.5, "IIXDR,A,12.5,D,RUDDER", false),
+		Entry("Invalid template", "$IIXDR,A,{{.,D,RUDDER", 12.5, "", true),
+	)
+})
+
+var _ = Describe("PUT raw commands", func() {
+	modbusMapping := func(functionCode uint16, expression string) config.PutMappingConfig {
+		return config.PutMappingConfig{
+			MappingConfig: config.MappingConfig{Expression: expression, ExpressionEnvironment: map[string]interface{}{"factor": 10}},
+			ModbusHeader:  protocol.ModbusHeader{Slave: 1, FunctionCode: functionCode, Address: 40},
+			Connector:     "testingConnector",
+			Protocol:      config.ModbusType,
+		}
+	}
+
+	DescribeTable("Modbus",
+		func(mapping config.PutMappingConfig, input interface{}, expected []byte, expectError bool) {
+			result, err := CreatePutRaw(mapping, input)
+			if expectError {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.Connector).To(Equal("testingConnector"))
+			Expect(result.Type).To(Equal(config.ModbusType))
+			Expect(result.Value).To(Equal(expected))
+		},
+		Entry("Without expression", modbusMapping(protocol.WRITE_SINGLE_REGISTER, ""), 50.0, []byte{0x01, 0x00, 0x06, 0x00, 0x28, 0x00, 0x01, 0x00, 0x32}, false),
+		Entry("Coil", modbusMapping(protocol.WRITE_SINGLE_COIL, "value == 1"), 1.0, []byte{0x01, 0x00, 0x05, 0x00, 0x28, 0x00, 0x01, 0xff, 0x00}, false),
+		Entry("Expression environment", modbusMapping(protocol.WRITE_SINGLE_REGISTER, "value * factor"), 5.0, []byte{0x01, 0x00, 0x06, 0x00, 0x28, 0x00, 0x01, 0x00, 0x32}, false),
+		Entry("Mapper functions", modbusMapping(protocol.WRITE_SINGLE_REGISTER, "currentToRatio(value) * 100"), 12000.0, []byte{0x01, 0x00, 0x06, 0x00, 0x28, 0x00, 0x01, 0x00, 0x32}, false),
+		Entry("Multiple registers", modbusMapping(protocol.WRITE_MULTIPLE_REGISTERS, "[value, -value]"), 1.0, []byte{0x01, 0x00, 0x10, 0x00, 0x28, 0x00, 0x02, 0x00, 0x01, 0xff, 0xff}, false),
+		Entry("Value of another type than the previous request", modbusMapping(protocol.WRITE_SINGLE_REGISTER, "value"), int64(50), []byte{0x01, 0x00, 0x06, 0x00, 0x28, 0x00, 0x01, 0x00, 0x32}, false),
+		Entry("Expression that can't run", modbusMapping(protocol.WRITE_SINGLE_REGISTER, "value ? 1 : 0"), 5.0, nil, true),
+		Entry("Expression that doesn't compile", modbusMapping(protocol.WRITE_SINGLE_REGISTER, "value +"), 5.0, nil, true),
+		Entry("Coil without bool", modbusMapping(protocol.WRITE_SINGLE_COIL, "value"), 1.0, nil, true),
+		Entry("Read function code", modbusMapping(protocol.READ_HOLDING_REGISTERS, "value"), 1.0, nil, true),
+	)
+
+	It("Fails on an unsupported protocol", func() {
+		_, err := CreatePutRaw(config.PutMappingConfig{Protocol: config.CanBusType}, 1.0)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,47 @@
+package writer
+
+import (
+	"time"
+
+	"github.com/munnik/gosk/config"
+	"github.com/munnik/gosk/message"
+)
+
+// sourceQueryParameter selects the values of a single source in the full data model
+const sourceQueryParameter = "$source"
+
+// sourcePriorities selects the value of a path when multiple sources send values for the same path
+type sourcePriorities struct {
+	config []config.SourcePriorityConfig
+}
+
+func newSourcePriorities(c []config.SourcePriorityConfig) sourcePriorities {
+	return sourcePriorities{config: c}
+}
+
+// canonical returns the value of the source with the highest priority that is not timed out, when no priority is
+// configured for the path or all sources are timed out the newest value is returned. All values should have the same
+// context and path.
+func (p sourcePriorities) canonical(values []message.SingleValueMapped, now time.Time) message.SingleValueMapped {
+	newest := values[0]
+	for _, value := range values[1:] {
+		if value.Timestamp.After(newest.Timestamp) {
+			newest = value
+		}
+	}
+
+	for _, priority := range p.config {
+		if !isPatternMatch([]rune(newest.Path), []rune(priority.Path)) {
+			continue
+		}
+		for _, source := range priority.Sources {
+			for _, value := range values {
+				if value.Source.Ref() == source.Source && now.Sub(value.Timestamp) <= source.Timeout {
+					return value
+				}
+			}
+		}
+		break
+	}
+	return newest
+}
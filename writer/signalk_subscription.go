@@ -0,0 +1,278 @@
+package writer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/munnik/gosk/message"
+)
+
+const (
+	subscriptionPolicyInstant = "instant" // send every change, but not faster than the min period
+	subscriptionPolicyIdeal   = "ideal"   // like instant, but resend the last value when nothing changed within the period
+	subscriptionPolicyFixed   = "fixed"   // send the last value every period
+
+	defaultSubscriptionPeriod = 1000 * time.Millisecond
+
+	selfContext = "vessels.self"
+)
+
+type subscription struct {
+	context   string
+	path      string
+	period    time.Duration
+	minPeriod time.Duration
+	policy    string
+	latest    map[string]message.SingleValueMapped // map of context and path to the last received value
+	sent      map[string]time.Time                 // map of context and path to the time the value was last sent
+	changed   map[string]bool                      // map of context and path to a flag indicating a value is not sent yet
+}
+
+func newSubscription(context string, section SubscribeSection) *subscription {
+	result := &subscription{
+		context:   context,
+		path:      section.Path,
+		period:    time.Duration(section.Period) * time.Millisecond,
+		minPeriod: time.Duration(section.MinPeriod) * time.Millisecond,
+		policy:    section.Policy,
+		latest:    make(map[string]message.SingleValueMapped),
+		sent:      make(map[string]time.Time),
+		changed:   make(map[string]bool),
+	}
+	if result.period <= 0 {
+		result.period = defaultSubscriptionPeriod
+	}
+	if result.policy != subscriptionPolicyInstant && result.policy != subscriptionPolicyFixed {
+		result.policy = subscriptionPolicyIdeal
+	}
+	return result
+}
+
+func (s *subscription) matches(context string, path string) bool {
+	return isPatternMatch([]rune(context), []rune(s.context)) && isPatternMatch([]rune(path), []rune(s.path))
+}
+
+// receive stores the value and returns true when the value should be sent immediately
+func (s *subscription) receive(value message.SingleValueMapped, now time.Time) bool {
+	key := value.Context + "." + value.Path
+	s.latest[key] = value
+	s.changed[key] = true
+	if s.policy == subscriptionPolicyFixed || now.Sub(s.sent[key]) < s.minPeriod {
+		return false
+	}
+	s.sent[key] = now
+	s.changed[key] = false
+	return true
+}
+
+// due returns the values that should be sent because of the period or because they were held back by the min period
+func (s *subscription) due(now time.Time) []message.SingleValueMapped {
+	result := make([]message.SingleValueMapped, 0)
+	for key, value := range s.latest {
+		elapsed := now.Sub(s.sent[key])
+		send := false
+		switch s.policy {
+		case subscriptionPolicyFixed:
+			send = elapsed >= s.period
+		case subscriptionPolicyIdeal:
+			send = (s.changed[key] && elapsed >= s.minPeriod) || elapsed >= s.period
+		case subscriptionPolicyInstant:
+			send = s.changed[key] && elapsed >= s.minPeriod
+		}
+		if send {
+			s.sent[key] = now
+			s.changed[key] = false
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// subscriptions keeps track of the subscriptions of a single websocket client
+type subscriptions struct {
+	mu       sync.Mutex
+	self     string
+	items    []*subscription
+	metaSent map[metaKey]struct{} // paths of which the metadata is sent to the client
+}
+
+func newSubscriptions(self string) *subscriptions {
+	return &subscriptions{
+		self:     self,
+		items:    make([]*subscription, 0),
+		metaSent: make(map[metaKey]struct{}),
+	}
+}
+
+func (s *subscriptions) handle(m SubscribeMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if m.Context == selfContext {
+		m.Context = s.self
+	}
+
+	for _, section := range m.Subscribe {
+		if section.Path == "" {
+			continue
+		}
+		s.items = append(s.items, newSubscription(m.Context, section))
+	}
+
+	for _, section := range m.Unsubscribe {
+		items := make([]*subscription, 0, len(s.items))
+		for _, item := range s.items {
+			if isPatternMatch([]rune(item.context), []rune(m.Context)) && isPatternMatch([]rune(item.path), []rune(section.Path)) {
+				continue
+			}
+			items = append(items, item)
+		}
+		s.items = items
+	}
+}
+
+// isSubscribedTo returns true if at least one of the subscriptions matches the context and path
+func (s *subscriptions) isSubscribedTo(context string, path string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, item := range s.items {
+		if item.matches(context, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// filter returns the values of the delta that should be sent immediately, other values that match one of the
+// subscriptions are held back and returned by due
+func (s *subscriptions) filter(m message.Mapped, now time.Time) []message.SingleValueMapped {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]message.SingleValueMapped, 0)
+	for _, value := range m.ToSingleValueMapped() {
+		send := false
+		for _, item := range s.items {
+			if item.matches(value.Context, value.Path) && item.receive(value, now) {
+				send = true
+			}
+		}
+		if send {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// snapshot returns the cached values that match one of the subscriptions, the values are marked as sent so they
+// are only repeated when the policy of the subscription requires it
+func (s *subscriptions) snapshot(cached []message.Mapped, now time.Time) []message.SingleValueMapped {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]message.SingleValueMapped, 0)
+	for _, m := range cached {
+		for _, value := range m.ToSingleValueMapped() {
+			send := false
+			for _, item := range s.items {
+				if item.matches(value.Context, value.Path) {
+					key := value.Context + "." + value.Path
+					item.latest[key] = value
+					item.sent[key] = now
+					send = true
+				}
+			}
+			if send {
+				result = append(result, value)
+			}
+		}
+	}
+	return result
+}
+
+func (s *subscriptions) due(now time.Time) []message.SingleValueMapped {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]message.SingleValueMapped, 0)
+	seen := make(map[string]struct{})
+	for _, item := range s.items {
+		for _, value := range item.due(now) {
+			key := value.Context + "." + value.Path
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// unsentMeta returns the paths of the values of which the metadata is not sent yet, the metadata of these paths is
+// marked as sent so it is only sent with the first value of a path
+func (s *subscriptions) unsentMeta(values []message.SingleValueMapped) []metaKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]metaKey, 0)
+	for _, value := range values {
+		key := metaKey{context: value.Context, path: value.Path}
+		if _, ok := s.metaSent[key]; ok {
+			continue
+		}
+		s.metaSent[key] = struct{}{}
+		result = append(result, key)
+	}
+	return result
+}
+
+// changedMeta returns the paths of the metadata in the delta that match one of the subscriptions, so changes of the
+// metadata are sent to the client
+func (s *subscriptions) changedMeta(m message.Mapped) []metaKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]metaKey, 0)
+	for _, u := range m.Updates {
+		for _, meta := range u.Meta {
+			for _, item := range s.items {
+				if item.matches(m.Context, meta.Path) {
+					key := metaKey{context: m.Context, path: meta.Path}
+					s.metaSent[key] = struct{}{}
+					result = append(result, key)
+					break
+				}
+			}
+		}
+	}
+	return result
+}
+
+// toDeltas groups the values by context and by source and timestamp
+func toDeltas(values []message.SingleValueMapped) []message.Mapped {
+	result := make([]message.Mapped, 0)
+	contexts := make(map[string]int)
+	for _, value := range values {
+		i, ok := contexts[value.Context]
+		if !ok {
+			result = append(result, *message.NewMapped().WithContext(value.Context).WithOrigin(value.Origin))
+			i = len(result) - 1
+			contexts[value.Context] = i
+		}
+		v := message.NewValue().WithPath(value.Path).WithValue(value.Value)
+		found := false
+		for j, u := range result[i].Updates {
+			if u.Source == value.Source && u.Timestamp.Equal(value.Timestamp) {
+				result[i].Updates[j].AddValue(v)
+				found = true
+				break
+			}
+		}
+		if !found {
+			result[i].AddUpdate(message.NewUpdate().WithSource(value.Source).WithTimestamp(value.Timestamp).AddValue(v))
+		}
+	}
+	return result
+}
@@ -0,0 +1,201 @@
+package writer_test
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/munnik/gosk/config"
+	"github.com/munnik/gosk/message"
+	. "github.com/munnik/gosk/writer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const testingSelf = "vessels.urn:mrn:imo:mmsi:123456789"
+
+func subscriptionDelta(context string, timestamp time.Time, values map[string]interface{}) message.Mapped {
+	s := message.NewSource().WithLabel("testingConnector").WithType(config.NMEA0183Type).WithUuid(uuid.Nil)
+	u := message.NewUpdate().WithSource(*s).WithTimestamp(timestamp)
+	for path, value := range values {
+		u.AddValue(message.NewValue().WithPath(path).WithValue(value))
+	}
+	return *message.NewMapped().WithContext(context).WithOrigin(context).AddUpdate(u)
+}
+
+func subscriptionPaths(values []message.SingleValueMapped) []string {
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		result = append(result, v.Context+"/"+v.Path)
+	}
+	return result
+}
+
+var _ = Describe("Subscriptions", func() {
+	now := time.Date(2022, 5, 10, 12, 0, 0, 0, time.UTC)
+	var s *Subscriptions
+	BeforeEach(func() {
+		s = NewSubscriptions(testingSelf)
+	})
+
+	Describe("Matches", func() {
+		It("replaces vessels.self with the self context", func() {
+			s.Handle(SubscribeMessage{Context: "vessels.self", Subscribe: []SubscribeSection{{Path: "navigation.speedOverGround"}}})
+			Expect(s.IsSubscribedTo(testingSelf, "navigation.speedOverGround")).To(BeTrue())
+			Expect(s.IsSubscribedTo("vessels.self", "navigation.speedOverGround")).To(BeFalse())
+			Expect(s.IsSubscribedTo(testingSelf, "navigation.headingTrue")).To(BeFalse())
+		})
+		It("matches wildcards in the context and the path", func() {
+			s.Handle(SubscribeMessage{Context: "vessels.*", Subscribe: []SubscribeSection{{Path: "navigation.*"}}})
+			Expect(s.IsSubscribedTo(testingSelf, "navigation.speedOverGround")).To(BeTrue())
+			Expect(s.IsSubscribedTo("vessels.urn:mrn:imo:mmsi:244123456", "navigation.position")).To(BeTrue())
+			Expect(s.IsSubscribedTo("atons.urn:mrn:imo:mmsi:992446000", "navigation.position")).To(BeFalse())
+			Expect(s.IsSubscribedTo(testingSelf, "propulsion.port.revolutions")).To(BeFalse())
+		})
+		It("ignores sections without a path", func() {
+			s.Handle(SubscribeMessage{Context: "*", Subscribe: []SubscribeSection{{Path: ""}}})
+			Expect(s.IsSubscribedTo(testingSelf, "navigation.speedOverGround")).To(BeFalse())
+		})
+		It("removes the matching subscriptions on unsubscribe", func() {
+			s.Handle(SubscribeMessage{Context: "vessels.self", Subscribe: []SubscribeSection{{Path: "navigation.speedOverGround"}, {Path: "navigation.headingTrue"}, {Path: "propulsion.port.revolutions"}}})
+			s.Handle(SubscribeMessage{Context: "vessels.self", Unsubscribe: []SubscribeSection{{Path: "navigation.*"}}})
+			Expect(s.IsSubscribedTo(testingSelf, "navigation.speedOverGround")).To(BeFalse())
+			Expect(s.IsSubscribedTo(testingSelf, "navigation.headingTrue")).To(BeFalse())
+			Expect(s.IsSubscribedTo(testingSelf, "propulsion.port.revolutions")).To(BeTrue())
+		})
+	})
+
+	Describe("Filter", func() {
+		It("only returns the subscribed values", func() {
+			s.Handle(SubscribeMessage{Context: "vessels.self", Subscribe: []SubscribeSection{{Path: "navigation.speedOverGround", Policy: "instant"}}})
+			result := s.Filter(subscriptionDelta(testingSelf, now, map[string]interface{}{"navigation.speedOverGround": 3.2, "navigation.headingTrue": 1.0}), now)
+			Expect(subscriptionPaths(result)).To(Equal([]string{testingSelf + "/navigation.speedOverGround"}))
+			result = s.Filter(subscriptionDelta("vessels.urn:mrn:imo:mmsi:244123456", now, map[string]interface{}{"navigation.speedOverGround": 3.2}), now)
+			Expect(result).To(BeEmpty())
+		})
+		It("returns a value that matches multiple subscriptions once", func() {
+			s.Handle(SubscribeMessage{Context: "vessels.self", Subscribe: []SubscribeSection{{Path: "navigation.*", Policy: "instant"}, {Path: "*", Policy: "instant"}}})
+			result := s.Filter(subscriptionDelta(testingSelf, now, map[string]interface{}{"navigation.speedOverGround": 3.2}), now)
+			Expect(result).To(HaveLen(1))
+		})
+	})
+
+	Describe("Instant policy", func() {
+		BeforeEach(func() {
+			s.Handle(SubscribeMessage{Context: "vessels.self", Subscribe: []SubscribeSection{{Path: "navigation.speedOverGround", Policy: "instant", MinPeriod: 1000, Period: 2000}}})
+		})
+		It("sends changes immediately", func() {
+			Expect(s.Filter(subscriptionDelta(testingSelf, now, map[string]interface{}{"navigation.speedOverGround": 3.2}), now)).To(HaveLen(1))
+			Expect(s.Due(now.Add(5 * time.Second))).To(BeEmpty())
+			Expect(s.Filter(subscriptionDelta(testingSelf, now, map[string]interface{}{"navigation.speedOverGround": 3.3}), now.Add(5*time.Second))).To(HaveLen(1))
+		})
+		It("holds back changes within the min period", func() {
+			Expect(s.Filter(subscriptionDelta(testingSelf, now, map[string]interface{}{"navigation.speedOverGround": 3.2}), now)).To(HaveLen(1))
+			Expect(s.Filter(subscriptionDelta(testingSelf, now, map[string]interface{}{"navigation.speedOverGround": 3.3}), now.Add(500*time.Millisecond))).To(BeEmpty())
+			Expect(s.Filter(subscriptionDelta(testingSelf, now, map[string]interface{}{"navigation.speedOverGround": 3.4}), now.Add(600*time.Millisecond))).To(BeEmpty())
+			Expect(s.Due(now.Add(900 * time.Millisecond))).To(BeEmpty())
+
+			result := s.Due(now.Add(time.Second))
+			Expect(result).To(HaveLen(1))
+			Expect(result[0].Value).To(Equal(3.4))
+			Expect(s.Due(now.Add(2 * time.Second))).To(BeEmpty())
+		})
+	})
+
+	Describe("Ideal policy", func() {
+		BeforeEach(func() {
+			s.Handle(SubscribeMessage{Context: "vessels.self", Subscribe: []SubscribeSection{{Path: "navigation.speedOverGround", MinPeriod: 1000, Period: 2000}}})
+		})
+		It("is the default policy", func() {
+			Expect(s.Filter(subscriptionDelta(testingSelf, now, map[string]interface{}{"navigation.speedOverGround": 3.2}), now)).To(HaveLen(1))
+		})
+		It("holds back changes within the min period", func() {
+			Expect(s.Filter(subscriptionDelta(testingSelf, now, map[string]interface{}{"navigation.speedOverGround": 3.2}), now)).To(HaveLen(1))
+			Expect(s.Filter(subscriptionDelta(testingSelf, now, map[string]interface{}{"navigation.speedOverGround": 3.3}), now.Add(500*time.Millisecond))).To(BeEmpty())
+			Expect(s.Due(now.Add(time.Second))).To(HaveLen(1))
+		})
+		It("resends the last value when nothing changed within the period", func() {
+			Expect(s.Filter(subscriptionDelta(testingSelf, now, map[string]interface{}{"navigation.speedOverGround": 3.2}), now)).To(HaveLen(1))
+			Expect(s.Due(now.Add(1999 * time.Millisecond))).To(BeEmpty())
+			result := s.Due(now.Add(2 * time.Second))
+			Expect(result).To(HaveLen(1))
+			Expect(result[0].Value).To(Equal(3.2))
+			Expect(s.Due(now.Add(3 * time.Second))).To(BeEmpty())
+			Expect(s.Due(now.Add(4 * time.Second))).To(HaveLen(1))
+		})
+	})
+
+	Describe("Fixed policy", func() {
+		BeforeEach(func() {
+			s.Handle(SubscribeMessage{Context: "vessels.self", Subscribe: []SubscribeSection{{Path: "navigation.speedOverGround", Policy: "fixed"}}})
+		})
+		It("only sends the last value every period", func() {
+			Expect(s.Filter(subscriptionDelta(testingSelf, now, map[string]interface{}{"navigation.speedOverGround": 3.2}), now)).To(BeEmpty())
+			Expect(s.Filter(subscriptionDelta(testingSelf, now, map[string]interface{}{"navigation.speedOverGround": 3.3}), now)).To(BeEmpty())
+
+			// the value was never sent, the default period is one second
+			result := s.Due(now)
+			Expect(result).To(HaveLen(1))
+			Expect(result[0].Value).To(Equal(3.3))
+			Expect(s.Due(now.Add(999 * time.Millisecond))).To(BeEmpty())
+			Expect(s.Due(now.Add(time.Second))).To(HaveLen(1))
+		})
+	})
+
+	Describe("Due", func() {
+		It("returns a value that matches multiple subscriptions once", func() {
+			s.Handle(SubscribeMessage{Context: "vessels.self", Subscribe: []SubscribeSection{{Path: "navigation.*", Policy: "fixed"}, {Path: "*", Policy: "fixed"}}})
+			s.Filter(subscriptionDelta(testingSelf, now, map[string]interface{}{"navigation.speedOverGround": 3.2}), now)
+			Expect(s.Due(now)).To(HaveLen(1))
+		})
+	})
+
+	Describe("Snapshot", func() {
+		cached := []message.Mapped{
+			subscriptionDelta(testingSelf, now, map[string]interface{}{"navigation.speedOverGround": 3.2}),
+			subscriptionDelta(testingSelf, now, map[string]interface{}{"navigation.headingTrue": 1.0}),
+			subscriptionDelta("vessels.urn:mrn:imo:mmsi:244123456", now, map[string]interface{}{"navigation.speedOverGround": 5.1}),
+		}
+
+		It("returns the subscribed cached values", func() {
+			s.Handle(SubscribeMessage{Context: "vessels.self", Subscribe: []SubscribeSection{{Path: "navigation.speedOverGround", Policy: "instant"}}})
+			Expect(subscriptionPaths(s.Snapshot(cached, now))).To(Equal([]string{testingSelf + "/navigation.speedOverGround"}))
+		})
+		It("returns the cached values of all subscribed contexts", func() {
+			s.Handle(SubscribeMessage{Context: "*", Subscribe: []SubscribeSection{{Path: "navigation.speedOverGround", Policy: "instant"}}})
+			Expect(subscriptionPaths(s.Snapshot(cached, now))).To(ConsistOf(testingSelf+"/navigation.speedOverGround", "vessels.urn:mrn:imo:mmsi:244123456/navigation.speedOverGround"))
+		})
+		It("marks the values as sent", func() {
+			s.Handle(SubscribeMessage{Context: "vessels.self", Subscribe: []SubscribeSection{{Path: "*", MinPeriod: 1000, Period: 2000}}})
+			Expect(s.Snapshot(cached, now)).To(HaveLen(2))
+			Expect(s.Due(now.Add(time.Second))).To(BeEmpty())
+			Expect(s.Filter(subscriptionDelta(testingSelf, now, map[string]interface{}{"navigation.speedOverGround": 3.3}), now.Add(500*time.Millisecond))).To(BeEmpty())
+			Expect(s.Due(now.Add(2 * time.Second))).To(HaveLen(2))
+		})
+	})
+})
+
+var _ = Describe("Websocket clients", func() {
+	It("counts the deltas that are dropped because a client can't keep up", func() {
+		reg := prometheus.NewRegistry()
+		w := NewSignalKWriter(config.NewSignalKConfig("../config/writer/sample-signalk.yaml"), reg)
+		slow := w.AddWebsocketClient("slow", 1)
+		fast := w.AddWebsocketClient("fast", 2)
+
+		delta := subscriptionDelta(testingSelf, time.Now(), map[string]interface{}{"navigation.speedOverGround": 3.2})
+		w.UpdateWebsocket(delta)
+		w.UpdateWebsocket(delta)
+
+		Expect(slow).To(HaveLen(1))
+		Expect(fast).To(HaveLen(2))
+		families, err := reg.Gather()
+		Expect(err).NotTo(HaveOccurred())
+		dropped := make(map[string]float64)
+		for _, family := range families {
+			for _, metric := range family.GetMetric() {
+				dropped[family.GetName()] += metric.GetCounter().GetValue()
+			}
+		}
+		Expect(dropped).To(HaveKeyWithValue("gosk_signalk_ws_deltas_dropped_total", 1.0))
+	})
+})
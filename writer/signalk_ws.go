@@ -2,6 +2,7 @@ package writer
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"time"
 
@@ -20,9 +21,15 @@ type hello struct {
 	Roles     []string  `json:"roles"`
 }
 
+const (
+	// interval used to check if held back or periodic values should be sent to the client
+	subscriptionTickInterval = 100 * time.Millisecond
+)
+
 type websocketClient struct {
-	host   string
-	deltas chan message.Mapped
+	host          string
+	deltas        chan message.Mapped
+	subscriptions *subscriptions
 }
 
 func (w *SignalKWriter) serveWebsocket(rw http.ResponseWriter, r *http.Request) {
@@ -37,10 +44,10 @@ func (w *SignalKWriter) serveWebsocket(rw http.ResponseWriter, r *http.Request)
 	}
 	defer c.Close(websocket.StatusInternalError, "the sky is falling")
 
-	ctx, cancel := context.WithTimeout(r.Context(), time.Second*60)
+	ctx, cancel := context.WithCancel(r.Context())
 	defer cancel()
 
-	err = wsjson.Write(ctx, c, w.createHello())
+	err = w.writeToWebsocket(ctx, c, w.createHello())
 	if err != nil {
 		logger.GetLogger().Warn(
 			"Error while writing hello message",
@@ -49,18 +56,14 @@ func (w *SignalKWriter) serveWebsocket(rw http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	client := websocketClient{
-		deltas: make(chan message.Mapped),
-		host:   r.RemoteAddr,
-	}
+	client := w.createWebsocketClient(r)
 	w.addClient(client)
 	defer w.removeClient(client)
 
-	for {
-		err = wsjson.Write(ctx, c, <-client.deltas)
-		if err != nil {
+	if r.URL.Query().Get("sendCachedValues") != "false" {
+		if err := w.sendCachedValues(ctx, c, client); err != nil {
 			logger.GetLogger().Warn(
-				"Error while writing delta message to the client, closing the connection",
+				"Error while writing the cached values to the client, closing the connection",
 				zap.String("Error", err.Error()),
 				zap.String("Client address", r.RemoteAddr),
 			)
@@ -68,11 +71,147 @@ func (w *SignalKWriter) serveWebsocket(rw http.ResponseWriter, r *http.Request)
 			return
 		}
 	}
+
+	go func() {
+		defer cancel()
+		w.readFromWebsocket(ctx, c, client)
+	}()
+
+	ticker := time.NewTicker(subscriptionTickInterval)
+	defer ticker.Stop()
+
+	var values []message.SingleValueMapped
+	var meta []metaKey
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case delta := <-client.deltas:
+			values = client.subscriptions.filter(delta, time.Now())
+			meta = client.subscriptions.changedMeta(delta)
+		case now := <-ticker.C:
+			values = client.subscriptions.due(now)
+			meta = nil
+		}
+		// the metadata is sent before the first value of a path
+		meta = append(meta, client.subscriptions.unsentMeta(values)...)
+		for _, delta := range append(w.metaDeltas(meta), toDeltas(values)...) {
+			err = w.writeToWebsocket(ctx, c, delta)
+			if err != nil {
+				logger.GetLogger().Warn(
+					"Error while writing delta message to the client, closing the connection",
+					zap.String("Error", err.Error()),
+					zap.String("Client address", r.RemoteAddr),
+				)
+				c.Close(websocket.StatusGoingAway, "error while writing")
+				return
+			}
+		}
+	}
+}
+
+// createWebsocketClient creates a client with the initial subscription requested with the subscribe query parameter
+func (w *SignalKWriter) createWebsocketClient(r *http.Request) websocketClient {
+	client := websocketClient{
+		deltas:        make(chan message.Mapped, 64),
+		host:          r.RemoteAddr,
+		subscriptions: newSubscriptions(w.config.SelfContext),
+	}
+	switch r.URL.Query().Get("subscribe") {
+	case "none":
+		// don't subscribe to anything, the client will send subscribe messages
+	case "all":
+		client.subscriptions.handle(SubscribeMessage{Context: "*", Subscribe: []SubscribeSection{{Path: "*", Policy: subscriptionPolicyInstant}}})
+	default:
+		client.subscriptions.handle(SubscribeMessage{Context: selfContext, Subscribe: []SubscribeSection{{Path: "*", Policy: subscriptionPolicyInstant}}})
+	}
+	return client
+}
+
+// sendCachedValues sends the current state of the subscribed values, so the client doesn't have to wait for values
+// that rarely change
+func (w *SignalKWriter) sendCachedValues(ctx context.Context, c *websocket.Conn, client websocketClient) error {
+	w.wg.Wait()
+
+	cached, err := w.cache.ReadMapped("")
+	if err != nil {
+		logger.GetLogger().Warn(
+			"Could not read the cached values",
+			zap.String("Error", err.Error()),
+		)
+		return nil
+	}
+
+	values := client.subscriptions.snapshot(cached, time.Now())
+	for _, delta := range append(w.metaDeltas(client.subscriptions.unsentMeta(values)), toDeltas(values)...) {
+		if err := w.writeToWebsocket(ctx, c, delta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readFromWebsocket handles the subscribe, unsubscribe and put messages of the client until the connection is closed
+func (w *SignalKWriter) readFromWebsocket(ctx context.Context, c *websocket.Conn, client websocketClient) {
+	for {
+		_, data, err := c.Read(ctx)
+		if err != nil {
+			if websocket.CloseStatus(err) == -1 && ctx.Err() == nil {
+				logger.GetLogger().Warn(
+					"Could not read a message from the client",
+					zap.String("Error", err.Error()),
+					zap.String("Client address", client.host),
+				)
+			}
+			return
+		}
+
+		var p putMessage
+		if err := json.Unmarshal(data, &p); err == nil && p.Put != nil {
+			status := w.handlePut(p.Context, p.Put.Path, p.Put.Value, p.RequestId)
+			if err := w.writeToWebsocket(ctx, c, status); err != nil {
+				logger.GetLogger().Warn(
+					"Error while writing the put response to the client",
+					zap.String("Error", err.Error()),
+					zap.String("Client address", client.host),
+				)
+			}
+			continue
+		}
+
+		var m SubscribeMessage
+		if err := json.Unmarshal(data, &m); err != nil {
+			logger.GetLogger().Warn(
+				"Could not unmarshal the message",
+				zap.ByteString("Received", data),
+				zap.String("Error", err.Error()),
+			)
+			continue
+		}
+		client.subscriptions.handle(m)
+	}
+}
+
+func (w *SignalKWriter) writeToWebsocket(ctx context.Context, c *websocket.Conn, v interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, writeWait)
+	defer cancel()
+
+	return wsjson.Write(ctx, c, v)
 }
 
 func (w *SignalKWriter) updateWebsocket(message message.Mapped) {
 	for _, c := range w.getClients() {
-		c.deltas <- message
+		select {
+		case c.deltas <- message:
+		default:
+			// the client can't keep up, drop the delta instead of blocking the other clients
+			w.deltasDropped.Inc()
+			logger.GetLogger().Debug(
+				"Dropped a delta because the client can't keep up",
+				zap.String("Client address", c.host),
+				zap.String("Context", message.Context),
+			)
+		}
 	}
 }
 
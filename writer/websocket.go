@@ -410,7 +410,11 @@ type SubscribeMessage struct {
 }
 
 type SubscribeSection struct {
-	Path string `json:"path"`
+	Path      string `json:"path"`
+	Period    int64  `json:"period,omitempty"`    // in milliseconds
+	Format    string `json:"format,omitempty"`    // only delta is supported
+	Policy    string `json:"policy,omitempty"`    // instant, ideal or fixed
+	MinPeriod int64  `json:"minPeriod,omitempty"` // in milliseconds
 }
 
 type Cache struct {